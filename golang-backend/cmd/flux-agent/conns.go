@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Live connections of one gost service. The kernel socket table is the source: ss (iproute2)
+// when installed, it also has byte counters per socket, else /proc/net/tcp{,6}. Client
+// connections are the ones on the service port, upstreams the ones to the service targets
+// (forwarder nodes, or the hops of its chain).
+
+type tcpConn struct {
+	local, remote string // host:port, ipv4-mapped addresses unwrapped
+	rx, tx        int64
+}
+
+var (
+	connSeenMu sync.Mutex
+	connSeen   = map[string]time.Time{} // local|remote -> first seen
+)
+
+func queryConns(q wsproto.QueryConnsRequest) (*wsproto.ConnsResult, error) {
+	svc := findGostService(q.Service)
+	if svc == nil {
+		return nil, fmt.Errorf("service %s not found", q.Service)
+	}
+	addr, _ := svc["addr"].(string)
+	port := parsePort(addr)
+	if port == 0 {
+		return nil, fmt.Errorf("service %s has no listen port", q.Service)
+	}
+	targets := serviceTargets(svc)
+	conns, source := establishedConns()
+	observed := trackConns(conns)
+
+	res := &wsproto.ConnsResult{Service: q.Service, Port: port, Source: source, Conns: []wsproto.ConnInfo{}, Upstreams: []wsproto.ConnInfo{}}
+	target := strings.Join(targets.addrs, ",")
+	for _, c := range conns {
+		info := wsproto.ConnInfo{Client: c.remote, RxBytes: c.rx, TxBytes: c.tx, ObservedS: observed[c.local+"|"+c.remote]}
+		if portOf(c.local) == port {
+			info.Target = target
+			res.Conns = append(res.Conns, info)
+		} else if targets.match(c.remote) {
+			info.Client, info.Target = c.local, c.remote
+			res.Upstreams = append(res.Upstreams, info)
+		}
+	}
+	// busiest first; without counters the longest observed
+	sort.Slice(res.Conns, func(i, j int) bool {
+		a, b := res.Conns[i], res.Conns[j]
+		if a.RxBytes+a.TxBytes != b.RxBytes+b.TxBytes {
+			return a.RxBytes+a.TxBytes > b.RxBytes+b.TxBytes
+		}
+		return a.ObservedS > b.ObservedS
+	})
+	res.Total = len(res.Conns)
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 200
+	}
+	if len(res.Conns) > limit {
+		res.Conns = res.Conns[:limit]
+	}
+	if len(res.Upstreams) > limit {
+		res.Upstreams = res.Upstreams[:limit]
+	}
+	if list, err := gostServices(); err == nil {
+		for _, s := range list {
+			if s.Name == q.Service && s.Status != nil {
+				res.Stats = s.Status.Stats
+			}
+		}
+	}
+	return res, nil
+}
+
+func findGostService(name string) map[string]any {
+	arr, _ := readGostConfig()["services"].([]any)
+	for _, it := range arr {
+		if m, ok := it.(map[string]any); ok && m["name"] == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// targetSet matches remote addresses against the service targets, hostnames resolved once
+type targetSet struct {
+	addrs []string
+	ips   map[string]bool // ip:port
+}
+
+func (t targetSet) match(remote string) bool { return t.ips[remote] }
+
+func serviceTargets(svc map[string]any) targetSet {
+	t := targetSet{ips: map[string]bool{}}
+	add := func(nodes any) {
+		list, _ := nodes.([]any)
+		for _, n := range list {
+			m, _ := n.(map[string]any)
+			if a, _ := m["addr"].(string); a != "" {
+				t.addrs = append(t.addrs, a)
+			}
+		}
+	}
+	if fw, ok := svc["forwarder"].(map[string]any); ok {
+		add(fw["nodes"])
+	}
+	if h, ok := svc["handler"].(map[string]any); ok {
+		if chain, _ := h["chain"].(string); chain != "" {
+			chains, _ := readGostConfig()["chains"].([]any)
+			for _, c := range chains {
+				cm, _ := c.(map[string]any)
+				if cm["name"] != chain {
+					continue
+				}
+				hops, _ := cm["hops"].([]any)
+				if len(hops) > 0 {
+					hm, _ := hops[0].(map[string]any) // gost only dials the first hop itself
+					add(hm["nodes"])
+				}
+			}
+		}
+	}
+	for _, a := range t.addrs {
+		host, port, err := net.SplitHostPort(a)
+		if err != nil {
+			continue
+		}
+		ips := []string{host}
+		if net.ParseIP(host) == nil {
+			ips, _ = net.LookupHost(host)
+		}
+		for _, ip := range ips {
+			t.ips[net.JoinHostPort(unmapIP(ip), port)] = true
+		}
+	}
+	return t
+}
+
+// establishedConns lists established tcp sockets, preferring ss for the byte counters
+func establishedConns() ([]tcpConn, string) {
+	if _, err := exec.LookPath("ss"); err == nil {
+		if out, err := exec.Command("ss", "-tniH", "state", "established").Output(); err == nil {
+			return parseSS(string(out)), "ss"
+		}
+	}
+	var out []tcpConn
+	for _, p := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		out = append(out, readProcTCP(p)...)
+	}
+	return out, "proc"
+}
+
+// parseSS reads `ss -tniH state established`: a socket line (Recv-Q Send-Q Local Peer)
+// followed by an indented line of tcp_info fields
+func parseSS(out string) []tcpConn {
+	var list []tcpConn
+	for _, ln := range strings.Split(out, "\n") {
+		if strings.TrimSpace(ln) == "" {
+			continue
+		}
+		if ln[0] == ' ' || ln[0] == '\t' {
+			if len(list) == 0 {
+				continue
+			}
+			c := &list[len(list)-1]
+			for _, f := range strings.Fields(ln) {
+				k, v, ok := strings.Cut(f, ":")
+				if !ok {
+					continue
+				}
+				switch k {
+				case "bytes_received":
+					c.rx, _ = strconv.ParseInt(v, 10, 64)
+				case "bytes_sent":
+					c.tx, _ = strconv.ParseInt(v, 10, 64)
+				case "bytes_acked": // older kernels have no bytes_sent
+					if c.tx == 0 {
+						c.tx, _ = strconv.ParseInt(v, 10, 64)
+					}
+				}
+			}
+			continue
+		}
+		f := strings.Fields(ln)
+		if len(f) < 4 {
+			continue
+		}
+		list = append(list, tcpConn{local: normAddr(f[2]), remote: normAddr(f[3])})
+	}
+	return list
+}
+
+// readProcTCP reads the established sockets of /proc/net/tcp or tcp6
+func readProcTCP(path string) []tcpConn {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var list []tcpConn
+	lines := strings.Split(string(b), "\n")
+	for _, ln := range lines[1:] { // skip header
+		f := strings.Fields(ln)
+		if len(f) < 4 || f[3] != "01" {
+			continue
+		}
+		l, r := procAddr(f[1]), procAddr(f[2])
+		if l == "" || r == "" {
+			continue
+		}
+		list = append(list, tcpConn{local: l, remote: r})
+	}
+	return list
+}
+
+// procAddr decodes "0100007F:1F90": the address is stored as 32-bit words in host (little
+// endian) order, the port in hex
+func procAddr(s string) string {
+	h, p, ok := strings.Cut(s, ":")
+	if !ok {
+		return ""
+	}
+	raw, err := hex.DecodeString(h)
+	if err != nil || len(raw)%4 != 0 {
+		return ""
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	port, err := strconv.ParseUint(p, 16, 16)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(unmapIP(net.IP(raw).String()), strconv.Itoa(int(port)))
+}
+
+// normAddr turns ss addresses ("[::ffff:1.2.3.4]:80", "1.2.3.4%eth0:80") into host:port
+func normAddr(a string) string {
+	host, port, err := net.SplitHostPort(a)
+	if err != nil {
+		return a
+	}
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.JoinHostPort(unmapIP(host), port)
+}
+
+func unmapIP(ip string) string {
+	if p := net.ParseIP(ip); p != nil {
+		if v4 := p.To4(); v4 != nil {
+			return v4.String()
+		}
+	}
+	return ip
+}
+
+func portOf(addr string) int {
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(p)
+	return n
+}
+
+// trackConns returns for how long each connection has been seen and forgets the ones that are gone
+func trackConns(conns []tcpConn) map[string]int64 {
+	now := time.Now()
+	out := make(map[string]int64, len(conns))
+	connSeenMu.Lock()
+	defer connSeenMu.Unlock()
+	alive := make(map[string]bool, len(conns))
+	for _, c := range conns {
+		k := c.local + "|" + c.remote
+		alive[k] = true
+		first, ok := connSeen[k]
+		if !ok {
+			first = now
+			connSeen[k] = now
+		}
+		out[k] = int64(now.Sub(first).Seconds())
+	}
+	for k := range connSeen {
+		if !alive[k] {
+			delete(connSeen, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// ---- Traffic metering ----
+// The agent samples gost's per-service byte counters through the gost web API, sums the
+// deltas in memory and cuts a batch every upload interval. Batches are spooled to disk
+// before upload and only removed once the panel acknowledged them, so a panel outage
+// delays accounting instead of losing it. Every batch carries (epoch, seq); the panel
+// stores applied pairs and acknowledges a retried batch without counting it again.
+//
+// state.json holds the epoch, the last seq and the counter baseline matching that seq.
+// A crash between writing a batch and the state re-cuts the same seq, which the panel
+// treats as a duplicate: the meter may under-count in that window, never double-count.
+
+var flowServiceName = regexp.MustCompile(`^\d+_\d+_\d+$`) // forwardId_userId_userTunnelId
+
+const defaultGostAPIAddr = "127.0.0.1:18080"
+
+type flowItem struct {
+	N string `json:"n"`
+	U int64  `json:"u"`
+	D int64  `json:"d"`
+}
+
+type flowBatch struct {
+	Epoch string     `json:"epoch"`
+	Seq   int64      `json:"seq"`
+	Time  int64      `json:"time"`
+	Items []flowItem `json:"items"`
+}
+
+type flowCounter struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+type flowState struct {
+	Epoch string                 `json:"epoch"`
+	Seq   int64                  `json:"seq"`
+	Last  map[string]flowCounter `json:"last"`
+}
+
+type flowMeter struct {
+	dir     string
+	maxKeep int
+	state   flowState
+	last    map[string]flowCounter // baseline of the latest sample, ahead of state.Last
+	pending map[string]flowCounter // deltas not yet cut into a batch
+	primed  bool                   // false on a fresh spool: the first sample is only a baseline
+}
+
+func envInt(k string, def int) int {
+	if v := getenv(k, ""); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// runFlowMeter runs for the whole agent lifetime (not per WS connection).
+// FLOW_METER=0 disables it, e.g. when gost observers still post to /flow/upload.
+func runFlowMeter(addr, secret, scheme string) {
+	if getenv("FLOW_METER", "1") == "0" {
+		return
+	}
+	m := &flowMeter{
+		dir:     getenv("FLOW_SPOOL_DIR", "/etc/gost/flow_spool"),
+		maxKeep: envInt("FLOW_SPOOL_MAX", 10000),
+		pending: map[string]flowCounter{},
+	}
+	if err := m.load(); err != nil {
+		slog.Warn("flow_meter_disabled", "error", err)
+		return
+	}
+	m.last = m.state.Last
+	m.primed = m.state.Seq > 0 || len(m.state.Last) > 0
+	sample := time.Duration(envInt("FLOW_SAMPLE_INTERVAL", 10)) * time.Second
+	upload := time.Duration(envInt("FLOW_UPLOAD_INTERVAL", 60)) * time.Second
+	slog.Info("flow_meter_start", "epoch", m.state.Epoch, "seq", m.state.Seq, "dir", m.dir)
+
+	st := time.NewTicker(sample)
+	defer st.Stop()
+	ut := time.NewTicker(upload)
+	defer ut.Stop()
+	for {
+		select {
+		case <-st.C:
+			m.sample()
+		case <-ut.C:
+			m.sample()
+			if err := m.cut(); err != nil {
+				slog.Error("flow_spool_err", "error", err)
+			}
+			m.flush(addr, secret, scheme)
+		}
+	}
+}
+
+func (m *flowMeter) load() error {
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return err
+	}
+	if b, err := os.ReadFile(filepath.Join(m.dir, "state.json")); err == nil {
+		if err := json.Unmarshal(b, &m.state); err != nil {
+			return fmt.Errorf("corrupt flow state: %v", err)
+		}
+	}
+	if m.state.Epoch == "" {
+		// new spool: a fresh epoch keeps seq numbers from colliding with a wiped one
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		m.state = flowState{Epoch: hex.EncodeToString(b)}
+		if err := m.saveState(); err != nil {
+			return err
+		}
+	}
+	if m.state.Last == nil {
+		m.state.Last = map[string]flowCounter{}
+	}
+	return nil
+}
+
+// sample reads the counters and adds the deltas since the previous sample to pending.
+func (m *flowMeter) sample() {
+	cur, err := gostServiceStats()
+	if err != nil {
+		slog.Error("flow_sample_err", "error", err)
+		return
+	}
+	if !m.primed {
+		// bytes gost counted before the meter existed were reported elsewhere (or nowhere)
+		m.last, m.primed = cur, true
+		return
+	}
+	next := make(map[string]flowCounter, len(cur))
+	for name, c := range cur {
+		next[name] = c
+		prev, seen := m.last[name]
+		d := flowCounter{In: c.In - prev.In, Out: c.Out - prev.Out}
+		if !seen || d.In < 0 || d.Out < 0 {
+			// new service or counters reset by a gost restart/reload
+			d = c
+		}
+		if d.In == 0 && d.Out == 0 {
+			continue
+		}
+		p := m.pending[name]
+		p.In += d.In
+		p.Out += d.Out
+		m.pending[name] = p
+	}
+	m.last = next
+}
+
+// cut turns pending deltas into the next spooled batch and persists the matching baseline.
+func (m *flowMeter) cut() error {
+	if len(m.pending) == 0 {
+		return nil
+	}
+	items := make([]flowItem, 0, len(m.pending))
+	for name, c := range m.pending {
+		items = append(items, flowItem{N: name, U: c.In, D: c.Out})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].N < items[j].N })
+	seq := m.state.Seq + 1
+	b, _ := json.Marshal(flowBatch{Epoch: m.state.Epoch, Seq: seq, Time: time.Now().UnixMilli(), Items: items})
+	if err := writeFileAtomic(filepath.Join(m.dir, batchFileName(seq)), b); err != nil {
+		return err
+	}
+	m.state.Seq = seq
+	m.state.Last = m.last
+	if err := m.saveState(); err != nil {
+		return err
+	}
+	m.pending = map[string]flowCounter{}
+	m.trim()
+	return nil
+}
+
+func (m *flowMeter) saveState() error {
+	b, _ := json.Marshal(m.state)
+	return writeFileAtomic(filepath.Join(m.dir, "state.json"), b)
+}
+
+// flush uploads spooled batches oldest first and stops at the first failure.
+func (m *flowMeter) flush(addr, secret, scheme string) {
+	files := m.spooled()
+	sent := 0
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var batch flowBatch
+		if json.Unmarshal(b, &batch) != nil {
+			slog.Warn("flow_spool_corrupt", "file", f)
+			_ = os.Remove(f)
+			continue
+		}
+		body := map[string]any{"secret": secret, "epoch": batch.Epoch, "seq": batch.Seq, "items": batch.Items}
+		code, resp, err := httpPostJSON(apiURL(scheme, addr, "/api/v1/agent/flow-report"), body)
+		if err != nil || code != 200 {
+			slog.Warn("flow_upload_err", "seq", batch.Seq, "status", code, "pending", len(files)-sent)
+			return
+		}
+		var r struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		if json.Unmarshal(resp, &r) != nil || r.Code != 0 {
+			slog.Warn("flow_upload_rejected", "seq", batch.Seq, "msg", r.Msg)
+			return
+		}
+		_ = os.Remove(f)
+		sent++
+	}
+	if sent > 0 {
+		slog.Info("flow_uploaded", "batches", sent)
+	}
+}
+
+// trim drops the oldest batches once the spool exceeds FLOW_SPOOL_MAX.
+func (m *flowMeter) trim() {
+	files := m.spooled()
+	if len(files) <= m.maxKeep {
+		return
+	}
+	drop := files[:len(files)-m.maxKeep]
+	for _, f := range drop {
+		_ = os.Remove(f)
+	}
+	slog.Warn("flow_spool_trimmed", "dropped", len(drop))
+}
+
+func (m *flowMeter) spooled() []string {
+	files, _ := filepath.Glob(filepath.Join(m.dir, "batch-*.json"))
+	sort.Strings(files) // zero-padded seq keeps lexical == numeric order
+	return files
+}
+
+func batchFileName(seq int64) string { return fmt.Sprintf("batch-%016d.json", seq) }
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// gostAPI resolves the gost web API base URL and basic auth.
+// GOST_API (e.g. http://127.0.0.1:18080/api) wins over the api section of gost.json.
+func gostAPI() (base, user, pass string) {
+	cfg := readGostConfig()
+	api, _ := cfg["api"].(map[string]any)
+	if api != nil {
+		if auth, ok := api["auth"].(map[string]any); ok {
+			user, _ = auth["username"].(string)
+			pass, _ = auth["password"].(string)
+		}
+	}
+	if v := getenv("GOST_API", ""); v != "" {
+		return strings.TrimSuffix(v, "/"), user, pass
+	}
+	if api == nil {
+		return "", "", ""
+	}
+	addr, _ := api["addr"].(string)
+	if addr == "" {
+		return "", "", ""
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	prefix, _ := api["pathPrefix"].(string)
+	return "http://" + addr + strings.TrimSuffix(prefix, "/"), user, pass
+}
+
+// gostService is a service as reported by the gost web API
+type gostService struct {
+	Name     string `json:"name"`
+	Observer string `json:"observer"`
+	Status   *struct {
+		Stats *wsproto.ServiceStats `json:"stats"`
+	} `json:"status"`
+}
+
+// gostServices reads the running config with status from the gost web API
+func gostServices() ([]gostService, error) {
+	base, user, pass := gostAPI()
+	if base == "" {
+		return nil, fmt.Errorf("gost api not configured")
+	}
+	req, _ := http.NewRequest("GET", base+"/config?format=json", nil)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	hc := &http.Client{Timeout: 5 * time.Second}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("gost api status %d", resp.StatusCode)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	var cfg struct {
+		Services []gostService `json:"services"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Services, nil
+}
+
+// gostServiceStats returns cumulative input/output bytes of panel forward services.
+// Services with their own observer are skipped: that observer already reports to the panel.
+func gostServiceStats() (map[string]flowCounter, error) {
+	list, err := gostServices()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]flowCounter{}
+	for _, s := range list {
+		if !flowServiceName.MatchString(s.Name) || s.Observer != "" || s.Status == nil || s.Status.Stats == nil {
+			continue
+		}
+		out[s.Name] = flowCounter{In: s.Status.Stats.InputBytes, Out: s.Status.Stats.OutputBytes}
+	}
+	return out, nil
+}
+
+// ensureGostStats turns on per-service stats for panel forwards and exposes the gost web
+// API on loopback when gost.json has none, so the meter has something to read.
+func ensureGostStats(cfg map[string]any, services []map[string]any) {
+	if _, ok := cfg["api"]; !ok && getenv("GOST_API", "") == "" {
+		cfg["api"] = map[string]any{"addr": getenv("GOST_API_ADDR", defaultGostAPIAddr)}
+	}
+	for _, svc := range services {
+		name, _ := svc["name"].(string)
+		if !flowServiceName.MatchString(name) {
+			continue
+		}
+		md, _ := svc["metadata"].(map[string]any)
+		if md == nil {
+			md = map[string]any{}
+			svc["metadata"] = md
+		}
+		md["enableStats"] = true
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Host and gost process health for the sysinfo report, all read from /proc. Anything that
+// cannot be read is left at zero instead of failing the report.
+
+// clkTck is USER_HZ, the unit of utime/stime in /proc/<pid>/stat (100 on every linux we run on)
+const clkTck = 100
+
+var tcpStateNames = map[string]string{
+	"01": "established", "02": "syn_sent", "03": "syn_recv", "04": "fin_wait1",
+	"05": "fin_wait2", "06": "time_wait", "07": "close", "08": "close_wait",
+	"09": "last_ack", "0A": "listen", "0B": "closing",
+}
+
+// healthSampler remembers the gost process between reports for its cpu and restarts
+type healthSampler struct {
+	gostPID   int // last pid seen, kept while gost is down to notice the next start
+	gostTicks uint64
+	gostAt    time.Time
+	restarts  int
+}
+
+func (h *healthSampler) sample() *wsproto.Health {
+	out := &wsproto.Health{TCP: tcpStates()}
+	out.Load1, out.Load5, out.Load15 = loadAvg()
+	out.DiskUsed, out.DiskTotal = diskUsage(filepath.Dir(resolveGostConfigPathForRead()))
+	if f := readFields("/proc/sys/fs/file-nr"); len(f) >= 3 {
+		out.FDsOpen, _ = strconv.ParseInt(f[0], 10, 64)
+		out.FDsMax, _ = strconv.ParseInt(f[2], 10, 64)
+	}
+	out.Conntrack = readInt("/proc/sys/net/netfilter/nf_conntrack_count")
+	out.ConntrackMax = readInt("/proc/sys/net/netfilter/nf_conntrack_max")
+
+	pid := findGostPID(h.gostPID)
+	if pid == 0 {
+		out.GostRestarts = h.restarts
+		return out
+	}
+	now := time.Now()
+	ticks := procCPUTicks(pid)
+	if pid != h.gostPID {
+		if h.gostPID != 0 {
+			h.restarts++
+		}
+	} else if secs := now.Sub(h.gostAt).Seconds(); secs > 0 && ticks >= h.gostTicks {
+		out.GostCPU = float64(ticks-h.gostTicks) / clkTck / secs * 100
+	}
+	h.gostPID, h.gostTicks, h.gostAt = pid, ticks, now
+	out.GostPID, out.GostRestarts = pid, h.restarts
+	out.GostRSS = procRSS(pid)
+	if ents, err := os.ReadDir("/proc/" + strconv.Itoa(pid) + "/fd"); err == nil {
+		out.GostFDs = len(ents)
+	}
+	out.GostFDLimit = procFDLimit(pid)
+	return out
+}
+
+func readFields(path string) []string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(b))
+}
+
+func readInt(path string) int64 {
+	f := readFields(path)
+	if len(f) == 0 {
+		return 0
+	}
+	v, _ := strconv.ParseInt(f[0], 10, 64)
+	return v
+}
+
+func loadAvg() (l1, l5, l15 float64) {
+	f := readFields("/proc/loadavg")
+	if len(f) < 3 {
+		return
+	}
+	l1, _ = strconv.ParseFloat(f[0], 64)
+	l5, _ = strconv.ParseFloat(f[1], 64)
+	l15, _ = strconv.ParseFloat(f[2], 64)
+	return
+}
+
+// diskUsage of the filesystem holding dir; the config dir may not exist yet, then its parents
+func diskUsage(dir string) (used, total int64) {
+	for {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err == nil {
+			bs := int64(st.Bsize)
+			return int64(st.Blocks-st.Bfree) * bs, int64(st.Blocks) * bs
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, 0
+		}
+		dir = parent
+	}
+}
+
+// tcpStates counts ipv4 and ipv6 sockets by state
+func tcpStates() map[string]int {
+	out := map[string]int{}
+	for _, p := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(b), "\n")
+		for _, ln := range lines[1:] { // skip header
+			f := strings.Fields(ln)
+			if len(f) < 4 {
+				continue
+			}
+			if name, ok := tcpStateNames[f[3]]; ok {
+				out[name]++
+			}
+		}
+	}
+	return out
+}
+
+// findGostPID returns the running gost pid, checking the last known one first
+func findGostPID(last int) int {
+	if last > 0 && procName(last) == "gost" {
+		return last
+	}
+	if _, err := exec.LookPath("systemctl"); err == nil {
+		if b, err := exec.Command("systemctl", "show", "-p", "MainPID", "--value", "gost").Output(); err == nil {
+			if pid, _ := strconv.Atoi(strings.TrimSpace(string(b))); pid > 0 {
+				return pid
+			}
+		}
+	}
+	ents, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	for _, e := range ents {
+		pid, err := strconv.Atoi(e.Name())
+		if err == nil && procName(pid) == "gost" {
+			return pid
+		}
+	}
+	return 0
+}
+
+func procName(pid int) string {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/comm")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// procCPUTicks is utime+stime of pid
+func procCPUTicks(pid int) uint64 {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+	// the command name may contain spaces: fields start after the closing parenthesis
+	s := string(b)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return 0
+	}
+	f := strings.Fields(s[i+1:])
+	if len(f) < 13 {
+		return 0
+	}
+	// f[0] is field 3 (state); utime and stime are fields 14 and 15
+	ut, _ := strconv.ParseUint(f[11], 10, 64)
+	st, _ := strconv.ParseUint(f[12], 10, 64)
+	return ut + st
+}
+
+func procRSS(pid int) int64 {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0
+	}
+	for _, ln := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(ln, "VmRSS:") {
+			f := strings.Fields(ln)
+			if len(f) >= 2 {
+				kb, _ := strconv.ParseInt(f[1], 10, 64)
+				return kb * 1024
+			}
+		}
+	}
+	return 0
+}
+
+// procFDLimit is the soft open files limit of pid, 0 when unlimited
+func procFDLimit(pid int) int {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/limits")
+	if err != nil {
+		return 0
+	}
+	for _, ln := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(ln, "Max open files") {
+			f := strings.Fields(ln)
+			if len(f) >= 4 {
+				n, _ := strconv.Atoi(f[3])
+				return n
+			}
+		}
+	}
+	return 0
+}
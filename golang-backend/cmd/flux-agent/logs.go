@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Journal tail for the panel (QueryLogs): journalctl of whitelisted units only, grep done
+// here so no pattern reaches a command line, the result sent back as LogChunk frames.
+
+var logUnits = map[string]string{"agent": "flux-agent", "agent2": "flux-agent2", "gost": "gost"}
+
+// journalTimeRe accepts journalctl time specs: "-1h", "today", "2024-05-01 10:00:00"
+var journalTimeRe = regexp.MustCompile(`^[0-9A-Za-z :+\-.]{1,40}$`)
+
+const (
+	logDefaultLines = 200
+	logMaxLines     = 5000
+	logDefaultBytes = 1 << 20
+	logMaxBytes     = 8 << 20
+	logGrepWindow   = 100000 // journal lines scanned when filtering
+)
+
+// journalArgs validates q and builds the journalctl command line
+func journalArgs(q wsproto.QueryLogsRequest, lines int, grep bool) ([]string, error) {
+	args := []string{"--no-pager", "-q", "-o", "short-iso"}
+	units := q.Units
+	if len(units) == 0 {
+		units = []string{"agent", "gost"}
+	}
+	for _, u := range units {
+		unit, ok := logUnits[u]
+		if !ok {
+			return nil, fmt.Errorf("unknown unit %q", u)
+		}
+		args = append(args, "-u", unit)
+	}
+	for _, t := range [][2]string{{"--since", q.Since}, {"--until", q.Until}} {
+		if t[1] == "" {
+			continue
+		}
+		if !journalTimeRe.MatchString(t[1]) {
+			return nil, fmt.Errorf("bad time %q", t[1])
+		}
+		args = append(args, t[0]+"="+t[1])
+	}
+	n := lines
+	if grep {
+		n = logGrepWindow
+	}
+	return append(args, "-n", strconv.Itoa(n)), nil
+}
+
+// readJournal returns the last lines (matching re) of the journal, oldest first
+func readJournal(q wsproto.QueryLogsRequest) ([]string, error) {
+	lines := q.Lines
+	if lines <= 0 {
+		lines = logDefaultLines
+	}
+	if lines > logMaxLines {
+		lines = logMaxLines
+	}
+	var re *regexp.Regexp
+	if q.Grep != "" {
+		if len(q.Grep) > 256 {
+			return nil, fmt.Errorf("grep pattern too long")
+		}
+		var err error
+		if re, err = regexp.Compile(q.Grep); err != nil {
+			return nil, fmt.Errorf("bad grep pattern: %v", err)
+		}
+	}
+	args, err := journalArgs(q, lines, re != nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return nil, fmt.Errorf("journalctl not available")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var tail []string
+	sc := bufio.NewScanner(out)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		ln := sc.Text()
+		if re != nil && !re.MatchString(ln) {
+			continue
+		}
+		tail = append(tail, ln)
+		if len(tail) >= 2*lines {
+			tail = append(tail[:0], tail[len(tail)-lines:]...)
+		}
+	}
+	if err := cmd.Wait(); err != nil && len(tail) == 0 {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("journalctl: %s", msg)
+		}
+		return nil, fmt.Errorf("journalctl: %v", err)
+	}
+	if len(tail) > lines {
+		tail = tail[len(tail)-lines:]
+	}
+	return tail, nil
+}
+
+// queryLogs answers QueryLogs with LogChunk frames; the newest lines win when the result
+// is larger than MaxBytes
+func queryLogs(c *agentConn, q wsproto.QueryLogsRequest) error {
+	lines, err := readJournal(q)
+	if err != nil {
+		return err
+	}
+	maxBytes := q.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = logDefaultBytes
+	}
+	if maxBytes > logMaxBytes {
+		maxBytes = logMaxBytes
+	}
+	truncated := false
+	size, first := 0, len(lines)
+	for first > 0 && size+len(lines[first-1])+1 <= maxBytes {
+		first--
+		size += len(lines[first]) + 1
+	}
+	if first > 0 {
+		truncated = true
+		lines = lines[first:]
+	}
+	seq, chunk, chunkBytes := 0, []string{}, 0
+	for _, ln := range lines {
+		if chunkBytes+len(ln) > wsproto.LogChunkBytes && len(chunk) > 0 {
+			if err := sendFrame(c, wsproto.TypeLogChunk, q.RequestID, wsproto.LogChunk{Seq: seq, Lines: chunk}); err != nil {
+				return err
+			}
+			seq, chunk, chunkBytes = seq+1, []string{}, 0
+		}
+		chunk = append(chunk, ln)
+		chunkBytes += len(ln) + 1
+	}
+	return sendFrame(c, wsproto.TypeLogChunk, q.RequestID, wsproto.LogChunk{Seq: seq, Lines: chunk, Done: true, Truncated: truncated})
+}
@@ -9,7 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/http"
@@ -20,15 +20,15 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
-)
 
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
+	"network-panel/golang-backend/internal/logx"
+	"network-panel/golang-backend/internal/wsproto"
 )
 
 // versionBase is the agent semantic version (without role prefix).
@@ -41,35 +41,46 @@ func isAgent2Binary() bool {
 	return strings.Contains(base, "flux-agent2")
 }
 
-type DiagnoseData struct {
-	RequestID string                 `json:"requestId"`
-	Host      string                 `json:"host"`
-	Port      int                    `json:"port,omitempty"`
-	Protocol  string                 `json:"protocol,omitempty"`
-	Mode      string                 `json:"mode,omitempty"` // icmp|iperf3|tcp(default)
-	Count     int                    `json:"count,omitempty"`
-	TimeoutMs int                    `json:"timeoutMs,omitempty"`
-	Reverse   bool                   `json:"reverse,omitempty"`
-	Duration  int                    `json:"duration,omitempty"`
-	Server    bool                   `json:"server,omitempty"`
-	Client    bool                   `json:"client,omitempty"`
-	Ctx       map[string]interface{} `json:"ctx,omitempty"`
+// supportedCommands is announced to the panel in the Capabilities handshake
+var supportedCommands = []string{
+	wsproto.TypeDiagnose, wsproto.TypeAddService, wsproto.TypeUpdateService, wsproto.TypeDeleteService,
+	wsproto.TypePauseService, wsproto.TypeResumeService, wsproto.TypeQueryServices,
+	wsproto.TypeUpgradeAgent, wsproto.TypeUpgradeAgent1, wsproto.TypeUpgradeAgent2,
+	wsproto.TypeRestartGost, wsproto.TypeUninstallAgent, wsproto.TypeQueryConns, wsproto.TypeQueryLogs,
+}
+
+var supportedFeatures = []string{wsproto.FeatureServiceDetail, wsproto.FeatureTypedSysInfo}
+
+// typedSysInfo is set once the panel has greeted us with Hello (older panels expect raw sysinfo JSON)
+var typedSysInfo atomic.Bool
+
+// agentConn serialises writes: gorilla/websocket allows only one concurrent writer, and
+// the read loop, diagnose goroutines and periodicSystemInfo all reply on the same conn.
+// (WriteControl used for pings is safe to call concurrently.)
+type agentConn struct {
+	*websocket.Conn
+	wmu sync.Mutex
 }
 
-type QueryServicesReq struct {
-	RequestID string `json:"requestId"`
-	Filter    string `json:"filter,omitempty"` // e.g. "ss"
+func (c *agentConn) writeMessage(mt int, b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.WriteMessage(mt, b)
 }
 
-// Control message from server; Data varies by Type
-type Message struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+// sendFrame writes one protocol frame
+func sendFrame(c *agentConn, typ, requestID string, data any) error {
+	b, err := wsproto.Encode(typ, requestID, data)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.TextMessage, b)
 }
 
-type Message2 struct {
-	Type string                 `json:"type"`
-	Data map[string]interface{} `json:"data"`
+// sendError reports a command the agent could not handle
+func sendError(c *agentConn, requestID, refType, code, msg string) {
+	_ = sendFrame(c, wsproto.TypeError, requestID, wsproto.Error{Code: code, Message: msg, RefType: refType})
 }
 
 func getenv(k, def string) string {
@@ -104,6 +115,8 @@ func main() {
 		flagScheme = flag.String("S", "", "ws or wss")
 	)
 	flag.Parse()
+	// LOG_LEVEL / LOG_FORMAT, secrets in payloads redacted
+	logx.Setup()
 
 	addr := getenv("ADDR", *flagAddr)
 	secret := getenv("SECRET", *flagSecret)
@@ -121,7 +134,8 @@ func main() {
 		}
 	}
 	if addr == "" || secret == "" {
-		log.Fatalf("missing ADDR/SECRET (env or flags) and /etc/gost/config.json fallback")
+		slog.Error("missing ADDR/SECRET (env or flags) and /etc/gost/config.json fallback")
+		os.Exit(1)
 	}
 
 	// compute version and role by binary name
@@ -143,26 +157,38 @@ func main() {
 	}
 	u.RawQuery = q.Encode()
 
+	// traffic meter outlives WS reconnects; agent2 runs on the same node, so only agent1 meters
+	if !isAgent2Binary() {
+		go runFlowMeter(addr, secret, scheme)
+	}
+
 	for {
-		if err := runOnce(u.String(), addr, secret, scheme); err != nil {
-			log.Printf("{\"event\":\"agent_error\",\"error\":%q}", err.Error())
+		err := runOnce(u.String(), addr, secret, scheme)
+		delay := 3 * time.Second
+		if websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+			// panel restarting: come back soon, spread so the agents don't all dial at once
+			slog.Info("panel_restarting")
+			delay = time.Second + time.Duration(rand.Intn(2000))*time.Millisecond
+		} else if err != nil {
+			slog.Warn("agent_error", "error", err)
 		}
-		time.Sleep(3 * time.Second)
+		time.Sleep(delay)
 	}
 }
 
 func runOnce(wsURL, addr, secret, scheme string) error {
-	log.Printf("{\"event\":\"connecting\",\"url\":%q}", wsURL)
+	slog.Debug("connecting", "url", wsURL)
 	d := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
 	if strings.HasPrefix(wsURL, "wss://") {
 		d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
-	c, _, err := d.Dial(wsURL, nil)
+	raw, _, err := d.Dial(wsURL, nil)
 	if err != nil {
 		return err
 	}
+	c := &agentConn{Conn: raw}
 	defer c.Close()
-	log.Printf("{\"event\":\"connected\"}")
+	slog.Info("connected")
 
 	// on connect reconcile & periodic reconcile
 	go reconcile(addr, secret, scheme)
@@ -201,161 +227,126 @@ func runOnce(wsURL, addr, secret, scheme string) error {
 	}()
 
 	for {
-
 		_, msg, err := c.ReadMessage()
 		if err != nil {
 			return err
 		}
-		msg = bytes.TrimSpace(bytes.Replace(msg, newline, space, -1))
-		var m *Message
-		var m2 *Message2
-		// primary parse
-		if err := json.Unmarshal(msg, &m); err != nil {
-			if es1 := json.Unmarshal(msg, &m2); es1 != nil {
-
-				// fallback 1: double-encoded JSON string
-				var s string
-				if e2 := json.Unmarshal(msg, &s); e2 == nil && s != "" {
-					if e3 := json.Unmarshal([]byte(s), &m); e3 == nil {
-						// ok
-					} else {
-						// fallback 2: best-effort trim to first '{' and last '}'
-						if i := strings.IndexByte(s, '{'); i >= 0 {
-							if j := strings.LastIndexByte(s, '}'); j > i {
-								if e4 := json.Unmarshal([]byte(s[i:j+1]), &m); e4 == nil {
-									// ok
-								} else {
-									log.Printf("{\"event\":\"unknown_msg\",\"error\":%q,\"payload\":%q}", e4.Error(), string(msg))
-									continue
-								}
-							} else {
-								log.Printf("{\"event\":\"unknown_msg\",\"error\":%q,\"payload\":%q}", e3.Error(), string(msg))
-								continue
-							}
-						} else {
-							log.Printf("{\"event\":\"unknown_msg\",\"error\":%q,\"payload\":%q}", e3.Error(), string(msg))
-							continue
-						}
-					}
-				} else {
-					// fallback 3: raw bytes trim to first '{'..'}'
-					bs := string(msg)
-					if i := strings.IndexByte(bs, '{'); i >= 0 {
-						if j := strings.LastIndexByte(bs, '}'); j > i {
-							if e5 := json.Unmarshal([]byte(bs[i:j+1]), &m); e5 == nil {
-								// ok
-							} else {
-								log.Printf("{\"event\":\"unknown_msg\",\"error\":%q,\"payload\":%q}", err.Error(), string(msg))
-								continue
-							}
-						} else {
-							log.Printf("{\"event\":\"unknown_msg\",\"error\":%q,\"payload\":%q}", err.Error(), string(msg))
-							continue
-						}
-					} else {
-						log.Printf("{\"event\":\"unknown_msg\",\"error\":%q,\"payload\":%q}", err.Error(), string(msg))
-						continue
-					}
-				}
-			}
-		}
-		if m == nil && m2 != nil {
-			log.Printf("{\"event\":\"message2\",\"ok\":%q}", m2.Type)
-			// convert Message2 to Message
-			b, _ := json.Marshal(m2.Data)
-			m = &Message{Type: m2.Type, Data: b}
-		} else {
-			log.Printf("{\"event\":\"message\",\"ok\":%q}", m.Type)
+		m, err := wsproto.Decode(msg)
+		if err != nil {
+			slog.Debug("unknown_msg", "error", err, "payload", string(msg))
+			continue
 		}
+		slog.Debug("message", "type", m.Type, "v", m.V)
 		switch m.Type {
-		case "Diagnose":
-			var d DiagnoseData
-			_ = json.Unmarshal(m.Data, &d)
-			log.Printf("{\"event\":\"recv_diagnose\",\"data\":%s}", string(mustJSON(d)))
-			go handleDiagnose(c, &d)
-		case "AddService":
-			var services []map[string]any
-			if err := json.Unmarshal(m.Data, &services); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_parse_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+		case wsproto.TypeHello:
+			var h wsproto.Hello
+			_ = m.DecodeData(&h)
+			typedSysInfo.Store(true)
+			caps := wsproto.Capabilities{Version: wsproto.Version, Agent: version, Commands: supportedCommands, Features: supportedFeatures}
+			_ = sendFrame(c, wsproto.TypeCapabilities, "", caps)
+			slog.Info("hello", "panel", h.Server, "version", h.Version)
+		case wsproto.TypeDiagnose:
+			var d wsproto.DiagnoseRequest
+			if err := m.DecodeData(&d); err != nil {
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeBadRequest, err.Error())
 				continue
 			}
-			if err := addOrUpdateServices(services, false); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_apply_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
-			} else {
-				log.Printf("{\"event\":\"svc_cmd_applied\",\"type\":%q,\"count\":%d}", m.Type, len(services))
+			if d.RequestID == "" {
+				d.RequestID = m.RequestID
 			}
-		case "UpdateService":
-			var services []map[string]any
-			if err := json.Unmarshal(m.Data, &services); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_parse_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+			slog.Debug("recv_diagnose", "data", d)
+			go handleDiagnose(c, &d)
+		case wsproto.TypeAddService, wsproto.TypeUpdateService:
+			var services wsproto.ServiceConfigs
+			if err := m.DecodeData(&services); err != nil {
+				slog.Warn("svc_cmd_parse_err", "type", m.Type, "error", err)
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeBadRequest, err.Error())
 				continue
 			}
-			if err := addOrUpdateServices(services, true); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_apply_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+			if err := addOrUpdateServices(services, m.Type == wsproto.TypeUpdateService); err != nil {
+				slog.Error("svc_cmd_apply_err", "type", m.Type, "error", err)
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeFailed, err.Error())
 			} else {
-				log.Printf("{\"event\":\"svc_cmd_applied\",\"type\":%q,\"count\":%d}", m.Type, len(services))
+				slog.Info("svc_cmd_applied", "type", m.Type, "count", len(services))
 			}
-		case "DeleteService":
-			var req struct {
-				Services []string `json:"services"`
-			}
-			if err := json.Unmarshal(m.Data, &req); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_parse_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+		case wsproto.TypeDeleteService, wsproto.TypePauseService, wsproto.TypeResumeService:
+			var req wsproto.ServiceNames
+			if err := m.DecodeData(&req); err != nil {
+				slog.Warn("svc_cmd_parse_err", "type", m.Type, "error", err)
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeBadRequest, err.Error())
 				continue
 			}
-			if err := deleteServices(req.Services); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_apply_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+			var err error
+			switch m.Type {
+			case wsproto.TypeDeleteService:
+				err = deleteServices(req.Services)
+			case wsproto.TypePauseService:
+				err = markServicesPaused(req.Services, true)
+			default:
+				err = markServicesPaused(req.Services, false)
+			}
+			if err != nil {
+				slog.Error("svc_cmd_apply_err", "type", m.Type, "error", err)
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeFailed, err.Error())
 			} else {
-				log.Printf("{\"event\":\"svc_cmd_applied\",\"type\":%q,\"count\":%d}", m.Type, len(req.Services))
+				slog.Info("svc_cmd_applied", "type", m.Type, "count", len(req.Services))
 			}
-		case "PauseService":
-			var req struct {
-				Services []string `json:"services"`
+		case wsproto.TypeQueryServices:
+			var q wsproto.QueryServicesRequest
+			_ = m.DecodeData(&q)
+			if q.RequestID == "" {
+				q.RequestID = m.RequestID
 			}
-			if err := json.Unmarshal(m.Data, &req); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_parse_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+			list := queryServices(q.Filter, q.Detail)
+			_ = sendFrame(c, wsproto.TypeQueryServicesResult, q.RequestID, list)
+			slog.Debug("send_qs_result", "count", len(list))
+		case wsproto.TypeQueryConns:
+			var q wsproto.QueryConnsRequest
+			if err := m.DecodeData(&q); err != nil {
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeBadRequest, err.Error())
 				continue
 			}
-			if err := markServicesPaused(req.Services, true); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_apply_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
-			} else {
-				log.Printf("{\"event\":\"svc_cmd_applied\",\"type\":%q,\"count\":%d}", m.Type, len(req.Services))
-			}
-		case "ResumeService":
-			var req struct {
-				Services []string `json:"services"`
+			if q.RequestID == "" {
+				q.RequestID = m.RequestID
 			}
-			if err := json.Unmarshal(m.Data, &req); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_parse_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
+			go func() {
+				res, err := queryConns(q)
+				if err != nil {
+					sendError(c, q.RequestID, wsproto.TypeQueryConns, wsproto.ErrCodeFailed, err.Error())
+					return
+				}
+				_ = sendFrame(c, wsproto.TypeQueryConnsResult, q.RequestID, res)
+			}()
+		case wsproto.TypeQueryLogs:
+			var q wsproto.QueryLogsRequest
+			if err := m.DecodeData(&q); err != nil {
+				sendError(c, m.RequestID, m.Type, wsproto.ErrCodeBadRequest, err.Error())
 				continue
 			}
-			if err := markServicesPaused(req.Services, false); err != nil {
-				log.Printf("{\"event\":\"svc_cmd_apply_err\",\"type\":%q,\"error\":%q}", m.Type, err.Error())
-			} else {
-				log.Printf("{\"event\":\"svc_cmd_applied\",\"type\":%q,\"count\":%d}", m.Type, len(req.Services))
+			if q.RequestID == "" {
+				q.RequestID = m.RequestID
 			}
-		case "QueryServices":
-			var q QueryServicesReq
-			_ = json.Unmarshal(m.Data, &q)
-			list := queryServices(q.Filter)
-			out := map[string]any{"type": "QueryServicesResult", "requestId": q.RequestID, "data": list}
-			_ = c.WriteJSON(out)
-			log.Printf("{\"event\":\"send_qs_result\",\"count\":%d}", len(list))
-		case "UpgradeAgent":
+			go func() {
+				if err := queryLogs(c, q); err != nil {
+					slog.Warn("query_logs_err", "requestId", q.RequestID, "error", err)
+					sendError(c, q.RequestID, wsproto.TypeQueryLogs, wsproto.ErrCodeFailed, err.Error())
+				}
+			}()
+		case wsproto.TypeUpgradeAgent:
 			// optional payload: {to: "go-agent-1.x.y"}
 			go func() { _ = selfUpgrade(addr, scheme) }()
-		case "UpgradeAgent1":
+		case wsproto.TypeUpgradeAgent1:
 			go func() { _ = upgradeAgent1(addr, scheme, "") }()
-		case "UpgradeAgent2":
+		case wsproto.TypeUpgradeAgent2:
 			go func() { _ = upgradeAgent2(addr, scheme, "") }()
-		case "RestartGost":
+		case wsproto.TypeRestartGost:
 			go func() { _ = restartGostService() }()
-		case "UninstallAgent":
+		case wsproto.TypeUninstallAgent:
 			go func() {
 				_ = uninstallSelf()
 			}()
 		default:
-			// ignore unknown
+			sendError(c, m.RequestID, m.Type, wsproto.ErrCodeUnsupported, "unsupported command")
 		}
 	}
 }
@@ -447,28 +438,6 @@ func memUsagePercent() float64 {
 	return used
 }
 
-func netBytes() (rx, tx uint64) {
-	b, err := ioutil.ReadFile("/proc/net/dev")
-	if err != nil {
-		return 0, 0
-	}
-	lines := strings.Split(string(b), "\n")
-	for _, ln := range lines[2:] { // skip headers
-		parts := strings.Fields(strings.TrimSpace(ln))
-		if len(parts) < 17 {
-			continue
-		}
-		// parts[0]=iface: ; rx bytes=parts[1]; tx bytes=parts[9]
-		// strip trailing ':' in iface
-		// sum over all interfaces
-		rxb, _ := strconv.ParseUint(parts[1], 10, 64)
-		txb, _ := strconv.ParseUint(parts[9], 10, 64)
-		rx += rxb
-		tx += txb
-	}
-	return
-}
-
 func uptimeSeconds() int64 {
 	b, err := ioutil.ReadFile("/proc/uptime")
 	if err != nil {
@@ -482,26 +451,37 @@ func uptimeSeconds() int64 {
 	return int64(f)
 }
 
-func periodicSystemInfo(c *websocket.Conn) {
+func periodicSystemInfo(c *agentConn) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
+	ns := newNetSampler()
+	hs := &healthSampler{}
 	for {
-		rx, tx := netBytes()
+		rx, tx, rxRate, txRate, netIfaces := ns.sample()
 		// gather interface list (best-effort)
 		ifaces := getInterfaces()
-		payload := map[string]any{
-			"Uptime": uptimeSeconds(),
-		}
-		payload["BytesReceived"] = int64(rx)
-		payload["BytesTransmitted"] = int64(tx)
-		payload["CPUUsage"] = cpuUsagePercent()
-		payload["MemoryUsage"] = memUsagePercent()
-		if len(ifaces) > 0 {
-			payload["Interfaces"] = ifaces
+		payload := wsproto.SysInfo{
+			Uptime:           uptimeSeconds(),
+			BytesReceived:    int64(rx),
+			BytesTransmitted: int64(tx),
+			CPUUsage:         cpuUsagePercent(),
+			MemoryUsage:      memUsagePercent(),
+			Interfaces:       ifaces,
+			RxRate:           rxRate,
+			TxRate:           txRate,
+			NetIfaces:        netIfaces,
+			Health:           hs.sample(),
 		}
 		b, _ := json.Marshal(payload)
-		log.Printf("{\"event\":\"sysinfo_report\",\"payload\":%s}", string(b))
-		if err := c.WriteMessage(websocket.TextMessage, b); err != nil {
+		slog.Debug("sysinfo_report", "payload", payload)
+		var err error
+		if typedSysInfo.Load() {
+			err = sendFrame(c, wsproto.TypeSysInfo, "", payload)
+		} else {
+			// panel has not greeted us: keep the raw legacy format
+			err = c.writeMessage(websocket.TextMessage, b)
+		}
+		if err != nil {
 			return
 		}
 		<-ticker.C
@@ -589,7 +569,7 @@ func reconcile(addr, secret, scheme string) {
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("{\"event\":\"reconcile_error\",\"step\":\"desired\",\"error\":%q}", err.Error())
+		slog.Warn("reconcile_error", "step", "desired", "error", err)
 		return
 	}
 	defer resp.Body.Close()
@@ -599,7 +579,7 @@ func reconcile(addr, secret, scheme string) {
 	}
 	_ = json.NewDecoder(resp.Body).Decode(&res)
 	if res.Code != 0 {
-		log.Printf("{\"event\":\"reconcile_error\",\"step\":\"desired\",\"code\":%d}", res.Code)
+		slog.Warn("reconcile_error", "step", "desired", "code", res.Code)
 		return
 	}
 	missing := make([]map[string]any, 0)
@@ -628,7 +608,7 @@ func reconcile(addr, secret, scheme string) {
 		}
 	}
 	if len(missing) == 0 && len(extras) == 0 {
-		log.Printf("{\"event\":\"reconcile_ok\",\"missing\":0,\"extras\":0}")
+		slog.Info("reconcile_ok", "missing", 0, "extras", 0)
 		return
 	}
 	if len(missing) > 0 {
@@ -637,10 +617,10 @@ func reconcile(addr, secret, scheme string) {
 		req2, _ := http.NewRequestWithContext(ctx, "POST", pushURL, strings.NewReader(string(pb)))
 		req2.Header.Set("Content-Type", "application/json")
 		if resp2, err := http.DefaultClient.Do(req2); err != nil {
-			log.Printf("{\"event\":\"reconcile_error\",\"step\":\"push\",\"error\":%q}", err.Error())
+			slog.Warn("reconcile_error", "step", "push", "error", err)
 		} else {
 			resp2.Body.Close()
-			log.Printf("{\"event\":\"reconcile_push\",\"count\":%d}", len(missing))
+			slog.Info("reconcile_push", "count", len(missing))
 		}
 	}
 	if strict && len(extras) > 0 {
@@ -649,15 +629,15 @@ func reconcile(addr, secret, scheme string) {
 		req3, _ := http.NewRequestWithContext(ctx, "POST", rmURL, strings.NewReader(string(rb)))
 		req3.Header.Set("Content-Type", "application/json")
 		if resp3, err := http.DefaultClient.Do(req3); err != nil {
-			log.Printf("{\"event\":\"reconcile_error\",\"step\":\"remove\",\"error\":%q}", err.Error())
+			slog.Warn("reconcile_error", "step", "remove", "error", err)
 		} else {
 			resp3.Body.Close()
-			log.Printf("{\"event\":\"reconcile_remove\",\"count\":%d}", len(extras))
+			slog.Info("reconcile_remove", "count", len(extras))
 		}
 	}
 }
 
-func handleDiagnose(c *websocket.Conn, d *DiagnoseData) {
+func handleDiagnose(c *agentConn, d *wsproto.DiagnoseRequest) {
 	// defaults
 	if d.Count <= 0 {
 		d.Count = 3
@@ -666,7 +646,7 @@ func handleDiagnose(c *websocket.Conn, d *DiagnoseData) {
 		d.TimeoutMs = 1500
 	}
 
-	var resp map[string]any
+	var resp wsproto.DiagnoseResult
 	switch strings.ToLower(d.Mode) {
 	case "icmp":
 		avg, loss := runICMP(d.Host, d.Count, d.TimeoutMs)
@@ -675,7 +655,7 @@ func handleDiagnose(c *websocket.Conn, d *DiagnoseData) {
 		if !ok {
 			msg = "unreachable"
 		}
-		resp = map[string]any{"success": ok, "averageTime": avg, "packetLoss": loss, "message": msg, "ctx": d.Ctx}
+		resp = wsproto.DiagnoseResult{Success: ok, AverageTime: avg, PacketLoss: loss, Message: msg, Ctx: d.Ctx}
 	case "iperf3":
 		if d.Server {
 			port := d.Port
@@ -687,7 +667,7 @@ func handleDiagnose(c *websocket.Conn, d *DiagnoseData) {
 			if !ok {
 				msg = "failed to start server"
 			}
-			resp = map[string]any{"success": ok, "port": port, "message": msg, "ctx": d.Ctx}
+			resp = wsproto.DiagnoseResult{Success: ok, Port: port, Message: msg, Ctx: d.Ctx}
 		} else if d.Client {
 			if d.Duration <= 0 {
 				d.Duration = 5
@@ -695,9 +675,9 @@ func handleDiagnose(c *websocket.Conn, d *DiagnoseData) {
 			// allow reverse mode via payload Reverse flag
 			bw := runIperf3Client(d.Host, d.Port, d.Duration, d.Reverse)
 			ok := bw > 0
-			resp = map[string]any{"success": ok, "bandwidthMbps": bw, "ctx": d.Ctx}
+			resp = wsproto.DiagnoseResult{Success: ok, BandwidthMbps: bw, Ctx: d.Ctx}
 		} else {
-			resp = map[string]any{"success": false, "message": "unknown iperf3 mode", "ctx": d.Ctx}
+			resp = wsproto.DiagnoseResult{Success: false, Message: "unknown iperf3 mode", Ctx: d.Ctx}
 		}
 	default:
 		// tcp connect
@@ -707,11 +687,10 @@ func handleDiagnose(c *websocket.Conn, d *DiagnoseData) {
 		if !ok {
 			msg = "connect fail"
 		}
-		resp = map[string]any{"success": ok, "averageTime": avg, "packetLoss": loss, "message": msg, "ctx": d.Ctx}
+		resp = wsproto.DiagnoseResult{Success: ok, AverageTime: avg, PacketLoss: loss, Message: msg, Ctx: d.Ctx}
 	}
-	out := map[string]any{"type": "DiagnoseResult", "requestId": d.RequestID, "data": resp}
-	_ = c.WriteJSON(out)
-	log.Printf("{\"event\":\"send_result\",\"requestId\":%q,\"data\":%s}", d.RequestID, string(mustJSON(resp)))
+	_ = sendFrame(c, wsproto.TypeDiagnoseResult, d.RequestID, resp)
+	slog.Debug("send_result", "requestId", d.RequestID, "data", resp)
 }
 
 func mustJSON(v any) []byte { b, _ := json.Marshal(v); return b }
@@ -763,10 +742,11 @@ func writeGostConfig(m map[string]any) error {
 }
 
 // queryServices returns a summary list of services, optionally filtered by handler type.
-func queryServices(filter string) []map[string]any {
+// When detail is true each item also carries the raw gost service config under "config".
+func queryServices(filter string, detail bool) []wsproto.ServiceInfo {
 	cfg := readGostConfig()
 	arrAny, _ := cfg["services"].([]any)
-	out := make([]map[string]any, 0, len(arrAny))
+	out := make([]wsproto.ServiceInfo, 0, len(arrAny))
 	for _, it := range arrAny {
 		m, ok := it.(map[string]any)
 		if !ok {
@@ -792,16 +772,20 @@ func queryServices(filter string) []map[string]any {
 		if port > 0 {
 			listening = portListening(port)
 		}
-		out = append(out, map[string]any{
-			"name":      name,
-			"addr":      addr,
-			"handler":   htype,
-			"port":      port,
-			"listening": listening,
-			"limiter":   limiter,
-			"rlimiter":  rlimiter,
-			"metadata":  meta,
-		})
+		item := wsproto.ServiceInfo{
+			Name:      name,
+			Addr:      addr,
+			Handler:   htype,
+			Port:      port,
+			Listening: listening,
+			Limiter:   limiter,
+			Rlimiter:  rlimiter,
+			Metadata:  meta,
+		}
+		if detail {
+			item.Config = m
+		}
+		out = append(out, item)
 	}
 	return out
 }
@@ -852,6 +836,7 @@ func portListening(port int) bool {
 // If updateOnly is true, only update existing by name; otherwise upsert (add if missing).
 func addOrUpdateServices(services []map[string]any, updateOnly bool) error {
 	cfg := readGostConfig()
+	ensureGostStats(cfg, services)
 	// merge optional chains injected per-service under _chains (upsert by name)
 	chainsAny, _ := cfg["chains"].([]any)
 	chainIdx := map[string]int{}
@@ -1202,16 +1187,16 @@ func selfUpgrade(addr, scheme string) error {
 	}
 	u := apiURL(scheme, addr, "/flux-agent/"+binName)
 	tmp := target + ".new"
-	log.Printf("{\"event\":\"agent_upgrade_begin\",\"url\":%q}", u)
+	slog.Info("agent_upgrade_begin", "url", u)
 	if err := download(u, tmp); err != nil {
-		log.Printf("upgrade download err: %v", err)
+		slog.Error("agent_upgrade_err", "url", u, "error", err)
 		return err
 	}
 	_ = os.Rename(tmp, target)
 	_ = os.Chmod(target, 0755)
 	// restart service or exec-replace
 	if tryRestartService(svc) {
-		log.Printf("{\"event\":\"agent_upgrade_done\",\"service\":%q}", svc)
+		slog.Info("agent_upgrade_done", "service", svc)
 		return nil
 	}
 	// fallback: exec replace self
@@ -1391,7 +1376,7 @@ func uninstallSelf() error {
 	} else {
 		_ = os.Remove("/etc/default/flux-agent2")
 	}
-	log.Printf("{\"event\":\"agent_uninstalled\",\"service\":%q}", svc)
+	slog.Info("agent_uninstalled", "service", svc)
 	// exit process
 	os.Exit(0)
 	return nil
@@ -1399,10 +1384,10 @@ func uninstallSelf() error {
 
 func restartGostService() error {
 	if tryRestartService("gost") {
-		log.Printf("{\"event\":\"gost_restarted\"}")
+		slog.Info("gost_restarted")
 		return nil
 	}
-	log.Printf("{\"event\":\"gost_restart_failed\"}")
+	slog.Warn("gost_restart_failed")
 	return fmt.Errorf("restart gost failed")
 }
 
@@ -1421,9 +1406,9 @@ func periodicEnsureGost() {
 		}
 		// try restart when inactive
 		if tryRestartService("gost") {
-			log.Printf("{\"event\":\"gost_autorestart\",\"status\":\"restarted\"}")
+			slog.Info("gost_autorestart", "status", "restarted")
 		} else {
-			log.Printf("{\"event\":\"gost_autorestart\",\"status\":\"failed\"}")
+			slog.Warn("gost_autorestart", "status", "failed")
 		}
 	}
 }
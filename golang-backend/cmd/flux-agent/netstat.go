@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Per-interface traffic from /proc/net/dev. Loopback and container/virtual devices are left
+// out, they only mirror traffic of the physical interfaces (or never leave the host).
+// NET_IFACE_EXCLUDE adds name prefixes to skip, comma separated.
+
+var defaultIfaceExclude = []string{"lo", "docker", "br-", "veth", "virbr", "cni", "flannel", "cali", "kube-ipvs", "vxlan"}
+
+type ifaceCounter struct {
+	rx, tx uint64
+}
+
+// netSampler keeps the previous counters to turn them into rates
+type netSampler struct {
+	exclude []string
+	last    map[string]ifaceCounter
+	lastAt  time.Time
+}
+
+func newNetSampler() *netSampler {
+	ex := append([]string{}, defaultIfaceExclude...)
+	for _, p := range strings.Split(getenv("NET_IFACE_EXCLUDE", ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ex = append(ex, p)
+		}
+	}
+	return &netSampler{exclude: ex, last: map[string]ifaceCounter{}}
+}
+
+func (s *netSampler) excluded(name string) bool {
+	for _, p := range s.exclude {
+		if name == p || (p != "lo" && strings.HasPrefix(name, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readNetDev returns rx/tx bytes per interface
+func readNetDev() map[string]ifaceCounter {
+	b, err := ioutil.ReadFile("/proc/net/dev")
+	if err != nil {
+		return nil
+	}
+	out := map[string]ifaceCounter{}
+	lines := strings.Split(string(b), "\n")
+	if len(lines) < 2 {
+		return out
+	}
+	for _, ln := range lines[2:] { // skip headers
+		name, rest, ok := strings.Cut(ln, ":")
+		if !ok {
+			continue
+		}
+		parts := strings.Fields(rest)
+		if len(parts) < 16 {
+			continue
+		}
+		// rx bytes=parts[0]; tx bytes=parts[8]
+		rx, _ := strconv.ParseUint(parts[0], 10, 64)
+		tx, _ := strconv.ParseUint(parts[8], 10, 64)
+		out[strings.TrimSpace(name)] = ifaceCounter{rx: rx, tx: tx}
+	}
+	return out
+}
+
+// counterDelta: a counter smaller than before was reset (reboot, driver reload) and counts from zero
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// sample returns the totals over the counted interfaces, their rates and the interfaces.
+// Rates are 0 on the first sample.
+func (s *netSampler) sample() (rx, tx uint64, rxRate, txRate float64, ifaces []wsproto.NetIface) {
+	now := time.Now()
+	secs := now.Sub(s.lastAt).Seconds()
+	first := s.lastAt.IsZero()
+	cur := readNetDev()
+	var drx, dtx uint64
+	for name, c := range cur {
+		if s.excluded(name) {
+			continue
+		}
+		rx += c.rx
+		tx += c.tx
+		it := wsproto.NetIface{Name: name, RxBytes: int64(c.rx), TxBytes: int64(c.tx)}
+		if prev, ok := s.last[name]; ok && !first && secs > 0 {
+			dr, dt := counterDelta(prev.rx, c.rx), counterDelta(prev.tx, c.tx)
+			it.RxRate, it.TxRate = float64(dr)/secs, float64(dt)/secs
+			drx += dr
+			dtx += dt
+		}
+		ifaces = append(ifaces, it)
+	}
+	if !first && secs > 0 {
+		rxRate, txRate = float64(drx)/secs, float64(dtx)/secs
+	}
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+	s.last, s.lastAt = cur, now
+	return
+}
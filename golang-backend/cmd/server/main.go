@@ -1,37 +1,110 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	app "network-panel/golang-backend/internal/app"
+	"network-panel/golang-backend/internal/app/controller"
+	"network-panel/golang-backend/internal/app/lifecycle"
+	"network-panel/golang-backend/internal/app/middleware"
 	"network-panel/golang-backend/internal/app/scheduler"
 	"network-panel/golang-backend/internal/app/util"
 	appver "network-panel/golang-backend/internal/app/version"
+	"network-panel/golang-backend/internal/config"
 	dbpkg "network-panel/golang-backend/internal/db"
+	"network-panel/golang-backend/internal/logx"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	configPath := flag.String("config", "", "config file (.yaml or .toml); PANEL_CONFIG or ./config.yaml when empty")
+	flag.Parse()
 	// load .env if present
 	util.LoadEnv()
-	if err := dbpkg.Init(); err != nil {
-		log.Fatalf("db init error: %v", err)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logx.Setup()
+		for _, line := range strings.Split(err.Error(), "\n") {
+			slog.Error("config_error", "error", line)
+		}
+		os.Exit(1)
+	}
+	logx.SetupWriter(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	slog.Info("config_loaded", "file", cfg.File, "env", cfg.Env)
+	for _, w := range cfg.Warnings() {
+		slog.Warn("config_warning", "message", w)
+	}
+	if err := dbpkg.Init(controller.DBMetrics{}); err != nil {
+		fatal("db init error", err)
+	}
+	// tunnel paths from vite_config keys into tunnel_hop (once)
+	controller.MigrateTunnelHops()
+	// replica bus (memory by default; bus.backend db for multiple replicas)
+	if err := controller.InitBus(); err != nil {
+		fatal("bus init error", err)
 	}
 	// start schedulerRs
 	scheduler.Start()
 
-	r := gin.Default()
-	gin.SetMode(gin.DebugMode)
+	mode := strings.ToLower(cfg.Server.GinMode)
+	gin.SetMode(mode)
+	r := gin.New()
+	r.Use(gin.Recovery(), middleware.AccessLog())
 	app.RegisterRoutes(r)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "6365"
+	port := strconv.Itoa(cfg.Server.Port)
+	slog.Info("server_start", "version", appver.Get(), "port", port, "ginMode", mode)
+	srv := &http.Server{Addr: ":" + port, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		fatal("server error", err)
+	case s := <-sig:
+		slog.Info("shutdown_begin", "signal", s.String())
+	}
+	signal.Stop(sig)
+	shutdown(srv, time.Duration(cfg.Server.ShutdownTimeoutS)*time.Second)
+}
+
+// shutdown stops accepting requests, lets in-flight ones finish, closes the node
+// connections with a restart close frame, then waits for the background jobs
+// (server.shutdownTimeoutS for the whole sequence)
+func shutdown(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	lifecycle.BeginShutdown()
+	// websocket connections are hijacked, Shutdown does not wait for them
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("http_shutdown_err", "error", err.Error())
+	}
+	controller.CloseConnections()
+	if err := lifecycle.Stop(ctx); err != nil {
+		slog.Warn("jobs_shutdown_timeout", "error", err.Error())
 	}
-	log.Printf("network-panel server version %s", appver.Get())
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("server error: %v", err)
+	_ = controller.CloseBus()
+	if err := dbpkg.Close(); err != nil {
+		slog.Warn("db_close_err", "error", err.Error())
 	}
+	slog.Info("shutdown_done", "ms", time.Since(start).Milliseconds())
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err.Error())
+	os.Exit(1)
 }
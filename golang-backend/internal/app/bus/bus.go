@@ -0,0 +1,34 @@
+// Package bus lets several panel replicas share node connections: a command for a node
+// whose agent is connected to another replica is published to that replica, and the
+// agent's reply travels back the same way.
+//
+// Backends: Memory (default, single replica: nothing leaves the process) and DB (shared
+// table polled by every replica, works with both MySQL and sqlite).
+package bus
+
+// Handler receives a published payload.
+type Handler func(payload []byte)
+
+// Bus is the transport between panel replicas.
+type Bus interface {
+	// Publish delivers payload to every subscriber of topic, on any replica.
+	Publish(topic string, payload []byte) error
+	// Subscribe registers a handler for topic on this replica.
+	Subscribe(topic string, h Handler)
+	// ClaimNode records that replica holds the node's connection of an agent role (an
+	// agent and agent2 of one node may sit on different replicas).
+	ClaimNode(nodeID int64, role, replica string) error
+	// ReleaseNode drops the claim if it is still held by replica.
+	ReleaseNode(nodeID int64, role, replica string) error
+	// NodeRoutes returns role -> replica for the node's live connections.
+	NodeRoutes(nodeID int64) map[string]string
+	// Replicas lists the replicas that hold at least one node connection.
+	Replicas() []string
+	Close() error
+}
+
+// ReplicaTopic is the per-replica inbox topic.
+func ReplicaTopic(replica string) string { return "replica." + replica }
+
+// TopicAdmins fans admin monitor messages out to every replica.
+const TopicAdmins = "admins"
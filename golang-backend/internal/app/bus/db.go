@@ -0,0 +1,222 @@
+package bus
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+
+	"network-panel/golang-backend/internal/app/model"
+)
+
+const (
+	// DefaultPollInterval is used when NewDB gets no interval. Every poll is two indexed
+	// queries per replica; cross-replica commands wait up to one interval each way.
+	DefaultPollInterval = 500 * time.Millisecond
+	dbMessageTTL        = time.Minute
+	dbRouteHeartbeat    = 15 * time.Second
+	dbRouteStale        = 45 * time.Second // a replica that stopped heartbeating loses its nodes
+	dbPollBatch         = 500
+	// dbLookBack re-reads recent rows below the cursor: MySQL hands out auto-increment IDs
+	// at insert time, so with several writers a lower ID can commit after a higher one was
+	// already read. Rows are deduplicated by ID. Replica clocks must agree within this.
+	dbLookBack = 10 * time.Second
+)
+
+// DB is a bus backed by the panel database: Publish inserts into bus_message and every
+// replica polls for rows of the topics it subscribed to. Node ownership lives in
+// bus_node_route, one row per node and agent role, refreshed by a heartbeat. Every poll
+// also re-reads the last dbLookBack of rows below the cursor so late commits are not
+// skipped.
+type DB struct {
+	db       *gorm.DB
+	replica  string
+	interval time.Duration
+
+	mu     sync.RWMutex
+	subs   map[string][]Handler
+	held   map[routeKey]struct{}
+	lastID int64
+	seen   map[int64]int64 // delivered IDs inside the look-back window -> created_ms
+	start  int64           // created_ms floor: rows from before NewDB are skipped
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type routeKey struct {
+	node int64
+	role string
+}
+
+// NewDB starts polling every interval (DefaultPollInterval when 0) right away; messages
+// published before start are skipped.
+func NewDB(db *gorm.DB, replica string, interval time.Duration) *DB {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	// polling must not flood the SQL log
+	quiet := db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	b := &DB{db: quiet, replica: replica, interval: interval, subs: map[string][]Handler{}, held: map[routeKey]struct{}{}, seen: map[int64]int64{}, start: time.Now().UnixMilli(), stop: make(chan struct{})}
+	var last model.BusMessage
+	if err := quiet.Order("id desc").Limit(1).Find(&last).Error; err == nil {
+		b.lastID = last.ID
+	}
+	go b.poll()
+	go b.housekeep()
+	return b
+}
+
+func (b *DB) Publish(topic string, payload []byte) error {
+	return b.db.Create(&model.BusMessage{Topic: topic, Payload: string(payload), CreatedMs: time.Now().UnixMilli()}).Error
+}
+
+func (b *DB) Subscribe(topic string, h Handler) {
+	b.mu.Lock()
+	b.subs[topic] = append(b.subs[topic], h)
+	b.mu.Unlock()
+}
+
+func (b *DB) ClaimNode(nodeID int64, role, replica string) error {
+	if replica == b.replica {
+		b.mu.Lock()
+		b.held[routeKey{nodeID, role}] = struct{}{}
+		b.mu.Unlock()
+	}
+	return b.upsertRoute(nodeID, role, replica)
+}
+
+func (b *DB) ReleaseNode(nodeID int64, role, replica string) error {
+	if replica == b.replica {
+		b.mu.Lock()
+		delete(b.held, routeKey{nodeID, role})
+		b.mu.Unlock()
+	}
+	return b.db.Where("node_id = ? AND role = ? AND replica = ?", nodeID, role, replica).Delete(&model.BusNodeRoute{}).Error
+}
+
+func (b *DB) NodeRoutes(nodeID int64) map[string]string {
+	var rows []model.BusNodeRoute
+	out := map[string]string{}
+	if err := b.db.Where("node_id = ? AND updated_ms >= ?", nodeID, b.freshSince()).Find(&rows).Error; err != nil {
+		return out
+	}
+	for _, r := range rows {
+		out[r.Role] = r.Replica
+	}
+	return out
+}
+
+func (b *DB) Replicas() []string {
+	var out []string
+	b.db.Model(&model.BusNodeRoute{}).Where("updated_ms >= ?", b.freshSince()).Distinct().Pluck("replica", &out)
+	return out
+}
+
+// freshSince is the oldest heartbeat of a route still considered live
+func (b *DB) freshSince() int64 {
+	return time.Now().Add(-dbRouteStale).UnixMilli()
+}
+
+func (b *DB) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	return nil
+}
+
+func (b *DB) upsertRoute(nodeID int64, role, replica string) error {
+	now := time.Now().UnixMilli()
+	return b.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}, {Name: "role"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"replica": replica, "updated_ms": now}),
+	}).Create(&model.BusNodeRoute{NodeID: nodeID, Role: role, Replica: replica, UpdatedMs: now}).Error
+}
+
+func (b *DB) poll() {
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+		}
+		b.pollOnce()
+	}
+}
+
+// pollOnce delivers new rows above the cursor and late rows inside the look-back window
+func (b *DB) pollOnce() {
+	b.mu.RLock()
+	topics := make([]string, 0, len(b.subs))
+	for k := range b.subs {
+		topics = append(topics, k)
+	}
+	last := b.lastID
+	b.mu.RUnlock()
+	if len(topics) == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-dbLookBack).UnixMilli()
+	if cutoff < b.start {
+		cutoff = b.start
+	}
+	var late, rows []model.BusMessage
+	if err := b.db.Where("id <= ? AND created_ms >= ? AND topic IN ?", last, cutoff, topics).Order("id asc").Limit(dbPollBatch).Find(&late).Error; err != nil {
+		return
+	}
+	if err := b.db.Where("id > ? AND topic IN ?", last, topics).Order("id asc").Limit(dbPollBatch).Find(&rows).Error; err != nil {
+		return
+	}
+	b.mu.Lock()
+	if len(rows) > 0 {
+		b.lastID = rows[len(rows)-1].ID
+	}
+	fresh := make([]model.BusMessage, 0, len(late)+len(rows))
+	for _, m := range append(late, rows...) {
+		if _, ok := b.seen[m.ID]; ok {
+			continue
+		}
+		b.seen[m.ID] = m.CreatedMs
+		fresh = append(fresh, m)
+	}
+	for id, at := range b.seen {
+		if at < cutoff && id <= b.lastID {
+			delete(b.seen, id)
+		}
+	}
+	b.mu.Unlock()
+	for _, m := range fresh {
+		b.mu.RLock()
+		hs := append([]Handler(nil), b.subs[m.Topic]...)
+		b.mu.RUnlock()
+		for _, h := range hs {
+			h([]byte(m.Payload))
+		}
+	}
+}
+
+// housekeep refreshes routes of locally held nodes and trims delivered messages
+func (b *DB) housekeep() {
+	t := time.NewTicker(dbRouteHeartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+		}
+		b.mu.RLock()
+		keys := make([]routeKey, 0, len(b.held))
+		for k := range b.held {
+			keys = append(keys, k)
+		}
+		b.mu.RUnlock()
+		for _, k := range keys {
+			_ = b.upsertRoute(k.node, k.role, b.replica)
+		}
+		cutoff := time.Now().Add(-dbMessageTTL).UnixMilli()
+		b.db.Where("created_ms < ?", cutoff).Delete(&model.BusMessage{})
+	}
+}
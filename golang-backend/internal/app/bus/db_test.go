@@ -0,0 +1,165 @@
+//go:build !loong64
+
+package bus
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"network-panel/golang-backend/internal/app/model"
+)
+
+const testPoll = 20 * time.Millisecond
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "bus.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.BusMessage{}, &model.BusNodeRoute{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestBus(t *testing.T, db *gorm.DB, replica string) *DB {
+	b := NewDB(db, replica, testPoll)
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+// collect subscribes to topic and returns the channel of received payloads
+func collect(b *DB, topic string) <-chan string {
+	ch := make(chan string, 16)
+	b.Subscribe(topic, func(p []byte) { ch <- string(p) })
+	return ch
+}
+
+func expect(t *testing.T, ch <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no message, want %q", want)
+	}
+}
+
+func expectNone(t *testing.T, ch <-chan string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected message %q", got)
+	case <-time.After(10 * testPoll):
+	}
+}
+
+func TestDBPublishReachesOtherReplica(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Create(&model.BusMessage{Topic: ReplicaTopic("b"), Payload: "old", CreatedMs: time.Now().UnixMilli()}).Error; err != nil {
+		t.Fatal(err)
+	}
+	a := newTestBus(t, db, "a")
+	b := newTestBus(t, db, "b")
+	inbox := collect(b, ReplicaTopic("b"))
+	other := collect(a, ReplicaTopic("a"))
+
+	if err := a.Publish(ReplicaTopic("b"), []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, inbox, "hello")
+	// rows from before start are skipped, delivered rows are not delivered again
+	expectNone(t, inbox)
+	expectNone(t, other)
+}
+
+func TestDBDeliversLateCommit(t *testing.T) {
+	db := openTestDB(t)
+	b := newTestBus(t, db, "b")
+	inbox := collect(b, ReplicaTopic("b"))
+	hi := model.BusMessage{ID: 100, Topic: ReplicaTopic("b"), Payload: "first", CreatedMs: time.Now().UnixMilli()}
+	if err := db.Create(&hi).Error; err != nil {
+		t.Fatal(err)
+	}
+	expect(t, inbox, "first")
+
+	// a row with a lower ID committed after the cursor moved past it
+	lo := model.BusMessage{ID: 50, Topic: ReplicaTopic("b"), Payload: "late", CreatedMs: time.Now().UnixMilli()}
+	if err := db.Create(&lo).Error; err != nil {
+		t.Fatal(err)
+	}
+	expect(t, inbox, "late")
+	expectNone(t, inbox)
+}
+
+func TestDBRoutesPerRole(t *testing.T) {
+	db := openTestDB(t)
+	a := newTestBus(t, db, "a")
+	b := newTestBus(t, db, "b")
+	if err := a.ClaimNode(1, "agent", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ClaimNode(1, "agent2", "b"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"agent": "a", "agent2": "b"}
+	if got := a.NodeRoutes(1); !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes = %v, want %v", got, want)
+	}
+	got := b.Replicas()
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("replicas = %v", got)
+	}
+
+	// the agent role moved to b before a noticed the old connection closing
+	if err := b.ClaimNode(1, "agent", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleaseNode(1, "agent", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.NodeRoutes(1); !reflect.DeepEqual(got, map[string]string{"agent": "b", "agent2": "b"}) {
+		t.Fatalf("routes after move = %v", got)
+	}
+
+	// a replica that stopped heartbeating loses its routes
+	old := time.Now().Add(-2 * dbRouteStale).UnixMilli()
+	if err := db.Model(&model.BusNodeRoute{}).Where("role = ?", "agent2").Update("updated_ms", old).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := a.NodeRoutes(1); !reflect.DeepEqual(got, map[string]string{"agent": "b"}) {
+		t.Fatalf("routes with stale agent2 = %v", got)
+	}
+	if err := b.ReleaseNode(1, "agent", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Replicas(); len(got) != 0 {
+		t.Fatalf("replicas after release = %v", got)
+	}
+}
+
+func TestMemoryRoutesPerRole(t *testing.T) {
+	m := NewMemory()
+	_ = m.ClaimNode(1, "agent", "a")
+	_ = m.ClaimNode(1, "agent2", "a")
+	_ = m.ReleaseNode(1, "agent", "b") // not the holder
+	if got := m.NodeRoutes(1); !reflect.DeepEqual(got, map[string]string{"agent": "a", "agent2": "a"}) {
+		t.Fatalf("routes = %v", got)
+	}
+	_ = m.ReleaseNode(1, "agent", "a")
+	_ = m.ReleaseNode(1, "agent2", "a")
+	if got := m.Replicas(); len(got) != 0 {
+		t.Fatalf("replicas = %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package bus
+
+import "sync"
+
+// Memory is an in-process bus for a single replica: published messages reach the
+// subscribers of this process only.
+type Memory struct {
+	mu     sync.RWMutex
+	subs   map[string][]Handler
+	owners map[int64]map[string]string // node -> role -> replica
+}
+
+func NewMemory() *Memory {
+	return &Memory{subs: map[string][]Handler{}, owners: map[int64]map[string]string{}}
+}
+
+func (m *Memory) Publish(topic string, payload []byte) error {
+	m.mu.RLock()
+	hs := append([]Handler(nil), m.subs[topic]...)
+	m.mu.RUnlock()
+	if len(hs) == 0 {
+		return nil
+	}
+	// never run handlers on the publisher's goroutine (it may hold locks)
+	go func() {
+		for _, h := range hs {
+			h(payload)
+		}
+	}()
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, h Handler) {
+	m.mu.Lock()
+	m.subs[topic] = append(m.subs[topic], h)
+	m.mu.Unlock()
+}
+
+func (m *Memory) ClaimNode(nodeID int64, role, replica string) error {
+	m.mu.Lock()
+	if m.owners[nodeID] == nil {
+		m.owners[nodeID] = map[string]string{}
+	}
+	m.owners[nodeID][role] = replica
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) ReleaseNode(nodeID int64, role, replica string) error {
+	m.mu.Lock()
+	if m.owners[nodeID][role] == replica {
+		delete(m.owners[nodeID], role)
+		if len(m.owners[nodeID]) == 0 {
+			delete(m.owners, nodeID)
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) NodeRoutes(nodeID int64) map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]string, len(m.owners[nodeID]))
+	for role, r := range m.owners[nodeID] {
+		out[role] = r
+	}
+	return out
+}
+
+func (m *Memory) Replicas() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := map[string]bool{}
+	out := []string{}
+	for _, roles := range m.owners {
+		for _, r := range roles {
+			if !seen[r] {
+				seen[r] = true
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,411 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Threshold rules are evaluated by the scheduler over node_sysinfo and node_probe_result.
+// Each (rule, node[, target]) is one alert key; the firing state is the alert itself, so an
+// evaluation only has to compare the window with the threshold of the current state:
+// Threshold while clear, ClearThreshold while firing. Both must hold for the whole window:
+// a ">" rule fires on the window minimum and clears on its maximum ("<" the other way
+// round), so a single spike neither fires nor clears a rule. probe_loss is a ratio over the
+// window and compares as is.
+
+const (
+	metricCPU       = "cpu"        // node_sysinfo.cpu, percent
+	metricMem       = "mem"        // node_sysinfo.mem, percent
+	metricProbeLoss = "probe_loss" // failed probes in percent
+	metricProbeRTT  = "probe_rtt"  // average rtt of successful probes, ms
+)
+
+var metricNames = map[string]string{
+	metricCPU:       "CPU",
+	metricMem:       "内存",
+	metricProbeLoss: "丢包率",
+	metricProbeRTT:  "延迟",
+}
+
+// metricSample is one evaluated series: a node, or a node and probe target
+type metricSample struct {
+	NodeID   int64
+	TargetID int64
+	Value    float64 // compared with Threshold: the window's least breaching value
+	Clear    float64 // compared with ClearThreshold: the window's most breaching value
+	Samples  int
+}
+
+// EvaluateAlertRules runs every enabled rule once
+func EvaluateAlertRules(ctx context.Context) {
+	var rules []model.AlertRule
+	dbpkg.DB.Where("enabled = ?", 1).Find(&rules)
+	if len(rules) == 0 {
+		return
+	}
+	nodes := map[int64]model.Node{}
+	var list []model.Node
+	dbpkg.DB.Find(&list)
+	for _, n := range list {
+		nodes[n.ID] = n
+	}
+	for _, r := range rules {
+		if ctx.Err() != nil {
+			return
+		}
+		evaluateRule(r, nodes)
+	}
+}
+
+func evaluateRule(r model.AlertRule, nodes map[int64]model.Node) {
+	from := time.Now().Add(-time.Duration(r.WindowS) * time.Second).UnixMilli()
+	samples, err := queryMetric(r, from)
+	if err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "alert_rule_err", "ruleId": r.ID, "error": err.Error()})
+		return
+	}
+	only := parseIDList(r.NodeIDs)
+	for _, s := range samples {
+		node, ok := nodes[s.NodeID]
+		if !ok || (len(only) > 0 && !only[s.NodeID]) || s.Samples < r.MinSamples {
+			continue
+		}
+		key := fmt.Sprintf("rule:%d:node:%d", r.ID, s.NodeID)
+		if s.TargetID != 0 {
+			key += fmt.Sprintf(":target:%d", s.TargetID)
+		}
+		var active int64
+		dbpkg.DB.Model(&model.Alert{}).Where("alert_key = ? AND status IN ?", key, []string{alertOpen, alertAcked}).Count(&active)
+		if active == 0 {
+			if breached(r.Op, s.Value, r.Threshold) {
+				fireRule(r, node, s, key)
+			}
+			continue
+		}
+		clear := r.Threshold
+		if r.ClearThreshold != nil {
+			clear = *r.ClearThreshold
+		}
+		if !breached(r.Op, s.Clear, clear) {
+			s.Value = s.Clear
+			// a reopened incident was not announced again, so neither is its recovery
+			if a, ok := ResolveAlert(key, "auto"); ok && a.Count == 1 {
+				ev := ruleNotifyEvent("rule_resolved", r, node, s, ruleMessage(r, s, false))
+				notify(ev)
+			}
+		}
+	}
+}
+
+func fireRule(r model.AlertRule, node model.Node, s metricSample, key string) {
+	msg := ruleMessage(r, s, true)
+	a := NodeAlert("rule", node, msg)
+	if _, isNew := RaiseAlert(key, a); isNew {
+		notify(ruleNotifyEvent("rule_alert", r, node, s, msg))
+	}
+}
+
+// breached: with op ">" (default) the value is above thr, with "<" below
+func breached(op string, v, thr float64) bool {
+	if op == "<" {
+		return v < thr
+	}
+	return v > thr
+}
+
+// windowValues orders the window extremes for r: fire on the least breaching, clear on the
+// most breaching value
+func windowValues(op string, lo, hi float64) (value, clear float64) {
+	if op == "<" {
+		return hi, lo
+	}
+	return lo, hi
+}
+
+func queryMetric(r model.AlertRule, from int64) ([]metricSample, error) {
+	var out []metricSample
+	switch r.Metric {
+	case metricCPU, metricMem:
+		var rows []struct {
+			NodeID  int64
+			Lo      float64
+			Hi      float64
+			Samples int
+		}
+		err := dbpkg.DB.Model(&model.NodeSysInfo{}).
+			Select("node_id, MIN("+r.Metric+") AS lo, MAX("+r.Metric+") AS hi, COUNT(*) AS samples").
+			Where("time_ms >= ?", from).Group("node_id").Scan(&rows).Error
+		for _, row := range rows {
+			s := metricSample{NodeID: row.NodeID, Samples: row.Samples}
+			s.Value, s.Clear = windowValues(r.Op, row.Lo, row.Hi)
+			out = append(out, s)
+		}
+		return out, err
+	case metricProbeLoss, metricProbeRTT:
+		var rows []struct {
+			NodeID   int64
+			TargetID int64
+			Total    int
+			Failed   int
+			RTTLo    *float64 `gorm:"column:rtt_lo"`
+			RTTHi    *float64 `gorm:"column:rtt_hi"`
+		}
+		q := dbpkg.DB.Model(&model.NodeProbeResult{}).
+			Select("node_id, target_id, COUNT(*) AS total, SUM(CASE WHEN ok = 1 THEN 0 ELSE 1 END) AS failed, MIN(CASE WHEN ok = 1 THEN rtt_ms END) AS rtt_lo, MAX(CASE WHEN ok = 1 THEN rtt_ms END) AS rtt_hi").
+			Where("time_ms >= ?", from)
+		if r.TargetID > 0 {
+			q = q.Where("target_id = ?", r.TargetID)
+		}
+		if err := q.Group("node_id, target_id").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			s := metricSample{NodeID: row.NodeID, TargetID: row.TargetID, Samples: row.Total}
+			if r.Metric == metricProbeLoss {
+				s.Value = float64(row.Failed) * 100 / float64(row.Total)
+				s.Clear = s.Value
+			} else {
+				if row.RTTLo == nil || row.RTTHi == nil {
+					continue // no successful probe: that is loss, not latency
+				}
+				s.Value, s.Clear = windowValues(r.Op, *row.RTTLo, *row.RTTHi)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown metric %q", r.Metric)
+}
+
+func ruleMessage(r model.AlertRule, s metricSample, firing bool) string {
+	name := metricNames[r.Metric]
+	if s.TargetID != 0 {
+		name = targetName(s.TargetID) + " " + name
+	}
+	unit := "%"
+	if r.Metric == metricProbeRTT {
+		unit = "ms"
+	}
+	win := humanDuration(time.Duration(r.WindowS) * time.Second)
+	// the value is the window minimum or maximum, probe loss the ratio over the window
+	agg := "整体 "
+	if r.Metric != metricProbeLoss {
+		if (r.Op == "<") == firing {
+			agg = "最高 "
+		} else {
+			agg = "最低 "
+		}
+	}
+	if firing {
+		return fmt.Sprintf("%s: %s %s内%s%.1f%s %s %g%s", r.Name, name, win, agg, s.Value, unit, ruleOp(r.Op), r.Threshold, unit)
+	}
+	return fmt.Sprintf("%s 已恢复: %s %s内%s%.1f%s", r.Name, name, win, agg, s.Value, unit)
+}
+
+func ruleOp(op string) string {
+	if op == "<" {
+		return "<"
+	}
+	return ">"
+}
+
+func targetName(id int64) string {
+	var t model.ProbeTarget
+	if err := dbpkg.DB.First(&t, id).Error; err != nil {
+		return fmt.Sprintf("#%d", id)
+	}
+	return t.Name
+}
+
+func ruleNotifyEvent(event string, r model.AlertRule, node model.Node, s metricSample, msg string) NotifyEvent {
+	ev := NodeNotifyEvent(event, node, msg)
+	ev.Rule = NotifyRule{ID: r.ID, Name: r.Name, Metric: r.Metric, Op: ruleOp(r.Op), Threshold: r.Threshold, WindowS: r.WindowS}
+	ev.Value = s.Value
+	if s.TargetID != 0 {
+		var t model.ProbeTarget
+		if dbpkg.DB.First(&t, s.TargetID).Error == nil {
+			ev.Target = NotifyTarget{ID: t.ID, Name: t.Name, IP: t.IP}
+		}
+	}
+	return ev
+}
+
+// parseIDList parses "1,2,3"; nil for an empty list
+func parseIDList(s string) map[int64]bool {
+	var out map[int64]bool
+	for _, f := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(f), 10, 64)
+		if err != nil || id <= 0 {
+			continue
+		}
+		if out == nil {
+			out = map[int64]bool{}
+		}
+		out[id] = true
+	}
+	return out
+}
+
+// validateRule normalizes r; it returns an error message or ""
+func validateRule(r *model.AlertRule) string {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return "请填写规则名称"
+	}
+	if _, ok := metricNames[r.Metric]; !ok {
+		return "metric 仅支持 cpu/mem/probe_loss/probe_rtt"
+	}
+	if r.Op == "" {
+		r.Op = ">"
+	}
+	if r.Op != ">" && r.Op != "<" {
+		return "op 仅支持 > 或 <"
+	}
+	if r.ClearThreshold != nil {
+		if (r.Op == ">" && *r.ClearThreshold > r.Threshold) || (r.Op == "<" && *r.ClearThreshold < r.Threshold) {
+			return "恢复阈值需在告警阈值的恢复一侧"
+		}
+	}
+	if r.WindowS <= 0 {
+		r.WindowS = 300
+	}
+	// rules read raw samples only
+	if keep := rawRetention(); time.Duration(r.WindowS)*time.Second > keep {
+		return fmt.Sprintf("windowS 不能超过原始数据保留时长（%d 小时）", int(keep.Hours()))
+	}
+	if r.MinSamples <= 0 {
+		r.MinSamples = 1
+	}
+	if r.Metric != metricProbeLoss && r.Metric != metricProbeRTT {
+		r.TargetID = 0
+	}
+	return ""
+}
+
+// POST /api/v1/alerts/rule/list
+func AlertRuleList(c *gin.Context) {
+	var list []model.AlertRule
+	dbpkg.DB.Order("id asc").Find(&list)
+	c.JSON(http.StatusOK, response.Ok(list))
+}
+
+// POST /api/v1/alerts/rule/create {name, metric, op?, threshold, clearThreshold?, windowS?, minSamples?, nodeIds?, targetId?, enabled?}
+func AlertRuleCreate(c *gin.Context) {
+	var r model.AlertRule
+	r.Enabled = 1
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if msg := validateRule(&r); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	r.ID = 0
+	r.CreatedTime = time.Now().UnixMilli()
+	r.UpdatedTime = r.CreatedTime
+	if err := dbpkg.DB.Create(&r).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("创建失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(r))
+}
+
+// POST /api/v1/alerts/rule/update {id, ...any fields of create}
+// Only the fields sent change; open alerts of the rule stay and clear by the new thresholds,
+// except those the rule no longer evaluates (disabled, node or target out of scope).
+func AlertRuleUpdate(c *gin.Context) {
+	body, err := c.GetRawData()
+	var p struct {
+		ID int64 `json:"id"`
+	}
+	if err != nil || json.Unmarshal(body, &p) != nil || p.ID == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var r model.AlertRule
+	if err := dbpkg.DB.First(&r, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("规则不存在"))
+		return
+	}
+	created := r.CreatedTime
+	if err := json.Unmarshal(body, &r); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if msg := validateRule(&r); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	r.ID, r.CreatedTime = p.ID, created
+	r.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&r).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("更新失败"))
+		return
+	}
+	resolveUncoveredAlerts(r)
+	c.JSON(http.StatusOK, response.Ok(r))
+}
+
+// resolveUncoveredAlerts resolves the open alerts of r that it no longer evaluates: all of
+// them once the rule is disabled, else those of nodes or targets now outside its scope.
+// Nothing would clear them otherwise.
+func resolveUncoveredAlerts(r model.AlertRule) {
+	var open []model.Alert
+	dbpkg.DB.Select("alert_key").Where("alert_key LIKE ? AND status IN ?", fmt.Sprintf("rule:%d:%%", r.ID), []string{alertOpen, alertAcked}).Find(&open)
+	for _, a := range open {
+		by := "rule disabled"
+		if r.Enabled == 1 {
+			if ruleCovers(r, a.AlertKey) {
+				continue
+			}
+			by = "rule scope changed"
+		}
+		ResolveAlert(a.AlertKey, by)
+	}
+}
+
+// ruleCovers reports whether r still evaluates the alert key rule:<id>:node:<n>[:target:<t>]
+func ruleCovers(r model.AlertRule, key string) bool {
+	var ruleID, nodeID, targetID int64
+	if n, _ := fmt.Sscanf(key, "rule:%d:node:%d:target:%d", &ruleID, &nodeID, &targetID); n < 2 {
+		return false
+	}
+	if only := parseIDList(r.NodeIDs); len(only) > 0 && !only[nodeID] {
+		return false
+	}
+	probe := r.Metric == metricProbeLoss || r.Metric == metricProbeRTT
+	if probe != (targetID != 0) {
+		return false
+	}
+	return r.TargetID == 0 || targetID == r.TargetID
+}
+
+// POST /api/v1/alerts/rule/delete {id}
+// Open alerts of the rule are resolved, nothing would clear them anymore.
+func AlertRuleDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	dbpkg.DB.Delete(&model.AlertRule{}, p.ID)
+	alertMu.Lock()
+	dbpkg.DB.Model(&model.Alert{}).Where("alert_key LIKE ? AND status IN ?", fmt.Sprintf("rule:%d:%%", p.ID), []string{alertOpen, alertAcked}).
+		Updates(map[string]any{"status": alertResolved, "resolved_by": "rule deleted", "resolved_time_ms": time.Now().UnixMilli()})
+	alertMu.Unlock()
+	c.JSON(http.StatusOK, response.OkNoData())
+}
@@ -1,13 +1,150 @@
 package controller
 
 import (
-	"github.com/gin-gonic/gin"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
 	"network-panel/golang-backend/internal/app/model"
 	"network-panel/golang-backend/internal/app/response"
 	dbpkg "network-panel/golang-backend/internal/db"
 )
 
+// Alerts are incidents keyed by what they are about (node:3:offline, user:7:quota_warning).
+// Raising a key that has an open or acknowledged incident only bumps its count; raising it
+// again shortly after it was resolved reopens the same row (flapping) without a second
+// notification. An offline alert only fires after the node stayed away for the grace period.
+//
+// vite_config keys:
+//   alert_offline_grace_s   seconds a node may be disconnected before it counts as offline, default 60
+//   alert_reopen_window_s   a resolved incident raised again within this window is reopened, default 1800
+
+const (
+	alertOpen     = "open"
+	alertAcked    = "acknowledged"
+	alertResolved = "resolved"
+)
+
+// alertMu serializes the find-or-create of incidents in this process; across replicas the
+// scheduler jobs raising them run on one replica only (job_lease)
+var alertMu sync.Mutex
+
+// RaiseAlert records an occurrence of a under key. It returns the incident and whether it is
+// new, i.e. whether the occurrence should be notified.
+func RaiseAlert(key string, a model.Alert) (model.Alert, bool) {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	now := a.TimeMs
+	if now == 0 {
+		now = time.Now().UnixMilli()
+	}
+	var cur model.Alert
+	if err := dbpkg.DB.Where("alert_key = ? AND status IN ?", key, []string{alertOpen, alertAcked}).Order("id desc").First(&cur).Error; err == nil {
+		dbpkg.DB.Model(&model.Alert{}).Where("id = ?", cur.ID).Updates(map[string]any{"count": cur.Count + 1, "last_time_ms": now, "message": a.Message})
+		cur.Count, cur.LastTimeMs, cur.Message = cur.Count+1, now, a.Message
+		return cur, false
+	}
+	window := int64(configInt("alert_reopen_window_s", 1800)) * 1000
+	if window > 0 {
+		if err := dbpkg.DB.Where("alert_key = ? AND status = ? AND resolved_time_ms >= ?", key, alertResolved, now-window).Order("id desc").First(&cur).Error; err == nil {
+			dbpkg.DB.Model(&model.Alert{}).Where("id = ?", cur.ID).Updates(map[string]any{
+				"status": alertOpen, "count": cur.Count + 1, "last_time_ms": now, "message": a.Message,
+				"ack_by": "", "ack_time_ms": nil, "resolved_by": "", "resolved_time_ms": nil,
+			})
+			cur.Status, cur.Count, cur.LastTimeMs, cur.Message = alertOpen, cur.Count+1, now, a.Message
+			cur.AckBy, cur.AckTimeMs, cur.ResolvedBy, cur.ResolvedTimeMs = "", nil, "", nil
+			jlog(map[string]interface{}{"event": "alert_reopened", "key": key, "count": cur.Count})
+			return cur, false
+		}
+	}
+	a.TimeMs, a.LastTimeMs, a.AlertKey, a.Status, a.Count = now, now, key, alertOpen, 1
+	if err := dbpkg.DB.Create(&a).Error; err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "alert_create_err", "key": key, "error": err.Error()})
+	}
+	return a, true
+}
+
+// ResolveAlert closes the active incident of key; ok is false when there was none
+func ResolveAlert(key, by string) (model.Alert, bool) {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	var cur model.Alert
+	if err := dbpkg.DB.Where("alert_key = ? AND status IN ?", key, []string{alertOpen, alertAcked}).Order("id desc").First(&cur).Error; err != nil {
+		return cur, false
+	}
+	now := time.Now().UnixMilli()
+	dbpkg.DB.Model(&model.Alert{}).Where("id = ?", cur.ID).Updates(map[string]any{"status": alertResolved, "resolved_time_ms": now, "resolved_by": by})
+	cur.Status, cur.ResolvedTimeMs, cur.ResolvedBy = alertResolved, &now, by
+	return cur, true
+}
+
+func nodeAlertKey(nodeID int64, typ string) string { return fmt.Sprintf("node:%d:%s", nodeID, typ) }
+
+// NodeAlert is the alert row of a node event
+func NodeAlert(typ string, node model.Node, msg string) model.Alert {
+	nid, name := node.ID, node.Name
+	return model.Alert{TimeMs: time.Now().UnixMilli(), Type: typ, NodeID: &nid, NodeName: &name, Message: msg}
+}
+
+// RaiseNodeAlert raises the node:<id>:<typ> incident
+func RaiseNodeAlert(typ string, node model.Node, msg string) (model.Alert, bool) {
+	return RaiseAlert(nodeAlertKey(node.ID, typ), NodeAlert(typ, node, msg))
+}
+
+// ResolveNodeAlert resolves the node:<id>:<typ> incident automatically
+func ResolveNodeAlert(typ string, nodeID int64) (model.Alert, bool) {
+	return ResolveAlert(nodeAlertKey(nodeID, typ), "auto")
+}
+
+var (
+	offlineMu     sync.Mutex
+	offlineTimers = map[int64]*time.Timer{}
+)
+
+// scheduleOfflineAlert raises the offline incident once the node stayed disconnected for
+// alert_offline_grace_s; a reconnect before that cancels it.
+func scheduleOfflineAlert(node model.Node, ev NotifyEvent) {
+	fire := func() {
+		offlineMu.Lock()
+		delete(offlineTimers, node.ID)
+		offlineMu.Unlock()
+		// the node may have reconnected (here or to another replica), or been deleted meanwhile
+		var cur model.Node
+		if isNodeConnected(node.ID) || dbpkg.DB.Select("status").First(&cur, node.ID).Error != nil || (cur.Status != nil && *cur.Status == 1) {
+			return
+		}
+		if _, isNew := RaiseNodeAlert("offline", node, "节点离线"); isNew {
+			notifyCallback(ev)
+		}
+	}
+	grace := time.Duration(configInt("alert_offline_grace_s", 60)) * time.Second
+	if grace <= 0 {
+		fire()
+		return
+	}
+	offlineMu.Lock()
+	if t := offlineTimers[node.ID]; t != nil {
+		t.Stop()
+	}
+	offlineTimers[node.ID] = time.AfterFunc(grace, fire)
+	offlineMu.Unlock()
+}
+
+// cancelOfflineAlert stops a pending offline alert; true when one was pending
+func cancelOfflineAlert(nodeID int64) bool {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	t := offlineTimers[nodeID]
+	if t == nil {
+		return false
+	}
+	delete(offlineTimers, nodeID)
+	return t.Stop()
+}
+
 // POST /api/v1/alerts/recent {limit?}
 func AlertsRecent(c *gin.Context) {
 	var p struct {
@@ -21,3 +158,91 @@ func AlertsRecent(c *gin.Context) {
 	dbpkg.DB.Order("time_ms desc").Limit(p.Limit).Find(&list)
 	c.JSON(http.StatusOK, response.Ok(list))
 }
+
+// POST /api/v1/alerts/list {status?, type?, nodeId?, userId?, startMs?, endMs?, offset?, limit?}
+// status may be "active" for open + acknowledged.
+func AlertList(c *gin.Context) {
+	var p struct {
+		Status  string `json:"status"`
+		Type    string `json:"type"`
+		NodeID  int64  `json:"nodeId"`
+		UserID  int64  `json:"userId"`
+		StartMs int64  `json:"startMs"`
+		EndMs   int64  `json:"endMs"`
+		Offset  int    `json:"offset"`
+		Limit   int    `json:"limit"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Limit <= 0 || p.Limit > 200 {
+		p.Limit = 50
+	}
+	q := dbpkg.DB.Model(&model.Alert{})
+	switch p.Status {
+	case "":
+	case "active":
+		q = q.Where("status IN ?", []string{alertOpen, alertAcked})
+	default:
+		q = q.Where("status = ?", p.Status)
+	}
+	if p.Type != "" {
+		q = q.Where("type = ?", p.Type)
+	}
+	if p.NodeID > 0 {
+		q = q.Where("node_id = ?", p.NodeID)
+	}
+	if p.UserID > 0 {
+		q = q.Where("user_id = ?", p.UserID)
+	}
+	if p.StartMs > 0 {
+		q = q.Where("time_ms >= ?", p.StartMs)
+	}
+	if p.EndMs > 0 {
+		q = q.Where("time_ms < ?", p.EndMs)
+	}
+	var total int64
+	q.Count(&total)
+	var list []model.Alert
+	q.Order("time_ms desc").Offset(p.Offset).Limit(p.Limit).Find(&list)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"list": list, "total": total}))
+}
+
+// POST /api/v1/alerts/ack {ids}
+func AlertAck(c *gin.Context) {
+	var p struct {
+		IDs []int64 `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || len(p.IDs) == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	res := dbpkg.DB.Model(&model.Alert{}).Where("id IN ? AND status = ?", p.IDs, alertOpen).
+		Updates(map[string]any{"status": alertAcked, "ack_by": operatorName(c), "ack_time_ms": time.Now().UnixMilli()})
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"updated": res.RowsAffected}))
+}
+
+// POST /api/v1/alerts/resolve {ids}
+func AlertResolve(c *gin.Context) {
+	var p struct {
+		IDs []int64 `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || len(p.IDs) == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	alertMu.Lock()
+	res := dbpkg.DB.Model(&model.Alert{}).Where("id IN ? AND status IN ?", p.IDs, []string{alertOpen, alertAcked}).
+		Updates(map[string]any{"status": alertResolved, "resolved_by": operatorName(c), "resolved_time_ms": time.Now().UnixMilli()})
+	alertMu.Unlock()
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"updated": res.RowsAffected}))
+}
+
+// operatorName is the name of the logged in user, for audit fields
+func operatorName(c *gin.Context) string {
+	uidInf, _ := c.Get("user_id")
+	uid, _ := uidInf.(int64)
+	var u model.User
+	if err := dbpkg.DB.First(&u, uid).Error; err != nil {
+		return fmt.Sprintf("#%d", uid)
+	}
+	return u.User
+}
@@ -1,20 +1,28 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"network-panel/golang-backend/internal/app/model"
 	"network-panel/golang-backend/internal/app/response"
+	"network-panel/golang-backend/internal/config"
 	dbpkg "network-panel/golang-backend/internal/db"
+	"network-panel/golang-backend/internal/logx"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // POST /api/v1/config/list
+// Site settings that are set: the public ones for everybody, all of them for admins.
 func ConfigList(c *gin.Context) {
+	admin := configAdmin(c)
 	var items []model.ViteConfig
-	dbpkg.DB.Find(&items)
+	dbpkg.DB.Where("name IN ?", siteSettingKeys(admin)).Find(&items)
 	m := map[string]string{}
 	for _, it := range items {
 		m[it.Name] = it.Value
@@ -31,29 +39,30 @@ func ConfigGet(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	var it model.ViteConfig
-	if err := dbpkg.DB.Where("name = ?", p.Name).First(&it).Error; err != nil {
-		c.JSON(http.StatusOK, response.Ok(""))
+	if s, ok := siteSettingFor(p.Name); !ok || (!s.Public && !configAdmin(c)) {
+		c.JSON(http.StatusOK, response.ErrMsg("配置项不存在"))
 		return
 	}
-	c.JSON(http.StatusOK, response.Ok(it.Value))
+	c.JSON(http.StatusOK, response.Ok(configValue(p.Name)))
+}
+
+// POST /api/v1/config/schema
+// Known site settings with type and range, for the settings page.
+func ConfigSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, response.Ok(siteSettings))
 }
 
 // POST /api/v1/config/update {"k":"v"...}
+// All keys are validated first; one bad value rejects the whole update.
 func ConfigUpdate(c *gin.Context) {
 	var m map[string]string
 	if err := c.ShouldBindJSON(&m); err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	for k, v := range m {
-		var it model.ViteConfig
-		if err := dbpkg.DB.Where("name = ?", k).First(&it).Error; err != nil {
-			it.Name, it.Value, it.Time = k, v, timeNow()
-			dbpkg.DB.Create(&it)
-		} else {
-			dbpkg.DB.Model(&it).Updates(map[string]any{"value": v, "time": timeNow()})
-		}
+	if err := saveSettings(m); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
 	}
 	c.JSON(http.StatusOK, response.OkNoData())
 }
@@ -65,14 +74,119 @@ func ConfigUpdateSingle(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	var it model.ViteConfig
-	if err := dbpkg.DB.Where("name = ?", p.Name).First(&it).Error; err != nil {
-		it.Name, it.Value, it.Time = p.Name, p.Value, timeNow()
-		dbpkg.DB.Create(&it)
-	} else {
-		dbpkg.DB.Model(&it).Updates(map[string]any{"value": p.Value, "time": timeNow()})
+	if err := saveSettings(map[string]string{p.Name: p.Value}); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
 	}
 	c.JSON(http.StatusOK, response.OkNoData())
 }
 
+// configAdmin reports an admin token on a route that does not require one
+func configAdmin(c *gin.Context) bool {
+	roleInf, ok := c.Get("role_id")
+	return ok && roleInf == 0
+}
+
+func siteSettingKeys(admin bool) []string {
+	keys := make([]string, 0, len(siteSettings))
+	for _, s := range siteSettings {
+		if s.Public || admin {
+			keys = append(keys, s.Key)
+		}
+	}
+	return keys
+}
+
+// saveSettings validates m against siteSettings and writes it in one transaction. The
+// settings page submits every key, so values equal to the stored ones are skipped: rows
+// written before validation existed must not block saving other settings.
+func saveSettings(m map[string]string) error {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	var stored []model.ViteConfig
+	dbpkg.DB.Where("name IN ?", names).Find(&stored)
+	changed := make(map[string]string, len(m))
+	for k, v := range m {
+		changed[k] = v
+	}
+	for _, it := range stored {
+		if _, ok := siteSettingFor(it.Name); ok && strings.TrimSpace(changed[it.Name]) == it.Value {
+			delete(changed, it.Name)
+		}
+	}
+	vals, err := normalizeSettings(changed)
+	if err != nil {
+		return err
+	}
+	// alert rules read raw samples, so their windows must stay within the raw retention
+	if v, ok := vals["metrics_raw_retention_h"]; ok {
+		h := defaultRawRetentionH // "" resets to the default
+		if v != "" {
+			if h, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("metrics_raw_retention_h 必须为整数: %v", err)
+			}
+		}
+		var longest *int
+		dbpkg.DB.Model(&model.AlertRule{}).Select("MAX(window_s)").Scan(&longest)
+		if longest != nil && *longest > h*3600 {
+			return fmt.Errorf("存在窗口为 %d 秒的告警规则，metrics_raw_retention_h 不能小于 %d", *longest, (*longest+3599)/3600)
+		}
+	}
+	now := timeNow()
+	err = dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		for k, v := range vals {
+			var it model.ViteConfig
+			if tx.Where("name = ?", k).First(&it).Error != nil {
+				if err := tx.Create(&model.ViteConfig{Name: k, Value: v, Time: now}).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Model(&it).Updates(map[string]any{"value": v, "time": now}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("保存失败: %v", err)
+	}
+	for k := range vals {
+		if strings.HasPrefix(k, "callback_") {
+			migrateLegacyCallback()
+			break
+		}
+	}
+	return nil
+}
+
+// POST /api/v1/config/server
+// The server configuration in effect (file + environment), secrets masked. Read-only:
+// changes go to the config file or environment and need a restart.
+func ConfigServer(c *gin.Context) {
+	cfg := config.C
+	c.JSON(http.StatusOK, response.Ok(map[string]interface{}{
+		"config":   logx.Redact(cfg),
+		"warnings": cfg.Warnings(),
+	}))
+}
+
 func timeNow() int64 { return time.Now().UnixMilli() }
+
+// configValue reads one vite_config value, "" when unset
+func configValue(name string) string {
+	var it model.ViteConfig
+	if err := dbpkg.DB.Where("name = ?", name).First(&it).Error; err != nil {
+		return ""
+	}
+	return it.Value
+}
+
+// configInt reads an integer vite_config value, def when unset or invalid
+func configInt(name string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(configValue(name)))
+	if err != nil {
+		return def
+	}
+	return n
+}
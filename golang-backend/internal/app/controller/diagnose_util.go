@@ -1,12 +1,17 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"time"
 )
 
+// msgDiagnoseTimeout is returned when the agent did not answer in time
+const msgDiagnoseTimeout = "节点未响应诊断"
+
 // diagnoseFromNode asks a node via WS to perform TCP connect tests.
 // Node agent should support command: {type: "Diagnose", data: {requestId, host, port, protocol, count, timeoutMs}}
 // and reply: {type: "DiagnoseResult", requestId, data: {success, averageTime, packetLoss, message}}
@@ -31,14 +36,22 @@ func diagnoseFromNodeCtx(nodeID int64, host string, port int, count int, timeout
 	if ctx != nil {
 		payload["ctx"] = ctx
 	}
-	log.Printf("%s", fmt.Sprintf("{\"event\":\"diagnose_begin\",\"mode\":\"tcp\",\"nodeId\":%d,\"reqId\":\"%s\",\"host\":\"%s\",\"port\":%d,\"count\":%d,\"timeoutMs\":%d,\"ctx\":%v}", nodeID, rid, host, port, count, timeoutMs, ctx))
-	if err := sendWSCommand(nodeID, "Diagnose", payload); err != nil {
+	start := time.Now()
+	defer func() { observeDiagnose("tcp", start, ok, msg, avg) }()
+	jlogAt(slog.LevelDebug, map[string]interface{}{"event": "diagnose_begin", "mode": "tcp", "nodeId": nodeID, "reqId": rid, "host": host, "port": port, "count": count, "timeoutMs": timeoutMs, "ctx": ctx})
+	ctxT, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	res, err := nodeRequest(ctxT, nodeID, "Diagnose", rid, payload)
+	if err != nil {
+		var nerr *nodeReplyError
+		if errors.As(err, &nerr) {
+			return 0, 100, false, nerr.Message, rid
+		}
+		if ctxT.Err() != nil {
+			return 0, 100, false, msgDiagnoseTimeout, rid
+		}
 		return 0, 100, false, "节点未在线或密钥不匹配", rid
 	}
-	res, ok2 := RequestDiagnose(nodeID, payload, 8*time.Second)
-	if !ok2 {
-		return 0, 100, false, "节点未响应诊断", rid
-	}
 	data, _ := res["data"].(map[string]interface{})
 	if data == nil {
 		return 0, 100, false, "诊断结果无数据", rid
@@ -105,14 +118,22 @@ func diagnosePingFromNodeCtx(nodeID int64, host string, count int, timeoutMs int
 	if ctx != nil {
 		payload["ctx"] = ctx
 	}
-	log.Printf("%s", fmt.Sprintf("{\"event\":\"diagnose_begin\",\"mode\":\"icmp\",\"nodeId\":%d,\"reqId\":\"%s\",\"host\":\"%s\",\"count\":%d,\"timeoutMs\":%d,\"ctx\":%v}", nodeID, rid, host, count, timeoutMs, ctx))
-	if err := sendWSCommand(nodeID, "Diagnose", payload); err != nil {
+	start := time.Now()
+	defer func() { observeDiagnose("icmp", start, ok, msg, avg) }()
+	jlogAt(slog.LevelDebug, map[string]interface{}{"event": "diagnose_begin", "mode": "icmp", "nodeId": nodeID, "reqId": rid, "host": host, "count": count, "timeoutMs": timeoutMs, "ctx": ctx})
+	ctxT, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	res, err := nodeRequest(ctxT, nodeID, "Diagnose", rid, payload)
+	if err != nil {
+		var nerr *nodeReplyError
+		if errors.As(err, &nerr) {
+			return 0, 100, false, nerr.Message, rid
+		}
+		if ctxT.Err() != nil {
+			return 0, 100, false, msgDiagnoseTimeout, rid
+		}
 		return 0, 100, false, "节点未在线或密钥不匹配", rid
 	}
-	res, ok2 := RequestDiagnose(nodeID, payload, 8*time.Second)
-	if !ok2 {
-		return 0, 100, false, "节点未响应诊断", rid
-	}
 	data, _ := res["data"].(map[string]interface{})
 	if data == nil {
 		return 0, 100, false, "诊断结果无数据", rid
@@ -0,0 +1,409 @@
+package controller
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// driftConcurrency bounds the nodes queried at once by the drift report
+const driftConcurrency = 16
+
+// driftField describes a single config field that differs between panel and node
+type driftField struct {
+	Field   string `json:"field"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+}
+
+type driftMismatch struct {
+	Name   string       `json:"name"`
+	Fields []driftField `json:"fields"`
+}
+
+type nodeDrift struct {
+	NodeID     int64           `json:"nodeId"`
+	NodeName   string          `json:"nodeName"`
+	Online     bool            `json:"online"`
+	Answered   bool            `json:"answered"`
+	Missing    []string        `json:"missing"`
+	Extra      []string        `json:"extra"`
+	Mismatched []driftMismatch `json:"mismatched"`
+	InSync     bool            `json:"inSync"`
+	CheckedAt  int64           `json:"checkedAt"`
+
+	// kept for apply/adopt; not part of the report
+	desired map[string]map[string]any
+	actual  map[string]map[string]any
+}
+
+// POST /api/v1/node/drift {nodeId?}
+// Compares desired services (panel DB) against gost.json reported by each online node.
+func NodeDrift(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	var nodes []model.Node
+	q := dbpkg.DB.Order("id asc")
+	if p.NodeID > 0 {
+		q = q.Where("id = ?", p.NodeID)
+	}
+	q.Find(&nodes)
+	// nodes are queried in parallel so offline ones do not add up their timeouts
+	out := make([]nodeDrift, len(nodes))
+	sem := make(chan struct{}, driftConcurrency)
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, n model.Node) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i] = computeNodeDrift(n)
+		}(i, n)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// POST /api/v1/node/drift/apply {nodeId, services?:[name]}
+// Pushes the desired config: adds missing, overwrites mismatched and removes panel-managed extras.
+func NodeDriftApply(c *gin.Context) {
+	var p struct {
+		NodeID   int64    `json:"nodeId" binding:"required"`
+		Services []string `json:"services"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.NodeID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	d := computeNodeDrift(n)
+	if !d.Answered {
+		c.JSON(http.StatusOK, response.ErrMsg("节点未在线或未响应"))
+		return
+	}
+	want := nameFilter(p.Services)
+	upserts := make([]map[string]any, 0)
+	for _, name := range d.Missing {
+		if want(name) {
+			upserts = append(upserts, d.desired[name])
+		}
+	}
+	for _, mm := range d.Mismatched {
+		if want(mm.Name) {
+			upserts = append(upserts, d.desired[mm.Name])
+		}
+	}
+	removes := make([]string, 0)
+	for _, name := range d.Extra {
+		if want(name) {
+			removes = append(removes, name)
+		}
+	}
+	if len(upserts) > 0 {
+		if err := sendWSCommand(n.ID, "AddService", upserts); err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("发送到节点失败: "+err.Error()))
+			return
+		}
+	}
+	if len(removes) > 0 {
+		_ = sendWSCommand(n.ID, "DeleteService", map[string]any{"services": removes})
+	}
+	if len(upserts)+len(removes) > 0 {
+		_ = sendWSCommand(n.ID, "RestartGost", map[string]any{"reason": "drift_apply"})
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"pushed": len(upserts), "removed": len(removes)}))
+}
+
+// POST /api/v1/node/drift/adopt {nodeId, services?:[name]}
+// Accepts the node's actual config: mismatched forwards are updated from gost.json,
+// extras are released from panel management. Missing services cannot be adopted.
+func NodeDriftAdopt(c *gin.Context) {
+	var p struct {
+		NodeID   int64    `json:"nodeId" binding:"required"`
+		Services []string `json:"services"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.NodeID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	d := computeNodeDrift(n)
+	if !d.Answered {
+		c.JSON(http.StatusOK, response.ErrMsg("节点未在线或未响应"))
+		return
+	}
+	want := nameFilter(p.Services)
+	adopted := 0
+	skipped := make([]string, 0)
+	for _, mm := range d.Mismatched {
+		if !want(mm.Name) {
+			continue
+		}
+		if adoptForwardFromService(mm.Name, d.actual[mm.Name]) {
+			adopted++
+		} else {
+			skipped = append(skipped, mm.Name)
+		}
+	}
+	released := make([]map[string]any, 0)
+	for _, name := range d.Extra {
+		if !want(name) {
+			continue
+		}
+		svc := cloneMap(d.actual[name])
+		if meta, ok := svc["metadata"].(map[string]any); ok {
+			meta = cloneMap(meta)
+			delete(meta, "managedBy")
+			delete(meta, "managedby")
+			svc["metadata"] = meta
+		}
+		released = append(released, svc)
+	}
+	if len(released) > 0 {
+		_ = sendWSCommand(n.ID, "UpdateService", released)
+	}
+	for _, name := range d.Missing {
+		if want(name) {
+			skipped = append(skipped, name)
+		}
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"adopted": adopted, "released": len(released), "skipped": skipped}))
+}
+
+// computeNodeDrift diffs desiredServices against the node's gost.json (via QueryServices detail).
+func computeNodeDrift(n model.Node) nodeDrift {
+	d := nodeDrift{
+		NodeID:     n.ID,
+		NodeName:   n.Name,
+		Online:     isNodeConnected(n.ID),
+		Missing:    []string{},
+		Extra:      []string{},
+		Mismatched: []driftMismatch{},
+		CheckedAt:  time.Now().UnixMilli(),
+		desired:    map[string]map[string]any{},
+		actual:     map[string]map[string]any{},
+	}
+	for _, svc := range desiredServices(n.ID) {
+		if name, _ := svc["name"].(string); name != "" {
+			d.desired[name] = svc
+		}
+	}
+	if !d.Online {
+		return d
+	}
+	items, ok := queryNodeServicesDetail(n.ID, true)
+	if !ok {
+		return d
+	}
+	d.Answered = true
+	managed := map[string]bool{}
+	summaryOnly := map[string]bool{}
+	for _, it := range items {
+		name, _ := it["name"].(string)
+		if name == "" {
+			continue
+		}
+		cfg, _ := it["config"].(map[string]any)
+		if cfg == nil {
+			// older agents without detail support: fall back to summary fields
+			cfg = map[string]any{"name": name, "addr": it["addr"], "metadata": it["metadata"]}
+			if h, _ := it["handler"].(string); h != "" {
+				cfg["handler"] = map[string]any{"type": h}
+			}
+			summaryOnly[name] = true
+		}
+		d.actual[name] = cfg
+		if meta, _ := cfg["metadata"].(map[string]any); meta != nil {
+			if v, _ := meta["managedBy"].(string); v == "network-panel" {
+				managed[name] = true
+			} else if v, _ := meta["managedby"].(string); v == "network-panel" {
+				managed[name] = true
+			}
+		}
+	}
+	for name, want := range d.desired {
+		got, ok := d.actual[name]
+		if !ok {
+			d.Missing = append(d.Missing, name)
+			continue
+		}
+		keys := driftFieldKeys
+		if summaryOnly[name] {
+			keys = driftSummaryKeys
+		}
+		if fields := diffServiceConfig(want, got, keys); len(fields) > 0 {
+			d.Mismatched = append(d.Mismatched, driftMismatch{Name: name, Fields: fields})
+		}
+	}
+	for name := range d.actual {
+		if _, ok := d.desired[name]; !ok && managed[name] && !isAuxService(n.ID, name) {
+			d.Extra = append(d.Extra, name)
+		}
+	}
+	sort.Strings(d.Missing)
+	sort.Strings(d.Extra)
+	sort.Slice(d.Mismatched, func(i, j int) bool { return d.Mismatched[i].Name < d.Mismatched[j].Name })
+	d.InSync = len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Mismatched) == 0
+	return d
+}
+
+// isAuxService reports panel-managed services that desiredServices does not model
+// (tunnel-forward legs, multi-hop mids, temporary iperf3 chains, SS exits).
+func isAuxService(nodeID int64, name string) bool {
+	if strings.Contains(name, "_mid_") || strings.HasPrefix(name, "tmp_iperf3_") || strings.HasPrefix(name, "exit_ss_") {
+		return true
+	}
+	fid := forwardIDFromServiceName(name)
+	if fid == 0 {
+		return false
+	}
+	var f model.Forward
+	if err := dbpkg.DB.First(&f, fid).Error; err != nil {
+		return false
+	}
+	var t model.Tunnel
+	if err := dbpkg.DB.First(&t, f.TunnelID).Error; err != nil {
+		return false
+	}
+	// tunnel-forward services are managed at create/update time
+	if t.Type == 2 {
+		return true
+	}
+	// port-forward hops reuse the same service name on every path node
+	for _, nid := range getTunnelPathNodes(t.ID) {
+		if nid == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// driftFieldKeys are the service fields the panel controls
+	driftFieldKeys = []string{"addr", "listener.type", "handler.type", "handler.chain", "forwarder.nodes", "metadata.interface"}
+	// driftSummaryKeys are comparable when the agent only returns the QueryServices summary
+	driftSummaryKeys = []string{"addr", "handler.type", "metadata.interface"}
+)
+
+// diffServiceConfig compares the given fields of a desired and an actual gost service.
+func diffServiceConfig(want, got map[string]any, keys []string) []driftField {
+	fields := make([]driftField, 0)
+	for _, k := range keys {
+		a, b := serviceField(want, k), serviceField(got, k)
+		if a != b {
+			fields = append(fields, driftField{Field: k, Desired: a, Actual: b})
+		}
+	}
+	return fields
+}
+
+func serviceField(svc map[string]any, key string) string {
+	switch key {
+	case "addr":
+		s, _ := svc["addr"].(string)
+		return s
+	case "forwarder.nodes":
+		fwd, _ := svc["forwarder"].(map[string]any)
+		if fwd == nil {
+			return ""
+		}
+		addrs := make([]string, 0)
+		switch nodes := fwd["nodes"].(type) {
+		case []any:
+			for _, it := range nodes {
+				if m, ok := it.(map[string]any); ok {
+					if a, _ := m["addr"].(string); a != "" {
+						addrs = append(addrs, a)
+					}
+				}
+			}
+		case []map[string]any:
+			for _, m := range nodes {
+				if a, _ := m["addr"].(string); a != "" {
+					addrs = append(addrs, a)
+				}
+			}
+		}
+		return strings.Join(addrs, ",")
+	}
+	parts := strings.SplitN(key, ".", 2)
+	sub, _ := svc[parts[0]].(map[string]any)
+	if sub == nil {
+		return ""
+	}
+	s, _ := sub[parts[1]].(string)
+	return s
+}
+
+// adoptForwardFromService writes a node's actual port/target/interface back into the forward row.
+func adoptForwardFromService(name string, svc map[string]any) bool {
+	fid := forwardIDFromServiceName(name)
+	if fid == 0 || svc == nil {
+		return false
+	}
+	var f model.Forward
+	if err := dbpkg.DB.First(&f, fid).Error; err != nil {
+		return false
+	}
+	upd := map[string]any{"updated_time": time.Now().UnixMilli()}
+	if p := parsePort(serviceField(svc, "addr")); p > 0 {
+		upd["in_port"] = p
+	}
+	if t := serviceField(svc, "forwarder.nodes"); t != "" {
+		upd["remote_addr"] = t
+	}
+	if iface := serviceField(svc, "metadata.interface"); iface != "" {
+		upd["interface_name"] = iface
+	} else {
+		upd["interface_name"] = nil
+	}
+	return dbpkg.DB.Model(&model.Forward{}).Where("id = ?", fid).Updates(upd).Error == nil
+}
+
+// forwardIDFromServiceName parses the forward ID from forwardId_userId_userTunnelId
+func forwardIDFromServiceName(name string) int64 {
+	parts := strings.Split(name, "_")
+	if len(parts) != 3 {
+		return 0
+	}
+	id, _ := strconv.ParseInt(parts[0], 10, 64)
+	return id
+}
+
+func nameFilter(names []string) func(string) bool {
+	if len(names) == 0 {
+		return func(string) bool { return true }
+	}
+	set := map[string]struct{}{}
+	for _, n := range names {
+		set[n] = struct{}{}
+	}
+	return func(n string) bool { _, ok := set[n]; return ok }
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -1,29 +1,29 @@
 package controller
 
 import (
+	"context"
+	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 	"network-panel/golang-backend/internal/app/dto"
 	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
 	dbpkg "network-panel/golang-backend/internal/db"
 )
 
 func FlowConfig(c *gin.Context) { c.String(http.StatusOK, "ok") }
 func FlowTest(c *gin.Context)   { c.String(http.StatusOK, "test") }
 
-// POST /flow/upload?secret=...
-// Updates forward/user/usertunnel flow counters and pauses when limits exceeded.
+// POST /flow/upload?secret=...&rid=...
+// Queues forward/user/usertunnel flow counters; the flusher applies them and pauses when limits exceeded.
+// rid (optional) makes a retried report idempotent.
 func FlowUpload(c *gin.Context) {
 	secret := c.Query("secret")
 	// validate node by secret (silent fail to avoid leaking info)
-	var nodeCount int64
-	dbpkg.DB.Model(&model.Node{}).Where("secret = ?", secret).Count(&nodeCount)
-	if nodeCount == 0 {
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", secret).First(&node).Error; err != nil {
 		c.String(http.StatusOK, "ok")
 		return
 	}
@@ -33,88 +33,53 @@ func FlowUpload(c *gin.Context) {
 		c.String(http.StatusOK, "ok")
 		return
 	}
-	// ignore internal reporter name
-	if payload.N == "web_api" {
-		c.String(http.StatusOK, "ok")
-		return
-	}
+	// legacy observer reports are fire-and-forget
+	flowUploads.Inc("observer")
+	flowBuf.add(newFlowReport(node.ID, c.Query("rid"), []dto.FlowDto{payload}, false))
+	c.String(http.StatusOK, "ok")
+}
 
-	// parse service name: forwardId_userId_userTunnelId
-	parts := strings.Split(payload.N, "_")
-	if len(parts) < 3 {
-		c.String(http.StatusOK, "ok")
-		return
+// POST /api/v1/agent/flow-report {secret, epoch, seq, items:[{n,u,d}]}
+// Batched counters from the flux-agent meter. (node, epoch, seq) identifies a batch: a retried
+// upload of an already applied batch is acknowledged without counting it again.
+// The reply waits for the flush so the agent only drops batches that are committed.
+func AgentFlowReport(c *gin.Context) {
+	var p struct {
+		Secret string        `json:"secret" binding:"required"`
+		Epoch  string        `json:"epoch" binding:"required"`
+		Seq    int64         `json:"seq" binding:"required"`
+		Items  []dto.FlowDto `json:"items"`
 	}
-	fwdID, _ := strconv.ParseInt(parts[0], 10, 64)
-	userID, _ := strconv.ParseInt(parts[1], 10, 64)
-	utID, _ := strconv.ParseInt(parts[2], 10, 64)
-
-	// load forward and tunnel
-	var fwd model.Forward
-	if err := dbpkg.DB.First(&fwd, fwdID).Error; err != nil {
-		c.String(http.StatusOK, "ok")
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	var tun model.Tunnel
-	_ = dbpkg.DB.First(&tun, fwd.TunnelID).Error
-
-	// Adjust flow by tunnel.flow (1 single, 2 double). Default double.
-	inInc, outInc := payload.U, payload.D
-	if tun.Flow == 1 { // single direction: count only one side (use total as out)
-		outInc = payload.U + payload.D
-		inInc = 0
-	}
-
-	// Forward increments
-	dbpkg.DB.Model(&model.Forward{}).Where("id = ?", fwdID).
-		Updates(map[string]any{
-			"in_flow":      gorm.Expr("in_flow + ?", inInc),
-			"out_flow":     gorm.Expr("out_flow + ?", outInc),
-			"updated_time": time.Now().UnixMilli(),
-		})
-
-	// User increments
-	dbpkg.DB.Model(&model.User{}).Where("id = ?", userID).
-		Updates(map[string]any{
-			"in_flow":      gorm.Expr("in_flow + ?", inInc),
-			"out_flow":     gorm.Expr("out_flow + ?", outInc),
-			"updated_time": time.Now().UnixMilli(),
-		})
-
-	// UserTunnel increments when applicable
-	if utID != 0 {
-		dbpkg.DB.Model(&model.UserTunnel{}).Where("id = ?", utID).
-			Updates(map[string]any{
-				"in_flow":  gorm.Expr("in_flow + ?", inInc),
-				"out_flow": gorm.Expr("out_flow + ?", outInc),
-			})
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", p.Secret).First(&node).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
 	}
-
-	// Reload latest user and userTunnel to check limits
-	var user model.User
-	if err := dbpkg.DB.First(&user, userID).Error; err == nil {
-		// check total flow and expiry
-		if overUserLimit(user) || expired(user.ExpTime) || user.Status != nil && *user.Status != 1 {
-			pauseAllUserForwards(user.ID)
-			// set user status 0 if not already
-			s := 0
-			user.Status = &s
-			_ = dbpkg.DB.Save(&user).Error
+	r := newFlowReport(node.ID, fmt.Sprintf("%s-%d", p.Epoch, p.Seq), p.Items, true)
+	flowUploads.Inc("agent")
+	flowBuf.add(r)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	select {
+	case err := <-r.done:
+		if err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+			return
 		}
+	case <-ctx.Done():
+		// still queued; the agent retries and the report id keeps it from counting twice
+		c.JSON(http.StatusOK, response.ErrMsg("处理超时"))
+		return
 	}
-
-	if utID != 0 {
-		var ut model.UserTunnel
-		if err := dbpkg.DB.First(&ut, utID).Error; err == nil {
-			if overUTunnelLimit(ut) || expired(ut.ExpTime) || ut.Status != 1 {
-				pauseUserTunnelForwards(ut.UserID, ut.TunnelID)
-				ut.Status = 0
-				_ = dbpkg.DB.Save(&ut).Error
-			}
-		}
+	if r.duplicate {
+		c.JSON(http.StatusOK, response.Ok(map[string]any{"seq": p.Seq, "duplicate": true}))
+		return
 	}
-
-	c.String(http.StatusOK, "ok")
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"seq": p.Seq, "applied": r.applied}))
 }
 
 // Over user limit if flow(GiB) <= in + out
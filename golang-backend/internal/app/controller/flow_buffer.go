@@ -0,0 +1,380 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"network-panel/golang-backend/internal/app/dto"
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Flow accounting is batched: handlers queue reports and the flusher applies everything
+// queued in one transaction. A report with an id inserts its flow_report row in that same
+// transaction, so it is counted exactly once even when retried or received by two replicas.
+// Increments are summed per row first (one UPDATE per forward/user/user_tunnel per flush),
+// and limits are checked on the touched rows once per flush instead of after every report.
+
+const (
+	flowFlushInterval = 2 * time.Second
+	flowMaxAttempts   = 5     // fire-and-forget reports are retried this often when a flush fails
+	flowQueueMax      = 50000 // queued items; beyond that new reports are refused
+)
+
+const gib = int64(1024 * 1024 * 1024)
+
+var errFlowQueueFull = errors.New("flow queue full")
+
+type flowReport struct {
+	nodeID    int64
+	reportID  string // "" = no dedupe (legacy observer without rid)
+	items     []dto.FlowDto
+	entryOnly bool       // agent meters report every service on the node; only the tunnel entry counts
+	done      chan error // agent waits for the commit; nil for fire-and-forget
+	attempts  int
+
+	// results, valid once done fired
+	applied   int
+	duplicate bool
+}
+
+func newFlowReport(nodeID int64, reportID string, items []dto.FlowDto, agent bool) *flowReport {
+	r := &flowReport{nodeID: nodeID, reportID: reportID, items: items, entryOnly: agent}
+	if agent {
+		r.done = make(chan error, 1)
+	}
+	return r
+}
+
+type flowBuffer struct {
+	mu    sync.Mutex
+	queue []*flowReport
+	items int
+}
+
+var flowBuf = &flowBuffer{}
+
+func (b *flowBuffer) add(r *flowReport) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.items+len(r.items) > flowQueueMax {
+		jlogAt(slog.LevelWarn, map[string]interface{}{"event": "flow_queue_full", "nodeId": r.nodeID, "reportId": r.reportID, "items": len(r.items)})
+		if r.done != nil {
+			r.done <- errFlowQueueFull
+		}
+		return
+	}
+	b.queue = append(b.queue, r)
+	b.items += len(r.items)
+}
+
+func (b *flowBuffer) take() []*flowReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queue
+	b.queue, b.items = nil, 0
+	return q
+}
+
+// quotaEvent: an account's used flow crossed Percent of its quota during a flush
+type quotaEvent struct {
+	Scope        string `json:"scope"` // user | user_tunnel
+	UserID       int64  `json:"userId"`
+	UserTunnelID int64  `json:"userTunnelId,omitempty"`
+	TunnelID     int64  `json:"tunnelId,omitempty"`
+	Percent      int    `json:"percent"`
+	Used         int64  `json:"used"`
+	Limit        int64  `json:"limit"`
+}
+
+var (
+	quotaListenerMu sync.RWMutex
+	quotaListeners  []func(quotaEvent)
+)
+
+// onQuotaEvent registers a listener; listeners run on the flusher goroutine after commit
+func onQuotaEvent(fn func(quotaEvent)) {
+	quotaListenerMu.Lock()
+	quotaListeners = append(quotaListeners, fn)
+	quotaListenerMu.Unlock()
+}
+
+func emitQuotaEvent(ev quotaEvent) {
+	jlog(map[string]interface{}{"event": "flow_quota", "scope": ev.Scope, "userId": ev.UserID, "userTunnelId": ev.UserTunnelID, "percent": ev.Percent, "used": ev.Used, "limit": ev.Limit})
+	quotaListenerMu.RLock()
+	ls := make([]func(quotaEvent), len(quotaListeners))
+	copy(ls, quotaListeners)
+	quotaListenerMu.RUnlock()
+	for _, fn := range ls {
+		fn(ev)
+	}
+}
+
+// RunFlowFlusher applies queued flow reports every flowFlushInterval; when ctx is done it
+// flushes what is left and returns
+func RunFlowFlusher(ctx context.Context) {
+	t := time.NewTicker(flowFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushFlow()
+			return
+		case <-t.C:
+			flushFlow()
+		}
+	}
+}
+
+// flowFlushResult is what a committed flush leaves to do outside the transaction
+type flowFlushResult struct {
+	events      []quotaEvent
+	pauseUsers  []int64
+	pauseTunnel []model.UserTunnel
+	userInc     map[int64]*flowDelta // committed increments, for metrics
+	fwdInc      map[int64]*flowDelta
+}
+
+func flushFlow() {
+	batch := flowBuf.take()
+	if len(batch) == 0 {
+		return
+	}
+	var res flowFlushResult
+	err := dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		res, err = applyFlowReports(tx, batch)
+		return err
+	})
+	if err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "flow_flush_err", "reports": len(batch), "error": err.Error()})
+		flowFlushes.Inc("error")
+		for _, r := range batch {
+			r.applied, r.duplicate = 0, false
+			if r.done != nil {
+				r.done <- err
+				continue
+			}
+			if r.attempts++; r.attempts < flowMaxAttempts {
+				flowBuf.add(r)
+			}
+		}
+		return
+	}
+	flowFlushes.Inc("ok")
+	countFlowBytes(res)
+	for _, r := range batch {
+		if r.done != nil {
+			r.done <- nil
+		}
+	}
+	for _, ev := range res.events {
+		emitQuotaEvent(ev)
+	}
+	for _, id := range res.pauseUsers {
+		pauseAllUserForwards(id)
+	}
+	for _, ut := range res.pauseTunnel {
+		pauseUserTunnelForwards(ut.UserID, ut.TunnelID)
+	}
+}
+
+type flowDelta struct{ in, out int64 }
+
+func (d *flowDelta) total() int64 { return d.in + d.out }
+
+// flowTarget caches the forward -> tunnel lookups of one flush
+type flowTarget struct {
+	ok       bool
+	inNodeID int64
+	flow     int // tunnel.flow: 1 single, 2 double
+}
+
+func applyFlowReports(tx *gorm.DB, batch []*flowReport) (flowFlushResult, error) {
+	var res flowFlushResult
+	now := time.Now().UnixMilli()
+	thresholds := quotaThresholds()
+	fwdInc := map[int64]*flowDelta{}
+	userInc := map[int64]*flowDelta{}
+	utInc := map[int64]*flowDelta{}
+	targets := map[int64]flowTarget{}
+	add := func(m map[int64]*flowDelta, id, in, out int64) {
+		d := m[id]
+		if d == nil {
+			d = &flowDelta{}
+			m[id] = d
+		}
+		d.in += in
+		d.out += out
+	}
+
+	for _, r := range batch {
+		if r.reportID != "" {
+			var bytes int64
+			for _, it := range r.items {
+				bytes += it.U + it.D
+			}
+			rep := model.FlowReport{NodeID: r.nodeID, ReportID: r.reportID, Items: len(r.items), Bytes: bytes, CreatedTime: now}
+			ins := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rep)
+			if ins.Error != nil {
+				return res, ins.Error
+			}
+			if ins.RowsAffected == 0 {
+				r.duplicate = true
+				continue
+			}
+		}
+		for _, it := range r.items {
+			fwdID, userID, utID, ok := parseFlowServiceName(it.N)
+			if !ok {
+				continue
+			}
+			t, seen := targets[fwdID]
+			if !seen {
+				t = loadFlowTarget(tx, fwdID)
+				targets[fwdID] = t
+			}
+			if !t.ok || (r.entryOnly && t.inNodeID != r.nodeID) {
+				continue
+			}
+			// Adjust flow by tunnel.flow (1 single, 2 double). Default double.
+			inInc, outInc := it.U, it.D
+			if t.flow == 1 { // single direction: count only one side (use total as out)
+				outInc = it.U + it.D
+				inInc = 0
+			}
+			add(fwdInc, fwdID, inInc, outInc)
+			add(userInc, userID, inInc, outInc)
+			if utID != 0 {
+				add(utInc, utID, inInc, outInc)
+			}
+			r.applied++
+		}
+	}
+
+	for id, d := range fwdInc {
+		if err := tx.Model(&model.Forward{}).Where("id = ?", id).Updates(map[string]any{
+			"in_flow":      gorm.Expr("in_flow + ?", d.in),
+			"out_flow":     gorm.Expr("out_flow + ?", d.out),
+			"updated_time": now,
+		}).Error; err != nil {
+			return res, err
+		}
+	}
+	for id, d := range userInc {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Updates(map[string]any{
+			"in_flow":      gorm.Expr("in_flow + ?", d.in),
+			"out_flow":     gorm.Expr("out_flow + ?", d.out),
+			"updated_time": now,
+		}).Error; err != nil {
+			return res, err
+		}
+	}
+	for id, d := range utInc {
+		if err := tx.Model(&model.UserTunnel{}).Where("id = ?", id).Updates(map[string]any{
+			"in_flow":  gorm.Expr("in_flow + ?", d.in),
+			"out_flow": gorm.Expr("out_flow + ?", d.out),
+		}).Error; err != nil {
+			return res, err
+		}
+	}
+
+	res.userInc, res.fwdInc = userInc, fwdInc
+
+	// one reload of the touched rows: threshold events and limit checks
+	if len(userInc) > 0 {
+		var users []model.User
+		if err := tx.Where("id IN ?", mapKeys(userInc)).Find(&users).Error; err != nil {
+			return res, err
+		}
+		for _, u := range users {
+			used := u.InFlow + u.OutFlow
+			for _, pct := range quotaCrossed(used-userInc[u.ID].total(), used, u.Flow*gib, thresholds) {
+				res.events = append(res.events, quotaEvent{Scope: "user", UserID: u.ID, Percent: pct, Used: used, Limit: u.Flow * gib})
+			}
+			// check total flow and expiry
+			if overUserLimit(u) || expired(u.ExpTime) || u.Status != nil && *u.Status != 1 {
+				if err := tx.Model(&model.User{}).Where("id = ?", u.ID).Update("status", 0).Error; err != nil {
+					return res, err
+				}
+				res.pauseUsers = append(res.pauseUsers, u.ID)
+			}
+		}
+	}
+	if len(utInc) > 0 {
+		var uts []model.UserTunnel
+		if err := tx.Where("id IN ?", mapKeys(utInc)).Find(&uts).Error; err != nil {
+			return res, err
+		}
+		for _, ut := range uts {
+			used := ut.InFlow + ut.OutFlow
+			for _, pct := range quotaCrossed(used-utInc[ut.ID].total(), used, ut.Flow*gib, thresholds) {
+				res.events = append(res.events, quotaEvent{Scope: "user_tunnel", UserID: ut.UserID, UserTunnelID: ut.ID, TunnelID: ut.TunnelID, Percent: pct, Used: used, Limit: ut.Flow * gib})
+			}
+			if overUTunnelLimit(ut) || expired(ut.ExpTime) || ut.Status != 1 {
+				if err := tx.Model(&model.UserTunnel{}).Where("id = ?", ut.ID).Update("status", 0).Error; err != nil {
+					return res, err
+				}
+				res.pauseTunnel = append(res.pauseTunnel, ut)
+			}
+		}
+	}
+	return res, nil
+}
+
+func loadFlowTarget(tx *gorm.DB, fwdID int64) flowTarget {
+	var fwd model.Forward
+	if err := tx.First(&fwd, fwdID).Error; err != nil {
+		return flowTarget{}
+	}
+	var tun model.Tunnel
+	_ = tx.First(&tun, fwd.TunnelID).Error
+	return flowTarget{ok: true, inNodeID: tun.InNodeID, flow: tun.Flow}
+}
+
+// parseFlowServiceName splits forwardId_userId_userTunnelId
+func parseFlowServiceName(name string) (fwdID, userID, utID int64, ok bool) {
+	// ignore internal reporter name
+	if name == "web_api" {
+		return 0, 0, 0, false
+	}
+	parts := strings.Split(name, "_")
+	if len(parts) < 3 {
+		return 0, 0, 0, false
+	}
+	fwdID, _ = strconv.ParseInt(parts[0], 10, 64)
+	userID, _ = strconv.ParseInt(parts[1], 10, 64)
+	utID, _ = strconv.ParseInt(parts[2], 10, 64)
+	return fwdID, userID, utID, fwdID > 0
+}
+
+// quotaCrossed returns the thresholds passed when usage went from before to after
+func quotaCrossed(before, after, limit int64, thresholds []int) []int {
+	if limit <= 0 {
+		return nil
+	}
+	var out []int
+	for _, pct := range thresholds {
+		thr := limit * int64(pct) / 100
+		if before <= thr && after > thr {
+			out = append(out, pct)
+		}
+	}
+	return out
+}
+
+func mapKeys(m map[int64]*flowDelta) []int64 {
+	out := make([]int64, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -1,15 +1,14 @@
 package controller
 
 import (
+    "context"
     "fmt"
-    "encoding/json"
-    "log"
+    "log/slog"
     "net"
     "net/http"
     "network-panel/golang-backend/internal/app/util"
     "strings"
     "time"
-    "strconv"
 
     "github.com/gin-gonic/gin"
     "network-panel/golang-backend/internal/app/dto"
@@ -41,22 +40,15 @@ func ForwardCreate(c *gin.Context) {
 			return
 		}
 	}
-    // allocate inPort if nil: find first port in range not used
-    inPort := 0
-    if req.InPort != nil {
-        inPort = *req.InPort
-    } else {
-        inPort = firstFreePort(tun.InNodeID, tun, 0)
-    }
-    // agent-level verification and range enforcement on entry node
-    var inNode model.Node
-    _ = dbpkg.DB.First(&inNode, tun.InNodeID).Error
-    minP := 10000; maxP := 65535
-    if inNode.PortSta > 0 { minP = inNode.PortSta }
-    if inNode.PortEnd > 0 { maxP = inNode.PortEnd }
+    // reserve inPort in the port registry on the entry node (multi-level port forwards
+    // listen on the same port on every hop, so reserve it there too)
+    minP, maxP := nodePortRange(tun.InNodeID)
+    prefer := 0
+    if req.InPort != nil { prefer = *req.InPort }
     // if provided port not in range, ignore it and pick a free one in range
-    if inPort < minP || inPort > maxP { inPort = 0 }
-    inPort = findFreePortOnNode(tun.InNodeID, inPort, minP, maxP)
+    if prefer < minP || prefer > maxP { prefer = 0 }
+    inNodes := forwardInNodes(tun)
+    inPort := allocPort(inNodes, prefer, minP, maxP, 0, "")
     if inPort == 0 {
         c.JSON(http.StatusOK, response.ErrMsg("隧道入口端口已满，无法分配新端口"))
         return
@@ -64,203 +56,42 @@ func ForwardCreate(c *gin.Context) {
 	now := time.Now().UnixMilli()
 	f := model.Forward{BaseEntity: model.BaseEntity{CreatedTime: now, UpdatedTime: now}, UserID: uid, Name: req.Name, TunnelID: req.TunnelID, InPort: inPort, RemoteAddr: req.RemoteAddr, InterfaceName: req.InterfaceName, Strategy: req.Strategy}
 	// allocate outPort for legacy tunnel-forward only (no SS params)
+    exitID := outNodeIDOr0(tun)
     if tun.Type == 2 {
         if !(req.SsPort != nil && req.SsPassword != nil && *req.SsPassword != "") {
-            if op := firstFreePortOut(tun, 0); op != 0 {
-                // verify against agent services on exit node
-                exitID := outNodeIDOr0(tun)
-                var outNode model.Node
-                _ = dbpkg.DB.First(&outNode, exitID).Error
-                minO := 10000; maxO := 65535
-                if outNode.PortSta > 0 { minO = outNode.PortSta }
-                if outNode.PortEnd > 0 { maxO = outNode.PortEnd }
-                free := findFreePortOnNode(exitID, op, minO, maxO)
-                if free == 0 { c.JSON(http.StatusOK, response.ErrMsg("隧道出口端口已满，无法分配新端口")); return }
-                f.OutPort = &free
-            } else {
+            free := 0
+            if exitID > 0 {
+                minO, maxO := nodePortRange(exitID)
+                free = allocPort([]int64{exitID}, 0, minO, maxO, 0, "")
+            }
+            if free == 0 {
+                releasePort(inNodes, inPort, 0)
                 c.JSON(http.StatusOK, response.ErrMsg("隧道出口端口已满，无法分配新端口"))
                 return
             }
+            f.OutPort = &free
         }
     }
 	if err := dbpkg.DB.Create(&f).Error; err != nil {
+		releasePort(inNodes, inPort, 0)
+		if f.OutPort != nil { releasePort([]int64{exitID}, *f.OutPort, 0) }
 		c.JSON(http.StatusOK, response.ErrMsg("端口转发创建失败"))
 		return
 	}
     // push to node(s)
     name := buildServiceName(f.ID, f.UserID, f.TunnelID)
-    if tun.Type == 2 && f.OutPort != nil {
-        // gRPC HTTP 隧道（出口=relay+grpc，入口=http+chain(dialer=grpc, connector=relay)）
-		user := fmt.Sprintf("u-%d", f.ID)
-		pass := util.MD5(fmt.Sprintf("%d:%d", f.ID, f.CreatedTime))[:16]
-        outSvc := map[string]any{
-            "name":     name,
-            "addr":     fmt.Sprintf(":%d", *f.OutPort),
-            "listener": map[string]any{"type": "grpc"},
-            // 出口不再配置 chain，仅作为 relay 服务端
-            "handler":  map[string]any{"type": "relay", "auth": map[string]any{"username": user, "password": pass}},
-            "metadata": map[string]any{"managedBy": "network-panel", "managedby": "network-panel"},
-        }
-		_ = sendWSCommand(outNodeIDOr0(tun), "AddService", []map[string]any{outSvc})
-
-        outIP := getOutNodeIP(tun)
-        exitAddr := safeHostPort(outIP, *f.OutPort)
-
-        // Multi-level path support for tunnel-forward:
-        // - exit: relay over gRPC (server)
-        // - mids: plain TCP forward (listen on port, forward to next hop addr:port)
-        // - entry: HTTP handler with chain(connector=relay, dialer=grpc) targeting FIRST MID addr:port
-        path := getTunnelPathNodes(tun.ID)
-        if len(path) > 0 {
-            // Pre-allocate TCP ports on mids (avoid conflicts using agent query), enforce each node's port range
-            midPorts := make([]int, len(path))
-            for i := range path {
-                // prefer use inPort as baseline, within node port range if needed
-                var n model.Node
-                _ = dbpkg.DB.First(&n, path[i]).Error
-                minP := 10000; maxP := 65535
-                if n.PortSta > 0 { minP = n.PortSta }
-                if n.PortEnd > 0 { maxP = n.PortEnd }
-                prefer := f.InPort
-                if prefer < minP || prefer > maxP { prefer = 0 }
-                midPorts[i] = findFreePortOnNode(path[i], prefer, minP, maxP)
-                if midPorts[i] == 0 { midPorts[i] = f.InPort }
-            }
-            // Read per-node interface mapping
-            ifaceMap := getTunnelIfaceMap(tun.ID)
-            // Deploy simple TCP forward on each mid to the next hop
-            for i := 0; i < len(path); i++ {
-                nid := path[i]
-                thisPort := midPorts[i]
-                var target string
-                if i < len(path)-1 {
-                    var nx model.Node
-                    if err := dbpkg.DB.First(&nx, path[i+1]).Error; err != nil { continue }
-                    target = safeHostPort(preferIPv4(nx), midPorts[i+1])
-                } else {
-                    // last mid forwards to exit relay (gRPC) address directly
-                    target = exitAddr
-                }
-                midName := fmt.Sprintf("%s_mid_%d", name, i)
-                var iface *string
-                if ip, ok := ifaceMap[nid]; ok && ip != "" { tmp := ip; iface = &tmp }
-                // bind IP (in IP) for mids and exit (nid != entry)
-                bindMap := getTunnelBindMap(tun.ID)
-                addrStr := fmt.Sprintf(":%d", thisPort)
-                if bindIP, ok := bindMap[nid]; ok && bindIP != "" {
-                    addrStr = safeHostPort(bindIP, thisPort)
-                }
-                svc := map[string]any{
-                    "name": midName,
-                    "addr": addrStr,
-                    "listener": map[string]any{"type": "tcp"},
-                    "handler": map[string]any{"type": "forward"},
-                    "forwarder": map[string]any{"nodes": []map[string]any{{"name":"target", "addr": target}}},
-                    "metadata": map[string]any{"managedBy":"network-panel", "managedby":"network-panel"},
-                }
-                if iface != nil && *iface != "" { svc["metadata"].(map[string]any)["interface"] = *iface }
-                _ = sendWSCommand(nid, "AddService", []map[string]any{svc})
-            }
-            // Entry node: forward handler + chain to first mid address using dialer=grpc（负载将被各中间节点逐跳TCP转发）
-            var first model.Node
-            if err := dbpkg.DB.First(&first, path[0]).Error; err == nil {
-                inSvc := map[string]any{
-                    "name":     name,
-                    "addr":     fmt.Sprintf(":%d", f.InPort),
-                    "listener": map[string]any{"type": "tcp"},
-                    "handler":  map[string]any{"type": "forward", "chain": "chain_" + name},
-                    "metadata": map[string]any{"managedBy": "network-panel", "managedby": "network-panel"},
-                }
-                // attach interface for entry if configured
-                if ip, ok := ifaceMap[tun.InNodeID]; ok && ip != "" {
-                    if meta, ok2 := inSvc["metadata"].(map[string]any); ok2 { meta["interface"] = ip }
-                }
-                chainName := "chain_" + name
-                hopName := "hop_" + name
-                node := map[string]any{
-                    "name":      "node-" + name,
-                    // Important: dial to FIRST MID address; bytes are gRPC frames that will be forwarded hop-by-hop to exit
-                    "addr":      safeHostPort(preferIPv4(first), midPorts[0]),
-                    "connector": map[string]any{"type": "relay", "auth": map[string]any{"username": user, "password": pass}},
-                    "dialer":    map[string]any{"type": "grpc"},
-                }
-                inSvc["_chains"] = []any{map[string]any{"name": chainName, "metadata": map[string]any{"managedBy": "network-panel"}, "hops": []any{map[string]any{"name": hopName, "nodes": []any{node}}}}}
-                // forwarder 目标为远程地址（取第一项）
-                inSvc["forwarder"] = map[string]any{"nodes": []map[string]any{{"name": "target", "addr": firstTargetHost(f.RemoteAddr)}}}
-                _ = sendWSCommand(tun.InNodeID, "AddService", []map[string]any{inSvc})
-            }
-        } else {
-            // Original single-hop: in -> exit relay
-            inSvc := map[string]any{
-                "name":     name,
-                "addr":     fmt.Sprintf(":%d", f.InPort),
-                "listener": map[string]any{"type": "tcp"},
-                "handler":  map[string]any{"type": "forward", "chain": "chain_" + name},
-                "metadata": map[string]any{"managedBy": "network-panel", "managedby": "network-panel"},
-            }
-            chainName := "chain_" + name
-            hopName := "hop_" + name
-            node := map[string]any{
-                "name":      "node-" + name,
-                "addr":      exitAddr,
-                "connector": map[string]any{"type": "relay", "auth": map[string]any{"username": user, "password": pass}},
-                "dialer":    map[string]any{"type": "grpc"},
-            }
-            inSvc["_chains"] = []any{map[string]any{"name": chainName, "metadata": map[string]any{"managedBy": "network-panel"}, "hops": []any{map[string]any{"name": hopName, "nodes": []any{node}}}}}
-            // forwarder 目标为远程地址（取第一项）
-            inSvc["forwarder"] = map[string]any{"nodes": []map[string]any{{"name": "target", "addr": firstTargetHost(f.RemoteAddr)}}}
-            _ = sendWSCommand(tun.InNodeID, "AddService", []map[string]any{inSvc})
-            // restart in & out to ensure effect
-            _ = sendWSCommand(tun.InNodeID, "RestartGost", map[string]any{"reason":"forward_create"})
-            _ = sendWSCommand(outNodeIDOr0(tun), "RestartGost", map[string]any{"reason":"forward_create"})
-        }
-        // restart gost on involved nodes to ensure services take effect
-        nodesToRestart := make(map[int64]struct{})
-        nodesToRestart[tun.InNodeID] = struct{}{}
-        if len(path) > 0 { for _, nid := range path { nodesToRestart[nid] = struct{}{} } }
-        nodesToRestart[outNodeIDOr0(tun)] = struct{}{}
-        for nid := range nodesToRestart { if nid > 0 { _ = sendWSCommand(nid, "RestartGost", map[string]any{"reason":"forward_create"}) } }
-    } else {
-        // port-forward: support multi-level path
-        path := getTunnelPathNodes(tun.ID)
-        ifaceMap := getTunnelIfaceMap(tun.ID)
-        if len(path) == 0 {
-            // single hop: in-node listens on inPort and forwards to remoteAddr
-            var iface *string
-            // entry iface mapping takes precedence, then forward/interfaceName, then tunnel.interfaceName
-            if ip, ok := ifaceMap[tun.InNodeID]; ok && ip != "" { tmp := ip; iface = &tmp } else { iface = preferIface(f.InterfaceName, tun.InterfaceName) }
-            svc := buildServiceConfig(name, f.InPort, f.RemoteAddr, iface)
-            _ = sendWSCommand(tun.InNodeID, "AddService", []map[string]any{svc})
-            _ = sendWSCommand(tun.InNodeID, "RestartGost", map[string]any{"reason":"forward_create"})
-        } else {
-            // chain: [inNode -> mid1 -> mid2 -> ... -> last], each listens on same inPort and forwards to next hop (last forwards to final remote)
-            hops := append([]int64{tun.InNodeID}, path...)
-            for i := 0; i < len(hops); i++ {
-                nodeID := hops[i]
-                var target string
-                if i < len(hops)-1 {
-                    // next hop server IP: use Node.server_ip
-                    var n model.Node
-                    if err := dbpkg.DB.First(&n, hops[i+1]).Error; err == nil {
-                        target = safeHostPort(n.ServerIP, f.InPort)
-                    } else {
-                        continue
-                    }
-                } else {
-                    // last hop forwards to final remote
-                    target = f.RemoteAddr
-                }
-                var iface *string
-                if ip, ok := ifaceMap[nodeID]; ok && ip != "" { tmp := ip; iface = &tmp } else { iface = preferIface(f.InterfaceName, tun.InterfaceName) }
-                svc := buildServiceConfig(name, f.InPort, target, iface)
-                _ = sendWSCommand(nodeID, "AddService", []map[string]any{svc})
-            }
-            // restart gost on all hops
-            nodesToRestart := make(map[int64]struct{})
-            for _, nid := range append([]int64{tun.InNodeID}, path...) { nodesToRestart[nid] = struct{}{} }
-            for nid := range nodesToRestart { if nid > 0 { _ = sendWSCommand(nid, "RestartGost", map[string]any{"reason":"forward_create"}) } }
+    claimPorts(inNodes, f.InPort, f.ID, name)
+    if f.OutPort != nil { claimPorts([]int64{exitID}, *f.OutPort, f.ID, name) }
+    // tunnel-forward mid hops need reserved ports too; without them the forward is not created
+    if path := getTunnelPathNodes(tun.ID); tun.Type == 2 && f.OutPort != nil && len(path) > 0 {
+        if _, err := forwardMidPorts(f, path, name, tunnelMidPorts(tun.ID)); err != nil {
+            releaseForwardPorts(f.ID)
+            dbpkg.DB.Delete(&model.Forward{}, f.ID)
+            c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+            return
         }
     }
+    _ = deployForward(f, tun, "forward_create")
     c.JSON(http.StatusOK, response.OkNoData())
 }
 
@@ -307,19 +138,22 @@ func ForwardUpdate(c *gin.Context) {
 	}
     if req.InPort != nil {
         // enforce entry node port range; if out of range, try to pick a free one within
-        var node model.Node
-        _ = dbpkg.DB.First(&node, tun.InNodeID).Error
-        minP := 10000; maxP := 65535
-        if node.PortSta > 0 { minP = node.PortSta }
-        if node.PortEnd > 0 { maxP = node.PortEnd }
+        minP, maxP := nodePortRange(tun.InNodeID)
+        inNodes := forwardInNodes(tun)
+        svcName := buildServiceName(f.ID, f.UserID, tun.ID)
         v := *req.InPort
         if v < minP || v > maxP {
-            v = findFreePortOnNode(tun.InNodeID, 0, minP, maxP)
+            v = allocPort(inNodes, 0, minP, maxP, f.ID, svcName)
             if v == 0 {
                 c.JSON(http.StatusOK, response.ErrMsg("入口端口超出范围且无法分配可用端口"))
                 return
             }
+        } else if !reservePort(inNodes, v, f.ID, svcName) {
+            c.JSON(http.StatusOK, response.ErrMsg("入口端口已被占用"))
+            return
         }
+        // drop the forward's previous entry reservations that are no longer in use
+        releaseForwardPort(f.ID, f.InPort, v, inNodes)
         f.InPort = v
     }
 	if req.RemoteAddr != "" {
@@ -336,7 +170,12 @@ func ForwardUpdate(c *gin.Context) {
     if tun.Type == 2 {
 		// ensure outPort exists as TLS tunnel port
 		if f.OutPort == nil {
-			if op := firstFreePortOut(tun, f.ID); op != 0 {
+			op := 0
+			if exitID := outNodeIDOr0(tun); exitID > 0 {
+				minO, maxO := nodePortRange(exitID)
+				op = allocPort([]int64{exitID}, 0, minO, maxO, f.ID, name)
+			}
+			if op != 0 {
 				f.OutPort = &op
 				dbpkg.DB.Model(&model.Forward{}).Where("id=?", f.ID).Update("out_port", op)
 			} else {
@@ -405,30 +244,14 @@ func ForwardDelete(c *gin.Context) {
 	_ = dbpkg.DB.First(&f, p.ID).Error
 	var tun model.Tunnel
 	_ = dbpkg.DB.First(&tun, f.TunnelID).Error
-    name := buildServiceName(f.ID, f.UserID, f.TunnelID)
-    if tun.Type == 2 && f.OutPort != nil {
-        // 删除入口与出口上的主服务
-        _ = sendWSCommand(tun.InNodeID, "DeleteService", map[string]any{"services": []string{name}})
-        _ = sendWSCommand(outNodeIDOr0(tun), "DeleteService", map[string]any{"services": []string{name}})
-        // 删除多级路径的中间节点 mid 服务（name_mid_i）
-        path := getTunnelPathNodes(tun.ID)
-        for i := 0; i < len(path); i++ {
-            midName := fmt.Sprintf("%s_mid_%d", name, i)
-            _ = sendWSCommand(path[i], "DeleteService", map[string]any{"services": []string{midName}})
-        }
-    } else {
-        // 端口转发：删除入口上的服务
-        _ = sendWSCommand(tun.InNodeID, "DeleteService", map[string]any{"services": []string{name}})
-        // 若端口转发也采用了多级路径（各 hop 使用相同 name），尝试在中间节点删除同名服务
-        path := getTunnelPathNodes(tun.ID)
-        for _, nid := range path {
-            _ = sendWSCommand(nid, "DeleteService", map[string]any{"services": []string{name}})
-        }
-    }
+    // 删除入口、出口及多级路径各 hop 上的服务
+    removeForwardServices(f, tun, getTunnelPathNodes(tun.ID))
 	if err := dbpkg.DB.Delete(&model.Forward{}, p.ID).Error; err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("端口转发删除失败"))
 		return
 	}
+	releaseForwardPorts(p.ID)
+	forgetForwardMetrics(p.ID)
 	c.JSON(http.StatusOK, response.OkMsg("端口转发删除成功"))
 }
 
@@ -707,7 +530,7 @@ func ForwardDiagnoseStep(c *gin.Context) {
 
     outId := int64(0)
     if t.OutNodeID != nil { outId = *t.OutNodeID }
-    log.Printf("API /forward/diagnose-step forwardId=%d step=%s inNode=%d outNode=%d tunnelType=%d", p.ForwardID, p.Step, t.InNodeID, outId, t.Type)
+    jlogAt(slog.LevelDebug, map[string]any{"event": "api_diagnose_step", "forwardId": p.ForwardID, "step": p.Step, "inNode": t.InNodeID, "outNode": outId, "tunnelType": t.Type})
     var res map[string]interface{}
     switch p.Step {
     case "entryExit":
@@ -861,27 +684,6 @@ func firstTargetHost(addr string) string {
 	return addr
 }
 
-// getTunnelPathNodes reads optional multi-level path from ViteConfig (name: tunnel_path_<id>), JSON array of node IDs
-func getTunnelPathNodes(tunnelID int64) []int64 {
-    var cfg model.ViteConfig
-    key := fmt.Sprintf("tunnel_path_%d", tunnelID)
-    if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err != nil || cfg.Value == "" {
-        return nil
-    }
-    var ids []int64
-    if e := json.Unmarshal([]byte(cfg.Value), &ids); e == nil {
-        return ids
-    }
-    // also support comma separated values
-    parts := strings.Split(cfg.Value, ",")
-    for _, p := range parts {
-        p = strings.TrimSpace(p)
-        if p == "" { continue }
-        if v, err := strconv.ParseInt(p, 10, 64); err == nil { ids = append(ids, v) }
-    }
-    return ids
-}
-
 func splitHostPortSafe(hp string) (string, int) {
 	host, portStr, err := net.SplitHostPort(hp)
 	if err != nil {
@@ -915,32 +717,6 @@ func ForwardUpdateOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, response.OkNoData())
 }
 
-// naive free port allocator within [port_sta, port_end] by scanning forward records for this tunnel
-func firstFreePort(inNodeID int64, t model.Tunnel, excludeForwardID int64) int {
-	if t.Type != 1 { // for tunnel-forward we cannot determine here
-		// try TCPListenAddr: not implementing scanning
-	}
-	// Use node's port range
-	var node model.Node
-	if err := dbpkg.DB.First(&node, inNodeID).Error; err != nil {
-		return 0
-	}
-	busy := map[int]bool{}
-	var list []model.Forward
-	dbpkg.DB.Where("tunnel_id = ?", t.ID).Find(&list)
-	for _, f := range list {
-		if f.ID != excludeForwardID {
-			busy[f.InPort] = true
-		}
-	}
-	for p := node.PortSta; p <= node.PortEnd; p++ {
-		if !busy[p] {
-			return p
-		}
-	}
-	return 0
-}
-
 // buildServiceName follows forwardId_userId_userTunnelId (userTunnelId taken as 0 for admin or when missing)
 func buildServiceName(forwardID int64, userID int64, tunnelID int64) string {
 	// try find user_tunnel id
@@ -985,31 +761,16 @@ func buildServiceConfig(name string, listenPort int, target string, iface *strin
 
 func queryNodeServicePorts(nodeID int64) map[int]bool {
     ports := map[int]bool{}
-    reqID := RandUUID()
-    payload := map[string]any{"requestId": reqID}
-    if err := sendWSCommand(nodeID, "QueryServices", payload); err != nil {
-        return ports
-    }
-    ch := make(chan map[string]interface{}, 1)
-    diagMu.Lock(); diagWaiters[reqID] = ch; diagMu.Unlock()
-    select {
-    case res := <-ch:
-        if data, ok := res["data"].([]interface{}); ok {
-            for _, it := range data {
-                m, _ := it.(map[string]interface{})
-                if m == nil { continue }
-                if v, ok2 := m["addr"].(string); ok2 {
-                    if p := parsePort(v); p > 0 { ports[p] = true; continue }
-                }
-                if lst, ok2 := m["listener"].(map[string]interface{}); ok2 {
-                    if v, ok3 := lst["addr"].(string); ok3 {
-                        if p := parsePort(v); p > 0 { ports[p] = true }
-                    }
-                }
+    list, _ := queryNodeServicesDetail(nodeID, false)
+    for _, m := range list {
+        if v, ok := m["addr"].(string); ok {
+            if p := parsePort(v); p > 0 { ports[p] = true; continue }
+        }
+        if lst, ok := m["listener"].(map[string]interface{}); ok {
+            if v, ok2 := lst["addr"].(string); ok2 {
+                if p := parsePort(v); p > 0 { ports[p] = true }
             }
         }
-    case <-time.After(3 * time.Second):
-        diagMu.Lock(); delete(diagWaiters, reqID); diagMu.Unlock()
     }
     return ports
 }
@@ -1024,28 +785,33 @@ func parsePort(addr string) int {
 
 // query raw services list for a node via WS (best-effort)
 func queryNodeServicesRaw(nodeID int64) []map[string]any {
+    out, _ := queryNodeServicesDetail(nodeID, false)
+    return out
+}
+
+// queryNodeServicesDetail queries a node's services; with detail=true each item carries the
+// full gost config under "config". ok=false means the node did not answer in time.
+func queryNodeServicesDetail(nodeID int64, detail bool) ([]map[string]any, bool) {
     reqID := RandUUID()
     payload := map[string]any{"requestId": reqID}
-    if err := sendWSCommand(nodeID, "QueryServices", payload); err != nil {
-        return nil
+    if detail { payload["detail"] = true }
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+    res, err := nodeRequest(ctx, nodeID, "QueryServices", reqID, payload)
+    if err != nil {
+        return nil, false
     }
-    ch := make(chan map[string]interface{}, 1)
-    diagMu.Lock(); diagWaiters[reqID] = ch; diagMu.Unlock()
-    defer func(){ diagMu.Lock(); delete(diagWaiters, reqID); diagMu.Unlock() }()
-    select {
-    case res := <-ch:
-        if data, ok := res["data"].([]interface{}); ok {
-            out := make([]map[string]any, 0, len(data))
-            for _, it := range data { if m, ok2 := it.(map[string]any); ok2 { out = append(out, m) } }
-            return out
-        }
-    case <-time.After(3 * time.Second):
+    out := make([]map[string]any, 0)
+    if data, ok := res["data"].([]interface{}); ok {
+        for _, it := range data { if m, ok2 := it.(map[string]any); ok2 { out = append(out, m) } }
     }
-    return nil
+    return out, true
 }
 
+// findFreePortOnNode proposes a port that is neither registered nor live on the node (no reservation)
 func findFreePortOnNode(nodeID int64, prefer int, min int, max int) int {
     used := queryNodeServicePorts(nodeID)
+    for p := range allocatedPorts(nodeID) { used[p] = true }
     start := prefer
     if start < min || start > max { start = min }
     if start <= 0 { start = min }
@@ -1129,28 +895,3 @@ func outNodeIDOr0(t model.Tunnel) int64 {
 	}
 	return 0
 }
-
-// find free out port on out-node range
-func firstFreePortOut(t model.Tunnel, excludeForwardID int64) int {
-	if t.OutNodeID == nil {
-		return 0
-	}
-	var outNode model.Node
-	if err := dbpkg.DB.First(&outNode, *t.OutNodeID).Error; err != nil {
-		return 0
-	}
-	busy := map[int]bool{}
-	var list []model.Forward
-	dbpkg.DB.Where("tunnel_id = ?", t.ID).Find(&list)
-	for _, f := range list {
-		if f.ID != excludeForwardID && f.OutPort != nil {
-			busy[*f.OutPort] = true
-		}
-	}
-	for p := outNode.PortSta; p <= outNode.PortEnd; p++ {
-		if !busy[p] {
-			return p
-		}
-	}
-	return 0
-}
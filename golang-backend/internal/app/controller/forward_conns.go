@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"network-panel/golang-backend/internal/app/lifecycle"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	"network-panel/golang-backend/internal/app/util"
+	dbpkg "network-panel/golang-backend/internal/db"
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Live connections of a forward, read from the socket table of the tunnel entry node where
+// the users connect (agent command QueryConnections).
+
+// queryForwardConns asks the entry node of the forward; errMsg is shown to the admin
+func queryForwardConns(ctx context.Context, fwdID int64, limit int) (map[string]any, string) {
+	var f model.Forward
+	if err := dbpkg.DB.First(&f, fwdID).Error; err != nil {
+		return nil, "转发不存在"
+	}
+	var t model.Tunnel
+	if err := dbpkg.DB.First(&t, f.TunnelID).Error; err != nil {
+		return nil, "隧道不存在"
+	}
+	name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+	reqID := RandUUID()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := nodeRequest(ctx, t.InNodeID, wsproto.TypeQueryConns, reqID, wsproto.QueryConnsRequest{RequestID: reqID, Service: name, Limit: limit})
+	if err != nil {
+		var nerr *nodeReplyError
+		if errors.As(err, &nerr) {
+			return nil, "节点查询失败: " + nerr.Message
+		}
+		if ctx.Err() != nil {
+			return nil, "查询超时"
+		}
+		return nil, "节点未连接: " + err.Error()
+	}
+	data, _ := res["data"].(map[string]any)
+	if data == nil {
+		return nil, "节点未返回数据"
+	}
+	data["forwardId"], data["nodeId"], data["timeMs"] = f.ID, t.InNodeID, time.Now().UnixMilli()
+	return data, ""
+}
+
+// POST /api/v1/forward/connections {id, limit?}
+func ForwardConnections(c *gin.Context) {
+	var p struct {
+		ID    int64 `json:"id" binding:"required"`
+		Limit int   `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	data, msg := queryForwardConns(c.Request.Context(), p.ID, p.Limit)
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(data))
+}
+
+// GET /api/v1/forward/connections/ws?id=&token=&intervalS=
+// Pushes {type:"forward_conns", forwardId, data?, error?} every intervalS seconds (2-60,
+// default 3) until the socket closes. Browsers cannot set headers on a websocket, so the
+// admin token comes as a query parameter.
+func ForwardConnectionsWS(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" || !util.ValidateToken(token) || util.GetRoleID(token) != 0 {
+		c.JSON(http.StatusUnauthorized, response.ErrMsg("未登录或token无效"))
+		return
+	}
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	interval := 3
+	if v, err := strconv.Atoi(c.Query("intervalS")); err == nil && v >= 2 && v <= 60 {
+		interval = v
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	w := newWSWriter(conn)
+	ctx, cancel := context.WithCancel(lifecycle.Context())
+	// read loop only detects the close
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	jlogAt(slog.LevelDebug, map[string]interface{}{"event": "forward_conns_watch", "forwardId": id, "intervalS": interval})
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	defer w.shutdown()
+	for {
+		frame := map[string]any{"type": "forward_conns", "forwardId": id}
+		if data, msg := queryForwardConns(ctx, id, 0); msg != "" {
+			frame["error"] = msg
+		} else {
+			frame["data"] = data
+		}
+		b, _ := json.Marshal(frame)
+		// a slow browser skips a frame instead of piling them up
+		if err := w.send(b, 0); err == errWSClosed {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			if lifecycle.Stopping() {
+				w.closeWith(websocket.CloseServiceRestart, "panel restarting")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"fmt"
+
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/util"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// deployForward pushes every service of f to the nodes of tun (entry, path hops, exit) and
+// restarts gost there. Ports must already be reserved. Returns the first node that could
+// not be reached; the remaining nodes are still updated.
+func deployForward(f model.Forward, tun model.Tunnel, reason string) error {
+	b := newDeployBatch()
+	b.add(f, tun)
+	return b.finish(reason)[0]
+}
+
+// deployBatch deploys several forwards and restarts gost once per node at the end instead
+// of once per forward, so a node carrying many forwards drops its connections only once
+type deployBatch struct {
+	restart map[int64]struct{}
+	items   []deployItem
+}
+
+type deployItem struct {
+	f     model.Forward
+	tun   model.Tunnel
+	nodes map[int64]struct{} // nodes the forward's services were sent to
+	err   error
+}
+
+func newDeployBatch() *deployBatch {
+	return &deployBatch{restart: map[int64]struct{}{}}
+}
+
+// add pushes the services of f and returns its index in the batch
+func (b *deployBatch) add(f model.Forward, tun model.Tunnel) int {
+	it := deployItem{f: f, tun: tun, nodes: map[int64]struct{}{}}
+	it.err = pushForwardServices(f, tun, it.nodes)
+	for nid := range it.nodes {
+		b.restart[nid] = struct{}{}
+	}
+	b.items = append(b.items, it)
+	return len(b.items) - 1
+}
+
+// finish restarts every touched node once, pauses the paused forwards again and returns
+// the first error of each forward in add order
+func (b *deployBatch) finish(reason string) []error {
+	restartErr := map[int64]error{}
+	for nid := range b.restart {
+		if nid <= 0 {
+			continue
+		}
+		if err := sendWSCommand(nid, "RestartGost", map[string]any{"reason": reason}); err != nil {
+			restartErr[nid] = err
+		}
+	}
+	errs := make([]error, len(b.items))
+	for i, it := range b.items {
+		errs[i] = it.err
+		for nid := range it.nodes {
+			if err := restartErr[nid]; err != nil && errs[i] == nil {
+				errs[i] = fmt.Errorf("节点 %d: %v", nid, err)
+			}
+		}
+		// a paused forward stays paused after a redeploy
+		if it.f.Status == nil || *it.f.Status != 0 {
+			continue
+		}
+		name := buildServiceName(it.f.ID, it.f.UserID, it.f.TunnelID)
+		pause := []int64{it.tun.InNodeID}
+		if it.tun.Type == 2 {
+			pause = append(pause, outNodeIDOr0(it.tun))
+		}
+		for _, nid := range pause {
+			if err := sendWSCommand(nid, "PauseService", map[string]any{"services": []string{name}}); err != nil && errs[i] == nil {
+				errs[i] = fmt.Errorf("节点 %d: %v", nid, err)
+			}
+		}
+	}
+	return errs
+}
+
+// pushForwardServices sends the AddService commands of f and records the nodes that need a
+// gost restart in restart
+func pushForwardServices(f model.Forward, tun model.Tunnel, restart map[int64]struct{}) error {
+	var firstErr error
+	send := func(nodeID int64, cmd string, data any) {
+		if err := sendWSCommand(nodeID, cmd, data); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("节点 %d: %v", nodeID, err)
+		}
+	}
+	restart[tun.InNodeID] = struct{}{}
+	name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+	path := getTunnelPathNodes(tun.ID)
+	ifaceMap := getTunnelIfaceMap(tun.ID)
+	bindMap := getTunnelBindMap(tun.ID)
+
+	if tun.Type == 2 && f.OutPort != nil {
+		// gRPC 隧道（出口=relay+grpc，入口=forward+chain(dialer=grpc, connector=relay)）
+		exitID := outNodeIDOr0(tun)
+		var midPorts []int
+		if len(path) > 0 {
+			var err error
+			if midPorts, err = forwardMidPorts(f, path, name, tunnelMidPorts(tun.ID)); err != nil {
+				return err
+			}
+		}
+		user := fmt.Sprintf("u-%d", f.ID)
+		pass := util.MD5(fmt.Sprintf("%d:%d", f.ID, f.CreatedTime))[:16]
+		outAddr := fmt.Sprintf(":%d", *f.OutPort)
+		if ip := bindMap[exitID]; ip != "" {
+			outAddr = safeHostPort(ip, *f.OutPort)
+		}
+		outSvc := map[string]any{
+			"name":     name,
+			"addr":     outAddr,
+			"listener": map[string]any{"type": "grpc"},
+			"handler":  map[string]any{"type": "relay", "auth": map[string]any{"username": user, "password": pass}},
+			"metadata": map[string]any{"managedBy": "network-panel", "managedby": "network-panel"},
+		}
+		send(exitID, "AddService", []map[string]any{outSvc})
+		restart[exitID] = struct{}{}
+
+		// entry dials the first mid (or the exit relay); mids forward the gRPC bytes hop by hop
+		dialAddr := safeHostPort(getOutNodeIP(tun), *f.OutPort)
+		if len(path) > 0 {
+			for i, nid := range path {
+				target := dialAddr
+				if i < len(path)-1 {
+					var nx model.Node
+					if err := dbpkg.DB.First(&nx, path[i+1]).Error; err != nil {
+						continue
+					}
+					target = safeHostPort(preferIPv4(nx), midPorts[i+1])
+				}
+				addr := fmt.Sprintf(":%d", midPorts[i])
+				if ip := bindMap[nid]; ip != "" {
+					addr = safeHostPort(ip, midPorts[i])
+				}
+				meta := map[string]any{"managedBy": "network-panel", "managedby": "network-panel"}
+				if ip := ifaceMap[nid]; ip != "" {
+					meta["interface"] = ip
+				}
+				svc := map[string]any{
+					"name":      fmt.Sprintf("%s_mid_%d", name, i),
+					"addr":      addr,
+					"listener":  map[string]any{"type": "tcp"},
+					"handler":   map[string]any{"type": "forward"},
+					"forwarder": map[string]any{"nodes": []map[string]any{{"name": "target", "addr": target}}},
+					"metadata":  meta,
+				}
+				send(nid, "AddService", []map[string]any{svc})
+				restart[nid] = struct{}{}
+			}
+			var first model.Node
+			if err := dbpkg.DB.First(&first, path[0]).Error; err != nil {
+				return fmt.Errorf("中间节点 %d 不存在", path[0])
+			}
+			dialAddr = safeHostPort(preferIPv4(first), midPorts[0])
+		}
+		inSvc := map[string]any{
+			"name":     name,
+			"addr":     fmt.Sprintf(":%d", f.InPort),
+			"listener": map[string]any{"type": "tcp"},
+			"handler":  map[string]any{"type": "forward", "chain": "chain_" + name},
+			"metadata": map[string]any{"managedBy": "network-panel", "managedby": "network-panel"},
+		}
+		if ip := ifaceMap[tun.InNodeID]; ip != "" {
+			inSvc["metadata"].(map[string]any)["interface"] = ip
+		}
+		node := map[string]any{
+			"name":      "node-" + name,
+			"addr":      dialAddr,
+			"connector": map[string]any{"type": "relay", "auth": map[string]any{"username": user, "password": pass}},
+			"dialer":    map[string]any{"type": "grpc"},
+		}
+		inSvc["_chains"] = []any{map[string]any{"name": "chain_" + name, "metadata": map[string]any{"managedBy": "network-panel"}, "hops": []any{map[string]any{"name": "hop_" + name, "nodes": []any{node}}}}}
+		// forwarder 目标为远程地址（取第一项）
+		inSvc["forwarder"] = map[string]any{"nodes": []map[string]any{{"name": "target", "addr": firstTargetHost(f.RemoteAddr)}}}
+		send(tun.InNodeID, "AddService", []map[string]any{inSvc})
+	} else {
+		// port-forward: [inNode -> mid1 -> ... -> last], each listens on inPort and forwards
+		// to the next hop (last forwards to the final remote)
+		hops := append([]int64{tun.InNodeID}, path...)
+		for i, nid := range hops {
+			target := f.RemoteAddr
+			if i < len(hops)-1 {
+				var n model.Node
+				if err := dbpkg.DB.First(&n, hops[i+1]).Error; err != nil {
+					continue
+				}
+				target = safeHostPort(n.ServerIP, f.InPort)
+			}
+			// per-node iface mapping takes precedence, then forward/interfaceName, then tunnel.interfaceName
+			iface := preferIface(f.InterfaceName, tun.InterfaceName)
+			if ip := ifaceMap[nid]; ip != "" {
+				tmp := ip
+				iface = &tmp
+			}
+			send(nid, "AddService", []map[string]any{buildServiceConfig(name, f.InPort, target, iface)})
+			restart[nid] = struct{}{}
+		}
+	}
+	return firstErr
+}
+
+// reserveForwardPorts reserves f's inPort (and the exit outPort of a tunnel-forward) on the
+// current nodes of tun. A port taken there is replaced by a free one and saved.
+func reserveForwardPorts(f *model.Forward, tun model.Tunnel) error {
+	name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+	inNodes := forwardInNodes(tun)
+	if !reservePort(inNodes, f.InPort, f.ID, name) {
+		minP, maxP := nodePortRange(tun.InNodeID)
+		p := allocPort(inNodes, f.InPort, minP, maxP, f.ID, name)
+		if p == 0 {
+			return fmt.Errorf("入口端口 %d 已被占用且无可用端口", f.InPort)
+		}
+		releaseForwardPort(f.ID, f.InPort, p, inNodes)
+		f.InPort = p
+		dbpkg.DB.Model(&model.Forward{}).Where("id = ?", f.ID).Update("in_port", p)
+	}
+	exitID := outNodeIDOr0(tun)
+	if tun.Type != 2 || f.OutPort == nil || exitID == 0 {
+		return nil
+	}
+	if !reservePort([]int64{exitID}, *f.OutPort, f.ID, name) {
+		minO, maxO := nodePortRange(exitID)
+		p := allocPort([]int64{exitID}, *f.OutPort, minO, maxO, f.ID, name)
+		if p == 0 {
+			return fmt.Errorf("出口端口 %d 已被占用且无可用端口", *f.OutPort)
+		}
+		releaseForwardPort(f.ID, *f.OutPort, p, []int64{exitID})
+		f.OutPort = &p
+		dbpkg.DB.Model(&model.Forward{}).Where("id = ?", f.ID).Update("out_port", p)
+	}
+	return nil
+}
+
+// forwardMidPorts reserves the listen port of each tunnel-forward mid hop: the hop's port
+// override, else the forward's inPort, else any free port in the node's range. A hop without
+// a free port fails the deploy: an unreserved port could collide with another forward.
+func forwardMidPorts(f model.Forward, path []int64, name string, override []int) ([]int, error) {
+	ports := make([]int, len(path))
+	for i, nid := range path {
+		minP, maxP := nodePortRange(nid)
+		prefer := f.InPort
+		if i < len(override) && override[i] > 0 {
+			prefer = override[i]
+		}
+		if prefer < minP || prefer > maxP {
+			prefer = 0
+		}
+		ports[i] = allocPort([]int64{nid}, prefer, minP, maxP, f.ID, fmt.Sprintf("%s_mid_%d", name, i))
+		if ports[i] == 0 {
+			return nil, fmt.Errorf("中间节点 %d 无可用端口", nid)
+		}
+	}
+	return ports, nil
+}
+
+// removeForwardServices deletes the services of f from the entry, the exit and the given
+// path hops. Unreachable nodes are skipped; drift/reconcile cleans them up later.
+func removeForwardServices(f model.Forward, tun model.Tunnel, path []int64) {
+	name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+	_ = sendWSCommand(tun.InNodeID, "DeleteService", map[string]any{"services": []string{name}})
+	if tun.Type == 2 {
+		if exitID := outNodeIDOr0(tun); exitID > 0 {
+			_ = sendWSCommand(exitID, "DeleteService", map[string]any{"services": []string{name}})
+		}
+		// 中间节点 mid 服务（name_mid_i）
+		for i, nid := range path {
+			_ = sendWSCommand(nid, "DeleteService", map[string]any{"services": []string{fmt.Sprintf("%s_mid_%d", name, i)}})
+		}
+		return
+	}
+	// 多级端口转发的各 hop 使用相同 name
+	for _, nid := range path {
+		_ = sendWSCommand(nid, "DeleteService", map[string]any{"services": []string{name}})
+	}
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"network-panel/golang-backend/internal/app/metrics"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/config"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Prometheus metrics of this replica. Counters start at zero on restart and with several
+// replicas each one counts what it handled itself; sum them in queries.
+
+var (
+	wsCommands       = metrics.NewCounter("np_ws_commands_total", "Commands sent to agents by type.", "type")
+	wsCommandErrors  = metrics.NewCounter("np_ws_command_failures_total", "Commands that could not be sent to agents by type.", "type")
+	diagnoseDuration = metrics.NewHistogram("np_diagnose_duration_seconds", "Time from diagnose request to agent reply.", metrics.DefBuckets, "mode", "result")
+	diagnoseRTT      = metrics.NewHistogram("np_diagnose_rtt_ms", "Average latency measured by successful diagnoses.", []float64{5, 10, 25, 50, 100, 200, 300, 500, 1000, 2000}, "mode")
+	flowUploads      = metrics.NewCounter("np_flow_uploads_total", "Flow reports received from nodes (observer: /flow/upload, agent: batched meter).", "source")
+	flowFlushes      = metrics.NewCounter("np_flow_flushes_total", "Flow buffer flushes by result.", "result")
+	userBytes        = metrics.NewCounter("np_user_bytes_total", "Counted traffic per user.", "user_id", "direction")
+	forwardBytes     = metrics.NewCounter("np_forward_bytes_total", "Counted traffic per forward.", "forward_id", "direction")
+	queryDuration    = metrics.NewHistogram("np_db_query_duration_seconds", "Duration of database statements by operation.", metrics.DefBuckets, "op")
+	queryErrors      = metrics.NewCounter("np_db_query_errors_total", "Failed database statements by operation (record not found is not a failure).", "op")
+
+	_ = metrics.NewGaugeFunc("np_nodes_connected", "Nodes with an agent connected to this replica.", nil, func(emit func(float64, ...string)) {
+		nodeConnMu.RLock()
+		n := 0
+		for _, list := range nodeConns {
+			if len(list) > 0 {
+				n++
+			}
+		}
+		nodeConnMu.RUnlock()
+		emit(float64(n))
+	})
+	_ = metrics.NewGaugeFunc("np_db_connections", "Database pool connections by state.", []string{"state"}, func(emit func(float64, ...string)) {
+		if dbpkg.DB == nil {
+			return
+		}
+		sqlDB, err := dbpkg.DB.DB()
+		if err != nil {
+			return
+		}
+		st := sqlDB.Stats()
+		emit(float64(st.InUse), "in_use")
+		emit(float64(st.Idle), "idle")
+	})
+	_ = metrics.NewGaugeFunc("np_node_online", "Node status as stored by the panel (1 online, 0 offline).", []string{"node_id", "node"}, func(emit func(float64, ...string)) {
+		var nodes []model.Node
+		dbpkg.DB.Select("id", "name", "status").Find(&nodes)
+		for _, n := range nodes {
+			v := 0.0
+			if n.Status != nil && *n.Status == 1 {
+				v = 1
+			}
+			emit(v, strconv.FormatInt(n.ID, 10), n.Name)
+		}
+	})
+)
+
+func observeDiagnose(mode string, start time.Time, ok bool, msg string, avg float64) {
+	result := "ok"
+	switch {
+	case ok:
+		diagnoseRTT.Observe(avg, mode)
+	case msg == msgDiagnoseTimeout:
+		result = "timeout"
+	default:
+		result = "failed"
+	}
+	diagnoseDuration.Observe(time.Since(start).Seconds(), mode, result)
+}
+
+// forgetUserMetrics / forgetForwardMetrics drop the traffic series of removed users and
+// forwards; their IDs are never reused, the series would only grow the scrape
+func forgetUserMetrics(userID int64) {
+	uid := strconv.FormatInt(userID, 10)
+	userBytes.Delete(uid, "in")
+	userBytes.Delete(uid, "out")
+}
+
+func forgetForwardMetrics(forwardIDs ...int64) {
+	for _, id := range forwardIDs {
+		fid := strconv.FormatInt(id, 10)
+		forwardBytes.Delete(fid, "in")
+		forwardBytes.Delete(fid, "out")
+	}
+}
+
+func countFlowBytes(res flowFlushResult) {
+	for id, d := range res.userInc {
+		uid := strconv.FormatInt(id, 10)
+		userBytes.Add(float64(d.in), uid, "in")
+		userBytes.Add(float64(d.out), uid, "out")
+	}
+	for id, d := range res.fwdInc {
+		fid := strconv.FormatInt(id, 10)
+		forwardBytes.Add(float64(d.in), fid, "in")
+		forwardBytes.Add(float64(d.out), fid, "out")
+	}
+}
+
+// DBMetrics is the gorm plugin timing every database statement; main hands it to db.Init
+type DBMetrics struct{}
+
+func (DBMetrics) Name() string { return "np:metrics" }
+
+const queryStartKey = "metrics:start"
+
+func (DBMetrics) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	before := func(tx *gorm.DB) { tx.InstanceSet(queryStartKey, time.Now()) }
+	after := func(op string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			v, ok := tx.InstanceGet(queryStartKey)
+			if !ok {
+				return
+			}
+			queryDuration.Observe(time.Since(v.(time.Time)).Seconds(), op)
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				queryErrors.Inc(op)
+			}
+		}
+	}
+	regs := []error{
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	}
+	return errors.Join(regs...)
+}
+
+// GET /metrics
+// Prometheus text format. With metrics.token (METRICS_TOKEN) set the scrape needs "Authorization: Bearer <token>".
+func Metrics(c *gin.Context) {
+	if token := config.C.Metrics.Token; token != "" {
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.String(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Write(c.Writer)
+}
@@ -0,0 +1,489 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Raw node_sysinfo (every 5s per agent) and node_probe_result (every minute per target) are
+// rolled up into 1-minute and 1-hour buckets and then pruned. Each run continues after the
+// newest bucket already written, and buckets are upserted, so replicas running the job at
+// the same time only repeat work. Queries pick the finest resolution that is still retained
+// and small enough for the requested range.
+//
+// vite_config keys:
+//   metrics_raw_retention_h   hours of raw samples, default 48 (min 2)
+//   metrics_1m_retention_d    days of 1-minute buckets, default 14
+//   metrics_1h_retention_d    days of 1-hour buckets, default 400
+
+const (
+	resRaw = "raw"
+	res1m  = "1m"
+	res1h  = "1h"
+
+	rollupLag   = 2 * time.Minute // late reports; older probe batches go through rerollProbeLate
+	rollupChunk = time.Hour       // raw rows loaded per query
+	rollupRun   = 30 * time.Second
+
+	defaultRawRetentionH = 48
+)
+
+var lastMetricsPrune time.Time
+
+// RunMetricsRollup rolls up closed buckets and prunes expired data; the scheduler calls it every minute
+func RunMetricsRollup(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, rollupRun)
+	defer cancel()
+	now := time.Now().Add(-rollupLag)
+	end1m := now.Truncate(time.Minute).UnixMilli()
+	// hours are rolled from minutes, so only up to where the minutes are complete
+	hourEnd := func(reached int64) int64 { return time.UnixMilli(reached).Truncate(time.Hour).UnixMilli() }
+	rollupSysinfoHour(hourEnd(rollupSysinfoRaw(ctx, end1m)))
+	rollupProbeHour(hourEnd(rollupProbeRaw(ctx, end1m)))
+	if ctx.Err() == nil && time.Since(lastMetricsPrune) > time.Hour {
+		pruneMetrics()
+		lastMetricsPrune = time.Now()
+	}
+}
+
+func rawRetention() time.Duration {
+	h := configInt("metrics_raw_retention_h", defaultRawRetentionH)
+	if h < 2 {
+		h = 2
+	}
+	return time.Duration(h) * time.Hour
+}
+
+func rollupRetention(res string) time.Duration {
+	if res == res1m {
+		return time.Duration(configInt("metrics_1m_retention_d", 14)) * 24 * time.Hour
+	}
+	return time.Duration(configInt("metrics_1h_retention_d", 400)) * 24 * time.Hour
+}
+
+// metricResolution picks the resolution for a chart over [from, now]: raw up to 1h, minutes up
+// to a day, hours beyond, coarser whenever the finer data no longer reaches back to from
+func metricResolution(from, now int64) string {
+	window := time.Duration(now-from) * time.Millisecond
+	res := res1h
+	switch {
+	case window <= time.Hour:
+		res = resRaw
+	case window <= 24*time.Hour:
+		res = res1m
+	}
+	if res == resRaw && now-from > rawRetention().Milliseconds() {
+		res = res1m
+	}
+	if res == res1m && now-from > rollupRetention(res1m).Milliseconds() {
+		res = res1h
+	}
+	return res
+}
+
+// rollupStart is where a rollup continues: after the newest bucket of res, else at the
+// oldest source row
+func rollupStart(rollup any, res string, source any, sourceWhere string, step time.Duration) int64 {
+	var last *int64
+	dbpkg.DB.Model(rollup).Where("res = ?", res).Select("MAX(time_ms)").Scan(&last)
+	if last != nil && *last > 0 {
+		return *last + step.Milliseconds()
+	}
+	var first *int64
+	q := dbpkg.DB.Model(source)
+	if sourceWhere != "" {
+		q = q.Where(sourceWhere)
+	}
+	q.Select("MIN(time_ms)").Scan(&first)
+	if first == nil || *first <= 0 {
+		return 0
+	}
+	return time.UnixMilli(*first).Truncate(step).UnixMilli()
+}
+
+func bucketOf(ms int64, step time.Duration) int64 {
+	return ms - ms%step.Milliseconds()
+}
+
+// counterDelta is the increase of a cumulative counter; a smaller value means it was reset
+// (agent or machine restart) and counts from zero
+func counterDelta(prev, cur int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// rollupSysinfoRaw rolls raw samples into minutes up to end and returns how far it got
+func rollupSysinfoRaw(ctx context.Context, end int64) int64 {
+	start := rollupStart(&model.NodeSysInfoRollup{}, res1m, &model.NodeSysInfo{}, "", time.Minute)
+	if start <= 0 {
+		return end
+	}
+	for start < end && ctx.Err() == nil {
+		stop := start + rollupChunk.Milliseconds()
+		if stop > end {
+			stop = end
+		}
+		var rows []model.NodeSysInfo
+		dbpkg.DB.Where("time_ms >= ? AND time_ms < ?", start, stop).Order("node_id asc, time_ms asc").Find(&rows)
+		if !upsertRollups(sysinfoMinuteRollups(rows), "node_id", "res", "time_ms") {
+			return start
+		}
+		start = stop
+	}
+	return start
+}
+
+// sysinfoMinuteRollups folds raw samples, ordered by node and time, into 1-minute buckets.
+// Rates are the average of the per-sample rates: the agent measures them per interface, so
+// an interface that disappears does not look like a counter reset of the summed totals
+// (samples of agents without rates got theirs derived from the totals on ingest).
+func sysinfoMinuteRollups(rows []model.NodeSysInfo) []model.NodeSysInfoRollup {
+	var out []model.NodeSysInfoRollup
+	var cur *model.NodeSysInfoRollup
+	var ifs ifaceAgg
+	var health healthAgg
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		n := float64(cur.Samples)
+		cur.CPU, cur.Mem = cur.CPU/n, cur.Mem/n
+		cur.RxRate, cur.TxRate = cur.RxRate/n, cur.TxRate/n
+		cur.Ifaces = ifs.result()
+		cur.NodeHealth = health.result()
+		out = append(out, *cur)
+		cur = nil
+	}
+	for i := range rows {
+		r := &rows[i]
+		b := bucketOf(r.TimeMs, time.Minute)
+		if cur == nil || cur.NodeID != r.NodeID || cur.TimeMs != b {
+			flush()
+			cur = &model.NodeSysInfoRollup{NodeID: r.NodeID, Res: res1m, TimeMs: b, CPUMin: math.MaxFloat64, MemMin: math.MaxFloat64}
+			ifs, health = ifaceAgg{}, healthAgg{}
+		}
+		cur.Samples++
+		cur.CPU += r.CPU
+		cur.Mem += r.Mem
+		cur.RxRate += r.RxRate
+		cur.TxRate += r.TxRate
+		cur.CPUMin, cur.CPUMax = math.Min(cur.CPUMin, r.CPU), math.Max(cur.CPUMax, r.CPU)
+		cur.MemMin, cur.MemMax = math.Min(cur.MemMin, r.Mem), math.Max(cur.MemMax, r.Mem)
+		cur.Uptime, cur.BytesRx, cur.BytesTx = r.Uptime, r.BytesRx, r.BytesTx
+		ifs.add(r.Ifaces, false)
+		health.add(r.NodeHealth)
+	}
+	flush()
+	return out
+}
+
+// rollupProbeRaw rolls raw probe results into minutes up to end and returns how far it got
+func rollupProbeRaw(ctx context.Context, end int64) int64 {
+	start := rollupStart(&model.NodeProbeRollup{}, res1m, &model.NodeProbeResult{}, "", time.Minute)
+	if start <= 0 {
+		return end
+	}
+	for start < end && ctx.Err() == nil {
+		stop := start + rollupChunk.Milliseconds()
+		if stop > end {
+			stop = end
+		}
+		var rows []model.NodeProbeResult
+		dbpkg.DB.Where("time_ms >= ? AND time_ms < ?", start, stop).Find(&rows)
+		if !upsertRollups(probeMinuteRollups(rows), "node_id", "target_id", "res", "time_ms") {
+			return start
+		}
+		start = stop
+	}
+	return start
+}
+
+func rollupSysinfoHour(end int64) {
+	start := rollupStart(&model.NodeSysInfoRollup{}, res1h, &model.NodeSysInfoRollup{}, "res = '1m'", time.Hour)
+	if start <= 0 || start >= end {
+		return
+	}
+	var rows []model.NodeSysInfoRollup
+	dbpkg.DB.Where("res = ? AND time_ms >= ? AND time_ms < ?", res1m, start, end).Order("node_id asc, time_ms asc").Find(&rows)
+	type key struct{ node, bucket int64 }
+	agg := map[key]*model.NodeSysInfoRollup{}
+	minutes := map[key]int{}
+	ifs := map[key]*ifaceAgg{}
+	health := map[key]*healthAgg{}
+	for _, r := range rows {
+		k := key{r.NodeID, bucketOf(r.TimeMs, time.Hour)}
+		a := agg[k]
+		if a == nil {
+			a = &model.NodeSysInfoRollup{NodeID: r.NodeID, Res: res1h, TimeMs: k.bucket, CPUMin: r.CPUMin, MemMin: r.MemMin}
+			agg[k] = a
+		}
+		a.CPU += r.CPU * float64(r.Samples)
+		a.Mem += r.Mem * float64(r.Samples)
+		a.Samples += r.Samples
+		a.CPUMin, a.CPUMax = math.Min(a.CPUMin, r.CPUMin), math.Max(a.CPUMax, r.CPUMax)
+		a.MemMin, a.MemMax = math.Min(a.MemMin, r.MemMin), math.Max(a.MemMax, r.MemMax)
+		a.RxRate += r.RxRate
+		a.TxRate += r.TxRate
+		a.Uptime, a.BytesRx, a.BytesTx = r.Uptime, r.BytesRx, r.BytesTx
+		minutes[k]++
+		if ifs[k] == nil {
+			ifs[k] = &ifaceAgg{}
+		}
+		ifs[k].add(r.Ifaces, true)
+		if health[k] == nil {
+			health[k] = &healthAgg{}
+		}
+		health[k].add(r.NodeHealth)
+	}
+	out := make([]model.NodeSysInfoRollup, 0, len(agg))
+	for k, a := range agg {
+		if a.Samples > 0 {
+			a.CPU /= float64(a.Samples)
+			a.Mem /= float64(a.Samples)
+		}
+		// average rate over the minutes that have data
+		a.RxRate /= float64(minutes[k])
+		a.TxRate /= float64(minutes[k])
+		a.Ifaces = ifs[k].result()
+		a.NodeHealth = health[k].result()
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TimeMs < out[j].TimeMs })
+	upsertRollups(out, "node_id", "res", "time_ms")
+}
+
+// ifaceAgg averages per-interface rates over the samples (or minutes) of a bucket. The
+// peak is the highest sample rate, or the highest minute peak when rolling up rollups.
+type ifaceAgg struct {
+	stats model.IfaceStats
+	n     map[string]int
+}
+
+func (a *ifaceAgg) add(src model.IfaceStats, rollup bool) {
+	if len(src) == 0 {
+		return
+	}
+	if a.stats == nil {
+		a.stats, a.n = model.IfaceStats{}, map[string]int{}
+	}
+	for name, it := range src {
+		rxPeak, txPeak := it.RxRate, it.TxRate
+		if rollup {
+			rxPeak, txPeak = it.RxMax, it.TxMax
+		}
+		d := a.stats[name]
+		d.RxBytes, d.TxBytes = it.RxBytes, it.TxBytes
+		d.RxRate += it.RxRate
+		d.TxRate += it.TxRate
+		d.RxMax, d.TxMax = math.Max(d.RxMax, rxPeak), math.Max(d.TxMax, txPeak)
+		a.stats[name] = d
+		a.n[name]++
+	}
+}
+
+func (a *ifaceAgg) result() model.IfaceStats {
+	for name, d := range a.stats {
+		d.RxRate /= float64(a.n[name])
+		d.TxRate /= float64(a.n[name])
+		a.stats[name] = d
+	}
+	return a.stats
+}
+
+// healthAgg folds NodeHealth samples (or minute rollups) into one bucket, see model.NodeHealth
+type healthAgg struct {
+	h model.NodeHealth
+	n int
+}
+
+func (a *healthAgg) add(h model.NodeHealth) {
+	a.n++
+	a.h.Load1 += h.Load1
+	a.h.Load5 += h.Load5
+	a.h.Load15 += h.Load15
+	a.h.GostCPU += h.GostCPU
+	a.h.GostRSS = max(a.h.GostRSS, h.GostRSS)
+	a.h.GostFDs = max(a.h.GostFDs, h.GostFDs)
+	a.h.FDsOpen = max(a.h.FDsOpen, h.FDsOpen)
+	a.h.Conntrack = max(a.h.Conntrack, h.Conntrack)
+	for st, c := range h.TCP {
+		if a.h.TCP == nil {
+			a.h.TCP = model.TCPStates{}
+		}
+		a.h.TCP[st] = max(a.h.TCP[st], c)
+	}
+	a.h.DiskUsed, a.h.DiskTotal = h.DiskUsed, h.DiskTotal
+	a.h.GostRestarts, a.h.GostFDLimit = h.GostRestarts, h.GostFDLimit
+	a.h.FDsMax, a.h.ConntrackMax = h.FDsMax, h.ConntrackMax
+}
+
+func (a *healthAgg) result() model.NodeHealth {
+	if a.n > 0 {
+		n := float64(a.n)
+		a.h.Load1, a.h.Load5, a.h.Load15, a.h.GostCPU = a.h.Load1/n, a.h.Load5/n, a.h.Load15/n, a.h.GostCPU/n
+	}
+	return a.h
+}
+
+func rollupProbeHour(end int64) {
+	start := rollupStart(&model.NodeProbeRollup{}, res1h, &model.NodeProbeRollup{}, "res = '1m'", time.Hour)
+	if start <= 0 || start >= end {
+		return
+	}
+	var rows []model.NodeProbeRollup
+	dbpkg.DB.Where("res = ? AND time_ms >= ? AND time_ms < ?", res1m, start, end).Find(&rows)
+	upsertRollups(probeHourRollups(rows), "node_id", "target_id", "res", "time_ms")
+}
+
+// probeMinuteRollups folds raw probe results into 1-minute buckets per node and target
+func probeMinuteRollups(rows []model.NodeProbeResult) []model.NodeProbeRollup {
+	type key struct{ node, target, bucket int64 }
+	agg := map[key]*model.NodeProbeRollup{}
+	for _, r := range rows {
+		k := key{r.NodeID, r.TargetID, bucketOf(r.TimeMs, time.Minute)}
+		a := agg[k]
+		if a == nil {
+			a = &model.NodeProbeRollup{NodeID: k.node, TargetID: k.target, Res: res1m, TimeMs: k.bucket}
+			agg[k] = a
+		}
+		a.Samples++
+		if r.OK != 1 {
+			continue
+		}
+		if a.OKCount == 0 || r.RTTMs < a.RTTMin {
+			a.RTTMin = r.RTTMs
+		}
+		if r.RTTMs > a.RTTMax {
+			a.RTTMax = r.RTTMs
+		}
+		a.RTTAvg += float64(r.RTTMs)
+		a.OKCount++
+	}
+	out := make([]model.NodeProbeRollup, 0, len(agg))
+	for _, a := range agg {
+		if a.OKCount > 0 {
+			a.RTTAvg /= float64(a.OKCount)
+		}
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TimeMs < out[j].TimeMs })
+	return out
+}
+
+// probeHourRollups folds 1-minute probe buckets into hours
+func probeHourRollups(rows []model.NodeProbeRollup) []model.NodeProbeRollup {
+	type key struct{ node, target, bucket int64 }
+	agg := map[key]*model.NodeProbeRollup{}
+	for _, r := range rows {
+		k := key{r.NodeID, r.TargetID, bucketOf(r.TimeMs, time.Hour)}
+		a := agg[k]
+		if a == nil {
+			a = &model.NodeProbeRollup{NodeID: r.NodeID, TargetID: r.TargetID, Res: res1h, TimeMs: k.bucket}
+			agg[k] = a
+		}
+		a.Samples += r.Samples
+		if r.OKCount == 0 {
+			continue
+		}
+		if a.OKCount == 0 || r.RTTMin < a.RTTMin {
+			a.RTTMin = r.RTTMin
+		}
+		if r.RTTMax > a.RTTMax {
+			a.RTTMax = r.RTTMax
+		}
+		a.RTTAvg += r.RTTAvg * float64(r.OKCount)
+		a.OKCount += r.OKCount
+	}
+	out := make([]model.NodeProbeRollup, 0, len(agg))
+	for _, a := range agg {
+		if a.OKCount > 0 {
+			a.RTTAvg /= float64(a.OKCount)
+		}
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TimeMs < out[j].TimeMs })
+	return out
+}
+
+// rerollProbeLate recomputes the buckets a late probe batch falls into when they were rolled
+// up already: the regular run only moves forward, and agents that could not report for a
+// while send their buffered results with the original times
+func rerollProbeLate(nodeID int64, rows []model.NodeProbeResult) {
+	done := rollupStart(&model.NodeProbeRollup{}, res1m, &model.NodeProbeResult{}, "", time.Minute)
+	from, to := int64(0), int64(0)
+	for _, r := range rows {
+		if r.TimeMs >= done {
+			continue
+		}
+		b := bucketOf(r.TimeMs, time.Minute)
+		if from == 0 || b < from {
+			from = b
+		}
+		if b+time.Minute.Milliseconds() > to {
+			to = b + time.Minute.Milliseconds()
+		}
+	}
+	if from == 0 {
+		return
+	}
+	var raw []model.NodeProbeResult
+	dbpkg.DB.Where("node_id = ? AND time_ms >= ? AND time_ms < ?", nodeID, from, to).Find(&raw)
+	if !upsertRollups(probeMinuteRollups(raw), "node_id", "target_id", "res", "time_ms") {
+		return
+	}
+	doneHour := rollupStart(&model.NodeProbeRollup{}, res1h, &model.NodeProbeRollup{}, "res = '1m'", time.Hour)
+	from = bucketOf(from, time.Hour)
+	if to > doneHour {
+		to = doneHour
+	}
+	if from >= to {
+		return
+	}
+	to = bucketOf(to-1, time.Hour) + time.Hour.Milliseconds()
+	var minutes []model.NodeProbeRollup
+	dbpkg.DB.Where("node_id = ? AND res = ? AND time_ms >= ? AND time_ms < ?", nodeID, res1m, from, to).Find(&minutes)
+	upsertRollups(probeHourRollups(minutes), "node_id", "target_id", "res", "time_ms")
+}
+
+// upsertRollups writes buckets, replacing ones written before
+func upsertRollups[T any](rows []T, keys ...string) bool {
+	if len(rows) == 0 {
+		return true
+	}
+	cols := make([]clause.Column, 0, len(keys))
+	for _, k := range keys {
+		cols = append(cols, clause.Column{Name: k})
+	}
+	if err := dbpkg.DB.Clauses(clause.OnConflict{Columns: cols, UpdateAll: true}).CreateInBatches(&rows, 500).Error; err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "metrics_rollup_err", "rows": len(rows), "error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// pruneMetrics drops data past its retention; raw rows are kept until they are rolled up
+func pruneMetrics() {
+	now := time.Now()
+	rawCut := now.Add(-rawRetention()).UnixMilli()
+	for _, t := range []struct {
+		rollup, raw any
+	}{{&model.NodeSysInfoRollup{}, &model.NodeSysInfo{}}, {&model.NodeProbeRollup{}, &model.NodeProbeResult{}}} {
+		var done *int64
+		dbpkg.DB.Model(t.rollup).Where("res = ?", res1m).Select("MAX(time_ms)").Scan(&done)
+		if done != nil {
+			dbpkg.DB.Where("time_ms < ?", min64(rawCut, *done)).Delete(t.raw)
+		}
+		dbpkg.DB.Where("res = ? AND time_ms < ?", res1m, now.Add(-rollupRetention(res1m)).UnixMilli()).Delete(t.rollup)
+		dbpkg.DB.Where("res = ? AND time_ms < ?", res1h, now.Add(-rollupRetention(res1h)).UnixMilli()).Delete(t.rollup)
+	}
+}
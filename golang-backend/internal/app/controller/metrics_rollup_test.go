@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"network-panel/golang-backend/internal/app/model"
+)
+
+func TestCounterDelta(t *testing.T) {
+	for _, c := range []struct{ prev, cur, want int64 }{
+		{100, 250, 150},
+		{100, 100, 0},
+		{500, 40, 40}, // reset: counts from zero
+		{0, 7, 7},
+	} {
+		if got := counterDelta(c.prev, c.cur); got != c.want {
+			t.Errorf("counterDelta(%d, %d) = %d, want %d", c.prev, c.cur, got, c.want)
+		}
+	}
+}
+
+func TestSysinfoMinuteRollups(t *testing.T) {
+	const m = 60_000
+	sample := func(node, ms int64, cpu, rx float64, ifaces model.IfaceStats) model.NodeSysInfo {
+		s := model.NodeSysInfo{NodeID: node, TimeMs: ms, CPU: cpu, Mem: 50, RxRate: rx, BytesRx: ms, Ifaces: ifaces}
+		s.Load1 = cpu / 10
+		s.GostRSS = int64(cpu)
+		return s
+	}
+	rows := []model.NodeSysInfo{
+		sample(1, 10*m+1000, 10, 100, model.IfaceStats{"eth0": {RxRate: 60}, "wg0": {RxRate: 40}}),
+		// wg0 went away: the node rate follows the agent, not a drop of the summed counters
+		sample(1, 10*m+6000, 30, 300, model.IfaceStats{"eth0": {RxRate: 300, RxBytes: 9}}),
+		sample(1, 11*m, 20, 200, nil),
+		sample(2, 10*m+2000, 5, 0, nil),
+	}
+	got := sysinfoMinuteRollups(rows)
+	if len(got) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(got))
+	}
+	b := got[0]
+	if b.NodeID != 1 || b.Res != res1m || b.TimeMs != 10*m || b.Samples != 2 {
+		t.Fatalf("bucket key = %d/%s/%d samples %d", b.NodeID, b.Res, b.TimeMs, b.Samples)
+	}
+	if b.CPU != 20 || b.CPUMin != 10 || b.CPUMax != 30 || b.MemMin != 50 || b.MemMax != 50 {
+		t.Fatalf("cpu/mem = %v %v %v %v %v", b.CPU, b.CPUMin, b.CPUMax, b.MemMin, b.MemMax)
+	}
+	if b.RxRate != 200 || b.BytesRx != 10*m+6000 {
+		t.Fatalf("rxRate = %v, bytesRx = %d", b.RxRate, b.BytesRx)
+	}
+	wantIfaces := model.IfaceStats{
+		"eth0": {RxBytes: 9, RxRate: 180, RxMax: 300},
+		"wg0":  {RxRate: 40, RxMax: 40},
+	}
+	if !reflect.DeepEqual(b.Ifaces, wantIfaces) {
+		t.Fatalf("ifaces = %+v", b.Ifaces)
+	}
+	if b.Load1 != 2 || b.GostRSS != 30 {
+		t.Fatalf("health load1 = %v, gostRss = %d", b.Load1, b.GostRSS)
+	}
+	if got[1].TimeMs != 11*m || got[1].Samples != 1 || got[2].NodeID != 2 || got[2].CPUMin != 5 {
+		t.Fatalf("later buckets = %+v, %+v", got[1], got[2])
+	}
+}
+
+func TestProbeRollups(t *testing.T) {
+	const m = 60_000
+	raw := []model.NodeProbeResult{
+		{NodeID: 1, TargetID: 7, TimeMs: 0, OK: 1, RTTMs: 20},
+		{NodeID: 1, TargetID: 7, TimeMs: 30_000, OK: 1, RTTMs: 40},
+		{NodeID: 1, TargetID: 7, TimeMs: 45_000, OK: 0, RTTMs: 0},
+		{NodeID: 1, TargetID: 7, TimeMs: m, OK: 0},
+		{NodeID: 1, TargetID: 7, TimeMs: 2 * m, OK: 1, RTTMs: 90},
+	}
+	mins := probeMinuteRollups(raw)
+	want := []model.NodeProbeRollup{
+		{NodeID: 1, TargetID: 7, Res: res1m, TimeMs: 0, Samples: 3, OKCount: 2, RTTMin: 20, RTTAvg: 30, RTTMax: 40},
+		{NodeID: 1, TargetID: 7, Res: res1m, TimeMs: m, Samples: 1},
+		{NodeID: 1, TargetID: 7, Res: res1m, TimeMs: 2 * m, Samples: 1, OKCount: 1, RTTMin: 90, RTTAvg: 90, RTTMax: 90},
+	}
+	if !reflect.DeepEqual(mins, want) {
+		t.Fatalf("minutes = %+v", mins)
+	}
+
+	// the hour average weighs each minute by its successful probes
+	hours := probeHourRollups(mins)
+	wantHour := []model.NodeProbeRollup{{NodeID: 1, TargetID: 7, Res: res1h, TimeMs: 0, Samples: 5, OKCount: 3, RTTMin: 20, RTTAvg: 50, RTTMax: 90}}
+	if !reflect.DeepEqual(hours, wantHour) {
+		t.Fatalf("hours = %+v", hours)
+	}
+}
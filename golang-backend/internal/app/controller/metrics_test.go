@@ -0,0 +1,62 @@
+//go:build !loong64
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/config"
+)
+
+func TestMetricsToken(t *testing.T) {
+	useTestDB(t, &model.Node{})
+	prev := config.C.Metrics.Token
+	config.C.Metrics.Token = "s3cret"
+	t.Cleanup(func() { config.C.Metrics.Token = prev })
+	gin.SetMode(gin.TestMode)
+
+	for auth, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"s3cret":         http.StatusUnauthorized, // the scheme is required
+		"bearer s3cret":  http.StatusUnauthorized,
+		"Basic s3cret":   http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"Bearer s3cret ": http.StatusUnauthorized,
+		"Bearer s3cret":  http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			c.Request.Header.Set("Authorization", auth)
+		}
+		Metrics(c)
+		if w.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", auth, w.Code, want)
+		}
+		if want == http.StatusOK && !strings.Contains(w.Body.String(), "# TYPE np_ws_commands_total counter\n") {
+			t.Errorf("exposition missing np_ws_commands_total:\n%s", w.Body.String())
+		}
+	}
+}
+
+func TestForgetForwardMetrics(t *testing.T) {
+	countFlowBytes(flowFlushResult{
+		userInc: map[int64]*flowDelta{7: {in: 1, out: 2}},
+		fwdInc:  map[int64]*flowDelta{8: {in: 3, out: 4}, 9: {in: 5, out: 6}},
+	})
+	forgetUserMetrics(7)
+	forgetForwardMetrics(8)
+	if userBytes.Delete("7", "in") || forwardBytes.Delete("8", "out") {
+		t.Fatal("series of a removed user or forward still present")
+	}
+	if !forwardBytes.Delete("9", "in") {
+		t.Fatal("series of another forward was dropped")
+	}
+}
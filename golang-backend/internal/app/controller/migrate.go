@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
+	"network-panel/golang-backend/internal/app/lifecycle"
 	"network-panel/golang-backend/internal/app/model"
 	"network-panel/golang-backend/internal/app/response"
 	dbpkg "network-panel/golang-backend/internal/db"
@@ -42,6 +44,8 @@ func MigrateFrom(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("迁移失败: "+err.Error()))
 		return
 	}
+	MigrateTunnelHops()
+	cleanTunnelHopOrphans()
 	c.JSON(http.StatusOK, response.Ok(map[string]any{"tables": stats}))
 }
 
@@ -218,7 +222,9 @@ func MigrateStart(c *gin.Context) {
 	migMu.Lock()
 	migJobs[job.JobID] = job
 	migMu.Unlock()
-	go func() {
+	// a server shutdown cancels ctx: the running statement aborts and the job stops
+	// before the next table, the remaining tables can be copied by starting it again
+	lifecycle.Go(func(ctx context.Context) {
 		defer func() { job.UpdatedAt = time.Now().UnixMilli() }()
 		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&timeout=10s", p.User, p.Password, p.Host, p.Port, p.DBName)
 		src, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
@@ -227,8 +233,11 @@ func MigrateStart(c *gin.Context) {
 			job.Error = "连接源数据库失败"
 			return
 		}
-		runWithProgress(src, dbpkg.DB, job)
-	}()
+		if sqlDB, err := src.DB(); err == nil {
+			defer sqlDB.Close()
+		}
+		runWithProgress(ctx, src, dbpkg.DB, job)
+	})
 	c.JSON(http.StatusOK, response.Ok(map[string]any{"jobId": job.JobID}))
 }
 
@@ -245,13 +254,21 @@ func MigrateStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, response.Ok(job))
 }
 
-func runWithProgress(src *gorm.DB, dst *gorm.DB, job *migProgress) {
+func runWithProgress(ctx context.Context, src *gorm.DB, dst *gorm.DB, job *migProgress) {
+	src, dst = src.WithContext(ctx), dst.WithContext(ctx)
 	update := func() { job.UpdatedAt = time.Now().UnixMilli() }
 	do := func(table string, fn func() (tableStat, error)) bool {
-		st, err := fn()
+		var st tableStat
+		err := ctx.Err()
+		if err == nil {
+			st, err = fn()
+		}
 		if err != nil {
 			job.Status = "error"
 			job.Error = table + ":" + err.Error()
+			if ctx.Err() != nil {
+				job.Error = "面板关闭，迁移中断于 " + table + "，可重新执行"
+			}
 			update()
 			return false
 		}
@@ -278,6 +295,9 @@ func runWithProgress(src *gorm.DB, dst *gorm.DB, job *migProgress) {
 			return
 		}
 	}
+	// vite_config of an older panel may still carry tunnel_path_* and friends
+	MigrateTunnelHops()
+	cleanTunnelHopOrphans()
 	job.Status = "done"
 	update()
 }
@@ -140,34 +140,6 @@ func monthsToDays(m int) int {
     }
 }
 
-// POST /api/v1/node/delete
-func NodeDelete(c *gin.Context) {
-    var p struct {
-        ID         int64 `json:"id"`
-        Uninstall  bool  `json:"uninstall"`
-    }
-	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
-		return
-	}
-    // usage checks
-    var cnt int64
-    dbpkg.DB.Model(&model.Tunnel{}).Where("in_node_id = ?", p.ID).Or("out_node_id = ?", p.ID).Count(&cnt)
-    if cnt > 0 {
-        c.JSON(http.StatusOK, response.ErrMsg("该节点仍被隧道使用"))
-        return
-    }
-    // best-effort uninstall agent on node if requested
-    if p.Uninstall {
-        _ = sendWSCommand(p.ID, "UninstallAgent", map[string]any{"reason": "node_deleted"})
-    }
-    if err := dbpkg.DB.Delete(&model.Node{}, p.ID).Error; err != nil {
-        c.JSON(http.StatusOK, response.ErrMsg("节点删除失败"))
-        return
-    }
-    c.JSON(http.StatusOK, response.OkMsg("节点删除成功"))
-}
-
 // POST /api/v1/node/install
 func NodeInstallCmd(c *gin.Context) {
 	var p struct {
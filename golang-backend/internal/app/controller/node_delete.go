@@ -0,0 +1,456 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Node deletion. The preview lists every tunnel, forward and hop that depends on a node;
+// the delete then refuses (default), migrates those tunnels to a replacement node, or
+// cascades. Rows owned by the node itself always go with it.
+
+const (
+	nodeDeleteRefuse  = "refuse"
+	nodeDeleteMigrate = "migrate"
+	nodeDeleteCascade = "cascade"
+)
+
+// nodeOwnedTables hold per-node state only; they are deleted with the node in every mode
+var nodeOwnedTables = []struct {
+	name  string
+	model any
+}{
+	{"exit_setting", &model.ExitSetting{}},
+	{"port_allocation", &model.PortAllocation{}},
+	{"node_probe_result", &model.NodeProbeResult{}},
+	{"node_probe_rollup", &model.NodeProbeRollup{}},
+	{"node_sysinfo", &model.NodeSysInfo{}},
+	{"node_sysinfo_rollup", &model.NodeSysInfoRollup{}},
+	{"node_disconnect_log", &model.NodeDisconnectLog{}},
+	{"node_runtime", &model.NodeRuntime{}},
+	{"bus_node_route", &model.BusNodeRoute{}},
+	{"flow_report", &model.FlowReport{}},
+}
+
+type nodeImpactTunnel struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Type     int    `json:"type"`
+	Role     string `json:"role"` // entry, mid or exit
+	Forwards int    `json:"forwards"`
+}
+
+type nodeImpactForward struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	UserID     int64  `json:"userId"`
+	UserName   string `json:"userName"`
+	TunnelID   int64  `json:"tunnelId"`
+	TunnelName string `json:"tunnelName"`
+	InPort     int    `json:"inPort"`
+	Role       string `json:"role"`
+}
+
+type nodeImpactHop struct {
+	TunnelID   int64  `json:"tunnelId"`
+	TunnelName string `json:"tunnelName"`
+	Role       string `json:"role"`
+	Inx        int    `json:"inx"`
+	Port       int    `json:"port"`
+	Interface  string `json:"interface"`
+	BindIP     string `json:"bindIp"`
+}
+
+type nodeImpactRule struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Only bool   `json:"only"` // the rule names no other node and is disabled on delete
+}
+
+type nodeImpact struct {
+	NodeID     int64               `json:"nodeId"`
+	NodeName   string              `json:"nodeName"`
+	Online     bool                `json:"online"`
+	Tunnels    []nodeImpactTunnel  `json:"tunnels"`
+	Forwards   []nodeImpactForward `json:"forwards"`
+	Hops       []nodeImpactHop     `json:"hops"`
+	AlertRules []nodeImpactRule    `json:"alertRules"`
+	Data       map[string]int64    `json:"data"`     // node-owned rows removed with the node
+	Blocking   bool                `json:"blocking"` // mode refuse would refuse
+
+	// kept for the delete; not part of the report
+	tunnels  []model.Tunnel
+	paths    map[int64][]int64
+	forwards map[int64][]model.Forward
+}
+
+type nodeDeleteResult struct {
+	ForwardID int64  `json:"forwardId"`
+	Name      string `json:"name"`
+	TunnelID  int64  `json:"tunnelId"`
+	Action    string `json:"action"` // migrated, redeployed or deleted
+	OK        bool   `json:"ok"`
+	Msg       string `json:"msg,omitempty"`
+}
+
+// POST /api/v1/node/delete/preview {id}
+func NodeDeletePreview(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(computeNodeImpact(n)))
+}
+
+// POST /api/v1/node/delete {id, uninstall?, mode?: refuse|migrate|cascade, replaceNodeId?}
+// refuse: fail while tunnels use the node. migrate: entry/exit/mid roles move to
+// replaceNodeId and the forwards are redeployed. cascade: tunnels with the node as entry
+// or exit are deleted with their forwards, mid hops are dropped from paths.
+func NodeDelete(c *gin.Context) {
+	var p struct {
+		ID            int64  `json:"id"`
+		Uninstall     bool   `json:"uninstall"`
+		Mode          string `json:"mode"`
+		ReplaceNodeID int64  `json:"replaceNodeId"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	imp := computeNodeImpact(n)
+	results := []nodeDeleteResult{}
+	var err error
+	switch p.Mode {
+	case "", nodeDeleteRefuse:
+		if imp.Blocking {
+			c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("该节点仍被 %d 条隧道、%d 条转发使用，请先处理，或选择迁移/级联删除", len(imp.Tunnels), len(imp.Forwards))))
+			return
+		}
+	case nodeDeleteMigrate:
+		results, err = migrateNodeTunnels(imp, p.ReplaceNodeID)
+	case nodeDeleteCascade:
+		results, err = cascadeNodeTunnels(imp)
+	default:
+		c.JSON(http.StatusOK, response.ErrMsg("删除模式错误"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
+	}
+	// the SS exit service lives only on this node
+	var exit model.ExitSetting
+	if dbpkg.DB.Where("node_id = ?", n.ID).First(&exit).Error == nil {
+		_ = sendWSCommand(n.ID, "DeleteService", map[string]any{"services": []string{fmt.Sprintf("exit_ss_%d", exit.Port)}})
+	}
+	// best-effort uninstall agent on node if requested
+	if p.Uninstall {
+		_ = sendWSCommand(n.ID, "UninstallAgent", map[string]any{"reason": "node_deleted"})
+	}
+	if err := deleteNodeRows(n.ID, imp.AlertRules); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点删除失败"))
+		return
+	}
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+	jlog(map[string]interface{}{"event": "node_deleted", "nodeId": n.ID, "mode": p.Mode, "tunnels": len(imp.Tunnels), "forwards": len(results), "failed": failed})
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"mode": p.Mode, "forwards": results, "failed": failed}))
+}
+
+// computeNodeImpact collects everything that references node n
+func computeNodeImpact(n model.Node) nodeImpact {
+	imp := nodeImpact{
+		NodeID:     n.ID,
+		NodeName:   n.Name,
+		Online:     isNodeConnected(n.ID),
+		Tunnels:    []nodeImpactTunnel{},
+		Forwards:   []nodeImpactForward{},
+		Hops:       []nodeImpactHop{},
+		AlertRules: []nodeImpactRule{},
+		Data:       map[string]int64{},
+		paths:      map[int64][]int64{},
+		forwards:   map[int64][]model.Forward{},
+	}
+	var hops []model.TunnelHop
+	dbpkg.DB.Where("node_id = ?", n.ID).Order("tunnel_id, role, inx").Find(&hops)
+	midOf := []int64{}
+	for _, h := range hops {
+		if h.Role == hopMid {
+			midOf = append(midOf, h.TunnelID)
+		}
+	}
+	q := dbpkg.DB.Where("in_node_id = ? OR out_node_id = ?", n.ID, n.ID)
+	if len(midOf) > 0 {
+		q = dbpkg.DB.Where("in_node_id = ? OR out_node_id = ? OR id IN ?", n.ID, n.ID, midOf)
+	}
+	q.Order("id").Find(&imp.tunnels)
+	names := map[int64]string{}
+	for _, t := range imp.tunnels {
+		path := getTunnelPathNodes(t.ID)
+		role := tunnelNodeRole(t, path, n.ID)
+		var fs []model.Forward
+		dbpkg.DB.Where("tunnel_id = ?", t.ID).Order("id").Find(&fs)
+		imp.paths[t.ID] = path
+		imp.forwards[t.ID] = fs
+		names[t.ID] = t.Name
+		imp.Tunnels = append(imp.Tunnels, nodeImpactTunnel{ID: t.ID, Name: t.Name, Type: t.Type, Role: role, Forwards: len(fs)})
+		for _, f := range fs {
+			imp.Forwards = append(imp.Forwards, nodeImpactForward{ID: f.ID, Name: f.Name, UserID: f.UserID, UserName: f.UserName, TunnelID: t.ID, TunnelName: t.Name, InPort: f.InPort, Role: role})
+		}
+	}
+	for _, h := range hops {
+		imp.Hops = append(imp.Hops, nodeImpactHop{TunnelID: h.TunnelID, TunnelName: names[h.TunnelID], Role: h.Role, Inx: h.Inx, Port: h.Port, Interface: h.Interface, BindIP: h.BindIP})
+	}
+	var rules []model.AlertRule
+	dbpkg.DB.Where("node_ids <> ''").Find(&rules)
+	for _, r := range rules {
+		if ids := parseIDList(r.NodeIDs); ids[n.ID] {
+			imp.AlertRules = append(imp.AlertRules, nodeImpactRule{ID: r.ID, Name: r.Name, Only: len(ids) == 1})
+		}
+	}
+	for _, t := range nodeOwnedTables {
+		var cnt int64
+		dbpkg.DB.Model(t.model).Where("node_id = ?", n.ID).Count(&cnt)
+		if cnt > 0 {
+			imp.Data[t.name] = cnt
+		}
+	}
+	imp.Blocking = len(imp.Tunnels) > 0
+	return imp
+}
+
+// migrateNodeTunnels moves every role of the node to repl and redeploys the forwards
+func migrateNodeTunnels(imp nodeImpact, replID int64) ([]nodeDeleteResult, error) {
+	var repl model.Node
+	if replID <= 0 || replID == imp.NodeID || dbpkg.DB.First(&repl, replID).Error != nil {
+		return nil, fmt.Errorf("请选择有效的替代节点")
+	}
+	for _, t := range imp.tunnels {
+		if tunnelNodeRole(t, imp.paths[t.ID], repl.ID) != "" {
+			return nil, fmt.Errorf("替代节点 %s 已在隧道 %s 中", repl.Name, t.Name)
+		}
+	}
+	minP, maxP := nodePortRange(repl.ID)
+	now := time.Now().UnixMilli()
+	err := dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Tunnel{}).Where("in_node_id = ?", imp.NodeID).
+			Updates(map[string]any{"in_node_id": repl.ID, "in_ip": repl.IP, "updated_time": now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Tunnel{}).Where("out_node_id = ?", imp.NodeID).
+			Updates(map[string]any{"out_node_id": repl.ID, "out_ip": repl.ServerIP, "updated_time": now}).Error; err != nil {
+			return err
+		}
+		// interface and bind IPs are addresses of the old node; ports only if still in range
+		if err := tx.Model(&model.TunnelHop{}).Where("node_id = ? AND role = ? AND (port < ? OR port > ?)", imp.NodeID, hopMid, minP, maxP).
+			Update("port", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.TunnelHop{}).Where("node_id = ? AND role = ?", imp.NodeID, hopMid).
+			Updates(map[string]any{"node_id": repl.ID, "interface": "", "bind_ip": "", "updated_time": now}).Error; err != nil {
+			return err
+		}
+		return tx.Where("node_id = ?", imp.NodeID).Delete(&model.TunnelHop{}).Error
+	})
+	if err != nil {
+		// nothing was torn down yet: the forwards keep running on the old layout
+		return nil, fmt.Errorf("隧道迁移失败: %v", err)
+	}
+	// only now that the new layout is stored, remove the services of the old one
+	items := []redeployItem{}
+	for _, old := range imp.tunnels {
+		for _, f := range imp.forwards[old.ID] {
+			removeForwardServices(f, old, imp.paths[old.ID])
+		}
+		var t model.Tunnel
+		if err := dbpkg.DB.First(&t, old.ID).Error; err != nil {
+			continue
+		}
+		for _, f := range imp.forwards[t.ID] {
+			items = append(items, redeployItem{f, t})
+		}
+	}
+	return redeployForwards(items, "migrated", "node_migrate"), nil
+}
+
+// cascadeNodeTunnels deletes tunnels that start or end on the node (with their forwards,
+// permissions and speed limits) and drops the node from the paths of the others
+func cascadeNodeTunnels(imp nodeImpact) ([]nodeDeleteResult, error) {
+	results := []nodeDeleteResult{}
+	for _, t := range imp.tunnels {
+		path := imp.paths[t.ID]
+		fs := imp.forwards[t.ID]
+		if tunnelNodeRole(t, path, imp.NodeID) == hopMid {
+			specs := make([]hopSpec, 0, len(path))
+			for _, id := range path {
+				if id != imp.NodeID {
+					specs = append(specs, hopSpec{NodeID: id})
+				}
+			}
+			if err := dbpkg.DB.Transaction(func(tx *gorm.DB) error { return saveMidHops(tx, t, specs, true) }); err != nil {
+				return results, fmt.Errorf("隧道 %s 路径更新失败: %v", t.Name, err)
+			}
+			items := make([]redeployItem, 0, len(fs))
+			for _, f := range fs {
+				removeForwardServices(f, t, path)
+				items = append(items, redeployItem{f, t})
+			}
+			results = append(results, redeployForwards(items, "redeployed", "node_cascade")...)
+			continue
+		}
+		fids := make([]int64, 0, len(fs))
+		for _, f := range fs {
+			removeForwardServices(f, t, path)
+			fids = append(fids, f.ID)
+		}
+		err := dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("tunnel_id = ?", t.ID).Delete(&model.Forward{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("tunnel_id = ?", t.ID).Delete(&model.UserTunnel{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("tunnel_id = ?", t.ID).Delete(&model.SpeedLimit{}).Error; err != nil {
+				return err
+			}
+			if err := deleteTunnelHops(tx, t.ID); err != nil {
+				return err
+			}
+			return tx.Delete(&model.Tunnel{}, t.ID).Error
+		})
+		if err != nil {
+			return results, fmt.Errorf("隧道 %s 删除失败: %v", t.Name, err)
+		}
+		releaseForwardPorts(fids...)
+		forgetForwardMetrics(fids...)
+		for _, f := range fs {
+			results = append(results, nodeDeleteResult{ForwardID: f.ID, Name: f.Name, TunnelID: t.ID, Action: "deleted", OK: true})
+		}
+	}
+	return results, nil
+}
+
+type redeployItem struct {
+	f model.Forward
+	t model.Tunnel
+}
+
+// redeployForwards re-reserves the ports of each forward on the current nodes of its tunnel
+// and pushes it; gost restarts once per node. A forward moved to another port says so in
+// msg, since its clients must follow.
+func redeployForwards(items []redeployItem, action, reason string) []nodeDeleteResult {
+	results := make([]nodeDeleteResult, 0, len(items))
+	batch := newDeployBatch()
+	deployed := map[int]int{} // batch index -> result index
+	for _, it := range items {
+		f, t := it.f, it.t
+		r := nodeDeleteResult{ForwardID: f.ID, Name: f.Name, TunnelID: t.ID, Action: action, OK: true}
+		inPort, outPort := f.InPort, f.OutPort
+		releaseForwardMidPorts(f.ID)
+		if err := reserveForwardPorts(&f, t); err != nil {
+			r.OK, r.Msg = false, err.Error()
+			results = append(results, r)
+			continue
+		}
+		moved := []string{}
+		if f.InPort != inPort {
+			moved = append(moved, fmt.Sprintf("入口端口 %d 已被占用，改为 %d", inPort, f.InPort))
+		}
+		if outPort != nil && f.OutPort != nil && *f.OutPort != *outPort {
+			moved = append(moved, fmt.Sprintf("出口端口 %d 已被占用，改为 %d", *outPort, *f.OutPort))
+		}
+		r.Msg = strings.Join(moved, "; ")
+		deployed[batch.add(f, t)] = len(results)
+		results = append(results, r)
+	}
+	for i, err := range batch.finish(reason) {
+		if err == nil {
+			continue
+		}
+		r := &results[deployed[i]]
+		msg := "下发失败: " + err.Error()
+		if r.Msg != "" {
+			msg = r.Msg + "; " + msg
+		}
+		r.OK, r.Msg = false, msg
+	}
+	return results
+}
+
+// deleteNodeRows removes the node, its own rows and its remaining hop settings; alert rules
+// lose the node (rules that named only this node are disabled rather than widened to all);
+// its incidents are resolved and a pending offline alert is cancelled
+func deleteNodeRows(nodeID int64, rules []nodeImpactRule) error {
+	now := time.Now().UnixMilli()
+	err := dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("node_id = ?", nodeID).Delete(&model.TunnelHop{}).Error; err != nil {
+			return err
+		}
+		for _, t := range nodeOwnedTables {
+			if err := tx.Where("node_id = ?", nodeID).Delete(t.model).Error; err != nil {
+				return err
+			}
+		}
+		for _, r := range rules {
+			var rule model.AlertRule
+			if err := tx.First(&rule, r.ID).Error; err != nil {
+				continue
+			}
+			ids := []int64{}
+			for id := range parseIDList(rule.NodeIDs) {
+				if id != nodeID {
+					ids = append(ids, id)
+				}
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			keep := make([]string, 0, len(ids))
+			for _, id := range ids {
+				keep = append(keep, strconv.FormatInt(id, 10))
+			}
+			up := map[string]any{"node_ids": strings.Join(keep, ","), "updated_time": now}
+			if len(keep) == 0 {
+				up["enabled"] = 0
+			}
+			if err := tx.Model(&model.AlertRule{}).Where("id = ?", r.ID).Updates(up).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&model.Alert{}).Where("node_id = ? AND status <> ?", nodeID, alertResolved).
+			Updates(map[string]any{"status": alertResolved, "resolved_time_ms": now, "resolved_by": "node deleted"}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Node{}, nodeID).Error
+	})
+	if err == nil {
+		// a pending grace timer would raise an incident for a node that no longer exists
+		cancelOfflineAlert(nodeID)
+	}
+	return err
+}
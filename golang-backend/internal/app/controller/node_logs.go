@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Remote journal of a node (agent command QueryLogs). The agent streams LogChunk frames;
+// the panel collects them up to maxBytes and answers with one response.
+
+const (
+	nodeLogsDefaultBytes = 1 << 20
+	nodeLogsMaxBytes     = 4 << 20
+	nodeLogsTimeout      = 25 * time.Second // journalctl gets 20s on the agent, the UI waits 30s
+)
+
+// POST /api/v1/node/logs {id, units?: ["agent","agent2","gost"], lines?, since?, until?, grep?, maxBytes?}
+func NodeLogs(c *gin.Context) {
+	var p struct {
+		ID       int64    `json:"id" binding:"required"`
+		Units    []string `json:"units"`
+		Lines    int      `json:"lines"`
+		Since    string   `json:"since"`
+		Until    string   `json:"until"`
+		Grep     string   `json:"grep"`
+		MaxBytes int      `json:"maxBytes"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	if p.MaxBytes <= 0 {
+		p.MaxBytes = nodeLogsDefaultBytes
+	}
+	if p.MaxBytes > nodeLogsMaxBytes {
+		p.MaxBytes = nodeLogsMaxBytes
+	}
+	reqID := RandUUID()
+	q := wsproto.QueryLogsRequest{RequestID: reqID, Units: p.Units, Lines: p.Lines, Since: p.Since, Until: p.Until, Grep: p.Grep, MaxBytes: p.MaxBytes}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), nodeLogsTimeout)
+	defer cancel()
+	ch := pending.registerStream(reqID, 64)
+	defer pending.cancel(reqID)
+	if err := sendNodeFrame(n.ID, wsproto.TypeQueryLogs, reqID, q); err != nil {
+		msg := "节点未连接"
+		if strings.Contains(err.Error(), "does not support") {
+			msg = "节点 Agent 版本过旧，不支持日志查询，请先升级"
+		}
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+
+	lines := []string{}
+	size, next := 0, 0
+	truncated, incomplete := false, false
+	for done := false; !done; {
+		var res map[string]interface{}
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			c.JSON(http.StatusOK, response.ErrMsg("日志查询超时"))
+			return
+		}
+		if err := replyError(res); err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("节点查询失败: "+err.(*nodeReplyError).Message))
+			return
+		}
+		var chunk wsproto.LogChunk
+		b, _ := json.Marshal(res["data"])
+		if json.Unmarshal(b, &chunk) != nil {
+			continue
+		}
+		if chunk.Seq != next {
+			incomplete = true // frames dropped on a full buffer
+		}
+		next = chunk.Seq + 1
+		done = chunk.Done
+		truncated = truncated || chunk.Truncated
+		for _, ln := range chunk.Lines {
+			if size+len(ln)+1 > p.MaxBytes {
+				truncated = true
+				done = true
+				break
+			}
+			size += len(ln) + 1
+			lines = append(lines, ln)
+		}
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]interface{}{
+		"nodeId": n.ID, "lines": lines, "bytes": size, "truncated": truncated, "incomplete": incomplete, "timeMs": time.Now().UnixMilli(),
+	}))
+}
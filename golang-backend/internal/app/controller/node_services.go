@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"network-panel/golang-backend/internal/app/response"
@@ -18,32 +20,32 @@ func NodeQueryServices(c *gin.Context) {
 		return
 	}
 
+	reqID := RandUUID()
 	req := map[string]interface{}{
-		"requestId": RandUUID(),
+		"requestId": reqID,
 		"filter":    p.Filter,
 	}
-	// send explicit QueryServices command
-	if err := sendWSCommand(p.NodeID, "QueryServices", req); err != nil {
+	// send explicit QueryServices command and wait for the correlated result
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	res, err := nodeRequest(ctx, p.NodeID, "QueryServices", reqID, req)
+	if err != nil {
+		var nerr *nodeReplyError
+		if errors.As(err, &nerr) {
+			c.JSON(http.StatusOK, response.ErrMsg("节点查询失败: "+nerr.Message))
+			return
+		}
+		if ctx.Err() != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("查询超时"))
+			return
+		}
 		c.JSON(http.StatusOK, response.ErrMsg("节点未连接: "+err.Error()))
 		return
 	}
-	// wait for result using the same waiter map
-	ch := make(chan map[string]interface{}, 1)
-	diagMu.Lock()
-	diagWaiters[req["requestId"].(string)] = ch
-	diagMu.Unlock()
-	select {
-	case res := <-ch:
-		// expect {type: QueryServicesResult, requestId, data: [...]}
-		if data, _ := res["data"].([]interface{}); data != nil {
-			c.JSON(http.StatusOK, response.Ok(data))
-			return
-		}
-		c.JSON(http.StatusOK, response.Ok(res["data"]))
-	case <-time.After(5 * time.Second):
-		diagMu.Lock()
-		delete(diagWaiters, req["requestId"].(string))
-		diagMu.Unlock()
-		c.JSON(http.StatusOK, response.ErrMsg("查询超时"))
+	// expect {type: QueryServicesResult, requestId, data: [...]}
+	if data, _ := res["data"].([]interface{}); data != nil {
+		c.JSON(http.StatusOK, response.Ok(data))
+		return
 	}
+	c.JSON(http.StatusOK, response.Ok(res["data"]))
 }
@@ -0,0 +1,539 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Notifications: notify() fans an event out to every enabled channel whose routing
+// matches, as one notification_delivery row per channel. The notifier worker sends due
+// rows and reschedules failures with exponential backoff; the rows double as the
+// delivery log.
+
+const (
+	notifyPollInterval = 3 * time.Second
+	notifyMaxAttempts  = 6
+	notifyBackoffBase  = 10 * time.Second
+	notifyBackoffMax   = 30 * time.Minute
+	notifyLease        = time.Minute // a claimed row is retried after this if the sender died
+	notifyLogRetention = 30 * 24 * time.Hour
+)
+
+const (
+	channelWebhook     = "webhook"
+	channelJSONWebhook = "json_webhook"
+	channelTelegram    = "telegram"
+	channelSMTP        = "smtp"
+)
+
+// channelConfig is the union of all channel type settings (stored as JSON)
+type channelConfig struct {
+	// webhook / json_webhook
+	URL          string            `json:"url,omitempty"`
+	Method       string            `json:"method,omitempty"` // webhook: GET|POST
+	Headers      map[string]string `json:"headers,omitempty"`
+	Secret       string            `json:"secret,omitempty"` // json_webhook HMAC key
+	Template     string            `json:"template,omitempty"`
+	UserTemplate string            `json:"userTemplate,omitempty"` // user events; falls back to template
+	// telegram
+	BotToken string `json:"botToken,omitempty"`
+	ChatID   string `json:"chatId,omitempty"`
+	APIBase  string `json:"apiBase,omitempty"` // default https://api.telegram.org
+	// smtp
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`  // comma separated
+	TLS      string `json:"tls,omitempty"` // none|starttls|tls
+	Subject  string `json:"subject,omitempty"`
+	// telegram / smtp: user events go to the user's notifyContact, else to chatId/to
+	UserContact bool `json:"userContact,omitempty"`
+}
+
+// recipient is the chat id (telegram) or address list (smtp) ev is sent to; "" = none
+func (c channelConfig) recipient(typ string, ev NotifyEvent) string {
+	if c.UserContact && ev.User.ID != 0 && strings.TrimSpace(ev.User.Contact) != "" {
+		return strings.TrimSpace(ev.User.Contact)
+	}
+	switch typ {
+	case channelTelegram:
+		return strings.TrimSpace(c.ChatID)
+	case channelSMTP:
+		return strings.TrimSpace(c.To)
+	}
+	return ""
+}
+
+// channelSecretKeys are masked in API responses
+var channelSecretKeys = []string{"secret", "botToken", "password"}
+
+const secretMask = "******"
+
+// notify queues ev for every matching channel. The delivery keeps the flat event JSON
+// (webhook bodies) and the template context.
+func notify(ev NotifyEvent) {
+	var chans []model.NotificationChannel
+	if err := dbpkg.DB.Where("enabled = ?", 1).Find(&chans).Error; err != nil || len(chans) == 0 {
+		return
+	}
+	pb, _ := json.Marshal(ev.payload())
+	cb, _ := json.Marshal(ev)
+	now := time.Now().UnixMilli()
+	for _, ch := range chans {
+		if !eventRouted(ch.Events, ev.Event) {
+			continue
+		}
+		if (ch.Type == channelTelegram || ch.Type == channelSMTP) && parseChannelConfig(ch.Config).recipient(ch.Type, ev) == "" {
+			continue
+		}
+		d := model.NotificationDelivery{
+			ChannelID: ch.ID, ChannelName: ch.Name, Event: ev.Event,
+			Payload: string(pb), Context: string(cb),
+			Status: "pending", NextAttemptMs: now, CreatedTime: now,
+		}
+		if err := dbpkg.DB.Create(&d).Error; err != nil {
+			jlogAt(slog.LevelError, map[string]interface{}{"event": "notify_enqueue_err", "channel": ch.Name, "notify": ev.Event, "error": err.Error()})
+		}
+	}
+}
+
+// eventRouted matches a channel's event rules: "" or "*" = all, "quota_*" = prefix
+func eventRouted(rules, event string) bool {
+	rules = strings.TrimSpace(rules)
+	if rules == "" || rules == "*" {
+		return true
+	}
+	for _, r := range strings.Split(rules, ",") {
+		r = strings.TrimSpace(r)
+		if r == event || (strings.HasSuffix(r, "*") && strings.HasPrefix(event, strings.TrimSuffix(r, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunNotifier moves the legacy callback settings into a channel, then delivers queued notifications
+func RunNotifier(ctx context.Context) {
+	migrateLegacyCallback()
+	t := time.NewTicker(notifyPollInterval)
+	defer t.Stop()
+	lastPrune := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		deliverDue(ctx)
+		if time.Since(lastPrune) > time.Hour {
+			cutoff := time.Now().Add(-notifyLogRetention).UnixMilli()
+			dbpkg.DB.Where("status <> ? AND created_time < ?", "pending", cutoff).Delete(&model.NotificationDelivery{})
+			lastPrune = time.Now()
+		}
+	}
+}
+
+// deliverDue sends the due deliveries; rows not reached when ctx ends stay due for the
+// next poll (of any replica)
+func deliverDue(ctx context.Context) {
+	now := time.Now().UnixMilli()
+	var due []model.NotificationDelivery
+	dbpkg.DB.Where("status = ? AND next_attempt_ms <= ?", "pending", now).Order("id asc").Limit(50).Find(&due)
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		// claim: another replica polling the same table skips rows it lost the race for
+		res := dbpkg.DB.Model(&model.NotificationDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_ms = ?", d.ID, "pending", d.NextAttemptMs).
+			Update("next_attempt_ms", now+notifyLease.Milliseconds())
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		deliverOne(d)
+	}
+}
+
+func deliverOne(d model.NotificationDelivery) {
+	var ch model.NotificationChannel
+	err := dbpkg.DB.First(&ch, d.ChannelID).Error
+	final := false // no point in retrying
+	switch {
+	case err != nil:
+		err, final = errors.New("channel deleted"), true
+	case ch.Enabled != 1:
+		err, final = errors.New("channel disabled"), true
+	default:
+		err = sendNotification(ch, d.Payload, d.Context)
+	}
+	attempts := d.Attempts + 1
+	now := time.Now()
+	upd := map[string]any{"attempts": attempts}
+	switch {
+	case err == nil:
+		upd["status"], upd["sent_time"], upd["last_error"] = "sent", now.UnixMilli(), ""
+	case final || attempts >= notifyMaxAttempts:
+		upd["status"], upd["last_error"] = "failed", err.Error()
+	default:
+		upd["next_attempt_ms"], upd["last_error"] = now.Add(notifyBackoff(attempts)).UnixMilli(), err.Error()
+	}
+	dbpkg.DB.Model(&model.NotificationDelivery{}).Where("id = ?", d.ID).Updates(upd)
+	if err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "notify_send_err", "channel": d.ChannelName, "notify": d.Event, "attempt": attempts, "error": err.Error()})
+	}
+}
+
+// notifyBackoff: 10s, 20s, 40s, ... capped at notifyBackoffMax
+func notifyBackoff(attempts int) time.Duration {
+	d := notifyBackoffBase << (attempts - 1)
+	if d <= 0 || d > notifyBackoffMax {
+		return notifyBackoffMax
+	}
+	return d
+}
+
+func parseChannelConfig(raw string) channelConfig {
+	var cfg channelConfig
+	_ = json.Unmarshal([]byte(raw), &cfg)
+	return cfg
+}
+
+// sendNotification delivers one event through ch synchronously
+func sendNotification(ch model.NotificationChannel, payloadJSON, contextJSON string) error {
+	cfg := parseChannelConfig(ch.Config)
+	var ev NotifyEvent
+	if err := json.Unmarshal([]byte(contextJSON), &ev); err != nil {
+		return fmt.Errorf("bad context: %v", err)
+	}
+	tpl := cfg.Template
+	if ev.User.ID != 0 && cfg.UserTemplate != "" {
+		tpl = cfg.UserTemplate
+	}
+
+	switch ch.Type {
+	case channelWebhook:
+		rendered, err := renderNotifyTemplate(tpl, ev)
+		if err != nil {
+			return fmt.Errorf("template: %v", err)
+		}
+		return sendWebhook(cfg, []byte(payloadJSON), rendered)
+	case channelJSONWebhook:
+		return sendJSONWebhook(cfg, []byte(payloadJSON))
+	case channelTelegram:
+		text, err := notifyText(tpl, ev)
+		if err != nil {
+			return err
+		}
+		chat := cfg.recipient(ch.Type, ev)
+		if chat == "" {
+			return errors.New("no recipient")
+		}
+		return sendTelegram(cfg, chat, text)
+	case channelSMTP:
+		text, err := notifyText(tpl, ev)
+		if err != nil {
+			return err
+		}
+		subject, err := renderNotifyTemplate(cfg.Subject, ev)
+		if err != nil {
+			return fmt.Errorf("subject template: %v", err)
+		}
+		if subject == "" {
+			subject = "[network-panel] " + ev.Event
+		}
+		to := cfg.recipient(ch.Type, ev)
+		if to == "" {
+			return errors.New("no recipient")
+		}
+		return sendMail(cfg, to, subject, text)
+	}
+	return fmt.Errorf("unknown channel type %q", ch.Type)
+}
+
+// notifyText is the chat/mail body: the rendered template, else the event message
+func notifyText(tpl string, ev NotifyEvent) (string, error) {
+	if tpl != "" {
+		out, err := renderNotifyTemplate(tpl, ev)
+		if err != nil {
+			return "", fmt.Errorf("template: %v", err)
+		}
+		return out, nil
+	}
+	switch {
+	case ev.Message != "" && ev.Node.Name != "":
+		return fmt.Sprintf("[%s] %s: %s", ev.Event, ev.Node.Name, ev.Message), nil
+	case ev.Message != "":
+		return fmt.Sprintf("[%s] %s", ev.Event, ev.Message), nil
+	case ev.Node.Name != "":
+		return fmt.Sprintf("[%s] %s", ev.Event, ev.Node.Name), nil
+	}
+	return "[" + ev.Event + "]", nil
+}
+
+var notifyHTTP = &http.Client{Timeout: 10 * time.Second}
+
+func doNotifyRequest(req *http.Request) error {
+	resp, err := notifyHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sendWebhook keeps the old callback semantics: GET appends the rendered template to the
+// query, POST sends it as body (default: the event JSON)
+func sendWebhook(cfg channelConfig, payload []byte, rendered string) error {
+	if cfg.URL == "" {
+		return errors.New("url required")
+	}
+	method := strings.ToUpper(cfg.Method)
+	if method != "GET" {
+		method = "POST"
+	}
+	u, body := cfg.URL, payload
+	if rendered != "" {
+		if method == "GET" {
+			if strings.Contains(u, "?") {
+				u = u + "&" + rendered
+			} else {
+				u = u + "?" + rendered
+			}
+		} else {
+			body = []byte(rendered)
+		}
+	}
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequest("GET", u, nil)
+	} else {
+		req, err = http.NewRequest("POST", u, bytes.NewReader(body))
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if method == "POST" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doNotifyRequest(req)
+}
+
+// sendJSONWebhook posts the event JSON signed with
+// X-Signature: sha256=hex(HMAC-SHA256(secret, X-Timestamp + "." + body))
+func sendJSONWebhook(cfg channelConfig, payload []byte) error {
+	if cfg.URL == "" {
+		return errors.New("url required")
+	}
+	req, err := http.NewRequest("POST", cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Timestamp", ts)
+		req.Header.Set("X-Signature", "sha256="+signPayload(cfg.Secret, ts, payload))
+	}
+	return doNotifyRequest(req)
+}
+
+func signPayload(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendTelegram(cfg channelConfig, chatID, text string) error {
+	if cfg.BotToken == "" || chatID == "" {
+		return errors.New("botToken and chatId required")
+	}
+	base := strings.TrimSuffix(cfg.APIBase, "/")
+	if base == "" {
+		base = "https://api.telegram.org"
+	}
+	b, _ := json.Marshal(map[string]any{"chat_id": chatID, "text": text, "disable_web_page_preview": true})
+	req, err := http.NewRequest("POST", base+"/bot"+cfg.BotToken+"/sendMessage", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotifyRequest(req)
+}
+
+func sendMail(cfg channelConfig, to, subject, text string) error {
+	if cfg.Host == "" || cfg.From == "" || strings.TrimSpace(to) == "" {
+		return errors.New("host, from and to required")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+		if cfg.TLS == "tls" {
+			port = 465
+		}
+	}
+	var rcpts []string
+	for _, r := range strings.Split(to, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			rcpts = append(rcpts, r)
+		}
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	tlsCfg := &tls.Config{ServerName: cfg.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if cfg.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if cfg.TLS == "starttls" {
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, r := range rcpts {
+		if err := c.Rcpt(r); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg strings.Builder
+	msg.WriteString("From: " + cfg.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(rcpts, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// legacyCallbackKeys are the old vite_config callback settings, mapped onto the "callback"
+// webhook channel
+var legacyCallbackKeys = []string{"callback_url", "callback_method", "callback_headers", "callback_template", "callback_user_template"}
+
+// migrateLegacyCallback applies the callback_* keys in vite_config to the webhook channel
+// named "callback" and deletes them. The channel is created on the first migration and
+// updated afterwards, so saving the old settings again never adds a second channel; only
+// the keys that were saved change. Without a channel and without a URL the keys wait.
+func migrateLegacyCallback() {
+	var rows []model.ViteConfig
+	dbpkg.DB.Where("name IN ?", legacyCallbackKeys).Find(&rows)
+	if len(rows) == 0 {
+		return
+	}
+	vals := map[string]string{}
+	for _, r := range rows {
+		vals[r.Name] = r.Value
+	}
+	var ch model.NotificationChannel
+	exists := dbpkg.DB.Where("name = ? AND type = ?", "callback", channelWebhook).Order("id").First(&ch).Error == nil
+	if !exists && vals["callback_url"] == "" {
+		return
+	}
+	var cfg channelConfig
+	if exists {
+		_ = json.Unmarshal([]byte(ch.Config), &cfg)
+	}
+	for k, v := range vals {
+		switch k {
+		case "callback_url":
+			cfg.URL = v
+		case "callback_method":
+			cfg.Method = strings.ToUpper(v)
+		case "callback_headers":
+			cfg.Headers = nil
+			if v != "" {
+				_ = json.Unmarshal([]byte(v), &cfg.Headers)
+			}
+		case "callback_template":
+			cfg.Template = v
+		case "callback_user_template":
+			cfg.UserTemplate = v
+		}
+	}
+	b, _ := json.Marshal(cfg)
+	now := time.Now().UnixMilli()
+	var err error
+	if exists {
+		up := map[string]any{"config": string(b), "updated_time": now}
+		if u, ok := vals["callback_url"]; ok {
+			// clearing the URL switched the old callback off
+			up["enabled"] = 0
+			if u != "" {
+				up["enabled"] = 1
+			}
+		}
+		err = dbpkg.DB.Model(&model.NotificationChannel{}).Where("id = ?", ch.ID).Updates(up).Error
+	} else {
+		ch = model.NotificationChannel{Name: "callback", Type: channelWebhook, Enabled: 1, Config: string(b), CreatedTime: now, UpdatedTime: now}
+		err = dbpkg.DB.Create(&ch).Error
+	}
+	if err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "notify_legacy_migrate_err", "error": err.Error()})
+		return
+	}
+	dbpkg.DB.Where("name IN ?", legacyCallbackKeys).Delete(&model.ViteConfig{})
+	jlog(map[string]interface{}{"event": "notify_legacy_migrated", "channelId": ch.ID, "keys": len(vals), "created": !exists})
+}
@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// channelView is a channel with its config decoded and secrets masked
+type channelView struct {
+	model.NotificationChannel
+	Config map[string]any `json:"config"`
+}
+
+type channelParams struct {
+	ID      int64          `json:"id"`
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Enabled *int           `json:"enabled"`
+	Events  *string        `json:"events"`
+	Config  map[string]any `json:"config"`
+}
+
+func toChannelView(ch model.NotificationChannel) channelView {
+	m := map[string]any{}
+	_ = json.Unmarshal([]byte(ch.Config), &m)
+	for _, k := range channelSecretKeys {
+		if s, _ := m[k].(string); s != "" {
+			m[k] = secretMask
+		}
+	}
+	return channelView{NotificationChannel: ch, Config: m}
+}
+
+// buildChannelConfig validates cfg for typ; masked secrets keep their stored value
+func buildChannelConfig(typ string, cfg map[string]any, old string) (string, string) {
+	if cfg == nil {
+		cfg = map[string]any{}
+	}
+	if old != "" {
+		prev := map[string]any{}
+		_ = json.Unmarshal([]byte(old), &prev)
+		for _, k := range channelSecretKeys {
+			if s, _ := cfg[k].(string); s == secretMask {
+				cfg[k] = prev[k]
+			}
+		}
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", "配置格式错误"
+	}
+	var c channelConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return "", "配置格式错误"
+	}
+	for name, tpl := range map[string]string{"template": c.Template, "userTemplate": c.UserTemplate, "subject": c.Subject} {
+		if _, err := parseNotifyTemplate(tpl); err != nil {
+			return "", name + " 模板错误: " + err.Error()
+		}
+	}
+	switch typ {
+	case channelWebhook, channelJSONWebhook:
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return "", "请填写有效的 URL"
+		}
+	case channelTelegram:
+		if c.BotToken == "" || (strings.TrimSpace(c.ChatID) == "" && !c.UserContact) {
+			return "", "请填写 Bot Token 与 Chat ID"
+		}
+	case channelSMTP:
+		if c.Host == "" || c.From == "" || (strings.TrimSpace(c.To) == "" && !c.UserContact) {
+			return "", "请填写 SMTP 服务器、发件人与收件人"
+		}
+		if c.TLS != "" && c.TLS != "none" && c.TLS != "starttls" && c.TLS != "tls" {
+			return "", "tls 仅支持 none/starttls/tls"
+		}
+	default:
+		return "", "不支持的通道类型"
+	}
+	return string(b), ""
+}
+
+// POST /api/v1/notify/channel/list
+func NotifyChannelList(c *gin.Context) {
+	var list []model.NotificationChannel
+	dbpkg.DB.Order("id asc").Find(&list)
+	out := make([]channelView, 0, len(list))
+	for _, ch := range list {
+		out = append(out, toChannelView(ch))
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// POST /api/v1/notify/channel/create {name, type, enabled, events, config}
+func NotifyChannelCreate(c *gin.Context) {
+	var p channelParams
+	if err := c.ShouldBindJSON(&p); err != nil || strings.TrimSpace(p.Name) == "" {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	cfg, msg := buildChannelConfig(p.Type, p.Config, "")
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	now := time.Now().UnixMilli()
+	ch := model.NotificationChannel{Name: strings.TrimSpace(p.Name), Type: p.Type, Enabled: 1, Config: cfg, CreatedTime: now, UpdatedTime: now}
+	if p.Enabled != nil {
+		ch.Enabled = *p.Enabled
+	}
+	if p.Events != nil {
+		ch.Events = strings.TrimSpace(*p.Events)
+	}
+	if err := dbpkg.DB.Create(&ch).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("创建失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(toChannelView(ch)))
+}
+
+// POST /api/v1/notify/channel/update {id, name?, type?, enabled?, events?, config?}
+func NotifyChannelUpdate(c *gin.Context) {
+	var p channelParams
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var ch model.NotificationChannel
+	if err := dbpkg.DB.First(&ch, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("通道不存在"))
+		return
+	}
+	if n := strings.TrimSpace(p.Name); n != "" {
+		ch.Name = n
+	}
+	if p.Type != "" {
+		ch.Type = p.Type
+	}
+	if p.Enabled != nil {
+		ch.Enabled = *p.Enabled
+	}
+	if p.Events != nil {
+		ch.Events = strings.TrimSpace(*p.Events)
+	}
+	if p.Config != nil || p.Type != "" {
+		cfgIn := p.Config
+		if cfgIn == nil {
+			cfgIn = map[string]any{}
+			_ = json.Unmarshal([]byte(ch.Config), &cfgIn)
+		}
+		cfg, msg := buildChannelConfig(ch.Type, cfgIn, ch.Config)
+		if msg != "" {
+			c.JSON(http.StatusOK, response.ErrMsg(msg))
+			return
+		}
+		ch.Config = cfg
+	}
+	ch.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&ch).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("更新失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(toChannelView(ch)))
+}
+
+// POST /api/v1/notify/channel/delete {id}
+func NotifyChannelDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	dbpkg.DB.Delete(&model.NotificationChannel{}, p.ID)
+	// pending deliveries of the channel would only fail
+	dbpkg.DB.Model(&model.NotificationDelivery{}).Where("channel_id = ? AND status = ?", p.ID, "pending").
+		Updates(map[string]any{"status": "failed", "last_error": "channel deleted"})
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// POST /api/v1/notify/channel/test {id, event?, template?}
+// Sends a sample event (default "test") synchronously and logs it like any other delivery.
+// template overrides the channel's template for this send only.
+func NotifyChannelTest(c *gin.Context) {
+	var p struct {
+		ID       int64   `json:"id" binding:"required"`
+		Event    string  `json:"event"`
+		Template *string `json:"template"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var ch model.NotificationChannel
+	if err := dbpkg.DB.First(&ch, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("通道不存在"))
+		return
+	}
+	if p.Event == "" {
+		p.Event = "test"
+	}
+	ev := sampleNotifyEvent(p.Event)
+	if p.Template != nil {
+		if _, err := parseNotifyTemplate(*p.Template); err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("模板错误: "+err.Error()))
+			return
+		}
+		cfg := parseChannelConfig(ch.Config)
+		cfg.Template, cfg.UserTemplate = *p.Template, ""
+		b, _ := json.Marshal(cfg)
+		ch.Config = string(b)
+	}
+	pb, _ := json.Marshal(ev.payload())
+	cb, _ := json.Marshal(ev)
+	err := sendNotification(ch, string(pb), string(cb))
+	now := time.Now().UnixMilli()
+	d := model.NotificationDelivery{ChannelID: ch.ID, ChannelName: ch.Name, Event: ev.Event, Payload: string(pb), Context: string(cb), Attempts: 1, CreatedTime: now}
+	if err != nil {
+		d.Status, d.LastError = "failed", err.Error()
+	} else {
+		d.Status, d.SentTime = "sent", now
+	}
+	_ = dbpkg.DB.Create(&d).Error
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("发送失败: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("发送成功"))
+}
+
+// POST /api/v1/notify/deliveries {channelId?, status?, event?, limit?}
+func NotifyDeliveries(c *gin.Context) {
+	var p struct {
+		ChannelID int64  `json:"channelId"`
+		Status    string `json:"status"`
+		Event     string `json:"event"`
+		Limit     int    `json:"limit"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	q := dbpkg.DB.Model(&model.NotificationDelivery{})
+	if p.ChannelID > 0 {
+		q = q.Where("channel_id = ?", p.ChannelID)
+	}
+	if p.Status != "" {
+		q = q.Where("status = ?", p.Status)
+	}
+	if p.Event != "" {
+		q = q.Where("event = ?", p.Event)
+	}
+	var list []model.NotificationDelivery
+	q.Order("id desc").Limit(p.Limit).Find(&list)
+	c.JSON(http.StatusOK, response.Ok(list))
+}
+
+// POST /api/v1/notify/deliveries/retry {id}
+// Puts a failed delivery back into the queue with a fresh attempt budget.
+func NotifyDeliveryRetry(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	res := dbpkg.DB.Model(&model.NotificationDelivery{}).Where("id = ? AND status = ?", p.ID, "failed").
+		Updates(map[string]any{"status": "pending", "attempts": 0, "next_attempt_ms": time.Now().UnixMilli()})
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("仅失败的记录可重试"))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkNoData())
+}
@@ -0,0 +1,324 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+)
+
+// NotifyEvent is what channel templates (text/template) are rendered with.
+// Fields that do not apply to an event are zero values, so {{.Node.Name}} is "" for
+// user events instead of an error.
+//
+//	.Event     agent_offline | agent_online | node_due | quota_warning | quota_exceeded | expiry_warning |
+//	           rule_alert | rule_resolved | test
+//	.Time      when the event happened
+//	.Message   human readable summary (Chinese)
+//	.Node      {ID, Name, IP}
+//	.Tunnel    {ID, Name}
+//	.User      {ID, Name, Contact}
+//	.DownAt    agent_offline/agent_online: when the node went offline
+//	.UpAt      agent_online: when the node came back
+//	.Duration  agent_online: the downtime; agent_offline: the online period that just ended
+//	.Remain    node_due: time left in the billing cycle
+//	.Percent   quota events: threshold crossed
+//	.Used      quota events: bytes used
+//	.Limit     quota events: quota in bytes
+//	.ExpTime   expiry_warning: expiry time
+//	.Rule      rule events: {ID, Name, Metric, Op, Threshold, WindowS}
+//	.Value     rule events: the window average that crossed the threshold
+//	.Target    probe rule events: {ID, Name, IP}
+//
+// Functions: bytes (1.50 GiB), duration (1天2小时3分), time (2006-01-02 15:04:05),
+// ms (unix milliseconds), secs (whole seconds), json, default, plus the text/template builtins
+// (printf, urlquery, ...).
+type NotifyEvent struct {
+	Event    string        `json:"event"`
+	Time     time.Time     `json:"time"`
+	Message  string        `json:"message"`
+	Node     NotifyNode    `json:"node"`
+	Tunnel   NotifyTunnel  `json:"tunnel"`
+	User     NotifyUser    `json:"user"`
+	DownAt   time.Time     `json:"downAt"`
+	UpAt     time.Time     `json:"upAt"`
+	Duration time.Duration `json:"duration"`
+	Remain   time.Duration `json:"remain"`
+	Percent  int           `json:"percent"`
+	Used     int64         `json:"used"`
+	Limit    int64         `json:"limit"`
+	ExpTime  time.Time     `json:"expTime"`
+	Rule     NotifyRule    `json:"rule"`
+	Value    float64       `json:"value"`
+	Target   NotifyTarget  `json:"target"`
+}
+
+type NotifyNode struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+type NotifyTunnel struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type NotifyRule struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	WindowS   int     `json:"windowS"`
+}
+
+type NotifyTarget struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+type NotifyUser struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Contact string `json:"contact"`
+}
+
+var notifyFuncs = template.FuncMap{
+	"bytes":    humanBytes,
+	"duration": humanDuration,
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"ms": func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.UnixMilli()
+	},
+	"secs": func(d time.Duration) int64 { return int64(d / time.Second) },
+	"json": func(v any) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+	"default": func(def, v any) any {
+		if v == nil || fmt.Sprint(v) == "" || fmt.Sprint(v) == "0" {
+			return def
+		}
+		return v
+	},
+}
+
+// legacyPlaceholders maps the old {name} placeholders onto template expressions
+var legacyPlaceholders = map[string]string{
+	"{event}":    "{{.Event}}",
+	"{nodeId}":   "{{.Node.ID}}",
+	"{name}":     "{{.Node.Name}}",
+	"{time}":     "{{ms .Time}}",
+	"{downAt}":   "{{ms .DownAt}}",
+	"{upAt}":     "{{ms .UpAt}}",
+	"{duration}": "{{secs .Duration}}",
+	"{userId}":   "{{.User.ID}}",
+	"{user}":     "{{.User.Name}}",
+	"{contact}":  "{{.User.Contact}}",
+	"{message}":  "{{.Message}}",
+	"{percent}":  "{{.Percent}}",
+	"{used}":     "{{bytes .Used}}",
+	"{limit}":    "{{bytes .Limit}}",
+	"{tunnel}":   "{{.Tunnel.Name}}",
+	"{expTime}":  "{{time .ExpTime}}",
+}
+
+var legacyPlaceholderRe = regexp.MustCompile(`\{[a-zA-Z]+\}`)
+
+// upgradeLegacyTemplate rewrites a pre-text/template template; templates that already use
+// {{ }} are returned as is
+func upgradeLegacyTemplate(tpl string) string {
+	if strings.Contains(tpl, "{{") {
+		return tpl
+	}
+	return legacyPlaceholderRe.ReplaceAllStringFunc(tpl, func(m string) string {
+		if v, ok := legacyPlaceholders[m]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+func parseNotifyTemplate(tpl string) (*template.Template, error) {
+	return template.New("notify").Funcs(notifyFuncs).Option("missingkey=zero").Parse(upgradeLegacyTemplate(tpl))
+}
+
+func renderNotifyTemplate(tpl string, ev NotifyEvent) (string, error) {
+	if tpl == "" {
+		return "", nil
+	}
+	t, err := parseNotifyTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ev); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// humanDuration formats d as 1天2小时3分4秒, dropping zero units
+func humanDuration(d time.Duration) string {
+	if d < time.Second {
+		return "0秒"
+	}
+	s := int64(d / time.Second)
+	parts := []struct {
+		n    int64
+		unit string
+	}{{s / 86400, "天"}, {s % 86400 / 3600, "小时"}, {s % 3600 / 60, "分"}, {s % 60, "秒"}}
+	var b strings.Builder
+	for _, p := range parts {
+		if p.n > 0 {
+			fmt.Fprintf(&b, "%d%s", p.n, p.unit)
+		}
+	}
+	return b.String()
+}
+
+// sampleNotifyEvent fills an event of the given type with example data for previews
+func sampleNotifyEvent(event string) NotifyEvent {
+	now := time.Now()
+	ev := NotifyEvent{Event: event, Time: now}
+	node := NotifyNode{ID: 1, Name: "node-1", IP: "203.0.113.10"}
+	user := NotifyUser{ID: 2, Name: "demo", Contact: "demo@example.com"}
+	switch event {
+	case "agent_offline", "agent_online", "node_due", "rule_alert", "rule_resolved":
+		ev.Node = node
+	case "quota_warning", "quota_exceeded", "expiry_warning":
+		ev.User, ev.Tunnel = user, NotifyTunnel{ID: 1, Name: "tunnel-1"}
+	default:
+		ev.Node = NotifyNode{Name: "network-panel"}
+	}
+	switch event {
+	case "agent_offline":
+		ev.Message = "节点离线"
+		ev.DownAt = now
+		ev.UpAt = now.Add(-26 * time.Hour)
+		ev.Duration = 26 * time.Hour
+	case "agent_online":
+		ev.Message = "节点恢复上线"
+		ev.DownAt = now.Add(-5 * time.Minute)
+		ev.UpAt = now
+		ev.Duration = 5 * time.Minute
+	case "node_due":
+		ev.Message = "节点即将到期，剩余 1 天"
+		ev.Remain = 20 * time.Hour
+	case "quota_warning", "quota_exceeded":
+		ev.Percent, ev.Limit = 80, 100*gib
+		if event == "quota_exceeded" {
+			ev.Percent = 100
+		}
+		ev.Used = ev.Limit * int64(ev.Percent) / 100
+		ev.Message = fmt.Sprintf("隧道 tunnel-1 流量已使用 %d%%（%s / %s）", ev.Percent, humanBytes(ev.Used), humanBytes(ev.Limit))
+	case "expiry_warning":
+		ev.ExpTime = now.Add(72 * time.Hour)
+		ev.Message = "隧道 tunnel-1 将于 " + ev.ExpTime.Format("2006-01-02 15:04") + " 到期"
+	case "rule_alert", "rule_resolved":
+		ev.Rule = NotifyRule{ID: 1, Name: "丢包过高", Metric: "probe_loss", Op: ">", Threshold: 20, WindowS: 600}
+		ev.Target = NotifyTarget{ID: 1, Name: "target-1", IP: "198.51.100.1"}
+		ev.Value = 35
+		ev.Message = "丢包过高: target-1 丢包率 10分内平均 35.0% > 20%"
+		if event == "rule_resolved" {
+			ev.Value = 5
+			ev.Message = "丢包过高 已恢复: target-1 丢包率 10分内平均 5.0%"
+		}
+	default:
+		ev.Message = "测试通知"
+	}
+	return ev
+}
+
+// POST /api/v1/notify/template/preview {template, event?}
+// Renders template against a sample event of the given type (default agent_offline).
+func NotifyTemplatePreview(c *gin.Context) {
+	var p struct {
+		Template string `json:"template"`
+		Event    string `json:"event"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if p.Event == "" {
+		p.Event = "agent_offline"
+	}
+	ev := sampleNotifyEvent(p.Event)
+	out, err := renderNotifyTemplate(p.Template, ev)
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("模板错误: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"output": out, "template": upgradeLegacyTemplate(p.Template), "context": ev}))
+}
+
+// payload is the flat event JSON posted by webhooks (field names of the old callback)
+func (ev NotifyEvent) payload() map[string]any {
+	m := map[string]any{"event": ev.Event, "time": ev.Time.UnixMilli()}
+	if ev.Message != "" {
+		m["message"] = ev.Message
+	}
+	if ev.Node.ID != 0 {
+		m["nodeId"], m["name"] = ev.Node.ID, ev.Node.Name
+	}
+	if ev.User.ID != 0 {
+		m["userId"], m["user"], m["contact"] = ev.User.ID, ev.User.Name, ev.User.Contact
+	}
+	if ev.Tunnel.ID != 0 {
+		m["tunnelId"], m["tunnelName"] = ev.Tunnel.ID, ev.Tunnel.Name
+	}
+	if !ev.DownAt.IsZero() {
+		m["downAtMs"] = ev.DownAt.UnixMilli()
+	}
+	if !ev.UpAt.IsZero() {
+		m["upAtMs"] = ev.UpAt.UnixMilli()
+	}
+	if ev.Duration > 0 {
+		m["durationS"] = int64(ev.Duration / time.Second)
+	}
+	if ev.Remain > 0 {
+		m["remainMs"] = ev.Remain.Milliseconds()
+	}
+	if ev.Limit > 0 {
+		m["percent"], m["usedBytes"], m["limitBytes"] = ev.Percent, ev.Used, ev.Limit
+	}
+	if !ev.ExpTime.IsZero() {
+		m["expTime"] = ev.ExpTime.UnixMilli()
+	}
+	if ev.Rule.ID != 0 {
+		m["ruleId"], m["ruleName"], m["metric"], m["threshold"], m["value"] = ev.Rule.ID, ev.Rule.Name, ev.Rule.Metric, ev.Rule.Threshold, ev.Value
+	}
+	if ev.Target.ID != 0 {
+		m["targetId"], m["targetName"], m["targetIp"] = ev.Target.ID, ev.Target.Name, ev.Target.IP
+	}
+	return m
+}
+
+// NodeNotifyEvent builds the template context of a node event
+func NodeNotifyEvent(event string, node model.Node, msg string) NotifyEvent {
+	return NotifyEvent{Event: event, Time: time.Now(), Message: msg, Node: NotifyNode{ID: node.ID, Name: node.Name, IP: node.IP}}
+}
+
+// userNotifyEvent builds the template context of a user event
+func userNotifyEvent(event string, u model.User, msg string) NotifyEvent {
+	return NotifyEvent{Event: event, Time: time.Now(), Message: msg, User: NotifyUser{ID: u.ID, Name: u.User, Contact: u.NotifyContact}}
+}
@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// capture records the last request a test server received
+type capture struct {
+	method, uri string
+	header      http.Header
+	body        string
+}
+
+func captureServer(t *testing.T, status int) (*httptest.Server, *capture) {
+	t.Helper()
+	got := &capture{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*got = capture{method: r.Method, uri: r.RequestURI, header: r.Header, body: string(b)}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("bad chat"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func TestSendWebhook(t *testing.T) {
+	srv, got := captureServer(t, http.StatusOK)
+	payload := []byte(`{"event":"agent_offline"}`)
+
+	cfg := channelConfig{URL: srv.URL + "/hook?k=1", Method: "get", Headers: map[string]string{"X-Key": "v"}}
+	if err := sendWebhook(cfg, payload, "node=n1"); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != "GET" || got.uri != "/hook?k=1&node=n1" || got.header.Get("X-Key") != "v" || got.body != "" {
+		t.Fatalf("GET request = %+v", got)
+	}
+
+	cfg = channelConfig{URL: srv.URL + "/hook"}
+	if err := sendWebhook(cfg, payload, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != "POST" || got.body != string(payload) || got.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("POST request = %+v", got)
+	}
+
+	cfg.Headers = map[string]string{"Content-Type": "text/plain"}
+	if err := sendWebhook(cfg, payload, "node n1 offline"); err != nil {
+		t.Fatal(err)
+	}
+	if got.body != "node n1 offline" || got.header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("POST template request = %+v", got)
+	}
+}
+
+func TestSendJSONWebhookSigns(t *testing.T) {
+	srv, got := captureServer(t, http.StatusNoContent)
+	payload := []byte(`{"event":"quota_warning"}`)
+	if err := sendJSONWebhook(channelConfig{URL: srv.URL, Secret: "s3"}, payload); err != nil {
+		t.Fatal(err)
+	}
+	ts := got.header.Get("X-Timestamp")
+	if ts == "" || got.body != string(payload) {
+		t.Fatalf("request = %+v", got)
+	}
+	if want := "sha256=" + signPayload("s3", ts, payload); got.header.Get("X-Signature") != want {
+		t.Fatalf("X-Signature = %q, want %q", got.header.Get("X-Signature"), want)
+	}
+
+	if err := sendJSONWebhook(channelConfig{URL: srv.URL}, payload); err != nil {
+		t.Fatal(err)
+	}
+	if got.header.Get("X-Signature") != "" {
+		t.Fatal("unsigned channel sent a signature")
+	}
+}
+
+func TestSendTelegram(t *testing.T) {
+	srv, got := captureServer(t, http.StatusOK)
+	cfg := channelConfig{BotToken: "123:abc", APIBase: srv.URL + "/"}
+	if err := sendTelegram(cfg, "-100", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != "POST" || got.uri != "/bot123:abc/sendMessage" {
+		t.Fatalf("request = %s %s", got.method, got.uri)
+	}
+	var body struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(got.body), &body); err != nil || body.ChatID != "-100" || body.Text != "hello" {
+		t.Fatalf("body = %s (%v)", got.body, err)
+	}
+
+	bad, _ := captureServer(t, http.StatusBadRequest)
+	cfg.APIBase = bad.URL
+	if err := sendTelegram(cfg, "-100", "hello"); err == nil || !strings.Contains(err.Error(), "http 400: bad chat") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestChannelRecipient(t *testing.T) {
+	user := NotifyEvent{User: NotifyUser{ID: 2, Contact: " u@example.com "}}
+	noContact := NotifyEvent{User: NotifyUser{ID: 3}}
+	node := NotifyEvent{Node: NotifyNode{ID: 1}}
+	cases := []struct {
+		cfg  channelConfig
+		typ  string
+		ev   NotifyEvent
+		want string
+	}{
+		{channelConfig{To: "ops@example.com"}, channelSMTP, user, "ops@example.com"},
+		{channelConfig{To: "ops@example.com", UserContact: true}, channelSMTP, user, "u@example.com"},
+		{channelConfig{To: "ops@example.com", UserContact: true}, channelSMTP, noContact, "ops@example.com"},
+		{channelConfig{UserContact: true}, channelSMTP, node, ""},
+		{channelConfig{ChatID: "-100", UserContact: true}, channelTelegram, node, "-100"},
+		{channelConfig{}, channelTelegram, user, ""},
+	}
+	for i, c := range cases {
+		if got := c.cfg.recipient(c.typ, c.ev); got != c.want {
+			t.Errorf("case %d: recipient = %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+// smtpStub accepts one session and returns the envelope recipients and message data
+func smtpStub(t *testing.T) (string, <-chan []string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	rcpts, data := make(chan []string, 1), make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var to []string
+		_ = tp.PrintfLine("220 stub ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO", "HELO", "MAIL":
+				_ = tp.PrintfLine("250 ok")
+			case "RCPT":
+				to = append(to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+				_ = tp.PrintfLine("250 ok")
+			case "DATA":
+				_ = tp.PrintfLine("354 go ahead")
+				b, _ := io.ReadAll(tp.DotReader())
+				rcpts <- to
+				data <- string(b)
+				_ = tp.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tp.PrintfLine("221 bye")
+				return
+			default:
+				_ = tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), rcpts, data
+}
+
+func TestSendMail(t *testing.T) {
+	addr, rcpts, data := smtpStub(t)
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	cfg := channelConfig{Host: host, Port: p, From: "panel@example.com", TLS: "none"}
+	if err := sendMail(cfg, "a@example.com, b@example.com", "节点离线", "line1\nline2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-rcpts; strings.Join(got, ",") != "a@example.com,b@example.com" {
+		t.Fatalf("recipients = %v", got)
+	}
+	msg := <-data
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(msg)))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("From") != "panel@example.com" || h.Get("To") != "a@example.com, b@example.com" || h.Get("Subject") != "=?utf-8?q?=E8=8A=82=E7=82=B9=E7=A6=BB=E7=BA=BF?=" {
+		t.Fatalf("headers = %v", h)
+	}
+	if body, _ := io.ReadAll(r.R); string(body) != "line1\nline2\n" {
+		t.Fatalf("body = %q", body)
+	}
+}
@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Port registry: every listening port the panel hands out is recorded in port_allocation.
+// The unique index on (node_id, port, protocol) is the source of truth across replicas;
+// portAllocMu only avoids pointless insert races inside one process.
+
+const portProtoTCP = "tcp"
+
+// portReservationGrace: a panel reservation without a forward (a create still in flight)
+// is kept this long; after that, if nothing listens on the port, reconcile releases it
+const portReservationGrace = 10 * time.Minute
+
+var portAllocMu sync.Mutex
+
+// allocPort reserves one port that is free on every node in nodeIDs, within [min,max] and
+// preferring prefer. Ports already owned by forwardID count as free so a forward can keep
+// its own port on update. Returns 0 when no common free port exists.
+func allocPort(nodeIDs []int64, prefer, min, max int, forwardID int64, service string) int {
+	if len(nodeIDs) == 0 || min <= 0 || max < min {
+		return 0
+	}
+	portAllocMu.Lock()
+	defer portAllocMu.Unlock()
+
+	// busy[node][port] = owning forward ID (0 = not a forward / imported)
+	busy := map[int64]map[int]int64{}
+	var rows []model.PortAllocation
+	dbpkg.DB.Where("node_id IN ? AND protocol = ? AND port BETWEEN ? AND ?", nodeIDs, portProtoTCP, min, max).Find(&rows)
+	for _, r := range rows {
+		if busy[r.NodeID] == nil {
+			busy[r.NodeID] = map[int]int64{}
+		}
+		var owner int64
+		if r.ForwardID != nil {
+			owner = *r.ForwardID
+		}
+		busy[r.NodeID][r.Port] = owner
+	}
+	free := func(nid int64, p int) (ok bool, own bool) {
+		owner, taken := busy[nid][p]
+		if !taken {
+			return true, false
+		}
+		if forwardID > 0 && owner == forwardID {
+			return true, true
+		}
+		return false, false
+	}
+
+	try := func(p int) bool {
+		need := make([]int64, 0, len(nodeIDs))
+		for _, nid := range nodeIDs {
+			ok, own := free(nid, p)
+			if !ok {
+				return false
+			}
+			if !own {
+				need = append(need, nid)
+			}
+		}
+		if len(need) == 0 {
+			return true
+		}
+		now := time.Now().UnixMilli()
+		err := dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+			for _, nid := range need {
+				rec := model.PortAllocation{NodeID: nid, Port: p, Protocol: portProtoTCP, Service: service, Source: "panel", CreatedTime: now}
+				if forwardID > 0 {
+					fid := forwardID
+					rec.ForwardID = &fid
+				}
+				if err := tx.Create(&rec).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			// most likely taken by another replica in the meantime
+			for _, nid := range need {
+				if busy[nid] == nil {
+					busy[nid] = map[int]int64{}
+				}
+				busy[nid][p] = 0
+			}
+			return false
+		}
+		return true
+	}
+
+	start := prefer
+	if start < min || start > max {
+		start = min
+	}
+	if try(start) {
+		return start
+	}
+	for p := start + 1; p <= max; p++ {
+		if try(p) {
+			return p
+		}
+	}
+	for p := start - 1; p >= min; p-- {
+		if try(p) {
+			return p
+		}
+	}
+	return 0
+}
+
+// reservePort reserves exactly port on all nodes; false if any node already has it taken
+func reservePort(nodeIDs []int64, port int, forwardID int64, service string) bool {
+	return allocPort(nodeIDs, port, port, port, forwardID, service) == port
+}
+
+// claimPorts attaches ports reserved before the forward row existed to the forward
+func claimPorts(nodeIDs []int64, port int, forwardID int64, service string) {
+	if len(nodeIDs) == 0 || port <= 0 {
+		return
+	}
+	dbpkg.DB.Model(&model.PortAllocation{}).
+		Where("node_id IN ? AND port = ? AND protocol = ? AND forward_id IS NULL AND source = ?", nodeIDs, port, portProtoTCP, "panel").
+		Updates(map[string]any{"forward_id": forwardID, "service": service})
+}
+
+// releasePort frees a port on the given nodes (only rows owned by forwardID when > 0)
+func releasePort(nodeIDs []int64, port int, forwardID int64) {
+	if len(nodeIDs) == 0 || port <= 0 {
+		return
+	}
+	q := dbpkg.DB.Where("node_id IN ? AND port = ? AND protocol = ?", nodeIDs, port, portProtoTCP)
+	if forwardID > 0 {
+		q = q.Where("forward_id = ?", forwardID)
+	}
+	q.Delete(&model.PortAllocation{})
+}
+
+// releaseForwardPort frees the forward's reservations of oldPort, keeping newPort on keep nodes
+func releaseForwardPort(forwardID int64, oldPort, newPort int, keep []int64) {
+	if forwardID <= 0 || oldPort <= 0 {
+		return
+	}
+	// mid hop services keep their own reservations even when they share the entry port number
+	q := dbpkg.DB.Where("forward_id = ? AND port = ? AND protocol = ? AND service NOT LIKE ?", forwardID, oldPort, portProtoTCP, "%_mid_%")
+	if oldPort == newPort && len(keep) > 0 {
+		q = q.Where("node_id NOT IN ?", keep)
+	}
+	q.Delete(&model.PortAllocation{})
+}
+
+// releaseForwardPorts frees every port owned by the forward (entry, exit and mid hops)
+func releaseForwardPorts(forwardIDs ...int64) {
+	if len(forwardIDs) == 0 {
+		return
+	}
+	dbpkg.DB.Where("forward_id IN ?", forwardIDs).Delete(&model.PortAllocation{})
+}
+
+// releaseForwardMidPorts frees the mid hop reservations of a forward before its path is redeployed
+func releaseForwardMidPorts(forwardID int64) {
+	dbpkg.DB.Where("forward_id = ? AND service LIKE ?", forwardID, "%_mid_%").Delete(&model.PortAllocation{})
+}
+
+// allocatedPorts returns the registered ports of a node
+func allocatedPorts(nodeID int64) map[int]bool {
+	out := map[int]bool{}
+	var ports []int
+	dbpkg.DB.Model(&model.PortAllocation{}).Where("node_id = ? AND protocol = ?", nodeID, portProtoTCP).Pluck("port", &ports)
+	for _, p := range ports {
+		out[p] = true
+	}
+	return out
+}
+
+// nodePortRange returns the usable listen range of a node (defaults 10000-65535)
+func nodePortRange(nodeID int64) (int, int) {
+	var n model.Node
+	_ = dbpkg.DB.First(&n, nodeID).Error
+	minP, maxP := 10000, 65535
+	if n.PortSta > 0 {
+		minP = n.PortSta
+	}
+	if n.PortEnd > 0 {
+		maxP = n.PortEnd
+	}
+	return minP, maxP
+}
+
+// forwardInNodes lists the nodes listening on a forward's inPort: the entry node, plus every
+// path hop for multi-level port forwards (tunnel-forward mids get their own ports)
+func forwardInNodes(t model.Tunnel) []int64 {
+	nodes := []int64{t.InNodeID}
+	if t.Type != 2 {
+		nodes = append(nodes, getTunnelPathNodes(t.ID)...)
+	}
+	return nodes
+}
+
+// portOwnerForward maps a service name (incl. name_mid_i) to an existing forward ID
+func portOwnerForward(name string) int64 {
+	if i := strings.Index(name, "_mid_"); i > 0 {
+		name = name[:i]
+	}
+	fid := forwardIDFromServiceName(name)
+	if fid <= 0 {
+		return 0
+	}
+	var cnt int64
+	dbpkg.DB.Model(&model.Forward{}).Where("id = ?", fid).Count(&cnt)
+	if cnt == 0 {
+		return 0
+	}
+	return fid
+}
+
+type portReconcileResult struct {
+	NodeID   int64 `json:"nodeId"`
+	Answered bool  `json:"answered"`
+	Seeded   int   `json:"seeded"`
+	Imported int   `json:"imported"`
+	Released int   `json:"released"`
+}
+
+// reconcileNodePorts syncs the registry of one node with the forwards in DB and the
+// ports actually in use on the node. Imported rows that disappeared from the node, rows of
+// deleted forwards and unclaimed panel reservations (a failed create) are released.
+func reconcileNodePorts(nodeID int64) portReconcileResult {
+	res := portReconcileResult{NodeID: nodeID}
+	have := allocatedPorts(nodeID)
+	add := func(port int, fid int64, service, source string) bool {
+		if port <= 0 || have[port] {
+			return false
+		}
+		rec := model.PortAllocation{NodeID: nodeID, Port: port, Protocol: portProtoTCP, Service: service, Source: source, CreatedTime: time.Now().UnixMilli()}
+		if fid > 0 {
+			v := fid
+			rec.ForwardID = &v
+		}
+		if err := dbpkg.DB.Create(&rec).Error; err != nil {
+			return false
+		}
+		have[port] = true
+		return true
+	}
+
+	// 1) ports the panel already handed out (entry, same-port hops, exit)
+	var rows []struct {
+		model.Forward
+		TType     int    `gorm:"column:t_type"`
+		InNodeID  int64  `gorm:"column:in_node_id"`
+		OutNodeID *int64 `gorm:"column:out_node_id"`
+	}
+	dbpkg.DB.Table("forward f").
+		Select("f.*, t.type as t_type, t.in_node_id, t.out_node_id").
+		Joins("left join tunnel t on t.id = f.tunnel_id").Scan(&rows)
+	for _, r := range rows {
+		name := buildServiceName(r.ID, r.UserID, r.TunnelID)
+		onNode := r.InNodeID == nodeID
+		if !onNode && r.TType != 2 {
+			for _, nid := range getTunnelPathNodes(r.TunnelID) {
+				if nid == nodeID {
+					onNode = true
+					break
+				}
+			}
+		}
+		if onNode && add(r.InPort, r.ID, name, "panel") {
+			res.Seeded++
+		}
+		if r.TType == 2 && r.OutNodeID != nil && *r.OutNodeID == nodeID && r.OutPort != nil {
+			if add(*r.OutPort, r.ID, name, "panel") {
+				res.Seeded++
+			}
+		}
+	}
+
+	// 2) ports in use on the node
+	live, ok := queryNodeServicesDetail(nodeID, false)
+	res.Answered = ok
+	if !ok {
+		return res
+	}
+	livePorts := map[int]bool{}
+	for _, it := range live {
+		port := 0
+		if v, ok := it["port"].(float64); ok {
+			port = int(v)
+		}
+		if port <= 0 {
+			if v, ok := it["addr"].(string); ok {
+				port = parsePort(v)
+			}
+		}
+		if port <= 0 {
+			continue
+		}
+		livePorts[port] = true
+		name, _ := it["name"].(string)
+		if add(port, portOwnerForward(name), name, "imported") {
+			res.Imported++
+		}
+	}
+
+	// 3) release stale rows
+	var stale []model.PortAllocation
+	dbpkg.DB.Where("node_id = ? AND protocol = ?", nodeID, portProtoTCP).Find(&stale)
+	graceCut := time.Now().Add(-portReservationGrace).UnixMilli()
+	for _, r := range stale {
+		drop := false
+		if r.Source == "imported" && !livePorts[r.Port] {
+			drop = true
+		}
+		if r.Source == "panel" && r.ForwardID == nil && r.CreatedTime < graceCut && !livePorts[r.Port] {
+			drop = true
+		}
+		if r.ForwardID != nil {
+			var cnt int64
+			dbpkg.DB.Model(&model.Forward{}).Where("id = ?", *r.ForwardID).Count(&cnt)
+			if cnt == 0 {
+				drop = true
+			}
+		}
+		if drop && dbpkg.DB.Delete(&model.PortAllocation{}, r.ID).Error == nil {
+			res.Released++
+		}
+	}
+	if res.Seeded+res.Imported+res.Released > 0 {
+		jlog(map[string]interface{}{"event": "port_reconcile", "nodeId": nodeID, "seeded": res.Seeded, "imported": res.Imported, "released": res.Released})
+	}
+	return res
+}
+
+// POST /api/v1/node/ports {nodeId}
+func NodePortAllocations(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var list []model.PortAllocation
+	dbpkg.DB.Where("node_id = ?", p.NodeID).Order("port asc").Find(&list)
+	c.JSON(http.StatusOK, response.Ok(list))
+}
+
+// POST /api/v1/node/ports/reconcile {nodeId?}
+func NodePortReconcile(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	var ids []int64
+	if p.NodeID > 0 {
+		ids = []int64{p.NodeID}
+	} else {
+		dbpkg.DB.Model(&model.Node{}).Pluck("id", &ids)
+	}
+	out := make([]portReconcileResult, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, reconcileNodePorts(id))
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
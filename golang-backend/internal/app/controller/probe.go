@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"math"
 	"net/http"
 	"time"
 
@@ -132,14 +133,18 @@ func AgentReportProbe(c *gin.Context) {
 		return
 	}
 	rows := make([]model.NodeProbeResult, 0, len(p.Results))
+	late := false
 	for _, r := range p.Results {
 		t := now
 		if r.TimeMs != nil && *r.TimeMs > 0 {
 			t = *r.TimeMs
 		}
+		late = late || t < now-rollupLag.Milliseconds()
 		rows = append(rows, model.NodeProbeResult{NodeID: node.ID, TargetID: r.TargetID, RTTMs: r.RTTMs, OK: r.OK, TimeMs: t})
 	}
-	_ = dbpkg.DB.Create(&rows).Error
+	if err := dbpkg.DB.Create(&rows).Error; err == nil && late {
+		rerollProbeLate(node.ID, rows)
+	}
 	c.JSON(http.StatusOK, response.OkNoData())
 }
 
@@ -175,18 +180,9 @@ func NodeNetworkStats(c *gin.Context) {
 	from := now - windowMs
 
 	// results
-	var results []model.NodeProbeResult
-	dbpkg.DB.Where("node_id = ? AND time_ms >= ?", p.NodeID, from).Order("time_ms asc").Find(&results)
+	results, targetIDs, res := probeSeries(p.NodeID, from, now)
 
 	// collect target meta
-	targetIDs := make([]int64, 0)
-	seen := map[int64]struct{}{}
-	for _, r := range results {
-		if _, ok := seen[r.TargetID]; !ok {
-			seen[r.TargetID] = struct{}{}
-			targetIDs = append(targetIDs, r.TargetID)
-		}
-	}
 	m := map[int64]map[string]string{}
 	if len(targetIDs) > 0 {
 		var tgts []model.ProbeTarget
@@ -228,70 +224,101 @@ func NodeNetworkStats(c *gin.Context) {
 		"sla":         sla,
 		"from":        from,
 		"to":          now,
+		"res":         res,
 	}))
 }
 
-func min64(a, b int64) int64 {
-	if a < b {
-		return a
+// probeSeries returns the probe results of a node since from at the resolution of the range.
+// Rolled up buckets keep the raw row fields (rttMs is the average, ok is 1 when any probe
+// succeeded) and add loss (percent), rttMin, rttMax and samples.
+func probeSeries(nodeID, from, now int64) (any, []int64, string) {
+	targetIDs := make([]int64, 0)
+	seen := map[int64]struct{}{}
+	addTarget := func(id int64) {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			targetIDs = append(targetIDs, id)
+		}
 	}
-	return b
-}
-func max64(a, b int64) int64 {
-	if a > b {
-		return a
+	res := metricResolution(from, now)
+	if res == resRaw {
+		var results []model.NodeProbeResult
+		dbpkg.DB.Where("node_id = ? AND time_ms >= ?", nodeID, from).Order("time_ms asc").Find(&results)
+		for _, r := range results {
+			addTarget(r.TargetID)
+		}
+		return results, targetIDs, res
 	}
-	return b
+	var rows []model.NodeProbeRollup
+	dbpkg.DB.Where("node_id = ? AND res = ? AND time_ms >= ?", nodeID, res, from).Order("time_ms asc").Find(&rows)
+	out := make([]map[string]any, 0, len(rows))
+	for _, r := range rows {
+		addTarget(r.TargetID)
+		ok, loss := 0, 100.0
+		if r.OKCount > 0 {
+			ok = 1
+		}
+		if r.Samples > 0 {
+			loss = float64(r.Samples-r.OKCount) * 100 / float64(r.Samples)
+		}
+		out = append(out, map[string]any{
+			"nodeId": r.NodeID, "targetId": r.TargetID, "timeMs": r.TimeMs,
+			"rttMs": int(math.Round(r.RTTAvg)), "ok": ok, "loss": loss,
+			"rttMin": r.RTTMin, "rttMax": r.RTTMax, "samples": r.Samples,
+		})
+	}
+	return out, targetIDs, res
 }
 
-// Batch network stats across nodes (latest+avg rtt in window)
-// POST /api/v1/node/network-stats-batch {range}
-func NodeNetworkStatsBatch(c *gin.Context) {
-	var p struct {
-		Range string `json:"range"`
-	}
-	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
-		return
-	}
-	now := time.Now().UnixMilli()
-	var windowMs int64
-	switch p.Range {
-	case "1h":
-		windowMs = 3600 * 1000
-	case "12h":
-		windowMs = 12 * 3600 * 1000
-	case "1d":
-		windowMs = 24 * 3600 * 1000
-	default:
-		windowMs = 3600 * 1000
-	}
-	from := now - windowMs
-	// fetch in window
-	var rows []model.NodeProbeResult
-	dbpkg.DB.Where("time_ms >= ?", from).Order("time_ms asc").Find(&rows)
-	// aggregate per node
+// probeNodeStats is the per node summary of the network overview since from: average rtt of
+// successful probes, the latest result and its target
+func probeNodeStats(from, now int64) map[int64]map[string]any {
 	type stat struct {
-		Sum          int
+		Sum          float64
 		Cnt          int
 		Latest       *int
 		LatestTarget int64
 	}
 	agg := map[int64]*stat{}
-	for _, r := range rows {
-		s := agg[r.NodeID]
+	get := func(id int64) *stat {
+		s := agg[id]
 		if s == nil {
 			s = &stat{}
-			agg[r.NodeID] = s
+			agg[id] = s
 		}
-		if r.OK == 1 && r.RTTMs > 0 {
-			s.Sum += r.RTTMs
+		return s
+	}
+	res := metricResolution(from, now)
+	// latest results always come from the raw rows
+	rawFrom := from
+	if res != resRaw {
+		rawFrom = max64(from, now-3600*1000)
+	}
+	var rows []model.NodeProbeResult
+	dbpkg.DB.Where("time_ms >= ?", rawFrom).Order("time_ms asc").Find(&rows)
+	for _, r := range rows {
+		s := get(r.NodeID)
+		if res == resRaw && r.OK == 1 && r.RTTMs > 0 {
+			s.Sum += float64(r.RTTMs)
 			s.Cnt++
 		}
 		v := r.RTTMs
 		s.Latest = &v
 		s.LatestTarget = r.TargetID
 	}
+	if res != resRaw {
+		var sums []struct {
+			NodeID int64
+			Sum    float64
+			Cnt    int
+		}
+		dbpkg.DB.Model(&model.NodeProbeRollup{}).Select("node_id, SUM(rtt_avg * ok_count) AS sum, SUM(ok_count) AS cnt").
+			Where("res = ? AND time_ms >= ?", res, from).Group("node_id").Scan(&sums)
+		for _, x := range sums {
+			s := get(x.NodeID)
+			s.Sum, s.Cnt = x.Sum, x.Cnt
+		}
+	}
 	// fetch target metas for latest target per node
 	tset := map[int64]struct{}{}
 	for _, s := range agg {
@@ -315,7 +342,7 @@ func NodeNetworkStatsBatch(c *gin.Context) {
 	for nid, s := range agg {
 		var avg *int
 		if s.Cnt > 0 {
-			v := s.Sum / s.Cnt
+			v := int(s.Sum / float64(s.Cnt))
 			avg = &v
 		}
 		out[nid] = map[string]any{"avg": avg, "latest": s.Latest}
@@ -323,5 +350,45 @@ func NodeNetworkStatsBatch(c *gin.Context) {
 			out[nid]["latestTarget"] = map[string]any{"id": s.LatestTarget, "name": m["name"], "ip": m["ip"]}
 		}
 	}
+	return out
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Batch network stats across nodes (latest+avg rtt in window)
+// POST /api/v1/node/network-stats-batch {range}
+func NodeNetworkStatsBatch(c *gin.Context) {
+	var p struct {
+		Range string `json:"range"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	var windowMs int64
+	switch p.Range {
+	case "1h":
+		windowMs = 3600 * 1000
+	case "12h":
+		windowMs = 12 * 3600 * 1000
+	case "1d":
+		windowMs = 24 * 3600 * 1000
+	default:
+		windowMs = 3600 * 1000
+	}
+	from := now - windowMs
+	out := probeNodeStats(from, now)
 	c.JSON(http.StatusOK, response.Ok(out))
 }
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Quota warnings tell users before their forwards get paused. Flow thresholds arrive as
+// quota events from the flow flusher, expiry warnings come from the scheduler. Every warning
+// writes an alert row and notifies the channels (which use their user template, if any).
+//
+// vite_config keys:
+//   quota_warn_percents  warning levels in percent, default "80,95" (100 always pauses)
+//   quota_warn_days      days before ExpTime to warn, default 3, 0 disables
+
+const dayMs = int64(24 * 3600 * 1000)
+
+func init() { onQuotaEvent(handleQuotaEvent) }
+
+// quotaThresholds are the usage percentages that raise a quotaEvent when crossed:
+// the configured warning levels plus 100
+func quotaThresholds() []int {
+	raw := configValue("quota_warn_percents")
+	if strings.TrimSpace(raw) == "" {
+		raw = "80,95"
+	}
+	seen := map[int]bool{100: true}
+	out := []int{100}
+	for _, f := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n <= 0 || n >= 100 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	sort.Ints(out)
+	return out
+}
+
+func quotaWarnDays() int {
+	v := strings.TrimSpace(configValue("quota_warn_days"))
+	if v == "" {
+		return 3
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 3
+	}
+	return n
+}
+
+func handleQuotaEvent(qe quotaEvent) {
+	var u model.User
+	if err := dbpkg.DB.First(&u, qe.UserID).Error; err != nil {
+		return
+	}
+	scope := "账号"
+	var tunnel NotifyTunnel
+	if qe.Scope == "user_tunnel" {
+		tunnel = NotifyTunnel{ID: qe.TunnelID, Name: tunnelName(qe.TunnelID)}
+		scope = "隧道 " + tunnel.Name
+	}
+	event, msg := "quota_warning", fmt.Sprintf("%s流量已使用 %d%%（%s / %s）", scope, qe.Percent, humanBytes(qe.Used), humanBytes(qe.Limit))
+	if qe.Percent >= 100 {
+		event, msg = "quota_exceeded", fmt.Sprintf("%s流量已用尽（%s / %s），转发已暂停", scope, humanBytes(qe.Used), humanBytes(qe.Limit))
+	}
+	recordUserAlert(event, u, msg, tunnel.ID)
+	ev := userNotifyEvent(event, u, msg)
+	ev.Tunnel, ev.Percent, ev.Used, ev.Limit = tunnel, qe.Percent, qe.Used, qe.Limit
+	notify(ev)
+}
+
+// CheckExpiryWarnings warns users and user tunnels expiring within quota_warn_days.
+// A warning is sent once per expiry date: extending ExpTime re-arms it.
+func CheckExpiryWarnings(ctx context.Context) {
+	days := quotaWarnDays()
+	if days == 0 {
+		return
+	}
+	now := time.Now().UnixMilli()
+	horizon := now + int64(days)*dayMs
+
+	var users []model.User
+	dbpkg.DB.Where("role_id <> ? AND exp_time > ? AND exp_time <= ?", 0, now, horizon).Find(&users)
+	for _, u := range users {
+		if ctx.Err() != nil {
+			return
+		}
+		if u.Status != nil && *u.Status != 1 {
+			continue
+		}
+		msg := fmt.Sprintf("账号将于 %s 到期", time.UnixMilli(*u.ExpTime).Format("2006-01-02 15:04"))
+		warnExpiry(u, msg, *u.ExpTime, NotifyTunnel{})
+	}
+
+	var uts []model.UserTunnel
+	dbpkg.DB.Where("status = ? AND exp_time > ? AND exp_time <= ?", 1, now, horizon).Find(&uts)
+	for _, ut := range uts {
+		if ctx.Err() != nil {
+			return
+		}
+		var u model.User
+		if err := dbpkg.DB.First(&u, ut.UserID).Error; err != nil {
+			continue
+		}
+		tun := NotifyTunnel{ID: ut.TunnelID, Name: tunnelName(ut.TunnelID)}
+		msg := fmt.Sprintf("隧道 %s 将于 %s 到期", tun.Name, time.UnixMilli(*ut.ExpTime).Format("2006-01-02 15:04"))
+		warnExpiry(u, msg, *ut.ExpTime, tun)
+	}
+}
+
+func warnExpiry(u model.User, msg string, expMs int64, tunnel NotifyTunnel) {
+	// the message carries the expiry date, so it doubles as the dedupe key
+	var cnt int64
+	dbpkg.DB.Model(&model.Alert{}).Where("type = ? AND user_id = ? AND message = ?", "expiry_warning", u.ID, msg).Count(&cnt)
+	if cnt > 0 {
+		return
+	}
+	recordUserAlert("expiry_warning", u, msg, tunnel.ID)
+	ev := userNotifyEvent("expiry_warning", u, msg)
+	ev.Tunnel, ev.ExpTime = tunnel, time.UnixMilli(expMs)
+	notify(ev)
+}
+
+// recordUserAlert raises the user:<id>:<type>[:tunnel:<id>] incident
+func recordUserAlert(typ string, u model.User, msg string, tunnelID int64) {
+	uid, name := u.ID, u.User
+	key := fmt.Sprintf("user:%d:%s", u.ID, typ)
+	if tunnelID != 0 {
+		key += fmt.Sprintf(":tunnel:%d", tunnelID)
+	}
+	RaiseAlert(key, model.Alert{TimeMs: time.Now().UnixMilli(), Type: typ, UserID: &uid, UserName: &name, Message: msg})
+}
+
+func tunnelName(id int64) string {
+	var t model.Tunnel
+	if err := dbpkg.DB.Select("name").First(&t, id).Error; err != nil {
+		return fmt.Sprintf("#%d", id)
+	}
+	return t.Name
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit && exp < 4; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
@@ -48,33 +48,7 @@ func ShareNetworkList(c *gin.Context) {
         windowMs = 3600 * 1000
     }
     from := now - windowMs
-    var rows []model.NodeProbeResult
-    dbpkg.DB.Where("time_ms >= ?", from).Order("time_ms asc").Find(&rows)
-    type stat struct{ Sum, Cnt int; Latest *int; LatestTarget int64 }
-    agg := map[int64]*stat{}
-    for _, r := range rows {
-        s := agg[r.NodeID]
-        if s == nil { s = &stat{}; agg[r.NodeID] = s }
-        if r.OK == 1 && r.RTTMs > 0 { s.Sum += r.RTTMs; s.Cnt++ }
-        v := r.RTTMs; s.Latest = &v; s.LatestTarget = r.TargetID
-    }
-    // target meta for latest
-    tset := map[int64]struct{}{}
-    for _, s := range agg { if s.LatestTarget > 0 { tset[s.LatestTarget] = struct{}{} } }
-    tmeta := map[int64]map[string]string{}
-    if len(tset) > 0 {
-        ids := make([]int64, 0, len(tset)); for id := range tset { ids = append(ids, id) }
-        var tgts []model.ProbeTarget
-        dbpkg.DB.Where("id IN ?", ids).Find(&tgts)
-        for _, t := range tgts { tmeta[t.ID] = map[string]string{"name": t.Name, "ip": t.IP} }
-    }
-    stats := map[int64]map[string]any{}
-    for nid, s := range agg {
-        var avg *int
-        if s.Cnt > 0 { v := s.Sum / s.Cnt; avg = &v }
-        stats[nid] = map[string]any{"avg": avg, "latest": s.Latest}
-        if m, ok := tmeta[s.LatestTarget]; ok { stats[nid]["latestTarget"] = map[string]any{"id": s.LatestTarget, "name": m["name"], "ip": m["ip"]} }
-    }
+    stats := probeNodeStats(from, now)
 
     // latest sysinfo per node (snapshot)
     sys := map[int64]model.NodeSysInfo{}
@@ -101,11 +75,8 @@ func ShareNetworkStats(c *gin.Context) {
     default: windowMs = 3600 * 1000
     }
     from := now - windowMs
-    var results []model.NodeProbeResult
-    dbpkg.DB.Where("node_id = ? AND time_ms >= ?", p.NodeID, from).Order("time_ms asc").Find(&results)
+    results, targetIDs, res := probeSeries(p.NodeID, from, now)
     // targets
-    targetIDs := make([]int64, 0); seen := map[int64]struct{}{}
-    for _, r := range results { if _, ok := seen[r.TargetID]; !ok { seen[r.TargetID] = struct{}{}; targetIDs = append(targetIDs, r.TargetID) } }
     m := map[int64]map[string]string{}
     if len(targetIDs) > 0 {
         var tgts []model.ProbeTarget
@@ -125,7 +96,7 @@ func ShareNetworkStats(c *gin.Context) {
     sla := 0.0
     if windowMs > 0 { sla = float64(windowMs-downMs) / float64(windowMs) }
     c.JSON(http.StatusOK, response.Ok(map[string]any{
-        "results": results, "targets": m, "disconnects": logs, "sla": sla, "from": from, "to": now,
+        "results": results, "targets": m, "disconnects": logs, "sla": sla, "from": from, "to": now, "res": res,
     }))
 }
 
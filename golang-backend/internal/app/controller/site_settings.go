@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Site settings stored in vite_config. Only the keys below can be read or written through
+// /config/*: public ones are served to anyone (login page, title), the rest to admins.
+// Tunnel state once kept in the same table (tunnel_path_*, tunnel_iface_*, tunnel_bindip_*,
+// now tunnel_hop) is neither listed nor writable there.
+
+const (
+	settingString   = "string"
+	settingBool     = "bool"
+	settingInt      = "int"
+	settingEnum     = "enum"
+	settingHostPort = "hostport"
+	settingURL      = "url"
+	settingHeaders  = "headers"  // JSON object of header -> value
+	settingPercents = "percents" // comma separated 1-99
+)
+
+type siteSetting struct {
+	Key     string   `json:"key"`
+	Type    string   `json:"type"`
+	Public  bool     `json:"public"`
+	Min     int      `json:"min,omitempty"`
+	Max     int      `json:"max,omitempty"` // int range, or max length for strings
+	Options []string `json:"options,omitempty"`
+}
+
+var siteSettings = []siteSetting{
+	{Key: "app_name", Type: settingString, Public: true, Max: 64},
+	{Key: "captcha_enabled", Type: settingBool, Public: true},
+	{Key: "captcha_type", Type: settingEnum, Public: true, Options: []string{"RANDOM", "SLIDER", "WORD_IMAGE_CLICK", "ROTATE", "CONCAT"}},
+	{Key: "ip", Type: settingHostPort},
+	{Key: "quota_warn_percents", Type: settingPercents},
+	{Key: "quota_warn_days", Type: settingInt, Min: 0, Max: 365},
+	{Key: "alert_reopen_window_s", Type: settingInt, Min: 0, Max: 7 * 86400},
+	{Key: "alert_offline_grace_s", Type: settingInt, Min: 0, Max: 3600},
+	{Key: "metrics_raw_retention_h", Type: settingInt, Min: 2, Max: 720},
+	{Key: "metrics_1m_retention_d", Type: settingInt, Min: 1, Max: 365},
+	{Key: "metrics_1h_retention_d", Type: settingInt, Min: 1, Max: 3650},
+	// legacy callback, turned into a webhook channel when saved
+	{Key: "callback_url", Type: settingURL},
+	{Key: "callback_method", Type: settingEnum, Options: []string{"GET", "POST"}},
+	{Key: "callback_headers", Type: settingHeaders},
+	{Key: "callback_template", Type: settingString, Max: 8192},
+	{Key: "callback_user_template", Type: settingString, Max: 8192},
+}
+
+var internalConfigPrefixes = []string{"tunnel_path_", "tunnel_iface_", "tunnel_bindip_"}
+
+func siteSettingFor(key string) (siteSetting, bool) {
+	for _, s := range siteSettings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return siteSetting{}, false
+}
+
+// isInternalConfig reports vite_config rows that hold panel state rather than settings
+func isInternalConfig(key string) bool {
+	for _, p := range internalConfigPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize validates v for s and returns the value to store; "" always resets to the default
+func (s siteSetting) normalize(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	switch s.Type {
+	case settingString:
+		if s.Max > 0 && len([]rune(v)) > s.Max {
+			return "", fmt.Errorf("%s 长度不能超过 %d", s.Key, s.Max)
+		}
+	case settingBool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", fmt.Errorf("%s 必须为 true 或 false", s.Key)
+		}
+		v = strconv.FormatBool(b)
+	case settingInt:
+		n, err := strconv.Atoi(v)
+		if err != nil || n < s.Min || n > s.Max {
+			return "", fmt.Errorf("%s 必须为 %d~%d 的整数", s.Key, s.Min, s.Max)
+		}
+		v = strconv.Itoa(n)
+	case settingEnum:
+		for _, o := range s.Options {
+			if strings.EqualFold(v, o) {
+				return o, nil
+			}
+		}
+		return "", fmt.Errorf("%s 取值须为 %s 之一", s.Key, strings.Join(s.Options, "/"))
+	case settingHostPort:
+		host, port, err := net.SplitHostPort(wrapIPv6(v))
+		if n, perr := strconv.Atoi(port); err != nil || host == "" || perr != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("%s 格式应为 ip:port", s.Key)
+		}
+	case settingURL:
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("%s 必须为 http(s) 地址", s.Key)
+		}
+	case settingHeaders:
+		var h map[string]string
+		if err := json.Unmarshal([]byte(v), &h); err != nil {
+			return "", fmt.Errorf("%s 必须为 JSON 对象，值为字符串", s.Key)
+		}
+	case settingPercents:
+		for _, f := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil || n < 1 || n > 99 {
+				return "", fmt.Errorf("%s 须为逗号分隔的 1~99 整数", s.Key)
+			}
+		}
+	}
+	return v, nil
+}
+
+// normalizeSettings checks a whole update before anything is written
+func normalizeSettings(in map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		s, ok := siteSettingFor(k)
+		if !ok && isInternalConfig(k) {
+			return nil, fmt.Errorf("%s 为内部数据，不能通过配置接口修改", k)
+		}
+		if !ok {
+			return nil, fmt.Errorf("未知配置项 %s", k)
+		}
+		nv, err := s.normalize(v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = nv
+	}
+	return out, nil
+}
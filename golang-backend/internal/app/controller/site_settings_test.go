@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSiteSettingNormalize(t *testing.T) {
+	cases := []struct {
+		key, in, want string
+		ok            bool
+	}{
+		{"app_name", "  面板  ", "面板", true},
+		{"app_name", strings.Repeat("名", 65), "", false},
+		{"captcha_enabled", "TRUE", "true", true},
+		{"captcha_enabled", "yes", "", false},
+		{"captcha_type", "slider", "SLIDER", true},
+		{"captcha_type", "audio", "", false},
+		{"quota_warn_days", "007", "7", true},
+		{"quota_warn_days", "366", "", false},
+		{"metrics_raw_retention_h", "1", "", false},
+		{"ip", "203.0.113.10:6365", "203.0.113.10:6365", true},
+		{"ip", "[2001:db8::1]:6365", "[2001:db8::1]:6365", true},
+		{"ip", "203.0.113.10", "", false},
+		{"ip", "203.0.113.10:70000", "", false},
+		{"callback_url", "https://hooks.example.com/x", "https://hooks.example.com/x", true},
+		{"callback_url", "ftp://hooks.example.com", "", false},
+		{"callback_headers", `{"X-Key":"v"}`, `{"X-Key":"v"}`, true},
+		{"callback_headers", `{"X-Retry":3}`, "", false},
+		{"quota_warn_percents", "80, 95", "80, 95", true},
+		{"quota_warn_percents", "80,100", "", false},
+		{"quota_warn_days", "  ", "", true}, // empty resets to the default
+	}
+	for _, c := range cases {
+		s, ok := siteSettingFor(c.key)
+		if !ok {
+			t.Fatalf("unknown setting %s", c.key)
+		}
+		got, err := s.normalize(c.in)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("%s %q: got %q, %v; want %q, ok=%v", c.key, c.in, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func TestNormalizeSettings(t *testing.T) {
+	got, err := normalizeSettings(map[string]string{"captcha_enabled": "1", "callback_method": "post", "app_name": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"captcha_enabled": "true", "callback_method": "POST", "app_name": ""}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for in, msg := range map[string]string{
+		"tunnel_path_3": "内部数据",
+		"jwt_secret":    "未知配置项",
+	} {
+		if _, err := normalizeSettings(map[string]string{"app_name": "ok", in: "x"}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: err = %v, want %q", in, err, msg)
+		}
+	}
+	// one bad value rejects the whole update
+	if out, err := normalizeSettings(map[string]string{"app_name": "ok", "quota_warn_days": "-1"}); err == nil || out != nil {
+		t.Fatalf("got %v, %v", out, err)
+	}
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,13 +11,15 @@ import (
 	dbpkg "network-panel/golang-backend/internal/db"
 )
 
-// POST /api/v1/node/sysinfo {nodeId, range}
-// range: 1h,12h,1d,7d,30d
+// POST /api/v1/node/sysinfo {nodeId, range, iface?}
+// range: 1h,12h,1d,7d,30d; 1h returns raw samples, longer ranges 1m/1h rollups.
+// iface keeps only that interface in the per-interface series.
 func NodeSysinfo(c *gin.Context) {
 	var p struct {
 		NodeID int64  `json:"nodeId" binding:"required"`
 		Range  string `json:"range"`
 		Limit  int    `json:"limit"`
+		Iface  string `json:"iface"`
 	}
 	if err := c.ShouldBindJSON(&p); err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
@@ -37,11 +40,73 @@ func NodeSysinfo(c *gin.Context) {
 		windowMs = 3600 * 1000
 	}
 	from := now - windowMs
+	// long ranges read the rollups (avg plus min/max and rates per bucket), see metricResolution
+	res := metricResolution(from, now)
+	if res != resRaw {
+		q := dbpkg.DB.Where("node_id = ? AND res = ? AND time_ms >= ?", p.NodeID, res, from).Order("time_ms asc")
+		if p.Limit > 0 {
+			q = q.Limit(p.Limit)
+		}
+		var list []model.NodeSysInfoRollup
+		q.Find(&list)
+		for i := range list {
+			list[i].Ifaces = onlyIface(list[i].Ifaces, p.Iface)
+		}
+		c.JSON(http.StatusOK, response.Ok(list))
+		return
+	}
 	q := dbpkg.DB.Model(&model.NodeSysInfo{}).Where("node_id = ? AND time_ms >= ?", p.NodeID, from).Order("time_ms asc")
 	if p.Limit > 0 {
 		q = q.Limit(p.Limit)
 	}
 	var list []model.NodeSysInfo
 	q.Find(&list)
+	for i := range list {
+		list[i].Ifaces = onlyIface(list[i].Ifaces, p.Iface)
+	}
 	c.JSON(http.StatusOK, response.Ok(list))
 }
+
+func onlyIface(ifs model.IfaceStats, name string) model.IfaceStats {
+	if name == "" || ifs == nil {
+		return ifs
+	}
+	it, ok := ifs[name]
+	if !ok {
+		return nil
+	}
+	return model.IfaceStats{name: it}
+}
+
+// last stored sample per node, for agents that report counters without rates
+var (
+	sysRateMu   sync.Mutex
+	sysRateLast = map[int64]model.NodeSysInfo{}
+)
+
+// fillSysInfoRates derives the rates of s from the previous sample of the node when the
+// agent did not send them, i.e. agents that predate per-interface rates and only report
+// totals. Counters that went down were reset and count from zero.
+func fillSysInfoRates(s *model.NodeSysInfo, agentRates bool) {
+	sysRateMu.Lock()
+	prev, ok := sysRateLast[s.NodeID]
+	sysRateLast[s.NodeID] = *s
+	sysRateMu.Unlock()
+	if agentRates || !ok || s.TimeMs <= prev.TimeMs {
+		return
+	}
+	secs := float64(s.TimeMs-prev.TimeMs) / 1000
+	// a connection gap longer than a few reports would average the rate over the outage
+	if secs > 60 {
+		return
+	}
+	s.RxRate = float64(counterDelta(prev.BytesRx, s.BytesRx)) / secs
+	s.TxRate = float64(counterDelta(prev.BytesTx, s.BytesTx)) / secs
+	for name, it := range s.Ifaces {
+		if p, ok := prev.Ifaces[name]; ok {
+			it.RxRate = float64(counterDelta(p.RxBytes, it.RxBytes)) / secs
+			it.TxRate = float64(counterDelta(p.TxBytes, it.TxBytes)) / secs
+			s.Ifaces[name] = it
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !loong64
+
+package controller
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// useTestDB points dbpkg.DB at a fresh SQLite database holding the tables of models
+func useTestDB(t *testing.T, models ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "panel.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	prev := dbpkg.DB
+	dbpkg.DB = db
+	t.Cleanup(func() { dbpkg.DB = prev })
+	return db
+}
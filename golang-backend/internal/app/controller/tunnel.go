@@ -2,6 +2,7 @@ package controller
 
 import (
     "fmt"
+    "log/slog"
     "net/http"
     "time"
     "strings"
@@ -12,7 +13,7 @@ import (
     "network-panel/golang-backend/internal/db"
 
     "github.com/gin-gonic/gin"
-    "log"
+    "gorm.io/gorm"
 )
 
 // POST /api/v1/tunnel/create
@@ -114,7 +115,13 @@ func TunnelDelete(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("该隧道还有用户权限关联，请先取消用户权限分配"))
 		return
 	}
-	if err := db.DB.Delete(&model.Tunnel{}, p.ID).Error; err != nil {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteTunnelHops(tx, p.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&model.Tunnel{}, p.ID).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("隧道删除失败"))
 		return
 	}
@@ -196,7 +203,11 @@ func TunnelUserRemove(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("未找到对应的用户隧道权限记录"))
 		return
 	}
+	var fids []int64
+	db.DB.Model(&model.Forward{}).Where("user_id = ? and tunnel_id = ?", ut.UserID, ut.TunnelID).Pluck("id", &fids)
 	db.DB.Where("user_id = ? and tunnel_id = ?", ut.UserID, ut.TunnelID).Delete(&model.Forward{})
+	releaseForwardPorts(fids...)
+	forgetForwardMetrics(fids...)
 	db.DB.Delete(&ut)
 	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限删除成功"))
 }
@@ -279,7 +290,7 @@ func TunnelDiagnose(c *gin.Context) {
     if t.Type == 2 {
         path := getTunnelPathNodes(t.ID)
         if len(path) == 0 {
-            jlog(map[string]any{"event":"iperf3_no_path","tunnelId": t.ID})
+            jlogAt(slog.LevelWarn, map[string]any{"event":"iperf3_no_path","tunnelId": t.ID})
         }
         // build pairs
         hops := make([]int64, 0, 2+len(path))
@@ -385,7 +396,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
     // avoid nil deref: compute outNodeId safely
     outId := int64(0)
     if t.OutNodeID != nil { outId = *t.OutNodeID }
-    log.Printf("API /tunnel/diagnose-step tunnelId=%d step=%s inNode=%d outNode=%d", p.TunnelID, p.Step, t.InNodeID, outId)
+    jlogAt(slog.LevelDebug, map[string]any{"event": "api_diagnose_step", "tunnelId": p.TunnelID, "step": p.Step, "inNode": t.InNodeID, "outNode": outId})
 	var res map[string]interface{}
 	switch p.Step {
 	case "entry":
@@ -447,7 +458,6 @@ func TunnelDiagnoseStep(c *gin.Context) {
         exitIP := orString(ptrString(t.OutIP), outNode.ServerIP)
         // 1) 出口节点启动 iperf3 server（随机端口）
         srvReq := map[string]interface{}{"requestId": RandUUID(), "mode": "iperf3", "server": true, "port": 0, "ctx": map[string]any{"src": "tunnel", "step": "iperf3_server", "tunnelId": t.ID}}
-        _ = sendWSCommand(outNode.ID, "Diagnose", srvReq)
         srvRes, ok := RequestDiagnose(outNode.ID, srvReq, 8*time.Second)
         if !ok {
             c.JSON(http.StatusOK, response.ErrMsg("出口节点未响应iperf3服务启动"))
@@ -473,7 +483,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
         tmpPorts := make([]int, len(fNodes))
         rid := RandUUID()
         tmpNames := make([]string, len(fNodes))
-        jlog(map[string]any{"event":"iperf3_path_nodes","tunnelId": t.ID, "nodes": fNodes, "ports": tmpPorts})
+        jlogAt(slog.LevelDebug, map[string]any{"event":"iperf3_path_nodes","tunnelId": t.ID, "nodes": fNodes, "ports": tmpPorts})
         for i, nid := range fNodes {
             var n model.Node
             _ = db.DB.First(&n, nid).Error
@@ -485,7 +495,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
             tmpPorts[i] = findFreePortOnNode(nid, prefer, minP, maxP)
             if tmpPorts[i] == 0 { tmpPorts[i] = minP }
             tmpNames[i] = fmt.Sprintf("tmp_iperf3_%d_%s_%d", t.ID, rid, i)
-            jlog(map[string]any{"event":"iperf3_tmp_port_pick","tunnelId": t.ID, "nodeId": nid, "name": tmpNames[i], "port": tmpPorts[i]})
+            jlogAt(slog.LevelDebug, map[string]any{"event":"iperf3_tmp_port_pick","tunnelId": t.ID, "nodeId": nid, "name": tmpNames[i], "port": tmpPorts[i]})
         }
         // 部署临时直转服务链
         for i := 0; i < len(fNodes); i++ {
@@ -503,7 +513,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
             if ip, ok := ifaceMap[nid]; ok && ip != "" { tmp := ip; iface = &tmp }
             svc := buildServiceConfig(tmpNames[i], tmpPorts[i], target, iface)
             _ = sendWSCommand(nid, "AddService", []map[string]any{svc})
-            jlog(map[string]any{"event":"iperf3_tmp_add","tunnelId": t.ID, "nodeId": nid, "name": tmpNames[i], "listen": tmpPorts[i], "target": target})
+            jlogAt(slog.LevelDebug, map[string]any{"event":"iperf3_tmp_add","tunnelId": t.ID, "nodeId": nid, "name": tmpNames[i], "listen": tmpPorts[i], "target": target})
         }
         // 下发 RestartGost 以确保临时配置立即生效
         for i := 0; i < len(fNodes); i++ {
@@ -514,7 +524,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
         for i := 0; i < len(fNodes); i++ {
             if !waitServiceOnNode(fNodes[i], tmpNames[i], tmpPorts[i], 8*time.Second) {
                 readyAll = false
-                jlog(map[string]any{"event":"iperf3_tmp_wait_timeout","tunnelId": t.ID, "nodeId": fNodes[i], "name": tmpNames[i], "port": tmpPorts[i]})
+                jlogAt(slog.LevelWarn, map[string]any{"event":"iperf3_tmp_wait_timeout","tunnelId": t.ID, "nodeId": fNodes[i], "name": tmpNames[i], "port": tmpPorts[i]})
             }
         }
         // 额外：逐跳 TCP 连通性验证（上一跳直连下一跳端口，最多重试3次）
@@ -530,19 +540,18 @@ func TunnelDiagnoseStep(c *gin.Context) {
                 }
                 if !okc {
                     readyAll = false
-                    jlog(map[string]any{"event":"iperf3_tmp_tcp_fail","tunnelId": t.ID, "from": fNodes[i], "to": host, "port": tmpPorts[i+1]})
+                    jlogAt(slog.LevelWarn, map[string]any{"event":"iperf3_tmp_tcp_fail","tunnelId": t.ID, "from": fNodes[i], "to": host, "port": tmpPorts[i+1]})
                     break
                 }
             }
         }
         if !readyAll {
-            jlog(map[string]any{"event":"iperf3_tmp_ready_partial","tunnelId": t.ID})
+            jlogAt(slog.LevelWarn, map[string]any{"event":"iperf3_tmp_ready_partial","tunnelId": t.ID})
             c.JSON(http.StatusOK, response.ErrMsg("临时通道未完全就绪，已中止 iperf3 测试"))
             return
         }
         // 3) 入口作为 iperf3 客户端，连接本机临时入口端口
         cliReq := map[string]interface{}{"requestId": RandUUID(), "mode": "iperf3", "client": true, "host": "127.0.0.1", "port": tmpPorts[0], "duration": 5, "reverse": true, "ctx": map[string]any{"src": "tunnel", "step": "iperf3_client_path", "tunnelId": t.ID}}
-        _ = sendWSCommand(inNode.ID, "Diagnose", cliReq)
         cliRes, ok := RequestDiagnose(inNode.ID, cliReq, 20*time.Second)
         if !ok {
             c.JSON(http.StatusOK, response.ErrMsg("入口节点未响应iperf3客户端"))
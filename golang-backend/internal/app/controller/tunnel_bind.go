@@ -1,9 +1,8 @@
 package controller
 
 import (
-    "encoding/json"
     "net/http"
-    "strconv"
+    "strings"
 
     "github.com/gin-gonic/gin"
     "network-panel/golang-backend/internal/app/model"
@@ -13,8 +12,6 @@ import (
 
 // in-bind IP map: per-node listener bind IP for tunnel-forward (exclude entry by convention)
 
-func tunnelBindKey(tid int64) string { return "tunnel_bindip_" + strconv.FormatInt(tid, 10) }
-
 // POST /api/v1/tunnel/bind/get {tunnelId}
 func TunnelBindGet(c *gin.Context) {
     var p struct{ TunnelID int64 `json:"tunnelId" binding:"required"` }
@@ -26,29 +23,19 @@ func TunnelBindGet(c *gin.Context) {
 }
 
 // POST /api/v1/tunnel/bind/set {tunnelId, binds:[{nodeId, ip}]}
+// Nodes must be a mid or the exit of the tunnel; empty ip clears the setting.
 func TunnelBindSet(c *gin.Context) {
     var p struct{ TunnelID int64 `json:"tunnelId" binding:"required"`; Binds []struct{ NodeID int64 `json:"nodeId"`; IP string `json:"ip"` } `json:"binds"` }
     if err := c.ShouldBindJSON(&p); err != nil { c.JSON(http.StatusOK, response.ErrMsg("参数错误")); return }
+    var t model.Tunnel
+    if err := dbpkg.DB.First(&t, p.TunnelID).Error; err != nil { c.JSON(http.StatusOK, response.ErrMsg("隧道不存在")); return }
     m := map[int64]string{}
-    for _, it := range p.Binds { if it.NodeID > 0 { m[it.NodeID] = it.IP } }
-    b, _ := json.Marshal(m)
-    key := tunnelBindKey(p.TunnelID)
-    var cfg model.ViteConfig
-    if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err == nil {
-        cfg.Value = string(b)
-        _ = dbpkg.DB.Save(&cfg).Error
-    } else {
-        _ = dbpkg.DB.Create(&model.ViteConfig{Name: key, Value: string(b)}).Error
+    for _, it := range p.Binds {
+        if it.NodeID <= 0 { continue }
+        ip := strings.TrimSpace(it.IP)
+        if !validHopBindIP(ip) { c.JSON(http.StatusOK, response.ErrMsg("监听IP格式错误: "+ip)); return }
+        m[it.NodeID] = ip
     }
+    if err := setTunnelNodeValues(t, "bind_ip", m); err != nil { c.JSON(http.StatusOK, response.ErrMsg(err.Error())); return }
     c.JSON(http.StatusOK, response.OkMsg("已保存"))
 }
-
-func getTunnelBindMap(tunnelID int64) map[int64]string {
-    key := tunnelBindKey(tunnelID)
-    var cfg model.ViteConfig
-    m := map[int64]string{}
-    if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err != nil || cfg.Value == "" { return m }
-    _ = json.Unmarshal([]byte(cfg.Value), &m)
-    return m
-}
-
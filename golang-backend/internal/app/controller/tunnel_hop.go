@@ -0,0 +1,416 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Tunnel path and per-node settings (tunnel_hop). Mid hops are the ordered relay path; entry
+// and exit rows exist only to hold their interface / bind IP.
+
+const (
+	hopEntry = "entry"
+	hopMid   = "mid"
+	hopExit  = "exit"
+)
+
+// interface names as the agent accepts them (eth0, ens3.100, wg-home)
+var ifaceNameRe = regexp.MustCompile(`^[A-Za-z0-9_.:\-]{1,32}$`)
+
+func tunnelHops(tunnelID int64) []model.TunnelHop {
+	var hops []model.TunnelHop
+	dbpkg.DB.Where("tunnel_id = ?", tunnelID).Order("role, inx").Find(&hops)
+	return hops
+}
+
+// tunnelMidHops returns the relay path of a tunnel in order
+func tunnelMidHops(tunnelID int64) []model.TunnelHop {
+	var hops []model.TunnelHop
+	dbpkg.DB.Where("tunnel_id = ? AND role = ?", tunnelID, hopMid).Order("inx").Find(&hops)
+	return hops
+}
+
+// getTunnelPathNodes lists the mid node IDs of a tunnel in path order
+func getTunnelPathNodes(tunnelID int64) []int64 {
+	hops := tunnelMidHops(tunnelID)
+	ids := make([]int64, 0, len(hops))
+	for _, h := range hops {
+		ids = append(ids, h.NodeID)
+	}
+	return ids
+}
+
+// getTunnelIfaceMap maps node ID -> outgoing IP / interface for every node of the tunnel
+func getTunnelIfaceMap(tunnelID int64) map[int64]string {
+	m := map[int64]string{}
+	for _, h := range tunnelHops(tunnelID) {
+		if h.Interface != "" {
+			m[h.NodeID] = h.Interface
+		}
+	}
+	return m
+}
+
+// getTunnelBindMap maps node ID -> listen IP for the mids and the exit
+func getTunnelBindMap(tunnelID int64) map[int64]string {
+	m := map[int64]string{}
+	for _, h := range tunnelHops(tunnelID) {
+		if h.BindIP != "" {
+			m[h.NodeID] = h.BindIP
+		}
+	}
+	return m
+}
+
+// tunnelMidPorts returns the preferred listen port of each mid hop, 0 when not overridden
+func tunnelMidPorts(tunnelID int64) []int {
+	hops := tunnelMidHops(tunnelID)
+	ports := make([]int, len(hops))
+	for i, h := range hops {
+		ports[i] = h.Port
+	}
+	return ports
+}
+
+type hopSpec struct {
+	NodeID    int64  `json:"nodeId"`
+	Port      int    `json:"port"`
+	Interface string `json:"interface"`
+	BindIP    string `json:"bindIp"`
+}
+
+func validHopInterface(v string) bool {
+	return v == "" || net.ParseIP(v) != nil || ifaceNameRe.MatchString(v)
+}
+
+func validHopBindIP(v string) bool {
+	return v == "" || net.ParseIP(strings.Trim(v, "[]")) != nil
+}
+
+// validateMidHops checks a new path: existing nodes, no repeats, not the entry/exit, ports
+// inside the node's range, well-formed IPs
+func validateMidHops(t model.Tunnel, specs []hopSpec) error {
+	seen := map[int64]bool{}
+	for i, s := range specs {
+		var n model.Node
+		if s.NodeID <= 0 || dbpkg.DB.First(&n, s.NodeID).Error != nil {
+			return fmt.Errorf("第 %d 跳节点不存在", i+1)
+		}
+		if seen[s.NodeID] {
+			return fmt.Errorf("节点 %s 在路径中重复", n.Name)
+		}
+		seen[s.NodeID] = true
+		if s.NodeID == t.InNodeID || (t.OutNodeID != nil && s.NodeID == *t.OutNodeID) {
+			return fmt.Errorf("节点 %s 已是入口或出口，不能作为中间节点", n.Name)
+		}
+		if s.Port != 0 {
+			minP, maxP := nodePortRange(s.NodeID)
+			if s.Port < minP || s.Port > maxP {
+				return fmt.Errorf("节点 %s 端口 %d 不在可用范围 %d-%d", n.Name, s.Port, minP, maxP)
+			}
+		}
+		if !validHopInterface(s.Interface) {
+			return fmt.Errorf("节点 %s 出站接口格式错误", n.Name)
+		}
+		if !validHopBindIP(s.BindIP) {
+			return fmt.Errorf("节点 %s 监听IP格式错误", n.Name)
+		}
+	}
+	return nil
+}
+
+// saveMidHops replaces the path of t. keep=true carries interface/bind/port of nodes that
+// stay on the path when specs only name the nodes (path/set).
+func saveMidHops(tx *gorm.DB, t model.Tunnel, specs []hopSpec, keep bool) error {
+	old := map[int64]model.TunnelHop{}
+	if keep {
+		var rows []model.TunnelHop
+		tx.Where("tunnel_id = ? AND role = ?", t.ID, hopMid).Find(&rows)
+		for _, r := range rows {
+			old[r.NodeID] = r
+		}
+	}
+	if err := tx.Where("tunnel_id = ? AND role = ?", t.ID, hopMid).Delete(&model.TunnelHop{}).Error; err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	for i, s := range specs {
+		h := model.TunnelHop{TunnelID: t.ID, Role: hopMid, Inx: i, NodeID: s.NodeID, Interface: s.Interface, BindIP: s.BindIP, Port: s.Port, UpdatedTime: now}
+		if o, ok := old[s.NodeID]; ok {
+			h.Interface, h.BindIP, h.Port = o.Interface, o.BindIP, o.Port
+		}
+		if err := tx.Create(&h).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tunnelNodeRole is the role a node has on a tunnel, "" when it is not part of it
+func tunnelNodeRole(t model.Tunnel, path []int64, nodeID int64) string {
+	switch {
+	case nodeID == t.InNodeID:
+		return hopEntry
+	case t.OutNodeID != nil && nodeID == *t.OutNodeID:
+		return hopExit
+	}
+	for _, id := range path {
+		if id == nodeID {
+			return hopMid
+		}
+	}
+	return ""
+}
+
+// setTunnelNodeValues writes one per-node setting (column interface or bind_ip) for the
+// nodes in m, creating entry/exit rows as needed; nodes not on the tunnel are an error
+func setTunnelNodeValues(t model.Tunnel, column string, m map[int64]string) error {
+	path := getTunnelPathNodes(t.ID)
+	return dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UnixMilli()
+		for nid, v := range m {
+			role := tunnelNodeRole(t, path, nid)
+			if role == "" {
+				return fmt.Errorf("节点 %d 不属于该隧道", nid)
+			}
+			if role == hopEntry && column == "bind_ip" {
+				continue // the entry listens on the forward's own address
+			}
+			var cnt int64
+			tx.Model(&model.TunnelHop{}).Where("tunnel_id = ? AND node_id = ?", t.ID, nid).Count(&cnt)
+			if cnt == 0 {
+				if role == hopMid || v == "" {
+					continue
+				}
+				h := model.TunnelHop{TunnelID: t.ID, Role: role, NodeID: nid, UpdatedTime: now}
+				if column == "interface" {
+					h.Interface = v
+				} else {
+					h.BindIP = v
+				}
+				if err := tx.Create(&h).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(&model.TunnelHop{}).Where("tunnel_id = ? AND node_id = ?", t.ID, nid).
+				Updates(map[string]any{column: v, "updated_time": now}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteTunnelHops drops every hop row of a tunnel (tunnel deleted)
+func deleteTunnelHops(tx *gorm.DB, tunnelID int64) error {
+	return tx.Where("tunnel_id = ?", tunnelID).Delete(&model.TunnelHop{}).Error
+}
+
+// MigrateTunnelHops moves tunnel_path_<id>, tunnel_iface_<id> and tunnel_bindip_<id>
+// from vite_config into tunnel_hop, once per key: converted keys are deleted, keys of
+// missing tunnels dropped, nodes that no longer exist skipped. Then adds the foreign keys of
+// tunnel_hop if they are missing. Runs at start and after a data import.
+func MigrateTunnelHops() {
+	migrateLegacyTunnelHops()
+	ensureTunnelHopKeys()
+}
+
+// ensureTunnelHopKeys adds the foreign keys tunnel_hop.tunnel_id -> tunnel.id and
+// tunnel_hop.node_id -> node.id once. MySQL tables created by older releases may keep
+// node.id/tunnel.id as int, which a bigint column cannot reference: they are widened first,
+// and rows the keys would reject are swept. From then on the database enforces them.
+func ensureTunnelHopKeys() {
+	m := dbpkg.DB.Migrator()
+	missing := []string{}
+	for _, rel := range []string{"Tunnel", "Node"} {
+		if !m.HasConstraint(&model.TunnelHop{}, rel) {
+			missing = append(missing, rel)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if err := widenIDColumns("tunnel", "node"); err != nil {
+		jlogAt(slog.LevelError, map[string]any{"event": "tunnel_hop_fk_err", "error": err.Error()})
+		return
+	}
+	cleanTunnelHopOrphans()
+	for _, rel := range missing {
+		if err := m.CreateConstraint(&model.TunnelHop{}, rel); err != nil {
+			jlogAt(slog.LevelError, map[string]any{"event": "tunnel_hop_fk_err", "relation": rel, "error": err.Error()})
+			continue
+		}
+		jlog(map[string]any{"event": "tunnel_hop_fk_added", "relation": rel})
+	}
+	// SQLite adds a key by copying the table, which drops its indexes
+	if err := dbpkg.DB.AutoMigrate(&model.TunnelHop{}); err != nil {
+		jlogAt(slog.LevelError, map[string]any{"event": "tunnel_hop_fk_err", "error": err.Error()})
+	}
+}
+
+// widenIDColumns turns int id columns of MySQL tables into the signed bigint the model uses
+func widenIDColumns(tables ...string) error {
+	if dbpkg.DB.Dialector.Name() != "mysql" {
+		return nil
+	}
+	for _, t := range tables {
+		var colType string
+		if err := dbpkg.DB.Raw("SELECT COLUMN_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'id'", t).Scan(&colType).Error; err != nil {
+			return err
+		}
+		if strings.HasPrefix(colType, "bigint") && !strings.Contains(colType, "unsigned") {
+			continue
+		}
+		if err := dbpkg.DB.Exec("ALTER TABLE `" + t + "` MODIFY `id` BIGINT NOT NULL AUTO_INCREMENT").Error; err != nil {
+			return err
+		}
+		jlog(map[string]any{"event": "id_column_widened", "table": t, "from": colType})
+	}
+	return nil
+}
+
+// cleanTunnelHopOrphans drops rows the foreign keys would reject (missing tunnels or nodes)
+// and rows that disagree with their tunnel: entry/exit rows of nodes that no longer hold that
+// role and mid rows naming the entry or exit. Runs once before the keys are added, and after a
+// data import, which upserts tunnels by ID so a tunnel can come back with other entry/exit
+// nodes.
+func cleanTunnelHopOrphans() {
+	conds := []string{
+		"NOT EXISTS (SELECT 1 FROM tunnel t WHERE t.id = tunnel_hop.tunnel_id)",
+		"NOT EXISTS (SELECT 1 FROM node n WHERE n.id = tunnel_hop.node_id)",
+		"role = 'entry' AND NOT EXISTS (SELECT 1 FROM tunnel t WHERE t.id = tunnel_hop.tunnel_id AND t.in_node_id = tunnel_hop.node_id)",
+		"role = 'exit' AND NOT EXISTS (SELECT 1 FROM tunnel t WHERE t.id = tunnel_hop.tunnel_id AND t.out_node_id = tunnel_hop.node_id)",
+		"role = 'mid' AND EXISTS (SELECT 1 FROM tunnel t WHERE t.id = tunnel_hop.tunnel_id AND (t.in_node_id = tunnel_hop.node_id OR t.out_node_id = tunnel_hop.node_id))",
+	}
+	removed := int64(0)
+	for _, c := range conds {
+		res := dbpkg.DB.Where(c).Delete(&model.TunnelHop{})
+		if res.Error != nil {
+			jlogAt(slog.LevelError, map[string]any{"event": "tunnel_hop_clean_err", "error": res.Error.Error()})
+			continue
+		}
+		removed += res.RowsAffected
+	}
+	if removed > 0 {
+		jlog(map[string]any{"event": "tunnel_hop_orphans_removed", "rows": removed})
+	}
+}
+
+func migrateLegacyTunnelHops() {
+	var rows []model.ViteConfig
+	dbpkg.DB.Where("name LIKE ? OR name LIKE ? OR name LIKE ?", "tunnel_path_%", "tunnel_iface_%", "tunnel_bindip_%").Find(&rows)
+	if len(rows) == 0 {
+		return
+	}
+	type legacy struct {
+		path         []int64
+		iface, binds map[int64]string
+		keys         []int64
+	}
+	byTunnel := map[int64]*legacy{}
+	for _, r := range rows {
+		var kind string
+		for _, p := range internalConfigPrefixes {
+			if strings.HasPrefix(r.Name, p) {
+				kind = p
+			}
+		}
+		tid, err := strconv.ParseInt(strings.TrimPrefix(r.Name, kind), 10, 64)
+		if kind == "" || err != nil {
+			continue
+		}
+		l := byTunnel[tid]
+		if l == nil {
+			l = &legacy{iface: map[int64]string{}, binds: map[int64]string{}}
+			byTunnel[tid] = l
+		}
+		l.keys = append(l.keys, r.ID)
+		switch kind {
+		case "tunnel_path_":
+			l.path = parseLegacyPath(r.Value)
+		case "tunnel_iface_":
+			_ = json.Unmarshal([]byte(r.Value), &l.iface)
+		case "tunnel_bindip_":
+			_ = json.Unmarshal([]byte(r.Value), &l.binds)
+		}
+	}
+	moved, dropped := 0, 0
+	for tid, l := range byTunnel {
+		var t model.Tunnel
+		if dbpkg.DB.First(&t, tid).Error != nil {
+			dbpkg.DB.Where("id IN ?", l.keys).Delete(&model.ViteConfig{})
+			dropped++
+			continue
+		}
+		hops := legacyHopRows(t, l.path, l.iface, l.binds)
+		err := dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+			var existing int64
+			tx.Model(&model.TunnelHop{}).Where("tunnel_id = ?", tid).Count(&existing)
+			if existing == 0 && len(hops) > 0 {
+				if err := tx.Create(&hops).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Where("id IN ?", l.keys).Delete(&model.ViteConfig{}).Error
+		})
+		if err != nil {
+			jlogAt(slog.LevelError, map[string]any{"event": "tunnel_hop_migrate_err", "tunnelId": tid, "error": err.Error()})
+			continue
+		}
+		moved++
+	}
+	jlog(map[string]any{"event": "tunnel_hop_migrated", "tunnels": moved, "dropped": dropped})
+}
+
+func parseLegacyPath(v string) []int64 {
+	var ids []int64
+	if json.Unmarshal([]byte(v), &ids) == nil {
+		return ids
+	}
+	ids = nil
+	for _, p := range strings.Split(v, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// legacyHopRows builds the rows for one tunnel from the old per-key values
+func legacyHopRows(t model.Tunnel, path []int64, iface, binds map[int64]string) []model.TunnelHop {
+	now := time.Now().UnixMilli()
+	rows := []model.TunnelHop{}
+	exists := func(id int64) bool {
+		var n int64
+		dbpkg.DB.Model(&model.Node{}).Where("id = ?", id).Count(&n)
+		return n > 0
+	}
+	onPath := map[int64]bool{}
+	for _, nid := range path {
+		if nid <= 0 || onPath[nid] || nid == t.InNodeID || (t.OutNodeID != nil && nid == *t.OutNodeID) || !exists(nid) {
+			continue
+		}
+		onPath[nid] = true
+		rows = append(rows, model.TunnelHop{TunnelID: t.ID, Role: hopMid, Inx: len(rows), NodeID: nid, Interface: iface[nid], BindIP: binds[nid], UpdatedTime: now})
+	}
+	if v := iface[t.InNodeID]; v != "" {
+		rows = append(rows, model.TunnelHop{TunnelID: t.ID, Role: hopEntry, NodeID: t.InNodeID, Interface: v, UpdatedTime: now})
+	}
+	if t.OutNodeID != nil {
+		if iface[*t.OutNodeID] != "" || binds[*t.OutNodeID] != "" {
+			rows = append(rows, model.TunnelHop{TunnelID: t.ID, Role: hopExit, NodeID: *t.OutNodeID, Interface: iface[*t.OutNodeID], BindIP: binds[*t.OutNodeID], UpdatedTime: now})
+		}
+	}
+	return rows
+}
@@ -1,9 +1,8 @@
 package controller
 
 import (
-    "encoding/json"
     "net/http"
-    "strconv"
+    "strings"
 
     "github.com/gin-gonic/gin"
     "network-panel/golang-backend/internal/app/model"
@@ -24,37 +23,19 @@ func TunnelIfaceGet(c *gin.Context) {
 }
 
 // POST /api/v1/tunnel/iface/set {tunnelId, ifaces:[{nodeId, ip}]}
+// Nodes must belong to the tunnel (entry, mid or exit); empty ip clears the setting.
 func TunnelIfaceSet(c *gin.Context) {
     var p struct{ TunnelID int64 `json:"tunnelId" binding:"required"`; Ifaces []struct{ NodeID int64 `json:"nodeId"`; IP string `json:"ip"` } `json:"ifaces"` }
     if err := c.ShouldBindJSON(&p); err != nil { c.JSON(http.StatusOK, response.ErrMsg("参数错误")); return }
+    var t model.Tunnel
+    if err := dbpkg.DB.First(&t, p.TunnelID).Error; err != nil { c.JSON(http.StatusOK, response.ErrMsg("隧道不存在")); return }
     m := map[int64]string{}
     for _, it := range p.Ifaces {
         if it.NodeID <= 0 { continue }
-        m[it.NodeID] = it.IP // empty allowed (means unset)
-    }
-    b, _ := json.Marshal(m)
-    key := tunnelIfaceKey(p.TunnelID)
-    var cfg model.ViteConfig
-    if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err == nil {
-        cfg.Value = string(b)
-        _ = dbpkg.DB.Save(&cfg).Error
-    } else {
-        _ = dbpkg.DB.Create(&model.ViteConfig{Name: key, Value: string(b)}).Error
+        ip := strings.TrimSpace(it.IP)
+        if !validHopInterface(ip) { c.JSON(http.StatusOK, response.ErrMsg("出站接口格式错误: "+ip)); return }
+        m[it.NodeID] = ip // empty allowed (means unset)
     }
+    if err := setTunnelNodeValues(t, "interface", m); err != nil { c.JSON(http.StatusOK, response.ErrMsg(err.Error())); return }
     c.JSON(http.StatusOK, response.OkMsg("已保存"))
 }
-
-func tunnelIfaceKey(tid int64) string { return "tunnel_iface_" + strconv.FormatInt(tid, 10) }
-
-// getTunnelIfaceMap reads map[nodeId]ip from ViteConfig
-func getTunnelIfaceMap(tunnelID int64) map[int64]string {
-    key := tunnelIfaceKey(tunnelID)
-    var cfg model.ViteConfig
-    m := map[int64]string{}
-    if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err != nil || cfg.Value == "" {
-        return m
-    }
-    _ = json.Unmarshal([]byte(cfg.Value), &m)
-    return m
-}
-
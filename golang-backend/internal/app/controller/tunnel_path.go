@@ -1,13 +1,12 @@
 package controller
 
 import (
-    "encoding/json"
     "net/http"
-    "time"
     "strconv"
     "strings"
 
     "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
     "network-panel/golang-backend/internal/app/model"
     "network-panel/golang-backend/internal/app/response"
     dbpkg "network-panel/golang-backend/internal/db"
@@ -20,63 +19,103 @@ func TunnelPathGet(c *gin.Context) {
         c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
         return
     }
-    key := tunnelPathKey(p.TunnelID)
-    var cfg model.ViteConfig
-    _ = dbpkg.DB.Where("name = ?", key).First(&cfg).Error
-    var ids []int64
-    if cfg.Value != "" {
-        _ = json.Unmarshal([]byte(cfg.Value), &ids)
-    }
-    c.JSON(http.StatusOK, response.Ok(map[string]any{"path": ids}))
+    c.JSON(http.StatusOK, response.Ok(map[string]any{"path": getTunnelPathNodes(p.TunnelID), "ports": tunnelMidPorts(p.TunnelID)}))
 }
 
 // POST /api/v1/tunnel/path/set {tunnelId, path:[nodeId...]}
+// Replaces the mid nodes; nodes that stay on the path keep their interface/bind IP/port.
 func TunnelPathSet(c *gin.Context) {
     var p struct{ TunnelID int64 `json:"tunnelId" binding:"required"`; Path []int64 `json:"path"` }
     if err := c.ShouldBindJSON(&p); err != nil {
         c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
         return
     }
-    // de-dup and validate nodes exist
-    uniq := make([]int64, 0, len(p.Path))
-    seen := map[int64]struct{}{}
-    for _, id := range p.Path {
-        if id <= 0 { continue }
-        if _, ok := seen[id]; ok { continue }
-        var n model.Node
-        if dbpkg.DB.First(&n, id).Error == nil {
-            uniq = append(uniq, id)
-            seen[id] = struct{}{}
-        }
+    specs := make([]hopSpec, 0, len(p.Path))
+    for _, id := range p.Path { specs = append(specs, hopSpec{NodeID: id}) }
+    saveTunnelPath(c, p.TunnelID, specs, true)
+}
+
+// POST /api/v1/tunnel/hop/list {tunnelId}
+// Every node of the tunnel in order (entry, mids, exit) with its settings.
+func TunnelHopList(c *gin.Context) {
+    var p struct{ TunnelID int64 `json:"tunnelId" binding:"required"` }
+    if err := c.ShouldBindJSON(&p); err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+        return
     }
-    // persist to ViteConfig
-    b, _ := json.Marshal(uniq)
-    key := tunnelPathKey(p.TunnelID)
-    now := time.Now().UnixMilli()
-    var cfg model.ViteConfig
-    if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err == nil {
-        cfg.Value = string(b)
-        cfg.Time = now
-        _ = dbpkg.DB.Save(&cfg).Error
-    } else {
-        _ = dbpkg.DB.Create(&model.ViteConfig{Name: key, Value: string(b), Time: now}).Error
-    }
-    // After saving path, best-effort restart gost on affected nodes so配置立即生效（入口+中间+出口）
     var t model.Tunnel
-    _ = dbpkg.DB.First(&t, p.TunnelID).Error
-    nodes := make([]int64, 0, 2+len(uniq))
-    nodes = append(nodes, t.InNodeID)
-    nodes = append(nodes, uniq...)
-    if t.OutNodeID != nil { nodes = append(nodes, *t.OutNodeID) }
-    restarted := 0
-    for _, nid := range nodes {
-        if nid <= 0 { continue }
-        if err := sendWSCommand(nid, "RestartGost", map[string]any{"reason":"path_set"}); err == nil { restarted++ }
+    if err := dbpkg.DB.First(&t, p.TunnelID).Error; err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
+        return
+    }
+    rows := map[string]model.TunnelHop{}
+    for _, h := range tunnelHops(t.ID) {
+        if h.Role != hopMid { rows[h.Role] = h }
+    }
+    item := func(role string, inx int, nid int64, h model.TunnelHop) map[string]any {
+        var n model.Node
+        _ = dbpkg.DB.First(&n, nid).Error
+        return map[string]any{"role": role, "inx": inx, "nodeId": nid, "nodeName": n.Name, "interface": h.Interface, "bindIp": h.BindIP, "port": h.Port}
+    }
+    out := []map[string]any{item(hopEntry, 0, t.InNodeID, rows[hopEntry])}
+    for _, h := range tunnelMidHops(t.ID) { out = append(out, item(hopMid, h.Inx, h.NodeID, h)) }
+    if t.OutNodeID != nil { out = append(out, item(hopExit, 0, *t.OutNodeID, rows[hopExit])) }
+    c.JSON(http.StatusOK, response.Ok(map[string]any{"hops": out}))
+}
+
+// POST /api/v1/tunnel/hop/set {tunnelId, hops:[{nodeId, port?, interface?, bindIp?}]}
+// Replaces the mid nodes together with their settings, in path order.
+func TunnelHopSet(c *gin.Context) {
+    var p struct{ TunnelID int64 `json:"tunnelId" binding:"required"`; Hops []hopSpec `json:"hops"` }
+    if err := c.ShouldBindJSON(&p); err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+        return
     }
-    c.JSON(http.StatusOK, response.Ok(map[string]any{"saved": len(uniq), "restarted": restarted}))
+    for i := range p.Hops {
+        p.Hops[i].Interface = strings.TrimSpace(p.Hops[i].Interface)
+        p.Hops[i].BindIP = strings.TrimSpace(p.Hops[i].BindIP)
+    }
+    saveTunnelPath(c, p.TunnelID, p.Hops, false)
 }
 
-func tunnelPathKey(tid int64) string { return "tunnel_path_" + strconv.FormatInt(tid, 10) }
+// saveTunnelPath validates and stores the mid hops, then moves every forward of the tunnel
+// onto the new path: stale mid services are removed, new hops deployed and the entry chain
+// repointed. Results are reported per forward; an unchanged path redeploys nothing.
+func saveTunnelPath(c *gin.Context, tunnelID int64, specs []hopSpec, keep bool) {
+    var t model.Tunnel
+    if err := dbpkg.DB.First(&t, tunnelID).Error; err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
+        return
+    }
+    if err := validateMidHops(t, specs); err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+        return
+    }
+    oldPath := getTunnelPathNodes(t.ID)
+    var forwards []model.Forward
+    dbpkg.DB.Where("tunnel_id = ?", t.ID).Order("id").Find(&forwards)
+    if err := checkPathPorts(t, forwards, oldPath, specs); err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+        return
+    }
+    before := tunnelMidHops(t.ID)
+    if err := dbpkg.DB.Transaction(func(tx *gorm.DB) error { return saveMidHops(tx, t, specs, keep) }); err != nil {
+        c.JSON(http.StatusOK, response.ErrMsg("保存路径失败"))
+        return
+    }
+    // same hops and settings: the running services are already right, skip the gost restarts
+    if sameMidHops(before, tunnelMidHops(t.ID)) {
+        c.JSON(http.StatusOK, response.Ok(map[string]any{"saved": len(specs), "forwards": []forwardRedeployResult{}, "failed": 0, "unchanged": true}))
+        return
+    }
+    results := redeployTunnelForwards(t, forwards, oldPath)
+    failed := 0
+    for _, r := range results {
+        if !r.OK { failed++ }
+    }
+    jlog(map[string]interface{}{"event": "tunnel_path_set", "tunnelId": t.ID, "hops": len(specs), "forwards": len(results), "failed": failed})
+    c.JSON(http.StatusOK, response.Ok(map[string]any{"saved": len(specs), "forwards": results, "failed": failed}))
+}
 
 // POST /api/v1/tunnel/path-check {tunnelId}
 // Check each hop for: node online, proposed free port (mid relay), relay presence (grpc)
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Path changes: every forward of the tunnel is moved onto the new hop list by deploying it
+// again, which rewrites the entry chain; services that only existed on the old path are
+// removed afterwards.
+
+// forwardService is one service of a forward on one node
+type forwardService struct {
+	NodeID int64
+	Name   string
+}
+
+type forwardRedeployResult struct {
+	ForwardID int64    `json:"forwardId"`
+	Name      string   `json:"name"`
+	OK        bool     `json:"ok"`
+	Services  int      `json:"services"` // services the forward runs on the new path
+	Removed   []string `json:"removed"`  // stale services deleted, as nodeId/name
+	Msg       string   `json:"msg,omitempty"`
+}
+
+// forwardServiceSet lists the services f runs for tunnel t along path
+func forwardServiceSet(f model.Forward, t model.Tunnel, path []int64) map[forwardService]bool {
+	name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+	set := map[forwardService]bool{{t.InNodeID, name}: true}
+	if t.Type == 2 && f.OutPort != nil {
+		if exitID := outNodeIDOr0(t); exitID > 0 {
+			set[forwardService{exitID, name}] = true
+		}
+		for i, nid := range path {
+			set[forwardService{nid, fmt.Sprintf("%s_mid_%d", name, i)}] = true
+		}
+		return set
+	}
+	for _, nid := range path {
+		set[forwardService{nid, name}] = true
+	}
+	return set
+}
+
+// checkPathPorts rejects a new path on which a port-forward could not keep its port: every
+// hop of a multi-level port forward listens on the forward's inPort
+func checkPathPorts(t model.Tunnel, forwards []model.Forward, oldPath []int64, specs []hopSpec) error {
+	if t.Type == 2 {
+		return nil
+	}
+	had := map[int64]bool{}
+	for _, id := range oldPath {
+		had[id] = true
+	}
+	for _, s := range specs {
+		if had[s.NodeID] {
+			continue
+		}
+		for _, f := range forwards {
+			var cnt int64
+			dbpkg.DB.Model(&model.PortAllocation{}).
+				Where("node_id = ? AND port = ? AND protocol = ? AND (forward_id IS NULL OR forward_id <> ?)", s.NodeID, f.InPort, portProtoTCP, f.ID).
+				Count(&cnt)
+			if cnt > 0 {
+				var n model.Node
+				_ = dbpkg.DB.First(&n, s.NodeID).Error
+				return fmt.Errorf("转发 %s 的端口 %d 在节点 %s 上已被占用", f.Name, f.InPort, n.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// sameMidHops reports whether two stored paths have the same nodes and settings in order
+func sameMidHops(a, b []model.TunnelHop) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.NodeID != y.NodeID || x.Port != y.Port || x.Interface != y.Interface || x.BindIP != y.BindIP {
+			return false
+		}
+	}
+	return true
+}
+
+// redeployTunnelForwards moves every forward of t from oldPath to the path now stored.
+// Services are pushed per forward; gost restarts once per node after all of them. Old
+// services on nodes that leave the path are deleted only once the forward is deployed, so
+// a failed deploy leaves the forward running on its old path.
+func redeployTunnelForwards(t model.Tunnel, forwards []model.Forward, oldPath []int64) []forwardRedeployResult {
+	newPath := getTunnelPathNodes(t.ID)
+	results := make([]forwardRedeployResult, 0, len(forwards))
+	fail := func(r *forwardRedeployResult, msg string) {
+		if !r.OK {
+			msg = r.Msg + "; " + msg
+		}
+		r.OK, r.Msg = false, msg
+	}
+	remove := func(r *forwardRedeployResult, services []forwardService) {
+		for _, s := range services {
+			if err := sendWSCommand(s.NodeID, "DeleteService", map[string]any{"services": []string{s.Name}}); err != nil {
+				fail(r, fmt.Sprintf("节点 %d 旧服务 %s 未能删除: %v", s.NodeID, s.Name, err))
+				continue
+			}
+			r.Removed = append(r.Removed, fmt.Sprintf("%d/%s", s.NodeID, s.Name))
+		}
+	}
+	batch := newDeployBatch()
+	// batch index -> result index and the services to delete once deployed
+	type redeployed struct {
+		result int
+		f      model.Forward
+		stale  []forwardService
+	}
+	deployed := map[int]redeployed{}
+	for _, f := range forwards {
+		r := forwardRedeployResult{ForwardID: f.ID, Name: f.Name, OK: true, Removed: []string{}}
+		want := forwardServiceSet(f, t, newPath)
+		onPath := map[int64]bool{}
+		for s := range want {
+			onPath[s.NodeID] = true
+		}
+		// a node that stays on the path under another hop index gets a new service on the
+		// same port, so its old one goes first; the rest waits for the deploy
+		var replaced, leaving []forwardService
+		for s := range forwardServiceSet(f, t, oldPath) {
+			switch {
+			case want[s]:
+			case onPath[s.NodeID]:
+				replaced = append(replaced, s)
+			default:
+				leaving = append(leaving, s)
+			}
+		}
+		sortForwardServices(replaced)
+		sortForwardServices(leaving)
+		remove(&r, replaced)
+		name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+		if t.Type == 2 {
+			releaseForwardMidPorts(f.ID)
+		} else if len(newPath) > 0 && !reservePort(newPath, f.InPort, f.ID, name) {
+			fail(&r, fmt.Sprintf("端口 %d 在新路径节点上已被占用", f.InPort))
+			results = append(results, r)
+			continue
+		}
+		r.Services = len(want)
+		deployed[batch.add(f, t)] = redeployed{len(results), f, leaving}
+		results = append(results, r)
+	}
+	for i, err := range batch.finish("path_set") {
+		d := deployed[i]
+		r := &results[d.result]
+		if err != nil {
+			fail(r, "下发失败，旧路径上的服务已保留: "+err.Error())
+			continue
+		}
+		remove(r, d.stale)
+		// port registry follows the path (mid hops were reallocated by the deploy)
+		if t.Type != 2 {
+			gone := []int64{}
+			for _, s := range d.stale {
+				if s.NodeID != t.InNodeID {
+					gone = append(gone, s.NodeID)
+				}
+			}
+			releasePort(gone, d.f.InPort, d.f.ID)
+		}
+	}
+	return results
+}
+
+func sortForwardServices(list []forwardService) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].NodeID != list[j].NodeID {
+			return list[i].NodeID < list[j].NodeID
+		}
+		return list[i].Name < list[j].Name
+	})
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"network-panel/golang-backend/internal/app/dto"
@@ -72,6 +73,7 @@ func UserCreate(c *gin.Context) {
 		InFlow:     0, OutFlow: 0,
 		Num:           req.Num,
 		FlowResetTime: req.FlowResetTime,
+		NotifyContact: req.NotifyContact,
 	}
 	if err := dbpkg.DB.Create(&u).Error; err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("用户创建失败"))
@@ -131,6 +133,9 @@ func UserUpdate(c *gin.Context) {
 	if req.Status != nil {
 		u.Status = req.Status
 	}
+	if req.NotifyContact != nil {
+		u.NotifyContact = *req.NotifyContact
+	}
 	u.UpdatedTime = time.Now().UnixMilli()
 	if err := dbpkg.DB.Save(&u).Error; err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("用户更新失败"))
@@ -158,13 +163,18 @@ func UserDelete(c *gin.Context) {
 		return
 	}
 	// cascade deletions: forward, user_tunnel, statistics_flow (best-effort)
+	var fids []int64
+	dbpkg.DB.Model(&model.Forward{}).Where("user_id = ?", p.ID).Pluck("id", &fids)
 	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.Forward{})
+	releaseForwardPorts(fids...)
+	forgetForwardMetrics(fids...)
 	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.UserTunnel{})
 	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.StatisticsFlow{})
 	if err := dbpkg.DB.Delete(&u).Error; err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("用户删除失败"))
 		return
 	}
+	forgetUserMetrics(u.ID)
 	c.JSON(http.StatusOK, response.OkMsg("用户及关联数据删除成功"))
 }
 
@@ -192,6 +202,7 @@ func UserPackage(c *gin.Context) {
 		"num":           user.Num,
 		"expTime":       user.ExpTime,
 		"flowResetTime": user.FlowResetTime,
+		"notifyContact": user.NotifyContact,
 	}
 
 	// tunnel permissions with names and tunnelFlow
@@ -232,6 +243,28 @@ func UserPackage(c *gin.Context) {
 	}))
 }
 
+// POST /api/v1/user/contact {"notifyContact":"..."}
+// Lets a user set where quota and expiry warnings are sent.
+func UserUpdateContact(c *gin.Context) {
+	uidInf, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusOK, response.ErrMsg("用户未登录或token无效"))
+		return
+	}
+	var p struct {
+		NotifyContact string `json:"notifyContact"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || len(p.NotifyContact) > 255 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if err := dbpkg.DB.Model(&model.User{}).Where("id = ?", uidInf.(int64)).Update("notify_contact", strings.TrimSpace(p.NotifyContact)).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("用户更新失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("通知联系方式已更新"))
+}
+
 // POST /api/v1/user/updatePassword
 func UserUpdatePassword(c *gin.Context) {
 	uidInf, exists := c.Get("user_id")
@@ -1,18 +1,22 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
-	"reflect"
 	"sync"
 	"time"
 
 	"fmt"
 	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
 	apputil "network-panel/golang-backend/internal/app/util"
 	appver "network-panel/golang-backend/internal/app/version"
+	"network-panel/golang-backend/internal/app/lifecycle"
 	dbpkg "network-panel/golang-backend/internal/db"
+	"network-panel/golang-backend/internal/logx"
+	"network-panel/golang-backend/internal/wsproto"
     "strings"
 
 	"github.com/gin-gonic/gin"
@@ -20,27 +24,37 @@ import (
 	"gorm.io/gorm/clause"
 )
 
-// jlog emits structured JSON logs for easier tracing
-func jlog(m map[string]interface{}) {
-	b, _ := json.Marshal(m)
-	log.Print(string(b))
-}
+// jlog logs one event at info level: the "event" key is the message, the other keys are
+// attributes (secrets redacted, see logx)
+func jlog(m map[string]interface{}) { logx.Event(slog.LevelInfo, m) }
+
+// jlogAt is jlog at another level: debug for payload dumps, warn/error for failures
+func jlogAt(level slog.Level, m map[string]interface{}) { logx.Event(level, m) }
 
 var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 
 // nodeConns stores active node websocket connections by node ID (support multiple conns per node)
 type nodeConn struct {
-	c   *websocket.Conn
-	ver string
+	c    *websocket.Conn
+	w    *wsWriter // sole writer of c
+	ver  string
+	role string                // agent|agent2, the key of the bus route
+	caps *wsproto.Capabilities // nil until the agent answered Hello (legacy agent)
+}
+
+// agentRole normalizes the role query parameter of an agent connection
+func agentRole(q string) string {
+	if q == "agent2" {
+		return "agent2"
+	}
+	return "agent"
 }
 
 var (
 	nodeConnMu  sync.RWMutex
 	nodeConns   = map[int64][]*nodeConn{}
 	adminMu     sync.RWMutex
-	adminConns  = map[*websocket.Conn]struct{}{}
-	diagMu      sync.Mutex
-	diagWaiters = map[string]chan map[string]interface{}{}
+	adminConns  = map[*websocket.Conn]*wsWriter{}
 )
 
 // GET /system-info?type=1&secret=...&version=...
@@ -53,14 +67,15 @@ func SystemInfoWS(c *gin.Context) {
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("websocket upgrade error: %v", err)
+		jlogAt(slog.LevelWarn, map[string]interface{}{"event": "ws_upgrade_err", "error": err.Error()})
 		return
 	}
 
 	// Admin monitor channel
 	if nodeType == "0" {
+		w := newWSWriter(conn)
 		adminMu.Lock()
-		adminConns[conn] = struct{}{}
+		adminConns[conn] = w
 		adminMu.Unlock()
 		// keep read loop to detect close
 		for {
@@ -68,7 +83,7 @@ func SystemInfoWS(c *gin.Context) {
 				adminMu.Lock()
 				delete(adminConns, conn)
 				adminMu.Unlock()
-				conn.Close()
+				w.shutdown()
 				return
 			}
 		}
@@ -92,17 +107,30 @@ func SystemInfoWS(c *gin.Context) {
 			lastLog.UpAtMs = &now
 			lastLog.DurationS = &dur
 			_ = dbpkg.DB.Save(&lastLog).Error
-			// alert online with downtime info
-			name := node.Name
-			nid := node.ID
-			_ = dbpkg.DB.Create(&model.Alert{TimeMs: now, Type: "online", NodeID: &nid, NodeName: &name, Message: "节点恢复上线，时长(s): " + fmt.Sprintf("%d", dur)}).Error
+			if cancelOfflineAlert(node.ID) {
+				// back within the grace period: no alert, no notification
+				jlog(map[string]interface{}{"event": "offline_alert_suppressed", "nodeId": node.ID, "downS": dur})
+			} else if a, ok := ResolveNodeAlert("offline", node.ID); ok && a.Count == 1 {
+				// reopened (flapping) incidents were not notified as offline again, so neither is the recovery
+				ev := NodeNotifyEvent("agent_online", node, "节点恢复上线，离线 "+humanDuration(time.Duration(dur)*time.Second))
+				ev.DownAt, ev.UpAt, ev.Duration = time.UnixMilli(lastLog.DownAtMs), time.UnixMilli(now), time.Duration(dur)*time.Second
+				go notifyCallback(ev)
+			}
 		}
 
+		nc := &nodeConn{c: conn, w: newWSWriter(conn), ver: version, role: agentRole(role)}
 		nodeConnMu.Lock()
-		nodeConns[node.ID] = append(nodeConns[node.ID], &nodeConn{c: conn, ver: version})
+		nodeConns[node.ID] = append(nodeConns[node.ID], nc)
 		nodeConnMu.Unlock()
+		claimNodeRoute(node.ID, nc.role)
+		// protocol handshake: typed agents answer with Capabilities, legacy agents ignore it
+		if b, err := wsproto.Encode(wsproto.TypeHello, "", wsproto.Hello{Version: wsproto.Version, Server: appver.Get()}); err == nil {
+			_ = nc.w.send(b, wsEnqueueTimeout)
+		}
 		// broadcast online status
 		broadcastToAdmins(map[string]interface{}{"id": node.ID, "type": "status", "data": 1})
+		// import ports actually in use on the node into the port registry
+		go reconcileNodePorts(node.ID)
 
         // auto-upgrade agent if version mismatch (expected strictly follows backend version)
         sv := appver.Get()
@@ -129,97 +157,216 @@ func SystemInfoWS(c *gin.Context) {
 						break
 					}
 				}
-				if len(nodeConns[node.ID]) == 0 {
+				roleLeft := true
+				for _, o := range nodeConns[node.ID] {
+					if o.role == nc.role {
+						roleLeft = false
+					}
+				}
+				offline := len(nodeConns[node.ID]) == 0
+				if offline {
 					delete(nodeConns, node.ID)
-					s := 0
-					node.Status = &s
-					_ = dbpkg.DB.Save(&node).Error
 				}
-				offline := (len(nodeConns[node.ID]) == 0)
 				nodeConnMu.Unlock()
-				if offline {
+				if roleLeft {
+					releaseNodeRoute(node.ID, nc.role)
+				}
+				// closed by our own shutdown: the agent reconnects, no offline status or alert.
+				// Neither when the agent already reconnected to another replica (failover).
+				stopping := lifecycle.Stopping()
+				if offline && !stopping && !connectedElsewhere(node.ID) {
+					_ = dbpkg.DB.Model(&model.Node{}).Where("id = ?", node.ID).Update("status", 0).Error
 					broadcastToAdmins(map[string]interface{}{"id": node.ID, "type": "status", "data": 0})
 					// create disconnect log
 					now := time.Now().UnixMilli()
+					ev := NodeNotifyEvent("agent_offline", node, "节点离线")
+					ev.DownAt = time.UnixMilli(now)
+					// duration of the online period that just ended: since the last reconnect
+					var prev model.NodeDisconnectLog
+					if err := dbpkg.DB.Where("node_id = ? AND up_at_ms IS NOT NULL", node.ID).Order("down_at_ms desc").First(&prev).Error; err == nil && *prev.UpAtMs < now {
+						ev.UpAt = time.UnixMilli(*prev.UpAtMs)
+						ev.Duration = time.Duration(now-*prev.UpAtMs) * time.Millisecond
+					}
 					rec := model.NodeDisconnectLog{NodeID: node.ID, DownAtMs: now}
 					_ = dbpkg.DB.Create(&rec).Error
-					go notifyCallback("agent_offline", node, map[string]any{"downAtMs": now})
-					// alert record
-					name := node.Name
-					nid := node.ID
-					_ = dbpkg.DB.Create(&model.Alert{TimeMs: now, Type: "offline", NodeID: &nid, NodeName: &name, Message: "节点离线"}).Error
+					scheduleOfflineAlert(node, ev)
 				}
-				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					log.Printf("ws closed: %v", err)
+				if !stopping && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					jlogAt(slog.LevelWarn, map[string]interface{}{"event": "node_ws_closed", "nodeId": node.ID, "error": err.Error()})
 				}
-				conn.Close()
+				nc.w.shutdown()
 				return
 			}
 			if mt != websocket.TextMessage && mt != websocket.BinaryMessage {
 				continue
 			}
-			// Try to parse as command reply first
-			var generic map[string]interface{}
-			if err := json.Unmarshal(msg, &generic); err == nil {
-				if t, ok := generic["type"].(string); ok && (t == "DiagnoseResult" || t == "QueryServicesResult") {
-					if reqID, ok := generic["requestId"].(string); ok {
-						diagMu.Lock()
-						ch := diagWaiters[reqID]
-						delete(diagWaiters, reqID)
-						diagMu.Unlock()
-						if ch != nil {
-							// pass full payload data back
-							select {
-							case ch <- generic:
-							default:
-							}
-							close(ch)
-							continue
-						}
-					}
+			env, derr := wsproto.Decode(msg)
+			if derr != nil && derr != wsproto.ErrNoType {
+				jlogAt(slog.LevelWarn, map[string]interface{}{"event": "node_non_json", "nodeId": node.ID, "len": len(msg)})
+				continue
+			}
+			switch env.Type {
+			case wsproto.TypeDiagnoseResult, wsproto.TypeQueryServicesResult, wsproto.TypeQueryConnsResult, wsproto.TypeLogChunk, wsproto.TypeError:
+				if env.Type == wsproto.TypeError {
+					jlogAt(slog.LevelWarn, map[string]interface{}{"event": "node_error", "nodeId": node.ID, "requestId": env.RequestID, "payload": string(env.Data)})
+				}
+				if env.RequestID == "" {
+					continue
+				}
+				// pass full payload back: {type, requestId, data}
+				var generic map[string]interface{}
+				_ = json.Unmarshal(msg, &generic)
+				// a LogChunk stream keeps its remote route until the last frame
+				final := true
+				if env.Type == wsproto.TypeLogChunk {
+					var ch wsproto.LogChunk
+					_ = env.DecodeData(&ch)
+					final = ch.Done
+				}
+				if !pending.deliver(env.RequestID, generic) && !routeReplyRemote(env.RequestID, generic, final) {
+					jlogAt(slog.LevelWarn, map[string]interface{}{"event": "node_reply_unmatched", "nodeId": node.ID, "type": env.Type, "requestId": env.RequestID})
+				}
+			case wsproto.TypeCapabilities:
+				var caps wsproto.Capabilities
+				if err := env.DecodeData(&caps); err != nil {
+					continue
+				}
+				nodeConnMu.Lock()
+				nc.caps = &caps
+				if caps.Agent != "" {
+					nc.ver = caps.Agent
+				}
+				nodeConnMu.Unlock()
+				jlog(map[string]interface{}{"event": "node_capabilities", "nodeId": node.ID, "agent": caps.Agent, "version": caps.Version, "commands": caps.Commands, "features": caps.Features})
+			case wsproto.TypeSysInfo, "":
+				// typed SysInfo frame, or legacy raw/encrypted sysinfo object
+				raw := msg
+				if env.Type == wsproto.TypeSysInfo {
+					raw = env.Data
+				}
+				payload := parseNodeSystemInfo(node.Secret, raw)
+				if payload != nil {
+					// store into DB for long-term charts
+					storeSysInfoSample(node.ID, payload)
+					broadcastNodeInfo(node.ID, payload)
 				} else {
-					// Other JSON payload received (debug)
-					jlog(map[string]interface{}{"event": "node_unknown_json", "nodeId": node.ID, "payload": string(msg)})
+					jlogAt(slog.LevelWarn, map[string]interface{}{"event": "node_non_json", "nodeId": node.ID, "len": len(msg)})
 				}
-			}
-			// Else treat as system info payload
-			payload := parseNodeSystemInfo(node.Secret, msg)
-			if payload != nil {
-				// store into DB for long-term charts
-				storeSysInfoSample(node.ID, payload)
-				broadcastToAdmins(map[string]interface{}{"id": node.ID, "type": "info", "data": payload})
-			} else {
-				jlog(map[string]interface{}{"event": "node_non_json", "nodeId": node.ID, "len": len(msg)})
+			default:
+				jlogAt(slog.LevelDebug, map[string]interface{}{"event": "node_unknown_json", "nodeId": node.ID, "payload": string(msg)})
 			}
 		}
 	} else {
 		// unknown node; just close
-		jlog(map[string]interface{}{"event": "node_rejected", "remote": c.Request.RemoteAddr, "secret": maskSecret(secret)})
+		jlogAt(slog.LevelWarn, map[string]interface{}{"event": "node_rejected", "remote": c.Request.RemoteAddr, "secret": maskSecret(secret)})
 		conn.Close()
 	}
 }
 
 // sendWSCommand sends a command to a node by ID: {type: ..., data: ...}
+// A "requestId" inside map data is lifted into the frame for reply correlation.
 func sendWSCommand(nodeID int64, cmdType string, data interface{}) error {
+	reqID := ""
+	if m, ok := data.(map[string]interface{}); ok {
+		reqID, _ = m["requestId"].(string)
+	}
+	return sendNodeFrame(nodeID, cmdType, reqID, data)
+}
+
+// sendNodeFrame queues a command frame on the node's connection(s), routing it over the
+// bus to the replicas holding the node's other connections. A request (Diagnose or a
+// requestId) goes to one connection: a local one, else the replica holding the "agent"
+// role. Service mutations go to every connection, local and remote.
+func sendNodeFrame(nodeID int64, cmdType string, reqID string, data interface{}) error {
+	nodeConnMu.RLock()
+	local := len(nodeConns[nodeID]) > 0
+	nodeConnMu.RUnlock()
+	var err error
+	if cmdType == wsproto.TypeDiagnose || reqID != "" {
+		if local {
+			err = sendLocalFrame(nodeID, cmdType, reqID, data)
+		} else {
+			err = forwardRequest(nodeID, cmdType, reqID, data)
+		}
+	} else {
+		err = broadcastNodeFrame(nodeID, local, cmdType, data)
+	}
+	wsCommands.Inc(cmdType)
+	if err != nil {
+		wsCommandErrors.Inc(cmdType)
+	}
+	return err
+}
+
+// forwardRequest sends a request to the replica holding the node's "agent" connection, or
+// to any replica holding one of its connections
+func forwardRequest(nodeID int64, cmdType string, reqID string, data interface{}) error {
+	routes := nodeBus.NodeRoutes(nodeID)
+	if r, ok := routes["agent"]; ok && r != replicaID {
+		return forwardToReplica(r, nodeID, cmdType, reqID, data)
+	}
+	if rs := remoteReplicas(nodeID); len(rs) > 0 {
+		return forwardToReplica(rs[0], nodeID, cmdType, reqID, data)
+	}
+	return fmt.Errorf("node %d not connected", nodeID)
+}
+
+// broadcastNodeFrame sends a mutation to the local connections and to every other replica
+// holding a connection of the node; it fails only when no connection took it
+func broadcastNodeFrame(nodeID int64, local bool, cmdType string, data interface{}) error {
+	var firstErr error
+	sent := false
+	if local {
+		if err := sendLocalFrame(nodeID, cmdType, "", data); err != nil {
+			firstErr = err
+		} else {
+			sent = true
+		}
+	}
+	for _, r := range remoteReplicas(nodeID) {
+		if err := forwardToReplica(r, nodeID, cmdType, "", data); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		return nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("node %d not connected", nodeID)
+	}
+	return firstErr
+}
+
+// sendLocalFrame writes to connections held by this replica only.
+func sendLocalFrame(nodeID int64, cmdType string, reqID string, data interface{}) error {
 	nodeConnMu.RLock()
 	list := append([]*nodeConn(nil), nodeConns[nodeID]...)
 	nodeConnMu.RUnlock()
 	if len(list) == 0 {
 		return fmt.Errorf("node %d not connected", nodeID)
 	}
-	msg := make(map[string]interface{})
-	kind := reflect.TypeOf(data).Kind()
-	if kind != reflect.String && kind != reflect.Slice {
-		dataBytes, _ := json.Marshal(data)
-		msg = map[string]interface{}{"type": cmdType, "data": json.RawMessage(dataBytes)}
-		jlog(map[string]interface{}{"event": "测试输出", "msg": msg})
-	} else {
-		msg = map[string]interface{}{"type": cmdType, "data": data}
+	// keep only connections whose agent handles this command
+	capable := list[:0:0]
+	for _, nc := range list {
+		if nc != nil && nc.w != nil && connSupports(nc, cmdType) {
+			capable = append(capable, nc)
+		}
+	}
+	if len(capable) == 0 {
+		return fmt.Errorf("node %d agent does not support %s", nodeID, cmdType)
+	}
+	list = capable
+	b, err := wsproto.Encode(cmdType, reqID, data)
+	if err != nil {
+		return err
 	}
-	b, _ := json.Marshal(msg)
 
-	// Diagnose: target only agent (or any single fallback)
-	if cmdType == "Diagnose" {
+	// Diagnose and other request/response commands: target only agent (or any single fallback)
+	if cmdType == wsproto.TypeDiagnose || reqID != "" {
 		var target *nodeConn
 		for i := range list {
 			if list[i].ver != "" && strings.Contains(list[i].ver, "agent") {
@@ -230,25 +377,22 @@ func sendWSCommand(nodeID int64, cmdType string, data interface{}) error {
 		if target == nil {
 			target = list[len(list)-1]
 		}
-		jlog(map[string]interface{}{"event": "ws_send", "cmd": cmdType, "nodeId": nodeID, "version": target.ver, "payload": string(b)})
-		return target.c.WriteMessage(websocket.TextMessage, b)
+		jlogAt(slog.LevelDebug, map[string]interface{}{"event": "ws_send", "cmd": cmdType, "nodeId": nodeID, "version": target.ver, "payload": string(b)})
+		return target.w.send(b, wsEnqueueTimeout)
 	}
 
 	// Service mutations: broadcast to all connections for reliability
 	var writeErr error
 	okCount := 0
 	for _, nc := range list {
-		if nc == nil || nc.c == nil {
-			continue
-		}
-		if err := nc.c.WriteMessage(websocket.TextMessage, b); err != nil {
+		if err := nc.w.send(b, wsEnqueueTimeout); err != nil {
 			writeErr = err
-			jlog(map[string]interface{}{"event": "ws_send_err", "cmd": cmdType, "nodeId": nodeID, "version": nc.ver, "error": err.Error()})
+			jlogAt(slog.LevelError, map[string]interface{}{"event": "ws_send_err", "cmd": cmdType, "nodeId": nodeID, "version": nc.ver, "error": err.Error()})
 			continue
 		}
 		okCount++
 		// include payload for debugging unknown_msg at agent side
-		jlog(map[string]interface{}{"event": "ws_send", "cmd": cmdType, "nodeId": nodeID, "version": nc.ver, "payload": string(b)})
+		jlogAt(slog.LevelDebug, map[string]interface{}{"event": "ws_send", "cmd": cmdType, "nodeId": nodeID, "version": nc.ver, "payload": string(b)})
 	}
 	if okCount == 0 && writeErr != nil {
 		return writeErr
@@ -256,100 +400,88 @@ func sendWSCommand(nodeID int64, cmdType string, data interface{}) error {
 	return nil
 }
 
-// notifyCallback sends a simple callback to configured URL on events (GET or POST)
-func notifyCallback(event string, node model.Node, extra map[string]any) {
-	// read from vite_config
-	var urlC, methodC, hdrC, bodyTpl model.ViteConfig
-	dbpkg.DB.Where("name = ?", "callback_url").First(&urlC)
-	if urlC.Value == "" {
-		return
+// connSupports checks a connection's announced commands (legacy agents: known legacy set)
+func connSupports(nc *nodeConn, cmdType string) bool {
+	nodeConnMu.RLock()
+	caps := nc.caps
+	nodeConnMu.RUnlock()
+	if caps == nil {
+		for _, c := range wsproto.LegacyCommands {
+			if c == cmdType {
+				return true
+			}
+		}
+		return false
 	}
-	dbpkg.DB.Where("name = ?", "callback_method").First(&methodC)
-	dbpkg.DB.Where("name = ?", "callback_headers").First(&hdrC)
-	dbpkg.DB.Where("name = ?", "callback_template").First(&bodyTpl)
+	return caps.Supports(cmdType)
+}
 
-	method := strings.ToUpper(methodC.Value)
-	if method != "GET" && method != "POST" {
-		method = "POST"
+// POST /api/v1/node/capabilities {nodeId}
+// Connections held by other replicas are fetched over the bus; a replica that does not
+// answer is left out.
+func NodeCapabilities(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId" binding:"required"`
 	}
-	headers := map[string]string{}
-	if hdrC.Value != "" {
-		var m map[string]string
-		if json.Unmarshal([]byte(hdrC.Value), &m) == nil {
-			headers = m
-		}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
 	}
-	payload := map[string]any{"event": event, "nodeId": node.ID, "name": node.Name, "time": time.Now().UnixMilli()}
-	for k, v := range extra {
-		payload[k] = v
+	out := localCapabilities(p.NodeID)
+	for _, r := range remoteReplicas(p.NodeID) {
+		var remote []map[string]any
+		if err := queryReplica(r, "caps", p.NodeID, &remote); err != nil {
+			jlogAt(slog.LevelWarn, map[string]interface{}{"event": "bus_query_err", "replica": r, "query": "caps", "nodeId": p.NodeID, "error": err.Error()})
+			continue
+		}
+		out = append(out, remote...)
 	}
-	b, _ := json.Marshal(payload)
+	c.JSON(http.StatusOK, response.Ok(out))
+}
 
-	// apply template helpers
-	apply := func(s string) string {
-		if s == "" {
-			return s
-		}
-		out := s
-		repl := map[string]string{
-			"{event}":  event,
-			"{nodeId}": fmt.Sprintf("%d", node.ID),
-			"{name}":   node.Name,
-			"{time}":   fmt.Sprintf("%d", payload["time"]),
-		}
-		if v, ok := extra["downAtMs"]; ok {
-			repl["{downAt}"] = fmt.Sprintf("%v", v)
-		}
-		if v, ok := extra["upAtMs"]; ok {
-			repl["{upAt}"] = fmt.Sprintf("%v", v)
-		}
-		if v, ok := extra["durationS"]; ok {
-			repl["{duration}"] = fmt.Sprintf("%v", v)
-		}
-		for k, v := range repl {
-			out = strings.ReplaceAll(out, k, v)
-		}
-		return out
-	}
-
-	go func() {
-		client := &http.Client{Timeout: 5 * time.Second}
-		u := urlC.Value
-		// If template provided, apply to query/body
-		if bodyTpl.Value != "" {
-			t := apply(bodyTpl.Value)
-			if method == "GET" {
-				if strings.Contains(u, "?") {
-					u = u + "&" + t
-				} else {
-					u = u + "?" + t
-				}
-			} else {
-				b = []byte(t)
-			}
-		}
-		if method == "GET" {
-			req, _ := http.NewRequest("GET", u, nil)
-			for k, v := range headers {
-				req.Header.Set(k, v)
-			}
-			_, _ = client.Do(req)
-			return
-		}
-		req, _ := http.NewRequest("POST", u, strings.NewReader(string(b)))
-		for k, v := range headers {
-			req.Header.Set(k, v)
-		}
-		if req.Header.Get("Content-Type") == "" {
-			req.Header.Set("Content-Type", "application/json")
+// localCapabilities lists the announced commands of the node's connections on this replica
+func localCapabilities(nodeID int64) []map[string]any {
+	out := make([]map[string]any, 0)
+	nodeConnMu.RLock()
+	for _, nc := range nodeConns[nodeID] {
+		item := map[string]any{"version": nc.ver, "role": nc.role, "replica": replicaID, "legacy": nc.caps == nil}
+		if nc.caps != nil {
+			item["protocol"] = nc.caps.Version
+			item["commands"] = nc.caps.Commands
+			item["features"] = nc.caps.Features
+		} else {
+			item["protocol"] = 0
+			item["commands"] = wsproto.LegacyCommands
+			item["features"] = []string{}
 		}
-		_, _ = client.Do(req)
-	}()
+		out = append(out, item)
+	}
+	nodeConnMu.RUnlock()
+	return out
+}
+
+// isNodeConnected reports whether at least one agent connection is open for the node,
+// on this replica or on another one
+func isNodeConnected(nodeID int64) bool {
+	nodeConnMu.RLock()
+	local := len(nodeConns[nodeID]) > 0
+	nodeConnMu.RUnlock()
+	return local || connectedElsewhere(nodeID)
+}
+
+// connectedElsewhere reports whether another replica holds a connection of the node
+func connectedElsewhere(nodeID int64) bool {
+	return len(remoteReplicas(nodeID)) > 0
+}
+
+// notifyCallback queues a node event for the notification channels
+func notifyCallback(ev NotifyEvent) {
+	notify(ev)
 }
 
 // TriggerCallback exposes the callback hook to other packages (e.g., scheduler)
-func TriggerCallback(event string, node model.Node, extra map[string]any) {
-	notifyCallback(event, node, extra)
+func TriggerCallback(ev NotifyEvent) {
+	notifyCallback(ev)
 }
 
 // (no-op helpers removed)
@@ -357,32 +489,35 @@ func TriggerCallback(event string, node model.Node, extra map[string]any) {
 // RequestDiagnose sends a Diagnose command to a node and waits for a reply with the same requestId.
 // Returns the parsed result map and a boolean indicating if it was received in time.
 func RequestDiagnose(nodeID int64, payload map[string]interface{}, timeout time.Duration) (map[string]interface{}, bool) {
-	reqID := payload["requestId"].(string)
-	ch := make(chan map[string]interface{}, 1)
-	diagMu.Lock()
-	diagWaiters[reqID] = ch
-	diagMu.Unlock()
-	// wait
-	select {
-	case res := <-ch:
-		b, _ := json.Marshal(res)
-		jlog(map[string]interface{}{"event": "diagnose_recv", "nodeId": nodeID, "payload": string(b)})
-		return res, true
-	case <-time.After(timeout):
-		diagMu.Lock()
-		delete(diagWaiters, reqID)
-		diagMu.Unlock()
-		jlog(map[string]interface{}{"event": "diagnose_timeout", "nodeId": nodeID, "reqId": reqID, "timeoutMs": timeout.Milliseconds()})
+	reqID, _ := payload["requestId"].(string)
+	if reqID == "" {
+		reqID = RandUUID()
+		payload["requestId"] = reqID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	res, err := nodeRequest(ctx, nodeID, wsproto.TypeDiagnose, reqID, payload)
+	if err != nil {
+		jlogAt(slog.LevelWarn, map[string]interface{}{"event": "diagnose_timeout", "nodeId": nodeID, "reqId": reqID, "timeoutMs": timeout.Milliseconds(), "error": err.Error()})
 		return nil, false
 	}
+	b, _ := json.Marshal(res)
+	jlogAt(slog.LevelDebug, map[string]interface{}{"event": "diagnose_recv", "nodeId": nodeID, "payload": string(b)})
+	return res, true
 }
 
-// broadcastToAdmins sends a JSON message to all admin monitor connections.
+// broadcastToAdmins sends a JSON message to all admin monitor connections (on every replica).
 func broadcastToAdmins(v interface{}) {
 	b, _ := json.Marshal(v)
+	broadcastToLocalAdmins(b)
+	publishAdmins(b)
+}
+
+func broadcastToLocalAdmins(b []byte) {
 	adminMu.RLock()
-	for c := range adminConns {
-		_ = c.WriteMessage(websocket.TextMessage, b)
+	for _, w := range adminConns {
+		// monitors are lossy: never block on a slow browser
+		_ = w.send(b, 0)
 	}
 	adminMu.RUnlock()
 }
@@ -443,6 +578,20 @@ func convertSysInfoJSON(b []byte) map[string]interface{} {
     } else if v, ok := in["interfaces"]; ok {
         out["interfaces"] = v
     }
+    // rates and per-interface counters (newer agents)
+    if v, ok := in["RxRate"]; ok {
+        out["rx_rate"] = v
+    }
+    if v, ok := in["TxRate"]; ok {
+        out["tx_rate"] = v
+    }
+    if v, ok := in["NetIfaces"]; ok {
+        out["net_ifaces"] = v
+    }
+    // host/gost health: keys already match model.NodeHealth
+    if v, ok := in["Health"]; ok {
+        out["health"] = v
+    }
     return out
 }
 
@@ -496,6 +645,30 @@ func storeSysInfoSample(nodeID int64, m map[string]interface{}) {
 		CPU:     toFloat(m["cpu_usage"]),
 		Mem:     toFloat(m["memory_usage"]),
 	}
+	_, agentRates := m["rx_rate"]
+	s.RxRate, s.TxRate = toFloat(m["rx_rate"]), toFloat(m["tx_rate"])
+	if list, ok := m["net_ifaces"].([]any); ok {
+		s.Ifaces = model.IfaceStats{}
+		for _, v := range list {
+			it, _ := v.(map[string]any)
+			name, _ := it["name"].(string)
+			if name == "" {
+				continue
+			}
+			s.Ifaces[name] = model.IfaceStat{
+				RxBytes: toInt64(it["rxBytes"]), TxBytes: toInt64(it["txBytes"]),
+				RxRate: toFloat(it["rxRate"]), TxRate: toFloat(it["txRate"]),
+			}
+		}
+	}
+	if h, ok := m["health"].(map[string]any); ok {
+		if b, err := json.Marshal(h); err == nil {
+			_ = json.Unmarshal(b, &s.NodeHealth)
+		}
+	}
+	fillSysInfoRates(&s, agentRates)
+	// the live monitor gets the same rates, also for agents that did not send them
+	m["rx_rate"], m["tx_rate"] = s.RxRate, s.TxRate
 	_ = dbpkg.DB.Create(&s).Error
 	// persist interfaces snapshot if provided
 	if ifs, ok := m["interfaces"]; ok && ifs != nil {
@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"network-panel/golang-backend/internal/app/bus"
+	"network-panel/golang-backend/internal/config"
+	dbpkg "network-panel/golang-backend/internal/db"
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// Multi-replica routing. Every replica claims the (node, agent role) pairs whose agents are
+// connected to it. sendNodeFrame for a connection held elsewhere publishes a "cmd" to the
+// owner's inbox; the owner remembers where the request came from and publishes the agent's
+// reply back as "reply". A "query" asks another replica for the state of its own
+// connections (capabilities, queue stats). Admin monitor messages are fanned out to all
+// replicas.
+
+var (
+	nodeBus   bus.Bus = bus.NewMemory()
+	replicaID         = defaultReplicaID()
+
+	remoteOriginMu sync.Mutex
+	remoteOrigins  = map[string]remoteOrigin{} // requestId -> replica waiting for the reply
+)
+
+type remoteOrigin struct {
+	replica string
+	at      time.Time
+}
+
+// busEnvelope is what replicas exchange over the bus
+type busEnvelope struct {
+	Kind      string          `json:"kind"` // cmd|reply|query|admin
+	From      string          `json:"from"`
+	NodeID    int64           `json:"nodeId,omitempty"`
+	Cmd       string          `json:"cmd,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+func defaultReplicaID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	if host == "" {
+		host = "panel"
+	}
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// ReplicaID names this panel instance on the bus and in scheduler job leases
+func ReplicaID() string { return replicaID }
+
+// InitBus selects the replica bus from config: bus.backend memory (default) | db,
+// bus.replicaId overrides the generated replica name, bus.pollMs sets the db poll interval.
+func InitBus() error {
+	if v := strings.TrimSpace(config.C.Bus.ReplicaID); v != "" {
+		replicaID = v
+	}
+	backend := strings.ToLower(strings.TrimSpace(config.C.Bus.Backend))
+	switch backend {
+	case "", "memory":
+		useBus(bus.NewMemory())
+	case "db", "database":
+		useBus(bus.NewDB(dbpkg.DB, replicaID, time.Duration(config.C.Bus.PollMs)*time.Millisecond))
+	default:
+		return fmt.Errorf("unknown PANEL_BUS %q", config.C.Bus.Backend)
+	}
+	jlog(map[string]interface{}{"event": "bus_init", "replica": replicaID, "backend": backend})
+	return nil
+}
+
+// CloseBus stops the bus on shutdown, after the node connections are closed
+func CloseBus() error {
+	if nodeBus == nil {
+		return nil
+	}
+	return nodeBus.Close()
+}
+
+// useBus installs the bus implementation and subscribes this replica's topics
+func useBus(b bus.Bus) {
+	if nodeBus != nil {
+		_ = nodeBus.Close()
+	}
+	nodeBus = b
+	b.Subscribe(bus.ReplicaTopic(replicaID), handleBusInbox)
+	b.Subscribe(bus.TopicAdmins, handleBusAdmins)
+}
+
+func publishBus(replica string, env busEnvelope) error {
+	env.From = replicaID
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return nodeBus.Publish(bus.ReplicaTopic(replica), b)
+}
+
+// forwardToReplica routes a command to the node's connections held by another replica
+func forwardToReplica(replica string, nodeID int64, cmdType string, reqID string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return publishBus(replica, busEnvelope{Kind: "cmd", NodeID: nodeID, Cmd: cmdType, RequestID: reqID, Data: raw})
+}
+
+// remoteReplicas lists the other replicas holding connections of the node
+func remoteReplicas(nodeID int64) []string {
+	seen := map[string]bool{replicaID: true}
+	out := []string{}
+	for _, r := range nodeBus.NodeRoutes(nodeID) {
+		if !seen[r] {
+			seen[r] = true
+			out = append(out, r)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// replicaQueryTimeout bounds a query to another replica: two bus hops plus a local lookup
+const replicaQueryTimeout = 5 * time.Second
+
+// queryReplica asks another replica for the state of its own connections (kind "caps" for
+// one node, "wsstats" for all) and decodes the answer into out
+func queryReplica(replica, kind string, nodeID int64, out interface{}) error {
+	reqID := RandUUID()
+	ch := pending.register(reqID)
+	defer pending.cancel(reqID)
+	if err := publishBus(replica, busEnvelope{Kind: "query", Cmd: kind, NodeID: nodeID, RequestID: reqID}); err != nil {
+		return err
+	}
+	t := time.NewTimer(replicaQueryTimeout)
+	defer t.Stop()
+	select {
+	case res := <-ch:
+		if err := replyError(res); err != nil {
+			return err
+		}
+		b, _ := json.Marshal(res["data"])
+		return json.Unmarshal(b, out)
+	case <-t.C:
+		return fmt.Errorf("replica %s did not answer", replica)
+	}
+}
+
+// answerQuery serves a query of another replica from the local connections
+func answerQuery(env busEnvelope) {
+	msg := map[string]interface{}{"type": "QueryAnswer", "requestId": env.RequestID}
+	switch env.Cmd {
+	case "caps":
+		msg["data"] = localCapabilities(env.NodeID)
+	case "wsstats":
+		msg["data"] = collectWSStats()
+	default:
+		msg["type"] = wsproto.TypeError
+		msg["data"] = map[string]interface{}{"code": wsproto.ErrCodeFailed, "message": "unknown query " + env.Cmd}
+	}
+	raw, _ := json.Marshal(msg)
+	_ = publishBus(env.From, busEnvelope{Kind: "reply", RequestID: env.RequestID, Data: raw})
+}
+
+// routeReplyRemote passes an agent reply to the replica that issued the request
+func routeReplyRemote(reqID string, msg map[string]interface{}, final bool) bool {
+	remoteOriginMu.Lock()
+	o, ok := remoteOrigins[reqID]
+	if final {
+		delete(remoteOrigins, reqID)
+	}
+	remoteOriginMu.Unlock()
+	if !ok {
+		return false
+	}
+	raw, _ := json.Marshal(msg)
+	return publishBus(o.replica, busEnvelope{Kind: "reply", RequestID: reqID, Data: raw}) == nil
+}
+
+func rememberOrigin(reqID, replica string) {
+	now := time.Now()
+	remoteOriginMu.Lock()
+	for k, v := range remoteOrigins {
+		if now.Sub(v.at) > 2*time.Minute {
+			delete(remoteOrigins, k)
+		}
+	}
+	remoteOrigins[reqID] = remoteOrigin{replica: replica, at: now}
+	remoteOriginMu.Unlock()
+}
+
+// adminInfoBusInterval limits how often a node's sysinfo frames are published to the other
+// replicas; local monitors still get every frame. With the DB bus every publish is a row.
+const adminInfoBusInterval = 10 * time.Second
+
+var (
+	adminInfoMu   sync.Mutex
+	adminInfoSent = map[int64]time.Time{}
+)
+
+// broadcastNodeInfo sends a node's sysinfo to the local monitors and, rate-limited, to the
+// monitors of the other replicas
+func broadcastNodeInfo(nodeID int64, payload map[string]interface{}) {
+	b, _ := json.Marshal(map[string]interface{}{"id": nodeID, "type": "info", "data": payload})
+	broadcastToLocalAdmins(b)
+	now := time.Now()
+	adminInfoMu.Lock()
+	due := now.Sub(adminInfoSent[nodeID]) >= adminInfoBusInterval
+	if due {
+		adminInfoSent[nodeID] = now
+	}
+	adminInfoMu.Unlock()
+	if due {
+		publishAdmins(b)
+	}
+}
+
+// publishAdmins fans an admin monitor message out to the other replicas
+func publishAdmins(b []byte) {
+	env, err := json.Marshal(busEnvelope{Kind: "admin", From: replicaID, Data: b})
+	if err != nil {
+		return
+	}
+	_ = nodeBus.Publish(bus.TopicAdmins, env)
+}
+
+func handleBusInbox(payload []byte) {
+	var env busEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return
+	}
+	switch env.Kind {
+	case "cmd":
+		var data interface{}
+		_ = json.Unmarshal(env.Data, &data)
+		if env.RequestID != "" {
+			rememberOrigin(env.RequestID, env.From)
+		}
+		if err := sendLocalFrame(env.NodeID, env.Cmd, env.RequestID, data); err != nil {
+			jlogAt(slog.LevelError, map[string]interface{}{"event": "bus_cmd_err", "nodeId": env.NodeID, "cmd": env.Cmd, "from": env.From, "error": err.Error()})
+			if env.RequestID != "" {
+				// wake the remote waiter instead of letting it time out
+				routeReplyRemote(env.RequestID, map[string]interface{}{
+					"type": wsproto.TypeError, "requestId": env.RequestID,
+					"data": map[string]interface{}{"code": wsproto.ErrCodeFailed, "message": err.Error(), "refType": env.Cmd},
+				}, true)
+			}
+		}
+	case "query":
+		answerQuery(env)
+	case "reply":
+		var msg map[string]interface{}
+		if err := json.Unmarshal(env.Data, &msg); err == nil {
+			pending.deliver(env.RequestID, msg)
+		}
+	}
+}
+
+func handleBusAdmins(payload []byte) {
+	var env busEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil || env.From == replicaID {
+		return
+	}
+	broadcastToLocalAdmins(env.Data)
+}
+
+// claimNodeRoute / releaseNodeRoute keep the bus route of a node's agent role in sync with
+// local connections
+func claimNodeRoute(nodeID int64, role string) {
+	if err := nodeBus.ClaimNode(nodeID, role, replicaID); err != nil {
+		jlogAt(slog.LevelError, map[string]interface{}{"event": "bus_claim_err", "nodeId": nodeID, "role": role, "error": err.Error()})
+	}
+}
+
+func releaseNodeRoute(nodeID int64, role string) {
+	_ = nodeBus.ReleaseNode(nodeID, role, replicaID)
+}
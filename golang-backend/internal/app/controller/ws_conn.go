@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"network-panel/golang-backend/internal/app/response"
+	"network-panel/golang-backend/internal/wsproto"
+)
+
+// gorilla/websocket allows only one concurrent writer per connection, so every
+// connection (node or admin) gets a wsWriter: a goroutine draining a bounded queue.
+// Senders never touch the *websocket.Conn directly.
+
+const (
+	wsSendQueueSize  = 256
+	wsEnqueueTimeout = 2 * time.Second  // how long a command may wait for queue space
+	wsWriteTimeout   = 10 * time.Second // per frame write deadline
+)
+
+var (
+	errWSQueueFull = errors.New("websocket send queue full")
+	errWSClosed    = errors.New("websocket connection closed")
+)
+
+type wsFrame struct {
+	mt   int
+	data []byte
+}
+
+type wsWriter struct {
+	c     *websocket.Conn
+	queue chan wsFrame
+	done  chan struct{}
+	once  sync.Once
+
+	// backpressure metrics
+	sent    atomic.Int64
+	dropped atomic.Int64 // frames rejected because the queue stayed full
+	blocked atomic.Int64 // enqueues that had to wait for space
+	failed  atomic.Int64 // write errors (connection is closed afterwards)
+	peak    atomic.Int64 // highest observed queue depth
+}
+
+func newWSWriter(c *websocket.Conn) *wsWriter {
+	w := &wsWriter{c: c, queue: make(chan wsFrame, wsSendQueueSize), done: make(chan struct{})}
+	go w.loop()
+	return w
+}
+
+func (w *wsWriter) loop() {
+	for {
+		select {
+		case f := <-w.queue:
+			_ = w.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := w.c.WriteMessage(f.mt, f.data); err != nil {
+				w.failed.Add(1)
+				w.shutdown()
+				return
+			}
+			w.sent.Add(1)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// send queues a text frame. When the queue is full it waits up to wait (0 = drop at once).
+func (w *wsWriter) send(b []byte, wait time.Duration) error {
+	f := wsFrame{mt: websocket.TextMessage, data: b}
+	select {
+	case <-w.done:
+		return errWSClosed
+	case w.queue <- f:
+		w.notePeak()
+		return nil
+	default:
+	}
+	if wait <= 0 {
+		w.dropped.Add(1)
+		return errWSQueueFull
+	}
+	w.blocked.Add(1)
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case w.queue <- f:
+		w.notePeak()
+		return nil
+	case <-w.done:
+		return errWSClosed
+	case <-t.C:
+		w.dropped.Add(1)
+		return errWSQueueFull
+	}
+}
+
+func (w *wsWriter) notePeak() {
+	d := int64(len(w.queue))
+	for {
+		p := w.peak.Load()
+		if d <= p || w.peak.CompareAndSwap(p, d) {
+			return
+		}
+	}
+}
+
+// shutdown stops the writer and closes the connection (the read loop then exits)
+func (w *wsWriter) shutdown() {
+	w.once.Do(func() {
+		close(w.done)
+		_ = w.c.Close()
+	})
+}
+
+// closeWith sends a close frame (best effort, bypassing the queue) before shutdown so
+// the peer sees why the connection ended instead of a dropped socket
+func (w *wsWriter) closeWith(code int, reason string) {
+	_ = w.c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	w.shutdown()
+}
+
+// CloseConnections ends every node and admin websocket with a "service restart" close
+// frame, so agents reconnect right away (to this server once it is back, or another
+// replica), and wakes requests still waiting for an agent reply.
+func CloseConnections() {
+	var ws []*wsWriter
+	nodeConnMu.RLock()
+	for _, list := range nodeConns {
+		for _, nc := range list {
+			ws = append(ws, nc.w)
+		}
+	}
+	nodeConnMu.RUnlock()
+	adminMu.RLock()
+	for _, w := range adminConns {
+		ws = append(ws, w)
+	}
+	adminMu.RUnlock()
+	for _, w := range ws {
+		w.closeWith(websocket.CloseServiceRestart, "panel restarting")
+	}
+	n := pending.failAll("panel shutting down")
+	jlog(map[string]interface{}{"event": "ws_closed_all", "conns": len(ws), "waiters": n})
+}
+
+type wsConnStats struct {
+	Kind     string `json:"kind"` // node|admin
+	NodeID   int64  `json:"nodeId,omitempty"`
+	Role     string `json:"role,omitempty"`    // agent|agent2 of a node connection
+	Replica  string `json:"replica,omitempty"` // panel replica holding the connection
+	Version  string `json:"version,omitempty"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Peak     int64  `json:"peak"`
+	Sent     int64  `json:"sent"`
+	Dropped  int64  `json:"dropped"`
+	Blocked  int64  `json:"blocked"`
+	Failed   int64  `json:"failed"`
+}
+
+func (w *wsWriter) stats() wsConnStats {
+	return wsConnStats{
+		Queued:   len(w.queue),
+		Capacity: cap(w.queue),
+		Peak:     w.peak.Load(),
+		Sent:     w.sent.Load(),
+		Dropped:  w.dropped.Load(),
+		Blocked:  w.blocked.Load(),
+		Failed:   w.failed.Load(),
+	}
+}
+
+// collectWSStats snapshots writer metrics of all node and admin connections of this replica
+func collectWSStats() []wsConnStats {
+	out := make([]wsConnStats, 0)
+	nodeConnMu.RLock()
+	for nid, list := range nodeConns {
+		for _, nc := range list {
+			s := nc.w.stats()
+			s.Kind, s.NodeID, s.Role, s.Version = "node", nid, nc.role, nc.ver
+			out = append(out, s)
+		}
+	}
+	nodeConnMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	adminMu.RLock()
+	for _, w := range adminConns {
+		s := w.stats()
+		s.Kind = "admin"
+		out = append(out, s)
+	}
+	adminMu.RUnlock()
+	for i := range out {
+		out[i].Replica = replicaID
+	}
+	return out
+}
+
+// POST /api/v1/node/ws-stats
+// Connections of the other replicas are fetched over the bus; a replica that does not
+// answer is left out.
+func NodeWSStats(c *gin.Context) {
+	out := collectWSStats()
+	for _, r := range nodeBus.Replicas() {
+		if r == replicaID {
+			continue
+		}
+		var remote []wsConnStats
+		if err := queryReplica(r, "wsstats", 0, &remote); err != nil {
+			jlogAt(slog.LevelWarn, map[string]interface{}{"event": "bus_query_err", "replica": r, "query": "wsstats", "error": err.Error()})
+			continue
+		}
+		out = append(out, remote...)
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// ---- request/response correlation ----
+
+// correlator matches agent replies (DiagnoseResult, QueryServicesResult, Error) to the
+// waiting request by requestId. Stream waiters (LogChunk) stay registered for several
+// replies until cancelled.
+type correlator struct {
+	mu      sync.Mutex
+	waiters map[string]chan map[string]interface{}
+	streams map[string]bool
+}
+
+var pending = &correlator{waiters: map[string]chan map[string]interface{}{}, streams: map[string]bool{}}
+
+func (p *correlator) register(id string) <-chan map[string]interface{} {
+	ch := make(chan map[string]interface{}, 1)
+	p.mu.Lock()
+	p.waiters[id] = ch
+	p.mu.Unlock()
+	return ch
+}
+
+// registerStream is register for a multi-frame reply; size bounds the frames buffered
+// while the waiter is busy
+func (p *correlator) registerStream(id string, size int) <-chan map[string]interface{} {
+	ch := make(chan map[string]interface{}, size)
+	p.mu.Lock()
+	p.waiters[id] = ch
+	p.streams[id] = true
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *correlator) cancel(id string) {
+	p.mu.Lock()
+	delete(p.waiters, id)
+	delete(p.streams, id)
+	p.mu.Unlock()
+}
+
+// deliver hands a reply to its waiter; false if nobody is waiting (late or unknown reply).
+// A frame for a stream whose buffer is full is dropped, the waiter sees the seq gap.
+func (p *correlator) deliver(id string, msg map[string]interface{}) bool {
+	p.mu.Lock()
+	ch := p.waiters[id]
+	stream := p.streams[id]
+	if !stream {
+		delete(p.waiters, id)
+	}
+	p.mu.Unlock()
+	if ch == nil {
+		return false
+	}
+	if !stream {
+		ch <- msg
+		return true
+	}
+	select {
+	case ch <- msg:
+	default:
+	}
+	return true
+}
+
+// failAll answers every waiter with an Error reply; returns how many were waiting
+func (p *correlator) failAll(msg string) int {
+	p.mu.Lock()
+	waiters := p.waiters
+	p.waiters = map[string]chan map[string]interface{}{}
+	p.streams = map[string]bool{}
+	p.mu.Unlock()
+	for id, ch := range waiters {
+		select {
+		case ch <- map[string]interface{}{"type": wsproto.TypeError, "requestId": id, "data": map[string]interface{}{"code": wsproto.ErrCodeFailed, "message": msg}}:
+		default:
+		}
+	}
+	return len(waiters)
+}
+
+// nodeRequest sends a command tagged with reqID and waits for the matching reply until
+// ctx is done. The waiter is registered before sending so fast replies are never lost.
+func nodeRequest(ctx context.Context, nodeID int64, cmdType string, reqID string, data interface{}) (map[string]interface{}, error) {
+	ch := pending.register(reqID)
+	defer pending.cancel(reqID)
+	if err := sendNodeFrame(nodeID, cmdType, reqID, data); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		if err := replyError(res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// nodeReplyError is an Error frame received for a request: sent by the agent, by failAll on
+// shutdown, or by the replica bus when the owning replica cannot reach the node
+type nodeReplyError struct {
+	Code    string
+	Message string
+}
+
+func (e *nodeReplyError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// replyError returns the error carried by an Error frame, nil for any other reply
+func replyError(res map[string]interface{}) error {
+	if res["type"] != wsproto.TypeError {
+		return nil
+	}
+	data, _ := res["data"].(map[string]interface{})
+	e := &nodeReplyError{}
+	e.Code, _ = data["code"].(string)
+	e.Message, _ = data["message"].(string)
+	if e.Message == "" {
+		e.Message = "node error"
+	}
+	return e
+}
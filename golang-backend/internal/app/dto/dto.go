@@ -16,6 +16,7 @@ type UserDto struct {
     ExpTime       int64  `json:"expTime"`
     FlowResetTime int64  `json:"flowResetTime"`
     Status        *int   `json:"status"`
+    NotifyContact string `json:"notifyContact"`
 }
 
 type UserUpdateDto struct {
@@ -27,6 +28,7 @@ type UserUpdateDto struct {
     ExpTime       *int64  `json:"expTime"`
     FlowResetTime *int64  `json:"flowResetTime"`
     Status        *int    `json:"status"`
+    NotifyContact *string `json:"notifyContact"`
 }
 
 type ChangePasswordDto struct {
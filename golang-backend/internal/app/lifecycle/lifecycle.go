@@ -0,0 +1,64 @@
+// Package lifecycle is the background context of the panel server. Work that outlives a
+// request (scheduler jobs, migrations, live websocket pushes) runs under Context, started
+// with Go, so a shutdown can cancel it and wait until it has finished its current step.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ctx, cancel = context.WithCancel(context.Background())
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	stopped  bool
+	stopping atomic.Bool
+)
+
+// Context is cancelled when the server stops
+func Context() context.Context { return ctx }
+
+// Stopping reports whether a shutdown has begun; node disconnects are then restarts, not
+// outages
+func Stopping() bool { return stopping.Load() }
+
+// BeginShutdown marks the server as stopping while requests still drain
+func BeginShutdown() { stopping.Store(true) }
+
+// Go runs fn in a goroutine Stop waits for; fn must return soon after its ctx is done.
+// After Stop fn is not run at all.
+func Go(fn func(ctx context.Context)) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stopped {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn(ctx)
+	}()
+}
+
+// Stop cancels Context and waits for the Go routines until wait is done
+func Stop(wait context.Context) error {
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	stopping.Store(true)
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-wait.Done():
+		return wait.Err()
+	}
+}
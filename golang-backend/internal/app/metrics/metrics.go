@@ -0,0 +1,235 @@
+// Package metrics is a small Prometheus text exposition registry: counters and histograms
+// updated in place, gauges computed at scrape time. It only writes the text format
+// (version 0.0.4), which is all /metrics needs.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is one metric family
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	regMu    sync.Mutex
+	registry []collector
+)
+
+func register(c collector) {
+	regMu.Lock()
+	registry = append(registry, c)
+	regMu.Unlock()
+}
+
+// Write renders every registered metric in registration order
+func Write(w io.Writer) {
+	regMu.Lock()
+	list := append([]collector(nil), registry...)
+	regMu.Unlock()
+	for _, c := range list {
+		c.write(w)
+	}
+}
+
+// DefBuckets are latency buckets in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type family struct {
+	name, help, typ string
+	labels          []string
+}
+
+func (f family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+}
+
+// key joins label values; \xff does not occur in them
+func key(values []string) string { return strings.Join(values, "\xff") }
+
+// labelPairs renders {a="x",b="y"} plus extra pairs (histogram le)
+func (f family) labelPairs(values []string, extra ...string) string {
+	var parts []string
+	for i, l := range f.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		parts = append(parts, l+`="`+escapeValue(v)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escapeValue(extra[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Counter is a monotonically increasing value per label set
+type Counter struct {
+	family
+	mu   sync.Mutex
+	vals map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	v      float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name, help, "counter", labels}, vals: map[string]*counterSeries{}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) { c.Add(1, labels...) }
+
+// Add adds v (negative values are ignored, counters only go up)
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		return
+	}
+	k := key(labels)
+	c.mu.Lock()
+	s := c.vals[k]
+	if s == nil {
+		s = &counterSeries{labels: append([]string(nil), labels...)}
+		c.vals[k] = s
+	}
+	s.v += v
+	c.mu.Unlock()
+}
+
+// Delete drops the series with exactly these label values, e.g. of a removed user, so
+// scrapes stop reporting it; false if there was none
+func (c *Counter) Delete(labels ...string) bool {
+	k := key(labels)
+	c.mu.Lock()
+	_, ok := c.vals[k]
+	delete(c.vals, k)
+	c.mu.Unlock()
+	return ok
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range sortedKeys(c.vals) {
+		s := c.vals[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.labels), formatFloat(s.v))
+	}
+}
+
+// Histogram counts observations into cumulative buckets per label set
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	vals    map[string]*histSeries
+}
+
+type histSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{family: family{name, help, "histogram", labels}, buckets: b, vals: map[string]*histSeries{}}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	k := key(labels)
+	h.mu.Lock()
+	s := h.vals[k]
+	if s == nil {
+		s = &histSeries{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.vals[k] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range sortedKeys(h.vals) {
+		s := h.vals[k]
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", formatFloat(b)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.labels), s.count)
+	}
+}
+
+// GaugeFunc reads its values at scrape time; collect calls emit once per label set
+type GaugeFunc struct {
+	family
+	collect func(emit func(v float64, labels ...string))
+}
+
+func NewGaugeFunc(name, help string, labels []string, collect func(emit func(v float64, labels ...string))) *GaugeFunc {
+	g := &GaugeFunc{family: family{name, help, "gauge", labels}, collect: collect}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	g.collect(func(v float64, labels ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(labels), formatFloat(v))
+	})
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
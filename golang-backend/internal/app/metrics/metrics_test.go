@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Collectors are built without register so tests do not grow the package registry.
+
+func render(c collector) string {
+	var b bytes.Buffer
+	c.write(&b)
+	return b.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	c := &Counter{family: family{"np_test_total", "Test counter.\nSecond line.", "counter", []string{"user_id", "direction"}}, vals: map[string]*counterSeries{}}
+	c.Add(3, "2", "out")
+	c.Inc("10", "in")
+	c.Add(-5, "10", "in") // counters never go down
+	c.Add(0.5, "2", "out")
+	want := `# HELP np_test_total Test counter.\nSecond line.
+# TYPE np_test_total counter
+np_test_total{user_id="10",direction="in"} 1
+np_test_total{user_id="2",direction="out"} 3.5
+`
+	if got := render(c); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if !c.Delete("2", "out") || c.Delete("2", "out") {
+		t.Fatal("Delete must report whether the series existed")
+	}
+	if got := render(c); strings.Contains(got, `user_id="2"`) {
+		t.Fatalf("deleted series still exposed:\n%s", got)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := &Counter{family: family{"np_esc_total", "h", "counter", []string{"node"}}, vals: map[string]*counterSeries{}}
+	c.Inc("a\"b\\c\nd")
+	want := `np_esc_total{node="a\"b\\c\nd"} 1`
+	if got := render(c); !strings.Contains(got, want+"\n") {
+		t.Fatalf("got\n%s\nwant line %s", got, want)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	h := &Histogram{family: family{"np_test_seconds", "Test histogram.", "histogram", []string{"op"}}, buckets: []float64{.1, 1}, vals: map[string]*histSeries{}}
+	h.Observe(.05, "query")
+	h.Observe(.1, "query") // an observation equal to a bound falls into that bucket
+	h.Observe(.5, "query")
+	h.Observe(7, "query")
+	want := `# HELP np_test_seconds Test histogram.
+# TYPE np_test_seconds histogram
+np_test_seconds_bucket{op="query",le="0.1"} 2
+np_test_seconds_bucket{op="query",le="1"} 3
+np_test_seconds_bucket{op="query",le="+Inf"} 4
+np_test_seconds_sum{op="query"} 7.65
+np_test_seconds_count{op="query"} 4
+`
+	if got := render(h); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncExposition(t *testing.T) {
+	g := &GaugeFunc{family: family{"np_test_gauge", "Test gauge.", "gauge", nil}, collect: func(emit func(float64, ...string)) {
+		emit(math.Inf(1))
+	}}
+	want := "# HELP np_test_gauge Test gauge.\n# TYPE np_test_gauge gauge\nnp_test_gauge +Inf\n"
+	if got := render(g); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for v, want := range map[float64]string{0: "0", 1e21: "1e+21", 0.25: "0.25", math.Inf(-1): "-Inf", math.NaN(): "NaN"} {
+		if got := formatFloat(v); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+    "log/slog"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// AccessLog logs each request through slog instead of gin's text logger, so query
+// secrets (agent ?secret=, websocket ?token=) are redacted like every other log line.
+// Successful requests log at debug level, client errors at info, server errors at warn.
+func AccessLog() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        start := time.Now()
+        c.Next()
+        status := c.Writer.Status()
+        level := slog.LevelDebug
+        switch {
+        case status >= 500:
+            level = slog.LevelWarn
+        case status >= 400:
+            level = slog.LevelInfo
+        }
+        path := c.Request.URL.Path
+        if q := c.Request.URL.RawQuery; q != "" {
+            path += "?" + q
+        }
+        slog.Log(c.Request.Context(), level, "http_request",
+            "method", c.Request.Method,
+            "path", path,
+            "status", status,
+            "ms", time.Since(start).Milliseconds(),
+            "ip", c.ClientIP(),
+        )
+    }
+}
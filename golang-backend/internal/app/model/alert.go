@@ -1,13 +1,46 @@
 package model
 
+// Alert is an incident: repeated events with the same key are grouped into one row
+// while it is open or acknowledged (Count/LastTimeMs track the repeats).
 type Alert struct {
     ID          int64  `gorm:"primaryKey;column:id" json:"id"`
     TimeMs      int64  `gorm:"column:time_ms" json:"timeMs"`
-    Type        string `gorm:"column:type" json:"type"` // offline, online, due
+    Type        string `gorm:"column:type" json:"type"` // offline, online, due, quota_warning, quota_exceeded, expiry_warning
     NodeID      *int64 `gorm:"column:node_id" json:"nodeId,omitempty"`
     NodeName    *string `gorm:"column:node_name" json:"nodeName,omitempty"`
+    UserID      *int64 `gorm:"column:user_id;index" json:"userId,omitempty"`
+    UserName    *string `gorm:"column:user_name" json:"userName,omitempty"`
     Message     string `gorm:"column:message" json:"message"`
+    // lifecycle; rows from before incidents existed read as resolved
+    Status         string `gorm:"column:status;size:16;index;default:resolved" json:"status"` // open, acknowledged, resolved
+    AlertKey       string `gorm:"column:alert_key;size:128;index" json:"alertKey"`           // e.g. node:3:offline
+    Count          int    `gorm:"column:count;default:1" json:"count"`
+    LastTimeMs     int64  `gorm:"column:last_time_ms" json:"lastTimeMs"`
+    AckBy          string `gorm:"column:ack_by;size:64" json:"ackBy,omitempty"`
+    AckTimeMs      *int64 `gorm:"column:ack_time_ms" json:"ackTimeMs,omitempty"`
+    ResolvedTimeMs *int64 `gorm:"column:resolved_time_ms" json:"resolvedTimeMs,omitempty"`
+    ResolvedBy     string `gorm:"column:resolved_by;size:64" json:"resolvedBy,omitempty"` // "auto" or admin name
 }
 
 func (Alert) TableName() string { return "alert" }
 
+// AlertRule: threshold on a node metric over WindowS seconds. It fires when every sample of
+// the window crossed Threshold and clears only once every sample is back across
+// ClearThreshold (hysteresis); probe loss compares the window's failure ratio.
+type AlertRule struct {
+    ID             int64    `gorm:"primaryKey;column:id" json:"id"`
+    Name           string   `gorm:"column:name;size:64" json:"name"`
+    Enabled        int      `gorm:"column:enabled" json:"enabled"`
+    Metric         string   `gorm:"column:metric;size:32" json:"metric"` // cpu, mem, probe_loss, probe_rtt
+    Op             string   `gorm:"column:op;size:4" json:"op"`         // > or <
+    Threshold      float64  `gorm:"column:threshold" json:"threshold"`
+    ClearThreshold *float64 `gorm:"column:clear_threshold" json:"clearThreshold,omitempty"` // nil: Threshold
+    WindowS        int      `gorm:"column:window_s" json:"windowS"`
+    MinSamples     int      `gorm:"column:min_samples" json:"minSamples"`
+    NodeIDs        string   `gorm:"column:node_ids;size:512" json:"nodeIds"` // comma separated, "" = all nodes
+    TargetID       int64    `gorm:"column:target_id" json:"targetId"`        // probe rules, 0 = every target
+    CreatedTime    int64    `gorm:"column:created_time" json:"createdTime"`
+    UpdatedTime    int64    `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (AlertRule) TableName() string { return "alert_rule" }
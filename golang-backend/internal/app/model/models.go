@@ -20,6 +20,7 @@ type User struct {
     OutFlow       int64  `gorm:"column:out_flow" json:"out_flow"`
     Num           int    `gorm:"column:num" json:"num"`
     FlowResetTime int64  `gorm:"column:flow_reset_time" json:"flow_reset_time"`
+    NotifyContact string `gorm:"column:notify_contact" json:"notifyContact"` // quota/expiry warnings: email, telegram chat id, ...
 }
 
 func (User) TableName() string { return "user" }
@@ -152,11 +153,11 @@ func (ProbeTarget) TableName() string { return "probe_target" }
 // NodeProbeResult: time series of ping results per node per target
 type NodeProbeResult struct {
     ID       int64 `gorm:"primaryKey;column:id" json:"id"`
-    NodeID   int64 `gorm:"column:node_id" json:"nodeId"`
+    NodeID   int64 `gorm:"column:node_id;index:idx_probe_node_time" json:"nodeId"`
     TargetID int64 `gorm:"column:target_id" json:"targetId"`
     RTTMs    int   `gorm:"column:rtt_ms" json:"rttMs"`
     OK       int   `gorm:"column:ok" json:"ok"` // 1 ok, 0 fail
-    TimeMs   int64 `gorm:"column:time_ms" json:"timeMs"`
+    TimeMs   int64 `gorm:"column:time_ms;index:idx_probe_node_time;index" json:"timeMs"`
 }
 func (NodeProbeResult) TableName() string { return "node_probe_result" }
 
@@ -173,16 +174,94 @@ func (NodeDisconnectLog) TableName() string { return "node_disconnect_log" }
 // NodeSysInfo stores periodic system info reported by agent for timeseries
 type NodeSysInfo struct {
     ID        int64   `gorm:"primaryKey;column:id" json:"id"`
-    NodeID    int64   `gorm:"column:node_id" json:"nodeId"`
-    TimeMs    int64   `gorm:"column:time_ms" json:"timeMs"`
+    NodeID    int64   `gorm:"column:node_id;index:idx_sysinfo_node_time" json:"nodeId"`
+    TimeMs    int64   `gorm:"column:time_ms;index:idx_sysinfo_node_time;index" json:"timeMs"`
     Uptime    int64   `gorm:"column:uptime" json:"uptime"`
     BytesRx   int64   `gorm:"column:bytes_rx" json:"bytesRx"`
     BytesTx   int64   `gorm:"column:bytes_tx" json:"bytesTx"`
     CPU       float64 `gorm:"column:cpu" json:"cpu"`
     Mem       float64 `gorm:"column:mem" json:"mem"`
+    RxRate    float64 `gorm:"column:rx_rate" json:"rxRate"` // bytes/s since the previous sample
+    TxRate    float64 `gorm:"column:tx_rate" json:"txRate"`
+    Ifaces    IfaceStats `gorm:"column:ifaces;type:text;serializer:json" json:"ifaces,omitempty"`
+    NodeHealth
 }
 func (NodeSysInfo) TableName() string { return "node_sysinfo" }
 
+// NodeHealth: host and gost process state, embedded in samples and rollups. In rollups load
+// and gost cpu are averages, memory, connection and handle counts the peak, the rest the last value.
+type NodeHealth struct {
+    Load1        float64   `gorm:"column:load1" json:"load1"`
+    Load5        float64   `gorm:"column:load5" json:"load5"`
+    Load15       float64   `gorm:"column:load15" json:"load15"`
+    DiskUsed     int64     `gorm:"column:disk_used" json:"diskUsed"` // bytes, partition of the gost config
+    DiskTotal    int64     `gorm:"column:disk_total" json:"diskTotal"`
+    TCP          TCPStates `gorm:"column:tcp;type:text;serializer:json" json:"tcp,omitempty"`
+    GostRSS      int64     `gorm:"column:gost_rss" json:"gostRss"` // bytes
+    GostCPU      float64   `gorm:"column:gost_cpu" json:"gostCpu"` // percent of one core
+    GostRestarts int       `gorm:"column:gost_restarts" json:"gostRestarts"`
+    GostFDs      int       `gorm:"column:gost_fds" json:"gostFds"`
+    GostFDLimit  int       `gorm:"column:gost_fd_limit" json:"gostFdLimit"`
+    FDsOpen      int64     `gorm:"column:fds_open" json:"fdsOpen"` // system wide
+    FDsMax       int64     `gorm:"column:fds_max" json:"fdsMax"`
+    Conntrack    int64     `gorm:"column:conntrack" json:"conntrack"`
+    ConntrackMax int64     `gorm:"column:conntrack_max" json:"conntrackMax"`
+}
+
+// TCPStates: tcp connections by state (established, time_wait, ...)
+type TCPStates map[string]int
+
+// IfaceStats: per network interface counters and rates of one sample or bucket
+type IfaceStats map[string]IfaceStat
+
+type IfaceStat struct {
+    RxBytes int64   `json:"rxBytes"` // cumulative counter (last in a bucket)
+    TxBytes int64   `json:"txBytes"`
+    RxRate  float64 `json:"rxRate"` // bytes/s (average in a bucket)
+    TxRate  float64 `json:"txRate"`
+    RxMax   float64 `json:"rxMax,omitempty"` // buckets: highest sample rate
+    TxMax   float64 `json:"txMax,omitempty"`
+}
+
+// NodeSysInfoRollup aggregates node_sysinfo per minute (Res "1m") or hour (Res "1h");
+// TimeMs is the bucket start, rates are bytes/s over the bucket
+type NodeSysInfoRollup struct {
+    ID       int64   `gorm:"primaryKey;column:id" json:"-"`
+    NodeID   int64   `gorm:"column:node_id;uniqueIndex:uk_sysinfo_rollup" json:"nodeId"`
+    Res      string  `gorm:"column:res;size:4;uniqueIndex:uk_sysinfo_rollup" json:"res"`
+    TimeMs   int64   `gorm:"column:time_ms;uniqueIndex:uk_sysinfo_rollup" json:"timeMs"`
+    Samples  int     `gorm:"column:samples" json:"samples"`
+    Uptime   int64   `gorm:"column:uptime" json:"uptime"`     // last
+    BytesRx  int64   `gorm:"column:bytes_rx" json:"bytesRx"` // last counter
+    BytesTx  int64   `gorm:"column:bytes_tx" json:"bytesTx"`
+    RxRate   float64 `gorm:"column:rx_rate" json:"rxRate"`
+    TxRate   float64 `gorm:"column:tx_rate" json:"txRate"`
+    CPU      float64 `gorm:"column:cpu" json:"cpu"` // avg
+    CPUMin   float64 `gorm:"column:cpu_min" json:"cpuMin"`
+    CPUMax   float64 `gorm:"column:cpu_max" json:"cpuMax"`
+    Mem      float64 `gorm:"column:mem" json:"mem"` // avg
+    MemMin   float64 `gorm:"column:mem_min" json:"memMin"`
+    MemMax   float64 `gorm:"column:mem_max" json:"memMax"`
+    Ifaces   IfaceStats `gorm:"column:ifaces;type:text;serializer:json" json:"ifaces,omitempty"`
+    NodeHealth
+}
+func (NodeSysInfoRollup) TableName() string { return "node_sysinfo_rollup" }
+
+// NodeProbeRollup aggregates node_probe_result per minute or hour and target
+type NodeProbeRollup struct {
+    ID       int64   `gorm:"primaryKey;column:id" json:"-"`
+    NodeID   int64   `gorm:"column:node_id;uniqueIndex:uk_probe_rollup" json:"nodeId"`
+    TargetID int64   `gorm:"column:target_id;uniqueIndex:uk_probe_rollup" json:"targetId"`
+    Res      string  `gorm:"column:res;size:4;uniqueIndex:uk_probe_rollup" json:"res"`
+    TimeMs   int64   `gorm:"column:time_ms;uniqueIndex:uk_probe_rollup" json:"timeMs"`
+    Samples  int     `gorm:"column:samples" json:"samples"`
+    OKCount  int     `gorm:"column:ok_count" json:"okCount"`
+    RTTMin   int     `gorm:"column:rtt_min" json:"rttMin"` // over successful probes
+    RTTAvg   float64 `gorm:"column:rtt_avg" json:"rttAvg"`
+    RTTMax   int     `gorm:"column:rtt_max" json:"rttMax"`
+}
+func (NodeProbeRollup) TableName() string { return "node_probe_rollup" }
+
 // NodeRuntime stores latest runtime metadata like interfaces list
 type NodeRuntime struct {
     NodeID      int64   `gorm:"primaryKey;column:node_id" json:"nodeId"`
@@ -190,3 +269,76 @@ type NodeRuntime struct {
     UpdatedTime int64   `gorm:"column:updated_time" json:"updatedTime"`
 }
 func (NodeRuntime) TableName() string { return "node_runtime" }
+
+// PortAllocation: persistent port registry per node; (node_id, port, protocol) is unique
+type PortAllocation struct {
+    ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+    NodeID      int64  `gorm:"column:node_id;uniqueIndex:uk_port_alloc" json:"nodeId"`
+    Port        int    `gorm:"column:port;uniqueIndex:uk_port_alloc" json:"port"`
+    Protocol    string `gorm:"column:protocol;size:8;uniqueIndex:uk_port_alloc" json:"protocol"`
+    ForwardID   *int64 `gorm:"column:forward_id;index" json:"forwardId,omitempty"`
+    Service     string `gorm:"column:service" json:"service"`
+    Source      string `gorm:"column:source;size:16" json:"source"` // panel, imported
+    CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+}
+func (PortAllocation) TableName() string { return "port_allocation" }
+
+// TunnelHop: one node of a tunnel with its per-node settings. "mid" rows are the relay path
+// between entry and exit in Inx order; "entry"/"exit" rows only carry settings. Foreign keys
+// keep tunnel_id/node_id valid: rows go with their tunnel, a node still named by a row can't
+// be deleted. They are added by ensureTunnelHopKeys, not by AutoMigrate, after existing
+// tables were cleaned up and their id columns widened.
+type TunnelHop struct {
+    ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+    TunnelID    int64  `gorm:"column:tunnel_id;uniqueIndex:uk_tunnel_hop" json:"tunnelId"`
+    Role        string `gorm:"column:role;size:8;uniqueIndex:uk_tunnel_hop" json:"role"` // entry, mid, exit
+    Inx         int    `gorm:"column:inx;uniqueIndex:uk_tunnel_hop" json:"inx"`
+    NodeID      int64  `gorm:"column:node_id;index" json:"nodeId"`
+    Interface   string `gorm:"column:interface;size:64" json:"interface"` // outgoing IP / interface
+    BindIP      string `gorm:"column:bind_ip;size:64" json:"bindIp"`      // listen IP (mids, exit)
+    Port        int    `gorm:"column:port" json:"port"`                   // preferred mid listen port, 0 = allocate
+    UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+
+    Tunnel *Tunnel `gorm:"foreignKey:TunnelID;constraint:OnDelete:CASCADE" json:"-"`
+    Node   *Node   `gorm:"foreignKey:NodeID;constraint:OnDelete:RESTRICT" json:"-"`
+}
+func (TunnelHop) TableName() string { return "tunnel_hop" }
+
+// BusMessage: cross-replica message queue used by the database bus backend
+type BusMessage struct {
+    ID        int64  `gorm:"primaryKey;column:id" json:"id"`
+    Topic     string `gorm:"column:topic;size:96;index" json:"topic"`
+    Payload   string `gorm:"column:payload;type:text" json:"payload"`
+    CreatedMs int64  `gorm:"column:created_ms;index" json:"createdMs"`
+}
+func (BusMessage) TableName() string { return "bus_message" }
+
+// BusNodeRoute: which panel replica currently holds a node's agent connection, per agent
+// role (agent / agent2 may be connected to different replicas)
+type BusNodeRoute struct {
+    NodeID    int64  `gorm:"primaryKey;autoIncrement:false;column:node_id" json:"nodeId"`
+    Role      string `gorm:"primaryKey;column:role;size:16" json:"role"`
+    Replica   string `gorm:"column:replica;size:64" json:"replica"`
+    UpdatedMs int64  `gorm:"column:updated_ms;index" json:"updatedMs"`
+}
+func (BusNodeRoute) TableName() string { return "bus_node_route" }
+
+// JobLease: the replica currently running a scheduler job; a lease that was not renewed
+// before ExpiresMs may be taken over by another replica
+type JobLease struct {
+    Job       string `gorm:"primaryKey;column:job;size:64" json:"job"`
+    Owner     string `gorm:"column:owner;size:64" json:"owner"`
+    ExpiresMs int64  `gorm:"column:expires_ms" json:"expiresMs"`
+}
+func (JobLease) TableName() string { return "job_lease" }
+
+// FlowReport: flow reports already applied; (node_id, report_id) dedupes retries
+type FlowReport struct {
+    ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+    NodeID      int64  `gorm:"column:node_id;uniqueIndex:uk_flow_report" json:"nodeId"`
+    ReportID    string `gorm:"column:report_id;size:64;uniqueIndex:uk_flow_report" json:"reportId"` // agent: epoch-seq
+    Items       int    `gorm:"column:items" json:"items"`
+    Bytes       int64  `gorm:"column:bytes" json:"bytes"`
+    CreatedTime int64  `gorm:"column:created_time;index" json:"createdTime"`
+}
+func (FlowReport) TableName() string { return "flow_report" }
@@ -0,0 +1,33 @@
+package model
+
+// NotificationChannel: one delivery target for panel events
+type NotificationChannel struct {
+    ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+    Name        string `gorm:"column:name;size:64" json:"name"`
+    Type        string `gorm:"column:type;size:16" json:"type"` // webhook, json_webhook, telegram, smtp
+    Enabled     int    `gorm:"column:enabled" json:"enabled"`
+    Events      string `gorm:"column:events;size:512" json:"events"` // comma separated, "*" or "" = all, "quota_*" = prefix
+    Config      string `gorm:"column:config;type:text" json:"-"`     // type specific JSON
+    CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+    UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (NotificationChannel) TableName() string { return "notification_channel" }
+
+// NotificationDelivery: delivery log and retry queue, one row per (event, channel)
+type NotificationDelivery struct {
+    ID            int64  `gorm:"primaryKey;column:id" json:"id"`
+    ChannelID     int64  `gorm:"column:channel_id;index" json:"channelId"`
+    ChannelName   string `gorm:"column:channel_name;size:64" json:"channelName"`
+    Event         string `gorm:"column:event;size:64;index" json:"event"`
+    Payload       string `gorm:"column:payload;type:text" json:"payload"` // event data JSON
+    Context       string `gorm:"column:context;type:text" json:"context"` // template context JSON (controller.NotifyEvent)
+    Status        string `gorm:"column:status;size:16;index" json:"status"` // pending, sent, failed
+    Attempts      int    `gorm:"column:attempts" json:"attempts"`
+    NextAttemptMs int64  `gorm:"column:next_attempt_ms;index" json:"nextAttemptMs"`
+    LastError     string `gorm:"column:last_error;type:text" json:"lastError"`
+    CreatedTime   int64  `gorm:"column:created_time;index" json:"createdTime"`
+    SentTime      int64  `gorm:"column:sent_time" json:"sentTime"`
+}
+
+func (NotificationDelivery) TableName() string { return "notification_delivery" }
@@ -15,6 +15,8 @@ func RegisterRoutes(r *gin.Engine) {
 	r.Use(middleware.CORS())
 	// health
 	r.GET("/health", func(c *gin.Context) { c.String(200, "ok") })
+	// prometheus scrape (METRICS_TOKEN: optional bearer token)
+	r.GET("/metrics", controller.Metrics)
 	// serve install script for nodes
 	r.GET("/install.sh", controller.InstallScript)
 	// serve flux-agent binaries
@@ -42,10 +44,12 @@ func RegisterRoutes(r *gin.Engine) {
 	// public config
 	conf := api.Group("/config")
 	{
-		conf.POST("/list", controller.ConfigList)
-		conf.POST("/get", controller.ConfigGet)
+		conf.POST("/list", middleware.AuthOptional(), controller.ConfigList)
+		conf.POST("/get", middleware.AuthOptional(), controller.ConfigGet)
+		conf.POST("/schema", middleware.RequireRole(), controller.ConfigSchema)
 		conf.POST("/update", middleware.RequireRole(), controller.ConfigUpdate)
 		conf.POST("/update-single", middleware.RequireRole(), controller.ConfigUpdateSingle)
+		conf.POST("/server", middleware.RequireRole(), controller.ConfigServer)
 	}
 
 	// user
@@ -54,6 +58,7 @@ func RegisterRoutes(r *gin.Engine) {
 		user.POST("/login", controller.UserLogin)
 		user.POST("/package", middleware.AuthOptional(), controller.UserPackage)
 		user.POST("/updatePassword", middleware.Auth(), controller.UserUpdatePassword)
+		user.POST("/contact", middleware.Auth(), controller.UserUpdateContact)
 
 		userAdmin := user.Group("")
 		userAdmin.Use(middleware.RequireRole())
@@ -74,6 +79,7 @@ func RegisterRoutes(r *gin.Engine) {
 		node.POST("/list", controller.NodeList)
 		node.POST("/update", controller.NodeUpdate)
 		node.POST("/delete", controller.NodeDelete)
+		node.POST("/delete/preview", controller.NodeDeletePreview)
 		node.POST("/install", controller.NodeInstallCmd)
 		node.GET("/connections", controller.NodeConnections)
 		// create/update exit node SS service
@@ -87,6 +93,19 @@ func RegisterRoutes(r *gin.Engine) {
 		node.POST("/network-stats-batch", controller.NodeNetworkStatsBatch)
 		node.POST("/sysinfo", controller.NodeSysinfo)
 		node.POST("/interfaces", controller.NodeInterfaces)
+		// drift between panel desired services and node gost.json
+		node.POST("/drift", controller.NodeDrift)
+		node.POST("/drift/apply", controller.NodeDriftApply)
+		node.POST("/drift/adopt", controller.NodeDriftAdopt)
+		// agent protocol capabilities (handshake)
+		node.POST("/capabilities", controller.NodeCapabilities)
+		// websocket send queue / backpressure metrics
+		node.POST("/ws-stats", controller.NodeWSStats)
+		// persistent port registry
+		node.POST("/ports", controller.NodePortAllocations)
+		node.POST("/ports/reconcile", controller.NodePortReconcile)
+		// remote journal of flux-agent / gost
+		node.POST("/logs", controller.NodeLogs)
 	}
 
 	// tunnel
@@ -103,6 +122,8 @@ func RegisterRoutes(r *gin.Engine) {
 			adm.POST("/delete", controller.TunnelDelete)
 			adm.POST("/path/get", controller.TunnelPathGet)
 			adm.POST("/path/set", controller.TunnelPathSet)
+			adm.POST("/hop/list", controller.TunnelHopList)
+			adm.POST("/hop/set", controller.TunnelHopSet)
 			adm.POST("/user/assign", controller.TunnelUserAssign)
 			adm.POST("/user/list", controller.TunnelUserList)
 			adm.POST("/user/remove", controller.TunnelUserRemove)
@@ -130,6 +151,9 @@ func RegisterRoutes(r *gin.Engine) {
 		forward.POST("/resume", middleware.Auth(), controller.ForwardResume)
 		forward.POST("/diagnose", middleware.RequireRole(), controller.ForwardDiagnose)
 		forward.POST("/diagnose-step", middleware.RequireRole(), controller.ForwardDiagnoseStep)
+		forward.POST("/connections", middleware.RequireRole(), controller.ForwardConnections)
+		// live view; checks the admin token from the query itself
+		forward.GET("/connections/ws", controller.ForwardConnectionsWS)
 		forward.POST("/update-order", middleware.Auth(), controller.ForwardUpdateOrder)
 	}
 
@@ -174,6 +198,27 @@ func RegisterRoutes(r *gin.Engine) {
 	r.Any("/flow/upload", controller.FlowUpload)
 	// alerts
 	api.POST("/alerts/recent", middleware.RequireRole(), controller.AlertsRecent)
+	api.POST("/alerts/list", middleware.RequireRole(), controller.AlertList)
+	api.POST("/alerts/ack", middleware.RequireRole(), controller.AlertAck)
+	api.POST("/alerts/resolve", middleware.RequireRole(), controller.AlertResolve)
+	api.POST("/alerts/rule/list", middleware.RequireRole(), controller.AlertRuleList)
+	api.POST("/alerts/rule/create", middleware.RequireRole(), controller.AlertRuleCreate)
+	api.POST("/alerts/rule/update", middleware.RequireRole(), controller.AlertRuleUpdate)
+	api.POST("/alerts/rule/delete", middleware.RequireRole(), controller.AlertRuleDelete)
+
+	// notification channels (admin)
+	notifyGrp := api.Group("/notify")
+	notifyGrp.Use(middleware.RequireRole())
+	{
+		notifyGrp.POST("/channel/list", controller.NotifyChannelList)
+		notifyGrp.POST("/channel/create", controller.NotifyChannelCreate)
+		notifyGrp.POST("/channel/update", controller.NotifyChannelUpdate)
+		notifyGrp.POST("/channel/delete", controller.NotifyChannelDelete)
+		notifyGrp.POST("/channel/test", controller.NotifyChannelTest)
+		notifyGrp.POST("/deliveries", controller.NotifyDeliveries)
+		notifyGrp.POST("/deliveries/retry", controller.NotifyDeliveryRetry)
+		notifyGrp.POST("/template/preview", controller.NotifyTemplatePreview)
+	}
 
 	// probe targets (admin)
 	probe := api.Group("/probe")
@@ -212,5 +257,6 @@ func RegisterRoutes(r *gin.Engine) {
 		agent.POST("/reconcile-node", controller.AgentReconcileNode)
 		agent.POST("/probe-targets", controller.AgentProbeTargets)
 		agent.POST("/report-probe", controller.AgentReportProbe)
+		agent.POST("/flow-report", controller.AgentFlowReport)
 	}
 }
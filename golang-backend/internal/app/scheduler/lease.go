@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Every replica runs the same job loops; a job_lease row per job makes only one of them do
+// the work. The holder renews the lease on each run, so it keeps the job while alive; when
+// it stops (or misses a run by half an interval) another replica takes over.
+
+// acquireLease takes or renews the lease of job for owner until now+ttl
+func acquireLease(ctx context.Context, job, owner string, ttl time.Duration) bool {
+	now := time.Now().UnixMilli()
+	exp := now + ttl.Milliseconds()
+	db := dbpkg.DB.WithContext(ctx)
+	res := db.Model(&model.JobLease{}).
+		Where("job = ? AND (owner = ? OR expires_ms < ?)", job, owner, now).
+		Updates(map[string]interface{}{"owner": owner, "expires_ms": exp})
+	if res.Error != nil {
+		return false
+	}
+	if res.RowsAffected > 0 {
+		return true
+	}
+	// no row yet (first run of the job anywhere): the first insert wins
+	res = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.JobLease{Job: job, Owner: owner, ExpiresMs: exp})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// releaseLease lets another replica take the job at its next tick instead of waiting for
+// the lease to expire
+func releaseLease(job, owner string) {
+	dbpkg.DB.Model(&model.JobLease{}).Where("job = ? AND owner = ?", job, owner).Update("expires_ms", 0)
+}
@@ -0,0 +1,57 @@
+//go:build !loong64
+
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+func TestLeaseOneHolder(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "lease.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.JobLease{}); err != nil {
+		t.Fatal(err)
+	}
+	prev := dbpkg.DB
+	dbpkg.DB = db
+	t.Cleanup(func() { dbpkg.DB = prev })
+	ctx := context.Background()
+
+	if !acquireLease(ctx, "job", "a", time.Minute) {
+		t.Fatal("a: first acquire failed")
+	}
+	if acquireLease(ctx, "job", "b", time.Minute) {
+		t.Fatal("b took a live lease")
+	}
+	if !acquireLease(ctx, "job", "a", time.Minute) {
+		t.Fatal("a could not renew")
+	}
+	if !acquireLease(ctx, "other", "b", time.Minute) {
+		t.Fatal("leases of different jobs must not conflict")
+	}
+
+	// a stopped holder: b takes over once the lease expired or was released
+	db.Model(&model.JobLease{}).Where("job = ?", "job").Update("expires_ms", time.Now().Add(-time.Second).UnixMilli())
+	if !acquireLease(ctx, "job", "b", time.Minute) {
+		t.Fatal("b could not take an expired lease")
+	}
+	if acquireLease(ctx, "job", "a", time.Minute) {
+		t.Fatal("a took b's lease")
+	}
+	releaseLease("job", "b")
+	if !acquireLease(ctx, "job", "a", time.Minute) {
+		t.Fatal("a could not take a released lease")
+	}
+}
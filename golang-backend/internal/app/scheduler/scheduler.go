@@ -1,33 +1,91 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"network-panel/golang-backend/internal/app/controller"
+	"network-panel/golang-backend/internal/app/lifecycle"
+	"network-panel/golang-backend/internal/app/metrics"
 	"network-panel/golang-backend/internal/app/model"
 	dbpkg "network-panel/golang-backend/internal/db"
 )
 
+// Start launches the background jobs under lifecycle.Context; each returns at its next
+// wait once the context is cancelled (the flow flusher after a last flush)
 func Start() {
-	go billingChecker()
+	lifecycle.Go(controller.RunFlowFlusher)
+	lifecycle.Go(controller.RunNotifier)
+	lifecycle.Go(every("billing", 6*time.Hour, true, checkOnce))
+	lifecycle.Go(every("flow_report_janitor", 24*time.Hour, true, flowReportJanitor))
+	lifecycle.Go(every("expiry_warnings", time.Hour, true, controller.CheckExpiryWarnings))
+	lifecycle.Go(every("alert_rules", time.Minute, false, controller.EvaluateAlertRules))
+	lifecycle.Go(every("metrics_rollup", time.Minute, false, controller.RunMetricsRollup))
 }
 
-func billingChecker() {
-	ticker := time.NewTicker(6 * time.Hour)
-	defer ticker.Stop()
-	for {
-		checkOnce()
-		<-ticker.C
+var (
+	jobRuns     = metrics.NewCounter("np_scheduler_job_runs_total", "Scheduler job runs by result (ok, panic).", "job", "result")
+	jobDuration = metrics.NewHistogram("np_scheduler_job_duration_seconds", "Scheduler job run time.", []float64{.01, .1, .5, 1, 5, 15, 30, 60, 300}, "job")
+)
+
+// every returns a job loop running fn each interval (and once right away when immediate)
+// until ctx is done. With several replicas only the holder of the job's lease runs it.
+func every(job string, interval time.Duration, immediate bool, fn func(context.Context)) func(context.Context) {
+	return func(ctx context.Context) {
+		owner := controller.ReplicaID()
+		ttl := interval + interval/2
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer releaseLease(job, owner)
+		if immediate && acquireLease(ctx, job, owner, ttl) {
+			runJob(ctx, job, fn)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if acquireLease(ctx, job, owner, ttl) {
+					runJob(ctx, job, fn)
+				}
+			}
+		}
 	}
 }
 
-func checkOnce() {
+// runJob runs one pass of a job; a panic is logged and counted instead of stopping the scheduler
+func runJob(ctx context.Context, job string, fn func(context.Context)) {
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		if r := recover(); r != nil {
+			result = "panic"
+			slog.Error("job_panic", "job", job, "error", fmt.Sprint(r))
+		}
+		jobRuns.Inc(job, result)
+		jobDuration.Observe(time.Since(start).Seconds(), job)
+	}()
+	fn(ctx)
+}
+
+// flowReportJanitor drops dedupe records long after any agent could still retry them
+func flowReportJanitor(ctx context.Context) {
+	cutoff := time.Now().Add(-30 * 24 * time.Hour).UnixMilli()
+	dbpkg.DB.WithContext(ctx).Where("created_time < ?", cutoff).Delete(&model.FlowReport{})
+}
+
+// checkOnce reminds about nodes whose billing cycle ends within a day
+func checkOnce(ctx context.Context) {
 	var nodes []model.Node
-	dbpkg.DB.Find(&nodes)
+	dbpkg.DB.WithContext(ctx).Find(&nodes)
 	now := time.Now().UnixMilli()
 	dayMs := int64(24 * 3600 * 1000)
 	for _, n := range nodes {
+		if ctx.Err() != nil {
+			return
+		}
 		if n.CycleDays == nil || *n.CycleDays <= 0 || n.StartDateMs == nil || *n.StartDateMs <= 0 {
 			continue
 		}
@@ -44,13 +102,15 @@ func checkOnce() {
 		// if <= 1 day, trigger reminder
 		if rem <= dayMs {
 			msg := fmt.Sprintf("节点即将到期，剩余 %d 天", (rem+dayMs-1)/dayMs)
-			// alert record
-			name := n.Name
-			nid := n.ID
-			a := model.Alert{TimeMs: now, Type: "due", NodeID: &nid, NodeName: &name, Message: msg}
-			_ = dbpkg.DB.Create(&a).Error
-			// callback
-			controller.TriggerCallback("node_due", n, map[string]any{"remainMs": rem})
+			// alert record; repeated checks within one cycle only bump the incident
+			if _, isNew := controller.RaiseNodeAlert("due", n, msg); isNew {
+				ev := controller.NodeNotifyEvent("node_due", n, msg)
+				ev.Remain = time.Duration(rem) * time.Millisecond
+				controller.TriggerCallback(ev)
+			}
+		} else {
+			// a new cycle started
+			controller.ResolveNodeAlert("due", n.ID)
 		}
 	}
 }
@@ -5,13 +5,14 @@ import (
     "crypto/sha256"
     "encoding/base64"
     "encoding/json"
-    "os"
     "strings"
     "time"
     "strconv"
+
+    "network-panel/golang-backend/internal/config"
 )
 
-var jwtSecret = func() string { return os.Getenv("JWT_SECRET") }
+var jwtSecret = func() string { return config.C.Auth.JWTSecret }
 
 type jwtHeader struct {
     Alg string `json:"alg"`
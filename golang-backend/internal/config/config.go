@@ -0,0 +1,265 @@
+// Package config is the typed configuration of the panel server. Values come from the
+// defaults, then an optional YAML or TOML file, then the environment (DB_HOST, JWT_SECRET,
+// PORT ...), so existing env-only deployments keep working. Validate reports every
+// problem at once before the server touches the database.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+type Server struct {
+	Port             int    `json:"port" yaml:"port" toml:"port"`
+	GinMode          string `json:"ginMode" yaml:"ginMode" toml:"ginMode"`
+	ShutdownTimeoutS int    `json:"shutdownTimeoutS" yaml:"shutdownTimeoutS" toml:"shutdownTimeoutS"`
+}
+
+type Database struct {
+	Dialect    string `json:"dialect" yaml:"dialect" toml:"dialect"`
+	Host       string `json:"host" yaml:"host" toml:"host"`
+	Port       int    `json:"port" yaml:"port" toml:"port"`
+	Name       string `json:"name" yaml:"name" toml:"name"`
+	User       string `json:"user" yaml:"user" toml:"user"`
+	Password   string `json:"password" yaml:"password" toml:"password"`
+	SQLitePath string `json:"sqlitePath" yaml:"sqlitePath" toml:"sqlitePath"`
+}
+
+type Auth struct {
+	JWTSecret string `json:"jwtSecret" yaml:"jwtSecret" toml:"jwtSecret"`
+}
+
+type Log struct {
+	Level  string `json:"level" yaml:"level" toml:"level"`
+	Format string `json:"format" yaml:"format" toml:"format"`
+}
+
+type Bus struct {
+	Backend   string `json:"backend" yaml:"backend" toml:"backend"`
+	ReplicaID string `json:"replicaId" yaml:"replicaId" toml:"replicaId"`
+	PollMs    int    `json:"pollMs" yaml:"pollMs" toml:"pollMs"` // db backend: how often a replica reads bus_message
+}
+
+type Metrics struct {
+	Token string `json:"token" yaml:"token" toml:"token"`
+}
+
+type Config struct {
+	Server   Server   `json:"server" yaml:"server" toml:"server"`
+	Database Database `json:"database" yaml:"database" toml:"database"`
+	Auth     Auth     `json:"auth" yaml:"auth" toml:"auth"`
+	Log      Log      `json:"log" yaml:"log" toml:"log"`
+	Bus      Bus      `json:"bus" yaml:"bus" toml:"bus"`
+	Metrics  Metrics  `json:"metrics" yaml:"metrics" toml:"metrics"`
+
+	// File is the config file read ("" when env only), Env the variables that overrode it
+	File string   `json:"file" yaml:"-" toml:"-"`
+	Env  []string `json:"env" yaml:"-" toml:"-"`
+}
+
+// C is the loaded configuration; the defaults until Load succeeds
+var C = Default()
+
+// Default is the configuration of an install without file or environment
+func Default() *Config {
+	return &Config{
+		Server:   Server{Port: 6365, GinMode: "release", ShutdownTimeoutS: 25},
+		Database: Database{Dialect: "mysql", Host: "127.0.0.1", Port: 3306, SQLitePath: "./flux.db"},
+		Log:      Log{Level: "info", Format: "json"},
+		Bus:      Bus{Backend: "memory", PollMs: 500},
+	}
+}
+
+// searched in the working directory when neither -config nor PANEL_CONFIG is given
+var defaultFiles = []string{"config.yaml", "config.yml", "config.toml"}
+
+// Load reads path (or PANEL_CONFIG, or the first default file present), applies the
+// environment and validates the result. On success it becomes C.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path == "" {
+		path = os.Getenv("PANEL_CONFIG")
+	}
+	if path == "" {
+		for _, f := range defaultFiles {
+			if _, err := os.Stat(f); err == nil {
+				path = f
+				break
+			}
+		}
+	}
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	C = c
+	return c, nil
+}
+
+// readFile decodes a YAML or TOML file over c; unknown keys are errors so typos surface
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalWithOptions(b, c, yaml.Strict())
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(b)).DisallowUnknownFields().Decode(c)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	c.File = path
+	return nil
+}
+
+// envVars maps the environment variables the panel has always read onto the config
+func (c *Config) envVars() []struct {
+	name string
+	ptr  any
+} {
+	return []struct {
+		name string
+		ptr  any
+	}{
+		{"PORT", &c.Server.Port},
+		{"GIN_MODE", &c.Server.GinMode},
+		{"SHUTDOWN_TIMEOUT_S", &c.Server.ShutdownTimeoutS},
+		{"DB_DIALECT", &c.Database.Dialect},
+		{"DB_HOST", &c.Database.Host},
+		{"DB_PORT", &c.Database.Port},
+		{"DB_NAME", &c.Database.Name},
+		{"DB_USER", &c.Database.User},
+		{"DB_PASSWORD", &c.Database.Password},
+		{"DB_SQLITE_PATH", &c.Database.SQLitePath},
+		{"JWT_SECRET", &c.Auth.JWTSecret},
+		{"LOG_LEVEL", &c.Log.Level},
+		{"LOG_FORMAT", &c.Log.Format},
+		{"PANEL_BUS", &c.Bus.Backend},
+		{"PANEL_REPLICA_ID", &c.Bus.ReplicaID},
+		{"PANEL_BUS_POLL_MS", &c.Bus.PollMs},
+		{"METRICS_TOKEN", &c.Metrics.Token},
+	}
+}
+
+// applyEnv overrides c with every non-empty variable of envVars
+func (c *Config) applyEnv() error {
+	c.Env = nil
+	for _, e := range c.envVars() {
+		v := strings.TrimSpace(os.Getenv(e.name))
+		if v == "" {
+			continue
+		}
+		switch p := e.ptr.(type) {
+		case *string:
+			*p = v
+		case *int:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s=%q: not a number", e.name, v)
+			}
+			*p = n
+		}
+		c.Env = append(c.Env, e.name)
+	}
+	return nil
+}
+
+// Validate checks c and returns all problems joined, naming both the file key and the
+// environment variable
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	oneOf := func(v string, allowed ...string) bool {
+		for _, a := range allowed {
+			if strings.EqualFold(v, a) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		bad("server.port (PORT) %d: must be 1-65535", c.Server.Port)
+	}
+	if !oneOf(c.Server.GinMode, "debug", "release", "test") {
+		bad("server.ginMode (GIN_MODE) %q: must be debug, release or test", c.Server.GinMode)
+	}
+	if c.Server.ShutdownTimeoutS < 1 {
+		bad("server.shutdownTimeoutS (SHUTDOWN_TIMEOUT_S) %d: must be at least 1", c.Server.ShutdownTimeoutS)
+	}
+
+	switch strings.ToLower(c.Database.Dialect) {
+	case "mysql":
+		if c.Database.Host == "" {
+			bad("database.host (DB_HOST) is empty")
+		}
+		if c.Database.Name == "" {
+			bad("database.name (DB_NAME) is empty")
+		}
+		if c.Database.User == "" {
+			bad("database.user (DB_USER) is empty")
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			bad("database.port (DB_PORT) %d: must be 1-65535", c.Database.Port)
+		}
+	case "sqlite":
+		if c.Database.SQLitePath == "" {
+			bad("database.sqlitePath (DB_SQLITE_PATH) is empty")
+		}
+	default:
+		bad("database.dialect (DB_DIALECT) %q: must be mysql or sqlite", c.Database.Dialect)
+	}
+
+	if strings.TrimSpace(c.Auth.JWTSecret) == "" {
+		bad("auth.jwtSecret (JWT_SECRET) is empty: no login token could be verified")
+	}
+
+	if !oneOf(c.Log.Level, "debug", "info", "warn", "warning", "error") {
+		bad("log.level (LOG_LEVEL) %q: must be debug, info, warn or error", c.Log.Level)
+	}
+	if !oneOf(c.Log.Format, "json", "text") {
+		bad("log.format (LOG_FORMAT) %q: must be json or text", c.Log.Format)
+	}
+	if !oneOf(c.Bus.Backend, "memory", "db", "database") {
+		bad("bus.backend (PANEL_BUS) %q: must be memory or db", c.Bus.Backend)
+	}
+	if c.Bus.PollMs < 50 || c.Bus.PollMs > 10000 {
+		bad("bus.pollMs (PANEL_BUS_POLL_MS) %d: must be 50-10000", c.Bus.PollMs)
+	}
+	return errors.Join(errs...)
+}
+
+// Warnings are settings that work but should be changed
+func (c *Config) Warnings() []string {
+	var w []string
+	if n := len(c.Auth.JWTSecret); n > 0 && n < 16 {
+		w = append(w, "auth.jwtSecret (JWT_SECRET) is shorter than 16 characters")
+	}
+	if strings.EqualFold(c.Bus.Backend, "db") && strings.EqualFold(c.Database.Dialect, "sqlite") {
+		w = append(w, "bus.backend db with sqlite: replicas must share one database file")
+	}
+	return w
+}
+
+// IsSQLite reports whether the panel runs on sqlite
+func (c *Config) IsSQLite() bool { return strings.EqualFold(c.Database.Dialect, "sqlite") }
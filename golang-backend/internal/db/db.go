@@ -2,11 +2,11 @@ package db
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"network-panel/golang-backend/internal/app/model"
 	"network-panel/golang-backend/internal/app/util"
+	"network-panel/golang-backend/internal/config"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -16,38 +16,22 @@ import (
 var DB *gorm.DB
 
 func dsn() string {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "3306"
-	}
-	name := os.Getenv("DB_NAME")
-	user := os.Getenv("DB_USER")
-	pass := os.Getenv("DB_PASSWORD")
+	d := config.C.Database
 	params := "charset=utf8mb4&parseTime=True&loc=Local&timeout=10s"
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s", user, pass, host, port, name, params)
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s", d.User, d.Password, d.Host, d.Port, d.Name, params)
 }
 
 func dsnNoDB() string {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "3306"
-	}
-	user := os.Getenv("DB_USER")
-	pass := os.Getenv("DB_PASSWORD")
+	d := config.C.Database
 	params := "charset=utf8mb4&parseTime=True&loc=Local&timeout=10s"
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/?%s", user, pass, host, port, params)
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/?%s", d.User, d.Password, d.Host, d.Port, params)
 }
 
 func ensureDatabase() error {
-	if os.Getenv("DB_DIALECT") == "sqlite" {
+	if config.C.IsSQLite() {
 		return nil
 	}
-	name := os.Getenv("DB_NAME")
-	if name == "" {
-		return fmt.Errorf("DB_NAME is empty")
-	}
+	name := config.C.Database.Name
 	tmp, err := gorm.Open(mysql.Open(dsnNoDB()), &gorm.Config{Logger: logger.Default.LogMode(logger.Warn)})
 	if err != nil {
 		return err
@@ -62,19 +46,17 @@ func ensureDatabase() error {
 	return nil
 }
 
-func Init() error {
+// Init opens the database, applies plugins (the app's query metrics) and migrates the schema
+func Init(plugins ...gorm.Plugin) error {
 	if err := ensureDatabase(); err != nil {
 		return err
 	}
-	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Info)}
+	// foreign keys are added by the migrations that clean up the rows first (tunnel_hop)
+	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Info), DisableForeignKeyConstraintWhenMigrating: true}
 	var db *gorm.DB
 	var err error
-	if os.Getenv("DB_DIALECT") == "sqlite" {
-		path := os.Getenv("DB_SQLITE_PATH")
-		if path == "" {
-			path = "./flux.db"
-		}
-		db, err = openSQLiteGorm(path, cfg)
+	if config.C.IsSQLite() {
+		db, err = openSQLiteGorm(config.C.Database.SQLitePath, cfg)
 	} else {
 		db, err = gorm.Open(mysql.Open(dsn()), cfg)
 	}
@@ -88,9 +70,33 @@ func Init() error {
 	sqlDB.SetMaxIdleConns(5)
 	sqlDB.SetMaxOpenConns(20)
 	sqlDB.SetConnMaxLifetime(30 * time.Minute)
+	for _, p := range plugins {
+		if err := db.Use(p); err != nil {
+			return err
+		}
+	}
 	DB = db
-	// Auto-migrate tables
-	if err := DB.AutoMigrate(
+	// Auto-migrate tables. SQLite alters a column by copying the table and dropping the old
+	// one, which with foreign keys enforced would cascade into tunnel_hop: migrate on one
+	// connection with enforcement off.
+	if err := DB.Connection(func(tx *gorm.DB) error {
+		if config.C.IsSQLite() {
+			tx.Exec("PRAGMA foreign_keys = OFF")
+			defer tx.Exec("PRAGMA foreign_keys = ON")
+		}
+		return autoMigrate(tx)
+	}); err != nil {
+		return err
+	}
+	// Seed admin user
+	if err := seedAdmin(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func autoMigrate(tx *gorm.DB) error {
+	return tx.AutoMigrate(
 		&model.User{},
 		&model.Node{},
 		&model.Tunnel{},
@@ -104,16 +110,32 @@ func Init() error {
 		&model.NodeProbeResult{},
 		&model.NodeDisconnectLog{},
 		&model.Alert{},
+		&model.AlertRule{},
 		&model.NodeSysInfo{},
+		&model.NodeSysInfoRollup{},
+		&model.NodeProbeRollup{},
 		&model.NodeRuntime{},
-	); err != nil {
-		return err
+		&model.PortAllocation{},
+		&model.TunnelHop{},
+		&model.BusMessage{},
+		&model.BusNodeRoute{},
+		&model.JobLease{},
+		&model.FlowReport{},
+		&model.NotificationChannel{},
+		&model.NotificationDelivery{},
+	)
+}
+
+// Close closes the connection pool; queries still running get an error
+func Close() error {
+	if DB == nil {
+		return nil
 	}
-	// Seed admin user
-	if err := seedAdmin(); err != nil {
+	sqlDB, err := DB.DB()
+	if err != nil {
 		return err
 	}
-	return nil
+	return sqlDB.Close()
 }
 
 func seedAdmin() error {
@@ -3,12 +3,19 @@
 package db
 
 import (
+    "strings"
+
     sqlite "github.com/glebarez/sqlite"
     "gorm.io/gorm"
 )
 
-// openSQLiteGorm opens a SQLite database using the pure-Go driver.
+// openSQLiteGorm opens a SQLite database using the pure-Go driver, with foreign keys
+// enforced on every connection (SQLite leaves them off by default).
 func openSQLiteGorm(path string, cfg *gorm.Config) (*gorm.DB, error) {
-    return gorm.Open(sqlite.Open(path), cfg)
+    sep := "?"
+    if strings.Contains(path, "?") {
+        sep = "&"
+    }
+    return gorm.Open(sqlite.Open(path+sep+"_pragma=foreign_keys(1)"), cfg)
 }
 
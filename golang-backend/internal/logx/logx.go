@@ -0,0 +1,179 @@
+// Package logx configures log/slog for the panel and the agent. LOG_LEVEL (debug, info,
+// warn, error; default info) and LOG_FORMAT (json or text; default json) select the
+// handler, and every attribute goes through Redact so node secrets, relay auth and
+// shadowsocks passwords in logged payloads never reach the log.
+package logx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const mask = "***"
+
+// Setup installs the default slog logger from LOG_LEVEL and LOG_FORMAT. The standard log
+// package is routed through it as well, at info level.
+func Setup() {
+	SetupWriter(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+}
+
+// SetupWriter is Setup with explicit settings
+func SetupWriter(w io.Writer, level, format string) {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level), ReplaceAttr: replaceAttr}
+	var h slog.Handler
+	if strings.EqualFold(format, "text") {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(h))
+	log.SetFlags(0)
+}
+
+// ParseLevel maps debug|info|warn|error to a slog level; anything else is info
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// Event logs a map as one record: the "event" key is the message, the other keys sorted
+// attributes. It keeps the event-per-line shape the panel used before slog.
+func Event(level slog.Level, m map[string]any) {
+	if !slog.Default().Enabled(context.Background(), level) {
+		return
+	}
+	msg, _ := m["event"].(string)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if k != "event" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	args := make([]any, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, slog.Any(k, m[k]))
+	}
+	slog.Log(context.Background(), level, msg, args...)
+}
+
+func replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey {
+		return a
+	}
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, mask)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(a.Value.String()))
+	case slog.KindAny:
+		return slog.Any(a.Key, Redact(a.Value.Any()))
+	}
+	return a
+}
+
+// sensitiveKey matches secret, password, passwd, token, api key and their prefixed forms
+// (ssPassword, botToken, jwt_secret, X-Api-Key ...), case and separators ignored
+func sensitiveKey(k string) bool {
+	k = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(k))
+	switch k {
+	case "pass", "authorization":
+		return true
+	}
+	for _, s := range []string{"secret", "password", "passwd", "token", "apikey"} {
+		if strings.HasSuffix(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	queryRe    = regexp.MustCompile(`(?i)\b((?:secret|token|password|passwd)=)[^&\s"']+`)
+	userinfoRe = regexp.MustCompile(`(://[^/:@\s"']+:)[^@/\s"']+@`)
+)
+
+// redactString masks JSON payloads by key and secrets in URLs (query or userinfo)
+func redactString(s string) string {
+	if t := strings.TrimSpace(s); len(t) > 1 && (t[0] == '{' || t[0] == '[') {
+		var v any
+		if json.Unmarshal([]byte(t), &v) == nil {
+			b, _ := json.Marshal(redactValue(v))
+			return string(b)
+		}
+	}
+	s = queryRe.ReplaceAllString(s, "${1}"+mask)
+	return userinfoRe.ReplaceAllString(s, "${1}"+mask+"@")
+}
+
+// Redact returns v with sensitive fields masked. Maps and slices are copied, structs go
+// through their JSON form, JSON strings and raw messages are parsed and re-encoded.
+func Redact(v any) any {
+	switch x := v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case string:
+		return redactString(x)
+	case []byte:
+		return redactString(string(x))
+	case json.RawMessage:
+		return json.RawMessage(redactString(string(x)))
+	case error:
+		return redactString(x.Error())
+	case map[string]any, []any:
+		return redactValue(x)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if json.Unmarshal(b, &out) != nil {
+		return v
+	}
+	return redactValue(out)
+}
+
+// redactValue walks decoded JSON
+func redactValue(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			if sensitiveKey(k) {
+				if s, ok := e.(string); ok && s == "" {
+					out[k] = ""
+				} else {
+					out[k] = mask
+				}
+				continue
+			}
+			out[k] = redactValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = redactValue(e)
+		}
+		return out
+	case string:
+		return redactString(x)
+	}
+	return v
+}
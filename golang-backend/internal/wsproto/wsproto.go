@@ -0,0 +1,341 @@
+// Package wsproto defines the WebSocket protocol spoken between the panel (/system-info)
+// and flux-agent. It has no dependencies besides the standard library so the agent
+// binary stays small.
+//
+// Every frame is an Envelope: {v, type, requestId?, data}. Commands flow panel -> agent,
+// results/sysinfo/errors flow agent -> panel. Right after a node connects the panel sends
+// Hello; agents that understand it answer with Capabilities and switch to typed frames.
+// Agents that never answer are treated as legacy (LegacyCommands, untyped sysinfo).
+package wsproto
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Version is the protocol version carried in Envelope.V.
+const Version = 1
+
+// Handshake
+const (
+	TypeHello        = "Hello"        // panel -> agent
+	TypeCapabilities = "Capabilities" // agent -> panel
+)
+
+// Commands (panel -> agent)
+const (
+	TypeDiagnose       = "Diagnose"
+	TypeAddService     = "AddService"
+	TypeUpdateService  = "UpdateService"
+	TypeDeleteService  = "DeleteService"
+	TypePauseService   = "PauseService"
+	TypeResumeService  = "ResumeService"
+	TypeQueryServices  = "QueryServices"
+	TypeUpgradeAgent   = "UpgradeAgent"
+	TypeUpgradeAgent1  = "UpgradeAgent1"
+	TypeUpgradeAgent2  = "UpgradeAgent2"
+	TypeRestartGost    = "RestartGost"
+	TypeUninstallAgent = "UninstallAgent"
+	TypeQueryConns     = "QueryConnections"
+	TypeQueryLogs      = "QueryLogs"
+)
+
+// Replies and reports (agent -> panel)
+const (
+	TypeDiagnoseResult      = "DiagnoseResult"
+	TypeQueryServicesResult = "QueryServicesResult"
+	TypeQueryConnsResult    = "QueryConnectionsResult"
+	TypeLogChunk            = "LogChunk" // several per QueryLogs, the last one has Done
+	TypeSysInfo             = "SysInfo"
+	TypeError               = "Error"
+)
+
+// Optional agent features announced in Capabilities.Features
+const (
+	FeatureServiceDetail = "services.detail" // QueryServices honours Detail
+	FeatureTypedSysInfo  = "sysinfo.typed"   // SysInfo frames instead of raw JSON
+)
+
+// LegacyCommands is what agents without the handshake are known to handle.
+var LegacyCommands = []string{
+	TypeDiagnose, TypeAddService, TypeUpdateService, TypeDeleteService,
+	TypePauseService, TypeResumeService, TypeQueryServices,
+	TypeUpgradeAgent, TypeUpgradeAgent1, TypeUpgradeAgent2,
+	TypeRestartGost, TypeUninstallAgent,
+}
+
+// ErrNoType is returned by Decode for frames without a type (legacy sysinfo payloads).
+var ErrNoType = errors.New("wsproto: frame has no type")
+
+// Envelope is the single frame format on the wire.
+type Envelope struct {
+	V         int             `json:"v,omitempty"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Encode builds a frame of the current protocol version.
+func Encode(typ, requestID string, data any) ([]byte, error) {
+	env := Envelope{V: Version, Type: typ, RequestID: requestID}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		env.Data = b
+	}
+	return json.Marshal(env)
+}
+
+// Decode parses a frame. A JSON object without "type" yields ErrNoType together with
+// the (empty-typed) envelope so callers can fall back to the legacy sysinfo format.
+func Decode(b []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	if env.Type == "" {
+		return &env, ErrNoType
+	}
+	return &env, nil
+}
+
+// DecodeData unmarshals the envelope payload into v; an empty payload is not an error.
+func (e *Envelope) DecodeData(v any) error {
+	if len(e.Data) == 0 || string(e.Data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// ---- Handshake ----
+
+// Hello is sent by the panel once a node connection is accepted.
+type Hello struct {
+	Version int    `json:"version"`
+	Server  string `json:"server,omitempty"` // panel version
+}
+
+// Capabilities is the agent's answer to Hello.
+type Capabilities struct {
+	Version  int      `json:"version"`
+	Agent    string   `json:"agent"` // e.g. go-agent-1.0.4.2
+	Commands []string `json:"commands"`
+	Features []string `json:"features,omitempty"`
+}
+
+// Supports reports whether the command type is listed.
+func (c *Capabilities) Supports(cmd string) bool {
+	for _, s := range c.Commands {
+		if s == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether the optional feature is listed.
+func (c *Capabilities) Has(feature string) bool {
+	for _, s := range c.Features {
+		if s == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- Commands ----
+
+// DiagnoseRequest asks the agent for a tcp/icmp/iperf3 test.
+type DiagnoseRequest struct {
+	RequestID string         `json:"requestId"`
+	Host      string         `json:"host"`
+	Port      int            `json:"port,omitempty"`
+	Protocol  string         `json:"protocol,omitempty"`
+	Mode      string         `json:"mode,omitempty"` // icmp|iperf3|tcp(default)
+	Count     int            `json:"count,omitempty"`
+	TimeoutMs int            `json:"timeoutMs,omitempty"`
+	Reverse   bool           `json:"reverse,omitempty"`
+	Duration  int            `json:"duration,omitempty"`
+	Server    bool           `json:"server,omitempty"`
+	Client    bool           `json:"client,omitempty"`
+	Ctx       map[string]any `json:"ctx,omitempty"`
+}
+
+// ServiceConfigs is the payload of AddService/UpdateService: raw gost service objects.
+// Panel-only helpers may ride along under keys prefixed with "_" (e.g. "_chains").
+type ServiceConfigs []map[string]any
+
+// ServiceNames is the payload of DeleteService/PauseService/ResumeService.
+type ServiceNames struct {
+	Services []string `json:"services"`
+}
+
+// QueryServicesRequest lists services from the agent's gost config.
+type QueryServicesRequest struct {
+	RequestID string `json:"requestId"`
+	Filter    string `json:"filter,omitempty"` // handler type, e.g. "ss"
+	Detail    bool   `json:"detail,omitempty"` // include full service config
+}
+
+// QueryConnsRequest asks for the live connections of one service.
+type QueryConnsRequest struct {
+	RequestID string `json:"requestId"`
+	Service   string `json:"service"`
+	Limit     int    `json:"limit,omitempty"` // max client connections returned, default 200
+}
+
+// QueryLogsRequest asks for the journal of the agent and/or gost units. Lines is the
+// tail length (after Grep); Since/Until take journalctl time specs ("-1h", "2024-05-01 10:00").
+type QueryLogsRequest struct {
+	RequestID string   `json:"requestId"`
+	Units     []string `json:"units,omitempty"` // agent, agent2, gost; default agent and gost
+	Lines     int      `json:"lines,omitempty"`
+	Since     string   `json:"since,omitempty"`
+	Until     string   `json:"until,omitempty"`
+	Grep      string   `json:"grep,omitempty"` // Go regexp, (?i) for case-insensitive
+	MaxBytes  int      `json:"maxBytes,omitempty"`
+}
+
+// UpgradeRequest is the optional payload of UpgradeAgent*.
+type UpgradeRequest struct {
+	To string `json:"to,omitempty"`
+}
+
+// RestartGostRequest is the optional payload of RestartGost.
+type RestartGostRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// ---- Replies ----
+
+// DiagnoseResult is the data of a DiagnoseResult frame.
+type DiagnoseResult struct {
+	Success       bool           `json:"success"`
+	AverageTime   int            `json:"averageTime"` // ms
+	PacketLoss    int            `json:"packetLoss"`  // percent
+	Message       string         `json:"message,omitempty"`
+	Port          int            `json:"port,omitempty"`
+	BandwidthMbps float64        `json:"bandwidthMbps,omitempty"`
+	Ctx           map[string]any `json:"ctx,omitempty"`
+}
+
+// ServiceInfo is one item of a QueryServicesResult frame.
+type ServiceInfo struct {
+	Name      string         `json:"name"`
+	Addr      string         `json:"addr"`
+	Handler   string         `json:"handler"`
+	Port      int            `json:"port"`
+	Listening bool           `json:"listening"`
+	Limiter   string         `json:"limiter"`
+	Rlimiter  string         `json:"rlimiter"`
+	Metadata  map[string]any `json:"metadata"`
+	Config    map[string]any `json:"config,omitempty"` // only with Detail
+}
+
+// ConnsResult is the data of a QueryConnectionsResult frame. Conns are the client
+// connections to the service port, Upstreams the connections gost holds to the service
+// targets; gost does not tell which client belongs to which upstream.
+type ConnsResult struct {
+	Service   string        `json:"service"`
+	Port      int           `json:"port"`
+	Source    string        `json:"source"` // ss (with byte counters) or proc
+	Total     int           `json:"total"`  // client connections before Limit
+	Conns     []ConnInfo    `json:"conns"`
+	Upstreams []ConnInfo    `json:"upstreams"`
+	Stats     *ServiceStats `json:"stats,omitempty"` // from the gost web API when enabled
+}
+
+// ConnInfo is one established tcp connection. Byte counters are only known with ss;
+// ObservedS is how long the agent has seen the connection, not its age: it starts at 0 on
+// the first query and again after an agent restart (neither /proc nor ss expose the
+// connection's start time).
+type ConnInfo struct {
+	Client    string `json:"client"` // remote address
+	Target    string `json:"target"` // forward target (clients) or upstream address
+	RxBytes   int64  `json:"rxBytes,omitempty"`
+	TxBytes   int64  `json:"txBytes,omitempty"`
+	ObservedS int64  `json:"observedS"`
+}
+
+// ServiceStats are gost's counters of a service.
+type ServiceStats struct {
+	CurrentConns int64 `json:"currentConns"`
+	TotalConns   int64 `json:"totalConns"`
+	InputBytes   int64 `json:"inputBytes"`
+	OutputBytes  int64 `json:"outputBytes"`
+	TotalErrs    int64 `json:"totalErrs"`
+}
+
+// LogChunk is the data of a LogChunk frame. Lines arrive oldest first, split over frames
+// of at most LogChunkBytes; Truncated marks a tail cut at MaxBytes.
+type LogChunk struct {
+	Seq       int      `json:"seq"`
+	Lines     []string `json:"lines"`
+	Done      bool     `json:"done,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// LogChunkBytes bounds the text of one LogChunk frame
+const LogChunkBytes = 64 << 10
+
+// SysInfo is the periodic system report. Keys keep the legacy spelling so the panel
+// can read typed and untyped reports the same way.
+type SysInfo struct {
+	Uptime           int64      `json:"Uptime"`
+	BytesReceived    int64      `json:"BytesReceived"` // physical interfaces only (no lo, docker, veth, bridges)
+	BytesTransmitted int64      `json:"BytesTransmitted"`
+	CPUUsage         float64    `json:"CPUUsage"`
+	MemoryUsage      float64    `json:"MemoryUsage"`
+	Interfaces       []string   `json:"Interfaces,omitempty"`
+	RxRate           float64    `json:"RxRate,omitempty"` // bytes/s since the previous report
+	TxRate           float64    `json:"TxRate,omitempty"`
+	NetIfaces        []NetIface `json:"NetIfaces,omitempty"`
+	Health           *Health    `json:"Health,omitempty"`
+}
+
+// Health is the host and gost process state of a SysInfo report. Zero values mean the
+// agent could not read it (no permission, module not loaded, gost not running).
+type Health struct {
+	Load1        float64        `json:"load1"`
+	Load5        float64        `json:"load5"`
+	Load15       float64        `json:"load15"`
+	DiskUsed     int64          `json:"diskUsed"` // bytes, partition holding gost.json
+	DiskTotal    int64          `json:"diskTotal"`
+	TCP          map[string]int `json:"tcp,omitempty"` // connections by state: established, time_wait, ...
+	GostPID      int            `json:"gostPid,omitempty"`
+	GostRSS      int64          `json:"gostRss"`      // bytes
+	GostCPU      float64        `json:"gostCpu"`      // percent of one core since the previous report
+	GostRestarts int            `json:"gostRestarts"` // restarts seen since the agent started
+	GostFDs      int            `json:"gostFds"`
+	GostFDLimit  int            `json:"gostFdLimit"`
+	FDsOpen      int64          `json:"fdsOpen"` // system wide file handles
+	FDsMax       int64          `json:"fdsMax"`
+	Conntrack    int64          `json:"conntrack"`
+	ConntrackMax int64          `json:"conntrackMax"`
+}
+
+// NetIface is one network interface of a SysInfo report: cumulative counters since boot
+// and the rate since the previous report (a counter reset counts from zero).
+type NetIface struct {
+	Name    string  `json:"name"`
+	RxBytes int64   `json:"rxBytes"`
+	TxBytes int64   `json:"txBytes"`
+	RxRate  float64 `json:"rxRate"`
+	TxRate  float64 `json:"txRate"`
+}
+
+// Error reports a command the agent could not parse or does not support.
+type Error struct {
+	Code    string `json:"code"` // bad_request|unsupported|failed
+	Message string `json:"message"`
+	RefType string `json:"refType,omitempty"` // command type the error refers to
+}
+
+// Error codes
+const (
+	ErrCodeBadRequest  = "bad_request"
+	ErrCodeUnsupported = "unsupported"
+	ErrCodeFailed      = "failed"
+)
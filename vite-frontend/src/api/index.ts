@@ -1,3 +1,4 @@
+import axios from 'axios';
 import Network from './network';
 
 // 登陆相关接口
@@ -27,7 +28,9 @@ export const getUserPackageInfo = () => Network.post("/user/package");
 export const createNode = (data: any) => Network.post("/node/create", data);
 export const getNodeList = () => Network.post("/node/list");
 export const updateNode = (data: any) => Network.post("/node/update", data);
-export const deleteNode = (id: number, uninstall?: boolean) => Network.post("/node/delete", { id, uninstall });
+export const deleteNode = (id: number, uninstall?: boolean, mode?: string, replaceNodeId?: number) => Network.post("/node/delete", { id, uninstall, mode, replaceNodeId });
+// 删除节点前预览依赖（隧道、转发、路径、告警规则）
+export const previewNodeDelete = (id: number) => Network.post("/node/delete/preview", { id });
 export const getNodeInstallCommand = (id: number) => Network.post("/node/install", { id });
 export const checkNodeStatus = (nodeId?: number) => {
   const params = nodeId ? { nodeId } : {};
@@ -39,6 +42,19 @@ export const setExitNode = (data: { nodeId: number; port: number; password: stri
 export const getExitNode = (nodeId: number) => Network.post("/node/get-exit", { nodeId });
 // 查询节点上的服务
 export const queryNodeServices = (data: { nodeId: number; filter?: string }) => Network.post("/node/query-services", data);
+// 配置漂移检测（面板期望 vs 节点 gost.json）
+export const getNodeDrift = (nodeId?: number) => Network.post("/node/drift", nodeId ? { nodeId } : {});
+export const applyNodeDrift = (nodeId: number, services?: string[]) => Network.post("/node/drift/apply", { nodeId, services });
+export const adoptNodeDrift = (nodeId: number, services?: string[]) => Network.post("/node/drift/adopt", { nodeId, services });
+// 节点 Agent 协议能力
+export const getNodeCapabilities = (nodeId: number) => Network.post("/node/capabilities", { nodeId });
+// WebSocket 发送队列统计
+export const getWsStats = () => Network.post("/node/ws-stats");
+// 端口分配登记表
+export const getNodePorts = (nodeId: number) => Network.post("/node/ports", { nodeId });
+export const reconcileNodePorts = (nodeId?: number) => Network.post("/node/ports/reconcile", nodeId ? { nodeId } : {});
+// 节点远程日志（journalctl：agent / agent2 / gost），maxBytes 默认 1MB、上限 4MB
+export const getNodeLogs = (data: { id: number; units?: string[]; lines?: number; since?: string; until?: string; grep?: string; maxBytes?: number }) => Network.post("/node/logs", data);
 
 // 隧道CRUD操作 - 全部使用POST请求
 export const createTunnel = (data: any) => Network.post("/tunnel/create", data);
@@ -51,6 +67,9 @@ export const diagnoseTunnelStep = (tunnelId: number, step: string) => Network.po
 // 隧道多级路径配置
 export const getTunnelPath = (tunnelId: number) => Network.post("/tunnel/path/get", { tunnelId });
 export const setTunnelPath = (tunnelId: number, path: number[]) => Network.post("/tunnel/path/set", { tunnelId, path });
+// 隧道逐跳配置（中间节点顺序、端口、出站接口、监听IP）
+export const getTunnelHops = (tunnelId: number) => Network.post("/tunnel/hop/list", { tunnelId });
+export const setTunnelHops = (tunnelId: number, hops: Array<{nodeId:number, port?:number, interface?:string, bindIp?:string}>) => Network.post("/tunnel/hop/set", { tunnelId, hops });
 export const checkTunnelPath = (tunnelId: number) => Network.post("/tunnel/path-check", { tunnelId });
 export const cleanupTunnelTemp = (tunnelId: number) => Network.post("/tunnel/cleanup-temp", { tunnelId });
 // 隧道每个节点出口IP（interface）设置
@@ -81,11 +100,37 @@ export const resumeForwardService = (forwardId: number) => Network.post("/forwar
 // 转发诊断操作
 export const diagnoseForward = (forwardId: number) => Network.post("/forward/diagnose", { forwardId });
 export const diagnoseForwardStep = (forwardId: number, step: string) => Network.post("/forward/diagnose-step", { forwardId, step });
+// 转发实时连接（入口节点），forwardConnectionsWsUrl 为实时推送地址
+export const getForwardConnections = (id: number, limit?: number) => Network.post("/forward/connections", { id, limit });
+export const forwardConnectionsWsUrl = (id: number, intervalS = 3) => {
+  const baseUrl = axios.defaults.baseURL || (import.meta.env.VITE_API_BASE ? `${import.meta.env.VITE_API_BASE}/api/v1/` : '/api/v1/');
+  const abs = baseUrl.startsWith('http') ? baseUrl : `${window.location.origin}${baseUrl.startsWith('/') ? '' : '/'}${baseUrl}`;
+  return abs.replace(/^http/, 'ws').replace(/\/$/, '') + `/forward/connections/ws?id=${id}&intervalS=${intervalS}&token=${encodeURIComponent(localStorage.getItem('token') || '')}`;
+};
 
 // 转发排序操作
 export const updateForwardOrder = (data: { forwards: Array<{ id: number; inx: number }> }) => Network.post("/forward/update-order", data);
 // 最近告警
 export const getRecentAlerts = (limit = 50) => Network.post("/alerts/recent", { limit });
+// 告警筛选、确认与解决
+export const getAlerts = (data: { status?: string; type?: string; nodeId?: number; userId?: number; startMs?: number; endMs?: number; offset?: number; limit?: number } = {}) => Network.post("/alerts/list", data);
+export const ackAlerts = (ids: number[]) => Network.post("/alerts/ack", { ids });
+export const resolveAlerts = (ids: number[]) => Network.post("/alerts/resolve", { ids });
+// 告警阈值规则
+export const getAlertRules = () => Network.post("/alerts/rule/list");
+export const createAlertRule = (data: any) => Network.post("/alerts/rule/create", data);
+export const updateAlertRule = (data: any) => Network.post("/alerts/rule/update", data);
+export const deleteAlertRule = (id: number) => Network.post("/alerts/rule/delete", { id });
+
+// 通知通道
+export const getNotifyChannels = () => Network.post("/notify/channel/list");
+export const createNotifyChannel = (data: any) => Network.post("/notify/channel/create", data);
+export const updateNotifyChannel = (data: any) => Network.post("/notify/channel/update", data);
+export const deleteNotifyChannel = (id: number) => Network.post("/notify/channel/delete", { id });
+export const testNotifyChannel = (id: number, opts: { event?: string; template?: string } = {}) => Network.post("/notify/channel/test", { id, ...opts });
+export const getNotifyDeliveries = (data: { channelId?: number; status?: string; event?: string; limit?: number } = {}) => Network.post("/notify/deliveries", data);
+export const retryNotifyDelivery = (id: number) => Network.post("/notify/deliveries/retry", { id });
+export const previewNotifyTemplate = (template: string, event?: string) => Network.post("/notify/template/preview", { template, event });
 
 // 限速规则CRUD操作 - 全部使用POST请求
 export const createSpeedLimit = (data: any) => Network.post("/speed-limit/create", data);
@@ -96,6 +141,9 @@ export const deleteSpeedLimit = (id: number) => Network.post("/speed-limit/delet
 // 修改密码接口
 export const updatePassword = (data: any) => Network.post("/user/updatePassword", data);
 
+// 修改本人提醒联系方式
+export const updateUserContact = (notifyContact: string) => Network.post("/user/contact", { notifyContact });
+
 // 重置流量接口
 export const resetUserFlow = (data: { id: number; type: number }) => Network.post("/user/reset", data);
 
@@ -104,6 +152,10 @@ export const getConfigs = () => Network.post("/config/list");
 export const getConfigByName = (name: string) => Network.post("/config/get", { name });
 export const updateConfigs = (configMap: Record<string, string>) => Network.post("/config/update", configMap);
 export const updateConfig = (name: string, value: string) => Network.post("/config/update-single", { name, value });
+// 站点配置项定义（类型、取值范围，管理员）
+export const getConfigSchema = () => Network.post("/config/schema");
+// 服务端配置（配置文件 + 环境变量，密钥已隐藏，只读）
+export const getServerConfig = () => Network.post("/config/server");
 
 
 // 验证码相关接口
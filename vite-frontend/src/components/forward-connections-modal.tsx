@@ -0,0 +1,134 @@
+import { useEffect, useRef, useState } from "react";
+import { Modal, ModalContent, ModalHeader, ModalBody, ModalFooter } from "@heroui/modal";
+import { Button } from "@heroui/button";
+import { Chip } from "@heroui/chip";
+import { forwardConnectionsWsUrl } from "@/api";
+
+interface ConnInfo {
+  client: string;
+  target: string;
+  rxBytes?: number;
+  txBytes?: number;
+  observedS: number;
+}
+
+interface ConnsData {
+  service: string;
+  port: number;
+  source: string;
+  total: number;
+  conns: ConnInfo[];
+  upstreams: ConnInfo[];
+  stats?: { currentConns: number; totalConns: number; inputBytes: number; outputBytes: number; totalErrs: number };
+  timeMs: number;
+}
+
+const fmtBytes = (n?: number) => {
+  if (n == null) return '-';
+  if (n < 1024) return `${n} B`;
+  const u = ['KB', 'MB', 'GB', 'TB']; let v = n / 1024; let i = 0;
+  while (v >= 1024 && i < u.length - 1) { v /= 1024; i++; }
+  return `${v.toFixed(1)} ${u[i]}`;
+};
+
+const fmtDur = (s: number) => {
+  if (s < 60) return `${s}秒`;
+  if (s < 3600) return `${Math.floor(s / 60)}分${s % 60}秒`;
+  return `${Math.floor(s / 3600)}小时${Math.floor((s % 3600) / 60)}分`;
+};
+
+// 转发实时连接：打开时订阅 /forward/connections/ws，关闭时断开
+export default function ForwardConnectionsModal({ forwardId, name, onClose }: { forwardId: number | null; name?: string; onClose: () => void }) {
+  const [data, setData] = useState<ConnsData | null>(null);
+  const [error, setError] = useState('');
+  const [live, setLive] = useState(false);
+  const wsRef = useRef<WebSocket | null>(null);
+
+  useEffect(() => {
+    setData(null); setError('');
+    if (!forwardId) return;
+    const ws = new WebSocket(forwardConnectionsWsUrl(forwardId));
+    wsRef.current = ws;
+    ws.onopen = () => setLive(true);
+    ws.onclose = () => setLive(false);
+    ws.onmessage = (ev) => {
+      try {
+        const msg = JSON.parse(ev.data);
+        if (msg.type !== 'forward_conns') return;
+        if (msg.error) { setError(msg.error); return; }
+        setError(''); setData(msg.data);
+      } catch {}
+    };
+    return () => { try { ws.close(); } catch {} wsRef.current = null; };
+  }, [forwardId]);
+
+  const rows = (list: ConnInfo[], clientLabel: string, targetLabel: string) => (
+    <div className="overflow-x-auto">
+      <table className="w-full text-xs font-mono">
+        <thead>
+          <tr className="text-default-500 text-left">
+            <th className="py-1 pr-3">{clientLabel}</th>
+            <th className="py-1 pr-3">{targetLabel}</th>
+            <th className="py-1 pr-3">接收</th>
+            <th className="py-1 pr-3">发送</th>
+            <th className="py-1" title="Agent 首次看到该连接以来的时间，Agent 重启后重新计时">已观察</th>
+          </tr>
+        </thead>
+        <tbody>
+          {list.map((c, i) => (
+            <tr key={`${c.client}-${c.target}-${i}`} className="border-t border-divider">
+              <td className="py-1 pr-3">{c.client}</td>
+              <td className="py-1 pr-3 break-all">{c.target || '-'}</td>
+              <td className="py-1 pr-3">{fmtBytes(c.rxBytes)}</td>
+              <td className="py-1 pr-3">{fmtBytes(c.txBytes)}</td>
+              <td className="py-1">{fmtDur(c.observedS)}</td>
+            </tr>
+          ))}
+          {list.length === 0 && (
+            <tr><td colSpan={5} className="py-3 text-center text-default-400">暂无连接</td></tr>
+          )}
+        </tbody>
+      </table>
+    </div>
+  );
+
+  return (
+    <Modal isOpen={!!forwardId} onClose={onClose} size="4xl" scrollBehavior="inside">
+      <ModalContent>
+        {(close) => (
+          <>
+            <ModalHeader className="flex items-center gap-2">
+              实时连接{name ? ` - ${name}` : ''}
+              <Chip size="sm" variant="flat" color={live ? 'success' : 'default'}>{live ? '实时' : '未连接'}</Chip>
+            </ModalHeader>
+            <ModalBody>
+              {error && <div className="text-danger text-sm">{error}</div>}
+              {data && (
+                <div className="space-y-4">
+                  <div className="text-xs text-default-500">
+                    服务 {data.service} · 端口 {data.port} · 客户端连接 {data.total}
+                    {data.stats && ` · gost 当前 ${data.stats.currentConns} / 累计 ${data.stats.totalConns} · 入 ${fmtBytes(data.stats.inputBytes)} 出 ${fmtBytes(data.stats.outputBytes)}`}
+                    {data.source === 'proc' && ' · 节点无 ss 命令，无单连接流量'}
+                    {' · '}{new Date(data.timeMs).toLocaleTimeString()}
+                  </div>
+                  <div>
+                    <div className="font-semibold text-sm mb-1">客户端</div>
+                    {rows(data.conns, '客户端', '转发目标')}
+                  </div>
+                  <div>
+                    <div className="font-semibold text-sm mb-1">上游</div>
+                    {rows(data.upstreams, '本地地址', '上游地址')}
+                  </div>
+                </div>
+              )}
+              {!data && !error && <div className="text-default-400 text-sm">加载中...</div>}
+            </ModalBody>
+            <ModalFooter>
+              <Button variant="light" onPress={close}>关闭</Button>
+            </ModalFooter>
+          </>
+        )}
+      </ModalContent>
+    </Modal>
+  );
+}
@@ -0,0 +1,218 @@
+import { useEffect, useState } from "react";
+import { Modal, ModalContent, ModalHeader, ModalBody, ModalFooter } from "@heroui/modal";
+import { Button } from "@heroui/button";
+import { Select, SelectItem } from "@heroui/select";
+import { Chip } from "@heroui/chip";
+import toast from 'react-hot-toast';
+import { deleteNode, previewNodeDelete } from "@/api";
+
+const roleLabel: Record<string, string> = { entry: '入口', mid: '中间节点', exit: '出口' };
+
+const modeOptions = [
+  { key: 'refuse', label: '仅在无依赖时删除' },
+  { key: 'migrate', label: '迁移到替代节点' },
+  { key: 'cascade', label: '级联删除' },
+];
+
+const dataLabel: Record<string, string> = {
+  exit_setting: '出口服务设置',
+  port_allocation: '端口登记',
+  node_probe_result: '探测结果',
+  node_probe_rollup: '探测汇总',
+  node_sysinfo: '系统指标',
+  node_sysinfo_rollup: '系统指标汇总',
+  node_disconnect_log: '离线记录',
+  node_runtime: '运行时信息',
+  bus_node_route: '副本路由',
+  flow_report: '流量上报去重',
+};
+
+interface Impact {
+  online: boolean;
+  blocking: boolean;
+  tunnels: Array<{ id: number; name: string; type: number; role: string; forwards: number }>;
+  forwards: Array<{ id: number; name: string; userName: string; tunnelName: string; inPort: number; role: string }>;
+  hops: Array<{ tunnelId: number; tunnelName: string; role: string; inx: number; port: number; interface: string; bindIp: string }>;
+  alertRules: Array<{ id: number; name: string; only: boolean }>;
+  data: Record<string, number>;
+}
+
+// 删除节点：预览依赖的隧道/转发/路径，选择拒绝、迁移或级联
+export default function NodeDeleteModal({ node, nodes, onClose, onDeleted }: {
+  node: { id: number; name: string } | null;
+  nodes: Array<{ id: number; name: string }>;
+  onClose: () => void;
+  onDeleted: (id: number) => void;
+}) {
+  const [impact, setImpact] = useState<Impact | null>(null);
+  const [loading, setLoading] = useState(false);
+  const [mode, setMode] = useState('refuse');
+  const [replaceId, setReplaceId] = useState('');
+  const [uninstall, setUninstall] = useState(false);
+  const [deleting, setDeleting] = useState(false);
+  const [results, setResults] = useState<Array<{ forwardId: number; name: string; action: string; ok: boolean; msg?: string }> | null>(null);
+
+  useEffect(() => {
+    setImpact(null); setMode('refuse'); setReplaceId(''); setUninstall(false); setResults(null);
+    if (!node) return;
+    setLoading(true);
+    previewNodeDelete(node.id)
+      .then((res: any) => { if (res.code === 0) setImpact(res.data); else toast.error(res.msg || '获取依赖失败'); })
+      .catch(() => toast.error('获取依赖失败'))
+      .finally(() => setLoading(false));
+  }, [node?.id]);
+
+  const confirm = async () => {
+    if (!node) return;
+    if (mode === 'migrate' && !replaceId) { toast.error('请选择替代节点'); return; }
+    setDeleting(true);
+    try {
+      const res: any = await deleteNode(node.id, uninstall, mode, replaceId ? Number(replaceId) : undefined);
+      if (res.code !== 0) { toast.error(res.msg || '删除失败'); return; }
+      onDeleted(node.id);
+      const list = res.data?.forwards || [];
+      if (res.data?.failed > 0) {
+        toast.error(`节点已删除，${res.data.failed} 条转发下发失败`);
+        setResults(list);
+        return;
+      }
+      // 迁移后端口被占用而改用新端口的转发，需通知用户修改客户端
+      if (list.some((r: any) => r.msg)) {
+        toast.success('删除成功，部分转发端口已变更');
+        setResults(list);
+        return;
+      }
+      toast.success('删除成功');
+      onClose();
+    } catch {
+      toast.error('网络错误，请重试');
+    } finally {
+      setDeleting(false);
+    }
+  };
+
+  const actionLabel: Record<string, string> = { migrated: '已迁移', redeployed: '已重新下发', deleted: '已删除' };
+
+  return (
+    <Modal isOpen={!!node} onClose={onClose} size="3xl" scrollBehavior="inside" backdrop="blur" placement="center">
+      <ModalContent>
+        {(close) => (
+          <>
+            <ModalHeader className="flex flex-col gap-1">
+              <h2 className="text-xl font-bold">删除节点 "{node?.name}"</h2>
+            </ModalHeader>
+            <ModalBody>
+              {results ? (
+                <div className="space-y-1 text-sm">
+                  {results.map(r => (
+                    <div key={r.forwardId} className="flex items-center gap-2">
+                      <Chip size="sm" variant="flat" color={r.ok ? 'success' : 'danger'}>{r.ok ? (actionLabel[r.action] || r.action) : '失败'}</Chip>
+                      <span>{r.name}</span>
+                      {r.msg && <span className="text-xs text-default-500">{r.msg}</span>}
+                    </div>
+                  ))}
+                  <p className="text-xs text-default-500 mt-2">失败的转发可在节点上线后编辑保存以重新下发。</p>
+                </div>
+              ) : loading || !impact ? (
+                <div className="text-sm text-default-500">{loading ? '正在分析依赖...' : ''}</div>
+              ) : (
+                <div className="space-y-3 text-sm">
+                  {!impact.online && <Chip size="sm" color="warning" variant="flat">节点离线：其上的服务无法清理</Chip>}
+                  {impact.tunnels.length === 0 ? (
+                    <p className="text-default-500">没有隧道或转发依赖该节点。</p>
+                  ) : (
+                    <div>
+                      <div className="font-medium mb-1">隧道（{impact.tunnels.length}）</div>
+                      {impact.tunnels.map(t => (
+                        <div key={t.id} className="flex items-center gap-2">
+                          <Chip size="sm" variant="flat">{roleLabel[t.role] || t.role}</Chip>
+                          <span>{t.name}</span>
+                          <span className="text-xs text-default-500">{t.forwards} 条转发</span>
+                        </div>
+                      ))}
+                    </div>
+                  )}
+                  {impact.forwards.length > 0 && (
+                    <div>
+                      <div className="font-medium mb-1">转发（{impact.forwards.length}）</div>
+                      <div className="max-h-40 overflow-auto text-xs space-y-0.5">
+                        {impact.forwards.map(f => (
+                          <div key={f.id}>{f.name} · {f.tunnelName} · :{f.inPort}{f.userName ? ` · ${f.userName}` : ''}</div>
+                        ))}
+                      </div>
+                    </div>
+                  )}
+                  {impact.hops.length > 0 && (
+                    <div>
+                      <div className="font-medium mb-1">路径节点设置（{impact.hops.length}）</div>
+                      <div className="text-xs space-y-0.5">
+                        {impact.hops.map(h => (
+                          <div key={`${h.tunnelId}-${h.role}-${h.inx}`}>
+                            {h.tunnelName} · {roleLabel[h.role] || h.role}{h.role === 'mid' ? ` #${h.inx + 1}` : ''}
+                            {h.port ? ` · 端口 ${h.port}` : ''}{h.interface ? ` · 出站 ${h.interface}` : ''}{h.bindIp ? ` · 监听 ${h.bindIp}` : ''}
+                          </div>
+                        ))}
+                      </div>
+                    </div>
+                  )}
+                  {impact.alertRules.length > 0 && (
+                    <div className="text-xs">
+                      告警规则：{impact.alertRules.map(r => r.only ? `${r.name}（将停用）` : r.name).join('、')}
+                    </div>
+                  )}
+                  {Object.keys(impact.data || {}).length > 0 && (
+                    <div className="text-xs text-default-500">
+                      将一并删除：{Object.entries(impact.data).map(([k, v]) => `${dataLabel[k] || k} ${v}`).join('、')}
+                    </div>
+                  )}
+                  <div className="grid grid-cols-1 md:grid-cols-2 gap-3">
+                    <Select
+                      label="删除方式"
+                      size="sm"
+                      selectedKeys={[mode]}
+                      onSelectionChange={(keys) => setMode((Array.from(keys)[0] as string) || 'refuse')}
+                    >
+                      {modeOptions.map(o => <SelectItem key={o.key}>{o.label}</SelectItem>)}
+                    </Select>
+                    {mode === 'migrate' && (
+                      <Select
+                        label="替代节点"
+                        size="sm"
+                        selectedKeys={replaceId ? [replaceId] : []}
+                        onSelectionChange={(keys) => setReplaceId((Array.from(keys)[0] as string) || '')}
+                      >
+                        {nodes.filter(n => n.id !== node?.id).map(n => <SelectItem key={String(n.id)}>{n.name}</SelectItem>)}
+                      </Select>
+                    )}
+                  </div>
+                  {mode === 'refuse' && impact.blocking && (
+                    <p className="text-xs text-danger">该节点仍被使用，请选择迁移或级联删除。</p>
+                  )}
+                  {mode === 'migrate' && (
+                    <p className="text-xs text-default-500">隧道中该节点的入口/出口/中间节点角色改由替代节点承担，相关转发重新下发；出站接口与监听IP需重新设置。</p>
+                  )}
+                  {mode === 'cascade' && (
+                    <p className="text-xs text-danger">以该节点为入口或出口的隧道将连同转发、用户权限、限速规则一并删除；作为中间节点时从路径中移除并重新下发转发。</p>
+                  )}
+                  <label className="flex items-center gap-2 text-sm">
+                    <input type="checkbox" checked={uninstall} onChange={(e) => setUninstall((e.target as any).checked)} />
+                    同步卸载节点上的 Agent（自我卸载）
+                  </label>
+                </div>
+              )}
+            </ModalBody>
+            <ModalFooter>
+              <Button variant="light" onPress={close}>{results ? '关闭' : '取消'}</Button>
+              {!results && (
+                <Button color="danger" onPress={confirm} isLoading={deleting}
+                  isDisabled={!impact || (mode === 'refuse' && impact.blocking)}>
+                  {deleting ? '删除中...' : '确认删除'}
+                </Button>
+              )}
+            </ModalFooter>
+          </>
+        )}
+      </ModalContent>
+    </Modal>
+  );
+}
@@ -0,0 +1,131 @@
+import { useEffect, useState } from "react";
+import { Modal, ModalContent, ModalHeader, ModalBody, ModalFooter } from "@heroui/modal";
+import { Button } from "@heroui/button";
+import { Chip } from "@heroui/chip";
+import toast from 'react-hot-toast';
+import { getNodeDrift, applyNodeDrift, adoptNodeDrift } from "@/api";
+
+interface Drift {
+  online: boolean;
+  answered: boolean;
+  inSync: boolean;
+  missing: string[];
+  extra: string[];
+  mismatched: Array<{ name: string; fields: Array<{ field: string; desired: string; actual: string }> }>;
+  checkedAt: number;
+}
+
+const kindLabel: Record<string, { label: string; color: 'warning' | 'secondary' | 'danger' }> = {
+  missing: { label: '节点缺失', color: 'danger' },
+  extra: { label: '节点多余', color: 'secondary' },
+  mismatched: { label: '配置不一致', color: 'warning' },
+};
+
+// 配置漂移：对比面板期望服务与节点 gost.json，可按服务或整节点以面板为准/以节点为准
+export default function NodeDriftModal({ nodeId, name, onClose }: { nodeId: number | null; name?: string; onClose: () => void }) {
+  const [drift, setDrift] = useState<Drift | null>(null);
+  const [loading, setLoading] = useState(false);
+  const [busy, setBusy] = useState('');
+
+  const load = async () => {
+    if (!nodeId) return;
+    setLoading(true);
+    try {
+      const res: any = await getNodeDrift(nodeId);
+      if (res.code === 0 && Array.isArray(res.data) && res.data.length > 0) setDrift(res.data[0]);
+      else toast.error(res.msg || '获取漂移报告失败');
+    } catch {
+      toast.error('获取漂移报告失败');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  useEffect(() => {
+    setDrift(null);
+    if (nodeId) load();
+  }, [nodeId]);
+
+  // services 为空表示整节点
+  const run = async (action: 'apply' | 'adopt', services?: string[]) => {
+    if (!nodeId) return;
+    setBusy(action + (services ? services.join(',') : ''));
+    try {
+      const res: any = action === 'apply' ? await applyNodeDrift(nodeId, services) : await adoptNodeDrift(nodeId, services);
+      if (res.code !== 0) { toast.error(res.msg || '操作失败'); return; }
+      const d = res.data || {};
+      if (action === 'apply') toast.success(`已下发 ${d.pushed || 0} 个，删除 ${d.removed || 0} 个`);
+      else toast.success(`已回写 ${d.adopted || 0} 个，解除托管 ${d.released || 0} 个${d.skipped?.length ? `，跳过 ${d.skipped.length} 个` : ''}`);
+      await load();
+    } catch {
+      toast.error('网络错误，请重试');
+    } finally {
+      setBusy('');
+    }
+  };
+
+  const rows = drift ? [
+    ...drift.missing.map(n => ({ kind: 'missing', name: n, fields: [] as Drift['mismatched'][number]['fields'] })),
+    ...drift.mismatched.map(m => ({ kind: 'mismatched', name: m.name, fields: m.fields })),
+    ...drift.extra.map(n => ({ kind: 'extra', name: n, fields: [] as Drift['mismatched'][number]['fields'] })),
+  ] : [];
+
+  return (
+    <Modal isOpen={!!nodeId} onClose={onClose} size="3xl" scrollBehavior="inside" backdrop="blur" placement="center">
+      <ModalContent>
+        {(close) => (
+          <>
+            <ModalHeader className="flex flex-col gap-1">
+              <h2 className="text-xl font-bold">配置漂移 - {name}</h2>
+              {drift && <p className="text-xs text-default-500">检查时间 {new Date(drift.checkedAt).toLocaleString()}</p>}
+            </ModalHeader>
+            <ModalBody>
+              {loading && !drift ? (
+                <div className="text-sm text-default-500">正在对比节点配置...</div>
+              ) : !drift ? null : !drift.answered ? (
+                <Chip size="sm" color="warning" variant="flat">{drift.online ? '节点未响应，无法对比' : '节点离线，无法对比'}</Chip>
+              ) : drift.inSync ? (
+                <Chip size="sm" color="success" variant="flat">节点配置与面板一致</Chip>
+              ) : (
+                <div className="space-y-2 text-sm">
+                  {rows.map(r => (
+                    <div key={`${r.kind}-${r.name}`} className="border border-divider rounded-md p-2">
+                      <div className="flex items-center gap-2">
+                        <Chip size="sm" variant="flat" color={kindLabel[r.kind].color}>{kindLabel[r.kind].label}</Chip>
+                        <span className="font-mono text-xs flex-1 truncate">{r.name}</span>
+                        <Button size="sm" variant="flat" color="primary" isLoading={busy === 'apply' + r.name}
+                          onPress={() => run('apply', [r.name])}>以面板为准</Button>
+                        <Button size="sm" variant="flat" isLoading={busy === 'adopt' + r.name}
+                          isDisabled={r.kind === 'missing'} onPress={() => run('adopt', [r.name])}>以节点为准</Button>
+                      </div>
+                      {r.fields.length > 0 && (
+                        <div className="mt-1 text-xs font-mono space-y-0.5">
+                          {r.fields.map(f => (
+                            <div key={f.field}>
+                              <span className="text-default-500">{f.field}</span>: <span className="text-success">{f.desired || '(空)'}</span> → <span className="text-danger">{f.actual || '(空)'}</span>
+                            </div>
+                          ))}
+                        </div>
+                      )}
+                    </div>
+                  ))}
+                  <p className="text-xs text-default-500">以面板为准：补齐缺失、覆盖不一致、删除多余服务并重启 gost；以节点为准：不一致的转发回写数据库，多余服务解除面板托管，缺失的服务无法回写。</p>
+                </div>
+              )}
+            </ModalBody>
+            <ModalFooter>
+              <Button variant="light" onPress={close}>关闭</Button>
+              <Button variant="flat" onPress={load} isLoading={loading}>重新检查</Button>
+              {drift?.answered && !drift.inSync && (
+                <>
+                  <Button variant="flat" isLoading={busy === 'adopt'} onPress={() => run('adopt')}>全部以节点为准</Button>
+                  <Button color="primary" isLoading={busy === 'apply'} onPress={() => run('apply')}>全部以面板为准</Button>
+                </>
+              )}
+            </ModalFooter>
+          </>
+        )}
+      </ModalContent>
+    </Modal>
+  );
+}
@@ -0,0 +1,114 @@
+import { useEffect, useState } from "react";
+import { Modal, ModalContent, ModalHeader, ModalBody, ModalFooter } from "@heroui/modal";
+import { Button } from "@heroui/button";
+import { Input } from "@heroui/input";
+import { Select, SelectItem } from "@heroui/select";
+import { Chip } from "@heroui/chip";
+import toast from 'react-hot-toast';
+import { getNodeLogs } from "@/api";
+
+const unitOptions = [
+  { key: 'agent', label: 'flux-agent' },
+  { key: 'agent2', label: 'flux-agent2' },
+  { key: 'gost', label: 'gost' },
+];
+
+const sinceOptions = [
+  { key: '', label: '不限' },
+  { key: '-15min', label: '最近 15 分钟' },
+  { key: '-1h', label: '最近 1 小时' },
+  { key: '-6h', label: '最近 6 小时' },
+  { key: '-24h', label: '最近 24 小时' },
+  { key: 'today', label: '今天' },
+];
+
+// 节点远程日志：通过 Agent 读取 journalctl，支持正则过滤
+export default function NodeLogsModal({ nodeId, name, onClose }: { nodeId: number | null; name?: string; onClose: () => void }) {
+  const [units, setUnits] = useState<string[]>(['agent', 'gost']);
+  const [lines, setLines] = useState('200');
+  const [since, setSince] = useState('');
+  const [grep, setGrep] = useState('');
+  const [loading, setLoading] = useState(false);
+  const [result, setResult] = useState<{ lines: string[]; bytes: number; truncated: boolean; incomplete: boolean } | null>(null);
+
+  const load = async () => {
+    if (!nodeId) return;
+    setLoading(true);
+    try {
+      const res = await getNodeLogs({ id: nodeId, units, lines: parseInt(lines) || 200, since: since || undefined, grep: grep || undefined });
+      if (res.code === 0) setResult(res.data);
+      else toast.error(res.msg || '获取日志失败');
+    } catch {
+      toast.error('获取日志失败');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  useEffect(() => {
+    setResult(null);
+    if (nodeId) load();
+  }, [nodeId]);
+
+  const copy = async () => {
+    try {
+      await navigator.clipboard.writeText((result?.lines || []).join('\n'));
+      toast.success('已复制');
+    } catch {
+      toast.error('复制失败');
+    }
+  };
+
+  return (
+    <Modal isOpen={!!nodeId} onClose={onClose} size="5xl" scrollBehavior="inside">
+      <ModalContent>
+        {(close) => (
+          <>
+            <ModalHeader>节点日志{name ? ` - ${name}` : ''}</ModalHeader>
+            <ModalBody>
+              <div className="flex flex-wrap gap-2 items-end">
+                <Select
+                  label="服务"
+                  size="sm"
+                  selectionMode="multiple"
+                  className="w-56"
+                  selectedKeys={new Set(units)}
+                  onSelectionChange={(keys) => setUnits(Array.from(keys as Set<string>))}
+                >
+                  {unitOptions.map(u => <SelectItem key={u.key}>{u.label}</SelectItem>)}
+                </Select>
+                <Select
+                  label="时间"
+                  size="sm"
+                  className="w-40"
+                  selectedKeys={new Set([since])}
+                  onSelectionChange={(keys) => setSince((Array.from(keys as Set<string>)[0]) || '')}
+                >
+                  {sinceOptions.map(o => <SelectItem key={o.key}>{o.label}</SelectItem>)}
+                </Select>
+                <Input label="行数" size="sm" type="number" className="w-28" value={lines} onValueChange={setLines} />
+                <Input label="过滤（正则）" size="sm" className="flex-1 min-w-40" value={grep} onValueChange={setGrep}
+                  onKeyDown={(e) => { if (e.key === 'Enter') load(); }} />
+                <Button color="primary" size="sm" onPress={load} isLoading={loading}>查询</Button>
+              </div>
+              {result && (
+                <div className="flex gap-2 items-center text-xs text-default-500">
+                  <span>{result.lines.length} 行 · {(result.bytes / 1024).toFixed(1)} KB</span>
+                  {result.truncated && <Chip size="sm" color="warning" variant="flat">已截断，仅显示最新部分</Chip>}
+                  {result.incomplete && <Chip size="sm" color="danger" variant="flat">部分数据丢失</Chip>}
+                </div>
+              )}
+              <pre className="text-xs font-mono bg-default-100 rounded p-2 whitespace-pre-wrap break-all min-h-40">
+                {result ? (result.lines.length ? result.lines.join('\n') : '无日志') : (loading ? '加载中...' : '')}
+              </pre>
+            </ModalBody>
+            <ModalFooter>
+              <Button variant="flat" onPress={copy} isDisabled={!result?.lines.length}>复制</Button>
+              <Button variant="light" onPress={close}>关闭</Button>
+            </ModalFooter>
+          </>
+        )}
+      </ModalContent>
+    </Modal>
+  );
+}
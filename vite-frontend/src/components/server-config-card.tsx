@@ -0,0 +1,62 @@
+import { useEffect, useState } from "react";
+import { Card, CardBody, CardHeader } from "@heroui/card";
+import { Divider } from "@heroui/divider";
+import { Chip } from "@heroui/chip";
+import { getServerConfig } from "@/api";
+
+const sections: { key: string; label: string }[] = [
+  { key: 'server', label: '服务' },
+  { key: 'database', label: '数据库' },
+  { key: 'auth', label: '认证' },
+  { key: 'log', label: '日志' },
+  { key: 'bus', label: '多副本' },
+  { key: 'metrics', label: '监控指标' },
+];
+
+// 服务端配置（只读）：来自配置文件与环境变量，修改后需重启面板
+export default function ServerConfigCard() {
+  const [data, setData] = useState<{ config: any; warnings: string[] | null } | null>(null);
+
+  useEffect(() => {
+    getServerConfig().then((res: any) => {
+      if (res.code === 0) setData(res.data);
+    }).catch(() => {});
+  }, []);
+
+  if (!data) return null;
+  const cfg = data.config || {};
+  const show = (v: any) => (v === '' || v === undefined || v === null ? '-' : String(v));
+
+  return (
+    <Card className="shadow-md mt-4">
+      <CardHeader className="pb-4">
+        <div>
+          <h2 className="text-xl font-semibold">服务端配置</h2>
+          <p className="text-sm text-gray-600 dark:text-gray-400">
+            {cfg.file ? `配置文件 ${cfg.file}` : '未使用配置文件'}
+            {cfg.env?.length ? `，环境变量覆盖：${cfg.env.join(', ')}` : ''}。只读，修改后需重启面板
+          </p>
+        </div>
+      </CardHeader>
+      <Divider />
+      <CardBody className="space-y-4 pt-4">
+        {(data.warnings || []).map((w, i) => (
+          <Chip key={i} size="sm" color="warning" variant="flat">{w}</Chip>
+        ))}
+        {sections.map(s => (
+          <div key={s.key}>
+            <div className="text-sm font-medium mb-1">{s.label}</div>
+            <div className="grid grid-cols-2 gap-x-4 gap-y-1 text-xs font-mono">
+              {Object.entries(cfg[s.key] || {}).map(([k, v]) => (
+                <div key={k} className="flex gap-2">
+                  <span className="text-default-500">{k}</span>
+                  <span className="break-all">{show(v)}</span>
+                </div>
+              ))}
+            </div>
+          </div>
+        ))}
+      </CardBody>
+    </Card>
+  );
+}
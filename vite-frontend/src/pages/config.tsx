@@ -10,6 +10,7 @@ import { Select, SelectItem } from "@heroui/select";
 import toast from 'react-hot-toast';
 import { updateConfigs } from '@/api';
 import { SettingsIcon } from '@/components/icons';
+import ServerConfigCard from '@/components/server-config-card';
 
 import { isAdmin } from '@/utils/auth';
 import { getCachedConfigs, clearConfigCache, updateSiteConfig } from '@/config/site';
@@ -410,6 +411,8 @@ export default function ConfigPage() {
           </CardBody>
         </Card>
 
+        <ServerConfigCard />
+
         {/* 操作提示 */}
         {hasChanges && (
           <Card className="mt-4 bg-warning-50 dark:bg-warning-900/20 border-warning-200 dark:border-warning-800">
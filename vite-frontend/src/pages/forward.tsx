@@ -51,6 +51,7 @@ import {
   setTunnelBind,
 } from "@/api";
 import { JwtUtil } from "@/utils/jwt";
+import ForwardConnectionsModal from "@/components/forward-connections-modal";
 
 interface Forward {
   id: number;
@@ -176,6 +177,7 @@ export default function ForwardPage() {
   const [forwardToDelete, setForwardToDelete] = useState<Forward | null>(null);
   const [currentDiagnosisForward, setCurrentDiagnosisForward] = useState<Forward | null>(null);
   const [diagnosisResult, setDiagnosisResult] = useState<DiagnosisResult | null>(null);
+  const [connsForward, setConnsForward] = useState<Forward | null>(null);
   const [addressModalTitle, setAddressModalTitle] = useState('');
   const [addressList, setAddressList] = useState<AddressItem[]>([]);
   
@@ -1409,6 +1411,20 @@ export default function ForwardPage() {
             >
               诊断
             </Button>
+            <Button
+              size="sm"
+              variant="flat"
+              color="secondary"
+              onPress={() => setConnsForward(forward)}
+              className="flex-1 min-h-8"
+              startContent={
+                <svg className="w-3 h-3" fill="currentColor" viewBox="0 0 20 20">
+                  <path d="M2 11a1 1 0 011-1h2a1 1 0 011 1v5a1 1 0 01-1 1H3a1 1 0 01-1-1v-5zM8 7a1 1 0 011-1h2a1 1 0 011 1v9a1 1 0 01-1 1H9a1 1 0 01-1-1V7zM14 4a1 1 0 011-1h2a1 1 0 011 1v12a1 1 0 01-1 1h-2a1 1 0 01-1-1V4z" />
+                </svg>
+              }
+            >
+              连接
+            </Button>
             <Button
               size="sm"
               variant="flat"
@@ -2332,6 +2348,13 @@ export default function ForwardPage() {
             )}
           </ModalContent>
         </Modal>
+
+        {/* 实时连接 */}
+        <ForwardConnectionsModal
+          forwardId={connsForward?.id ?? null}
+          name={connsForward?.name}
+          onClose={() => setConnsForward(null)}
+        />
       </div>
     
   );
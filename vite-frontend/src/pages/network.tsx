@@ -29,6 +29,9 @@ export default function NetworkPage() {
   const [loading, setLoading] = useState(false);
   const chartRef = useRef<HTMLDivElement>(null);
   const chartInstanceRef = useRef<any>(null);
+  const [sysSeries, setSysSeries] = useState<any[]>([]);
+  const sysChartRef = useRef<HTMLDivElement>(null);
+  const sysChartInstanceRef = useRef<any>(null);
 
   // Ensure chart is disposed when leaving detail view
   useEffect(() => {
@@ -42,9 +45,10 @@ export default function NetworkPage() {
     setLoading(true);
     try {
       if (params.id) {
-        const res = await getNodeNetworkStats(nodeId, range);
+        const [res, sys] = await Promise.all([getNodeNetworkStats(nodeId, range), getNodeSysinfo(nodeId, range)]);
         if (res.code === 0) setData(res.data || { results: [], disconnects: [], sla: 0 });
         else toast.error(res.msg || '加载失败');
+        if (sys.code === 0) setSysSeries(Array.isArray(sys.data) ? sys.data : []);
       } else {
         const [l, b] = await Promise.all([getNodeList(), getNodeNetworkStatsBatch(range)]);
         if (l.code === 0) {
@@ -114,7 +118,7 @@ export default function NetworkPage() {
           name: `${label} 丢包%`,
           showSymbol: false,
           yAxisIndex: 1,
-          data: arr.map((it:any)=>[it.timeMs, it.ok? 0 : 100])
+          data: arr.map((it:any)=>[it.timeMs, it.loss ?? (it.ok? 0 : 100)])
         });
       });
       chartInstanceRef.current.setOption({
@@ -145,6 +149,58 @@ export default function NetworkPage() {
     };
   }, [grouped, data.targets]);
 
+  // host and gost health of the node (load, gost cpu/memory, connections, conntrack)
+  useEffect(() => {
+    const render = async () => {
+      if (!sysChartRef.current) return;
+      const echarts = await import('echarts');
+      if (sysChartInstanceRef.current) {
+        try { sysChartInstanceRef.current.dispose(); } catch {}
+      }
+      sysChartInstanceRef.current = echarts.init(sysChartRef.current);
+      const line = (name:string, yAxisIndex:number, fn:(it:any)=>any) => ({
+        type: 'line', sampling: 'lttb', name, showSymbol: false, yAxisIndex,
+        data: sysSeries.map((it:any)=>[it.timeMs, fn(it)])
+      });
+      sysChartInstanceRef.current.setOption({
+        tooltip: { trigger: 'axis' },
+        legend: { type: 'scroll' },
+        dataZoom: [
+          { type: 'inside', throttle: 50 },
+          { type: 'slider', height: 20 }
+        ],
+        xAxis: { type: 'time' },
+        yAxis: [
+          { type: 'value', name: '负载/%' },
+          { type: 'value', name: '连接数' }
+        ],
+        series: [
+          line('负载(1m)', 0, (it)=>it.load1 ?? null),
+          line('gost CPU%', 0, (it)=>it.gostCpu ?? null),
+          line('gost 内存MB', 0, (it)=>it.gostRss ? +(it.gostRss/1048576).toFixed(1) : null),
+          line('磁盘%', 0, (it)=>it.diskTotal ? +(it.diskUsed*100/it.diskTotal).toFixed(1) : null),
+          line('TCP ESTABLISHED', 1, (it)=>it.tcp?.established ?? null),
+          line('TCP TIME_WAIT', 1, (it)=>it.tcp?.time_wait ?? null),
+          line('conntrack', 1, (it)=>it.conntrackMax ? it.conntrack : null),
+          line('gost 文件句柄', 1, (it)=>it.gostFdLimit ? it.gostFds : null),
+        ],
+        grid: { left: 40, right: 40, top: 40, bottom: 30 }
+      });
+      window.addEventListener('resize', handleResize);
+    };
+    const handleResize = () => { try { sysChartInstanceRef.current?.resize(); } catch {} };
+    render();
+    return () => {
+      window.removeEventListener('resize', handleResize);
+      if (sysChartInstanceRef.current) {
+        try { sysChartInstanceRef.current.dispose(); } catch {}
+        sysChartInstanceRef.current = null;
+      }
+    };
+  }, [sysSeries, params.id]);
+
+  const lastSys = sysSeries.length > 0 ? sysSeries[sysSeries.length-1] : null;
+
   return (
     <div className="px-4 py-6 space-y-4">
       <div className="flex items-center justify-between">
@@ -274,6 +330,22 @@ export default function NetworkPage() {
       </Card>
       )}
 
+      {params.id && (
+      <Card>
+        <CardHeader className="justify-between">
+          <div className="font-semibold">系统状态</div>
+          {lastSys && (
+            <div className="text-xs text-default-500 font-mono">
+              gost 重启 {lastSys.gostRestarts || 0} 次 · 句柄 {lastSys.gostFds || 0}/{lastSys.gostFdLimit || '-'} · 系统句柄 {lastSys.fdsOpen || 0}/{lastSys.fdsMax || '-'} · conntrack {lastSys.conntrack || 0}/{lastSys.conntrackMax || '-'}
+            </div>
+          )}
+        </CardHeader>
+        <CardBody>
+          <div className="h-[300px]" ref={sysChartRef} />
+        </CardBody>
+      </Card>
+      )}
+
       {params.id && (
       <Card>
         <CardHeader className="font-semibold">断联记录</CardHeader>
@@ -14,13 +14,15 @@ import { Divider } from "@heroui/divider";
 import { queryNodeServices, getNodeNetworkStatsBatch, getVersionInfo } from "@/api";
 import toast from 'react-hot-toast';
 import axios from 'axios';
+import NodeLogsModal from "@/components/node-logs-modal";
+import NodeDeleteModal from "@/components/node-delete-modal";
+import NodeDriftModal from "@/components/node-drift-modal";
 
 
 import { 
   createNode, 
   getNodeList, 
   updateNode, 
-  deleteNode,
   getNodeInstallCommand,
   setExitNode,
   getExitNode
@@ -70,10 +72,9 @@ export default function NodePage() {
   const [dialogTitle, setDialogTitle] = useState('');
   const [isEdit, setIsEdit] = useState(false);
   const [submitLoading, setSubmitLoading] = useState(false);
-  const [deleteModalOpen, setDeleteModalOpen] = useState(false);
-  const [deleteLoading, setDeleteLoading] = useState(false);
   const [nodeToDelete, setNodeToDelete] = useState<Node | null>(null);
-  const [deleteAlsoUninstall, setDeleteAlsoUninstall] = useState(false);
+  const [logsNode, setLogsNode] = useState<Node | null>(null);
+  const [driftNode, setDriftNode] = useState<Node | null>(null);
   const [form, setForm] = useState<NodeForm>({
     id: null,
     name: '',
@@ -638,32 +639,9 @@ export default function NodePage() {
     setDialogVisible(true);
   };
 
-  // 删除节点
+  // 删除节点（先预览依赖，再选择删除方式）
   const handleDelete = (node: Node) => {
     setNodeToDelete(node);
-    setDeleteAlsoUninstall(false);
-    setDeleteModalOpen(true);
-  };
-
-  const confirmDelete = async () => {
-    if (!nodeToDelete) return;
-    
-    setDeleteLoading(true);
-    try {
-      const res = await deleteNode(nodeToDelete.id, deleteAlsoUninstall);
-      if (res.code === 0) {
-        toast.success('删除成功');
-        setNodeList(prev => prev.filter(n => n.id !== nodeToDelete.id));
-        setDeleteModalOpen(false);
-        setNodeToDelete(null);
-      } else {
-        toast.error(res.msg || '删除失败');
-      }
-    } catch (error) {
-      toast.error('网络错误，请重试');
-    } finally {
-      setDeleteLoading(false);
-    }
   };
 
   // 复制安装命令
@@ -1048,6 +1026,24 @@ export default function NodePage() {
                       >
                         编辑
                       </Button>
+                      <Button
+                        size="sm"
+                        variant="flat"
+                        color="secondary"
+                        onPress={() => setLogsNode(node)}
+                        className="flex-1 min-h-8"
+                      >
+                        日志
+                      </Button>
+                      <Button
+                        size="sm"
+                        variant="flat"
+                        color="default"
+                        onPress={() => setDriftNode(node)}
+                        className="flex-1 min-h-8"
+                      >
+                        漂移
+                      </Button>
                       <Button
                         size="sm"
                         variant="flat"
@@ -1253,44 +1249,12 @@ export default function NodePage() {
         </Modal>
 
         {/* 删除确认模态框 */}
-        <Modal 
-          isOpen={deleteModalOpen}
-          onOpenChange={setDeleteModalOpen}
-          size="2xl"
-        scrollBehavior="outside"
-        backdrop="blur"
-        placement="center"
-        >
-          <ModalContent>
-            {(onClose) => (
-              <>
-                <ModalHeader className="flex flex-col gap-1">
-                  <h2 className="text-xl font-bold">确认删除</h2>
-                </ModalHeader>
-                <ModalBody>
-                  <p>确定要删除节点 <strong>"{nodeToDelete?.name}"</strong> 吗？</p>
-                  <p className="text-small text-default-500">此操作不可恢复，请谨慎操作。</p>
-                  <label className="flex items-center gap-2 text-sm mt-2">
-                    <input type="checkbox" checked={deleteAlsoUninstall} onChange={(e)=>setDeleteAlsoUninstall((e.target as any).checked)} />
-                    同步卸载节点上的 Agent（自我卸载）
-                  </label>
-                </ModalBody>
-                <ModalFooter>
-                  <Button variant="light" onPress={onClose}>
-                    取消
-                  </Button>
-                  <Button 
-                    color="danger" 
-                    onPress={confirmDelete}
-                    isLoading={deleteLoading}
-                  >
-                    {deleteLoading ? '删除中...' : '确认删除'}
-                  </Button>
-                </ModalFooter>
-              </>
-            )}
-          </ModalContent>
-        </Modal>
+        <NodeDeleteModal
+          node={nodeToDelete}
+          nodes={nodeList}
+          onClose={() => setNodeToDelete(null)}
+          onDeleted={(id) => setNodeList(prev => prev.filter(n => n.id !== id))}
+        />
 
         {/* 安装命令模态框 */}
         <Modal 
@@ -1345,6 +1309,20 @@ export default function NodePage() {
             </ModalFooter>
           </ModalContent>
         </Modal>
+
+        {/* 节点日志 */}
+        <NodeLogsModal
+          nodeId={logsNode?.id ?? null}
+          name={logsNode?.name}
+          onClose={() => setLogsNode(null)}
+        />
+
+        {/* 配置漂移 */}
+        <NodeDriftModal
+          nodeId={driftNode?.id ?? null}
+          name={driftNode?.name}
+          onClose={() => setDriftNode(null)}
+        />
       </div>
     
   );
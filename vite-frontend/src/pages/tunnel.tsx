@@ -105,6 +105,8 @@ export default function TunnelPage() {
   const [midIfaces, setMidIfaces] = useState<Record<number,string>>({});
   // 中间与出口节点的入站绑定IP（监听IP）
   const [midBindIps, setMidBindIps] = useState<Record<number,string>>({});
+  // 中间节点监听端口（留空自动分配）
+  const [midPorts, setMidPorts] = useState<Record<number,number>>({});
   const [exitBindIp, setExitBindIp] = useState<string>('');
   const [ifaceCache, setIfaceCache] = useState<Record<number,string[]>>({});
   
@@ -241,7 +243,7 @@ export default function TunnelPage() {
     setErrors({});
     setMidPath([]);
     setAddMidNodeId('');
-    setEntryIface(''); setMidIfaces({}); setIfaceCache({});
+    setEntryIface(''); setMidIfaces({}); setMidBindIps({}); setMidPorts({}); setIfaceCache({});
     setExitPort(null); setExitPassword(""); setExitMethod("AEAD_CHACHA20_POLY1305"); setExitObserver("console"); setExitLimiter(""); setExitRLimiter(""); setExitDeployed(""); setExitMetaItems([]);
     setModalOpen(true);
   };
@@ -266,8 +268,16 @@ export default function TunnelPage() {
     setErrors({});
     setMidPath([]);
     setAddMidNodeId('');
-    // 拉取路径
-    (async ()=>{ try { const { getTunnelPath } = await import('@/api'); const r:any = await getTunnelPath(tunnel.id); if (r.code===0 && Array.isArray(r.data?.path)) setMidPath(r.data.path); } catch {} })();
+    setMidPorts({});
+    // 拉取路径及每跳端口
+    (async ()=>{ try { const { getTunnelPath } = await import('@/api'); const r:any = await getTunnelPath(tunnel.id);
+      if (r.code===0 && Array.isArray(r.data?.path)) {
+        setMidPath(r.data.path);
+        const ports:Record<number,number> = {};
+        (r.data.path as number[]).forEach((nid, i)=>{ const pt = Number(r.data.ports?.[i]||0); if (pt>0) ports[nid] = pt; });
+        setMidPorts(ports);
+      }
+    } catch {} })();
     setExitPort(null); setExitPassword(""); setExitMethod("AEAD_CHACHA20_POLY1305"); setExitObserver("console"); setExitLimiter(""); setExitRLimiter(""); setExitDeployed(""); setExitMetaItems([]);
     setModalOpen(true);
     // 读取已保存的每节点接口IP
@@ -342,7 +352,7 @@ export default function TunnelPage() {
       if (response.code === 0) {
         // 保存多级路径、每节点出站接口IP、以及每节点监听IP（仅隧道转发）
         try {
-          const { setTunnelPath, getTunnelList, setTunnelIface, setTunnelBind } = await import('@/api');
+          const { setTunnelHops, getTunnelList, setTunnelIface, setTunnelBind } = await import('@/api');
           let tid = isEdit ? form.id : undefined;
           if (!tid) {
             const lr:any = await getTunnelList();
@@ -352,15 +362,16 @@ export default function TunnelPage() {
             }
           }
           if (form.type===2 && tid) {
-            if (midPath.length>0) await setTunnelPath(tid as number, midPath);
-            const ifaces: Array<{nodeId:number, ip:string}> = [];
-            if (form.inNodeId) ifaces.push({ nodeId: form.inNodeId, ip: entryIface||'' });
-            midPath.forEach(nid=>{ ifaces.push({ nodeId: nid, ip: midIfaces[nid]||'' }); });
-            if (ifaces.length>0) await setTunnelIface(tid as number, ifaces);
-            const binds: Array<{nodeId:number, ip:string}> = [];
-            midPath.forEach(nid=>{ binds.push({ nodeId: nid, ip: (midBindIps[nid]||'') }); });
-            if (form.outNodeId) binds.push({ nodeId: form.outNodeId, ip: (exitBindIp||'') });
-            if (binds.length>0) await setTunnelBind(tid as number, binds);
+            // 先保存入口出站接口与出口监听IP，路径变更时的重新下发才会用到新值
+            if (form.inNodeId) await setTunnelIface(tid as number, [{ nodeId: form.inNodeId, ip: entryIface||'' }]);
+            if (form.outNodeId) await setTunnelBind(tid as number, [{ nodeId: form.outNodeId, ip: (exitBindIp||'') }]);
+            // 中间节点整体保存（含端口/接口/监听IP），编辑时清空路径也需提交；未变化时后端不重新下发
+            if (midPath.length>0 || isEdit) {
+              const hops = midPath.map(nid=>({ nodeId: nid, port: midPorts[nid]||0, interface: midIfaces[nid]||'', bindIp: midBindIps[nid]||'' }));
+              const hr:any = await setTunnelHops(tid as number, hops);
+              if (hr && hr.code!==0) toast.error(hr.msg || '路径保存失败');
+              else if (hr?.data?.failed>0) toast.error(`路径已保存，${hr.data.failed} 条转发重新下发失败：${(hr.data.forwards||[]).filter((x:any)=>!x.ok).map((x:any)=>`${x.name}(${x.msg||''})`).join('；')}`);
+            }
           }
         } catch {}
         toast.success(isEdit ? '更新成功' : '创建成功');
@@ -979,7 +990,15 @@ export default function TunnelPage() {
                                       <Button size="sm" color="danger" variant="flat" onPress={()=> setMidPath(prev=>prev.filter(id=>id!==nid))}>移除</Button>
                                     </div>
                                   </div>
-                                  <div className="grid grid-cols-1 md:grid-cols-2 gap-3">
+                                  <div className="grid grid-cols-1 md:grid-cols-3 gap-3">
+                                    <Input
+                                      label="监听端口"
+                                      size="sm"
+                                      type="number"
+                                      placeholder="留空自动分配"
+                                      value={midPorts[nid] ? String(midPorts[nid]) : ''}
+                                      onChange={(e)=>{ const v = Number((e.target as any).value)||0; setMidPorts(prev=>({...prev, [nid]: v})); }}
+                                    />
                                     <Select
                                       aria-label="选择出站IP(接口)"
                                       label="出站IP(接口)"
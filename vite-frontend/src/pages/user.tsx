@@ -270,7 +270,8 @@ export default function UserPage() {
       flow: user.flow,
       num: user.num,
       expTime: user.expTime ? new Date(user.expTime) : null,
-      flowResetTime: user.flowResetTime ?? 0
+      flowResetTime: user.flowResetTime ?? 0,
+      notifyContact: user.notifyContact ?? ''
     });
     onUserModalOpen();
   };
@@ -828,6 +829,12 @@ export default function UserPage() {
                 showMonthAndYearPickers
                 className="cursor-pointer"
               />
+              <Input
+                label="提醒联系方式"
+                placeholder="邮箱 / Telegram 等，用于流量与到期提醒"
+                value={userForm.notifyContact || ''}
+                onChange={(e) => setUserForm(prev => ({ ...prev, notifyContact: e.target.value }))}
+              />
             </div>
             
             <RadioGroup
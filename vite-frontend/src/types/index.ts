@@ -15,6 +15,7 @@ export interface User {
   num: number; // 转发数量
   expTime?: number; // 过期时间戳
   flowResetTime?: number; // 流量重置日期(1-31号)
+  notifyContact?: string; // 配额/到期提醒联系方式
   createdTime?: number; // 创建时间戳
   inFlow?: number; // 下载流量(字节)
   outFlow?: number; // 上传流量(字节)
@@ -30,6 +31,7 @@ export interface UserForm {
   num: number;
   expTime: Date | null;
   flowResetTime: number;
+  notifyContact?: string;
 }
 
 export interface UserTunnel {